@@ -0,0 +1,30 @@
+package gopqr
+
+// maxApplicationName - Longest application_name the server keeps, longer
+// ones are truncated and would lose the slot
+const maxApplicationName = 63
+
+// applicationNameFor returns the setting of the DSN tagged with the slot the
+// connection opens with, when the driver has TagApplicationName set. That is
+// the application_name of the DSN with the slot appended, or else its
+// fallback_application_name, or else a fallback_application_name of
+// "gopqr/<slot>" so that one set in the environment still takes precedence.
+// get returns the value of a setting of the DSN. key is empty when there is
+// nothing to set, which is always the case with RDSProxy.
+func (d *Driver) applicationNameFor(get func(string) string, slot string) (key, value string) {
+	if !d.tagApplicationName() || d.RDSProxy {
+		return "", ""
+	}
+	key, name := "application_name", get("application_name")
+	if name == "" {
+		key, name = "fallback_application_name", get("fallback_application_name")
+	}
+	if name == "" {
+		name = "gopqr"
+	}
+	suffix := "/" + slot
+	if len(name)+len(suffix) > maxApplicationName && len(suffix) < maxApplicationName {
+		name = name[:maxApplicationName-len(suffix)]
+	}
+	return key, name + suffix
+}
@@ -0,0 +1,147 @@
+package gopqr
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// AuditRotation - The active credential of the driver changed
+	AuditRotation = "rotation"
+	// AuditAuthFailure - A credential failed authentication
+	AuditAuthFailure = "auth_failure"
+	// AuditFallback - Open connected with another credential after the one
+	// it tried first failed authentication
+	AuditFallback = "fallback"
+	// AuditExhausted - Every credential failed authentication
+	AuditExhausted = "exhausted"
+	// AuditRefresh - The credentials were refreshed, or failed to be
+	AuditRefresh = "refresh"
+)
+
+const (
+	// AuditSuccess - Outcome of a record of something that went through
+	AuditSuccess = "success"
+	// AuditFailure - Outcome of a record of something that failed
+	AuditFailure = "failure"
+)
+
+// AuditRecord is what an AuditSink receives for every credential flip,
+// authentication failure, fallback and refresh of the driver. Like all the
+// output of the driver, it names slots but never carries usernames or
+// passwords.
+type AuditRecord struct {
+	// Time - When it happened
+	Time time.Time `json:"time"`
+	// Event - AuditRotation, AuditAuthFailure, AuditFallback, AuditExhausted
+	// or AuditRefresh
+	Event string `json:"event"`
+	// Slot - The slot the record is about, for AuditRotation and
+	// AuditFallback the one moved to
+	Slot string `json:"slot,omitempty"`
+	// From - The slot moved from, for AuditRotation and AuditFallback
+	From string `json:"from,omitempty"`
+	// Outcome - AuditSuccess or AuditFailure
+	Outcome string `json:"outcome"`
+	// Error - The failure, redacted, if any
+	Error string `json:"error,omitempty"`
+	// Host and Database - Of the DSN passed to Open, for the records of Open
+	Host     string `json:"host,omitempty"`
+	Database string `json:"database,omitempty"`
+}
+
+// AuditSink receives an AuditRecord for every credential flip,
+// authentication failure, fallback and refresh of the driver, for the
+// auditable trail security teams require. It is invoked synchronously from
+// Open and refreshes, so implementations must be quick and safe for
+// concurrent use. FileSink and SyslogSink are built in.
+type AuditSink interface {
+	Audit(AuditRecord)
+}
+
+// audit hands the record to the AuditSink of the driver, if it has one.
+func (d *Driver) audit(r AuditRecord) {
+	if d.AuditSink == nil {
+		return
+	}
+	r.Time = time.Now().UTC()
+	d.AuditSink.Audit(r)
+}
+
+// auditOpen hands a record of Open to the AuditSink of the driver, with the
+// host and database of the DSN.
+func (d *Driver) auditOpen(dsn string, r AuditRecord, err error) {
+	if d.AuditSink == nil {
+		return
+	}
+	r.Host, r.Database, _ = DatabaseOf(dsn)
+	r.Outcome = AuditSuccess
+	if err != nil {
+		r.Outcome, r.Error = AuditFailure, err.Error()
+	}
+	d.audit(r)
+}
+
+// FileSink is an AuditSink writing the records as JSON lines, like to an
+// append only file.
+type FileSink struct {
+	// OnError func, when set, is invoked with the failures to write a
+	// record, which are otherwise dropped
+	OnError func(AuditRecord, error)
+
+	mu sync.Mutex
+	w  io.Writer
+	f  *os.File
+}
+
+// NewFileSink returns a FileSink appending to the file at the path, which is
+// created readable by its owner only when missing.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{w: f, f: f}, nil
+}
+
+// NewWriterSink returns a FileSink writing to w, like os.Stderr.
+func NewWriterSink(w io.Writer) *FileSink {
+	return &FileSink{w: w}
+}
+
+// Audit writes the record as a line of JSON.
+func (s *FileSink) Audit(r AuditRecord) {
+	line, err := json.Marshal(r)
+	if err == nil {
+		s.mu.Lock()
+		_, err = s.w.Write(append(line, '\n'))
+		s.mu.Unlock()
+	}
+	if err != nil && s.OnError != nil {
+		s.OnError(r, err)
+	}
+}
+
+// Flush commits the records written to the file of a sink made by
+// NewFileSink to stable storage.
+func (s *FileSink) Flush() error {
+	if s.f == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Sync()
+}
+
+// Close closes the file of a sink made by NewFileSink.
+func (s *FileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
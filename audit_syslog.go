@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package gopqr
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogSink is an AuditSink sending the records as JSON to syslog, with
+// the auth facility. Failures are sent with the warning severity and the
+// rest with notice.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink returns a SyslogSink sending to the local syslog daemon
+// under the tag, the name of the program when empty.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_NOTICE, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// Audit sends the record to syslog.
+func (s *SyslogSink) Audit(r AuditRecord) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	if r.Outcome == AuditFailure {
+		s.w.Warning(string(line))
+		return
+	}
+	s.w.Notice(string(line))
+}
+
+// Close closes the connection to syslog.
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
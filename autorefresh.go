@@ -0,0 +1,130 @@
+package gopqr
+
+import (
+	"sync"
+	"time"
+)
+
+// StartAutoRefresh refreshes the credentials of the driver in the background
+// every interval, plus a random duration of up to jitter so that a fleet of
+// processes does not hit the secret store at the same instant. Refreshing
+// ahead of time means new connections pick up rotated credentials without
+// first failing authentication once. The RefreshInterval handed to
+// NewDriver or UpdateConfig takes the place of the interval. It returns a func that stops the
+// background refresh and waits for a refresh in flight to finish.
+func (d *Driver) StartAutoRefresh(interval, jitter time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.runWorker("auto_refresh", true, func(ran func()) {
+			t := time.NewTimer(withJitter(d.autoRefreshInterval(interval), jitter))
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+					if err := d.refreshCredentials(); err != nil {
+						d.logf("scheduled credential refresh failed - %v", err)
+					}
+					ran()
+					t.Reset(withJitter(d.autoRefreshInterval(interval), jitter))
+				}
+			}
+		})
+	}()
+	var once sync.Once
+	return d.tracked(func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	})
+}
+
+const (
+	//DEFAULTEXPIRYFRACTION - Fraction of the remaining lifetime of the
+	//active credential that StartExpiryRefresh waits before refreshing it
+	DEFAULTEXPIRYFRACTION = 0.5
+	//DEFAULTEXPIRYMININTERVAL - Least time StartExpiryRefresh waits
+	DEFAULTEXPIRYMININTERVAL = time.Minute
+)
+
+// StartExpiryRefresh refreshes the credentials of the driver in the
+// background before the active credential expires, rather than after it
+// failed authentication. It waits the fraction (0 to 1, defaults to
+// DEFAULTEXPIRYFRACTION) of the remaining lifetime given by the ValidUntil
+// of the active credential, like of a Vault lease, but at least minInterval
+// (defaults to DEFAULTEXPIRYMININTERVAL) so that a credential the refresh
+// did not replace is not refreshed in a tight loop. While the active
+// credential does not expire, it checks again every minInterval. It returns a func that stops
+// the background refresh and waits for a refresh in flight to finish.
+func (d *Driver) StartExpiryRefresh(fraction float64, minInterval time.Duration) (stop func()) {
+	if fraction <= 0 || fraction > 1 {
+		fraction = DEFAULTEXPIRYFRACTION
+	}
+	if minInterval <= 0 {
+		minInterval = DEFAULTEXPIRYMININTERVAL
+	}
+	next := func() (time.Duration, bool) {
+		until := d.activeValidUntil()
+		if until.IsZero() {
+			return minInterval, false
+		}
+		wait := time.Duration(float64(time.Until(until)) * fraction)
+		if wait < minInterval {
+			wait = minInterval
+		}
+		return wait, true
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.runWorker("expiry_refresh", true, func(ran func()) {
+			wait, expires := next()
+			t := time.NewTimer(wait)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+					if expires {
+						if err := d.refreshCredentials(); err != nil {
+							d.logf("refresh ahead of credential expiry failed - %v", err)
+						}
+					}
+					ran()
+					wait, expires = next()
+					t.Reset(wait)
+				}
+			}
+		})
+	}()
+	var once sync.Once
+	return d.tracked(func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	})
+}
+
+// activeValidUntil returns when the active credential expires, or the zero
+// time when it does not.
+func (d *Driver) activeValidUntil() time.Time {
+	s := d.current()
+	return s.ring[slotIndex(s.ring, s.activeSlot())].ValidUntil
+}
+
+// withJitter adds a random duration of up to jitter to the interval.
+func withJitter(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(randInt63n(int64(jitter)))
+}
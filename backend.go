@@ -0,0 +1,184 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// dialContext returns the dial func connecting through the backend, honoring
+// the cancellation of ctx when the backend supports it. lib/pq always does,
+// as it is dialed through pq.NewConnectorConfig rather than pq.Open.
+func (d *Driver) dialContext(ctx context.Context) func(string) (driver.Conn, error) {
+	if d.Backend == nil {
+		dialer := d.Dialer
+		return func(dsn string) (driver.Conn, error) {
+			return pqConnect(ctx, dsn, dialer)
+		}
+	}
+	backend := d.Backend
+	return func(dsn string) (driver.Conn, error) {
+		if dc, ok := backend.(driver.DriverContext); ok {
+			connector, err := dc.OpenConnector(dsn)
+			if err != nil {
+				return nil, err
+			}
+			return connector.Connect(ctx)
+		}
+		return backend.Open(dsn)
+	}
+}
+
+// DefaultBackend returns the backend the driver connects through while its
+// Backend is nil - lib/pq, dialed with the Dialer of the driver and bound by
+// the context of Connect. It is for wrappers of the Backend of a driver
+// that has none, like the chaos subpackage, to keep connecting the way the
+// driver does.
+func (d *Driver) DefaultBackend() driver.Driver {
+	return pqBackend{d: d}
+}
+
+// pqBackend is the DefaultBackend of a driver.
+type pqBackend struct {
+	d *Driver
+}
+
+var _ driver.DriverContext = pqBackend{}
+
+func (b pqBackend) Open(dsn string) (driver.Conn, error) {
+	return pqConnect(context.Background(), dsn, b.d.Dialer)
+}
+
+func (b pqBackend) OpenConnector(dsn string) (driver.Connector, error) {
+	return pqBackendConnector{backend: b, dsn: dsn}, nil
+}
+
+type pqBackendConnector struct {
+	backend pqBackend
+	dsn     string
+}
+
+func (c pqBackendConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return pqConnect(ctx, c.dsn, c.backend.d.Dialer)
+}
+
+func (c pqBackendConnector) Driver() driver.Driver {
+	return c.backend
+}
+
+// pqConnect connects to the DSN with lib/pq bound by ctx, so that a
+// cancelled Open or the deadline of a connector stops dialing the hosts and
+// the handshake instead of waiting on them. The DSN is parsed into a
+// pq.Config once, with the credentials it carries as they were escaped. The
+// sockets are dialed with the dialer, unless nil.
+func pqConnect(ctx context.Context, dsn string, dialer pq.Dialer) (driver.Conn, error) {
+	cfg, err := pq.NewConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := pq.NewConnectorConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	interrupting := &ctxDialer{dialer: dialer}
+	connector.Dialer(interrupting)
+	conn, err := connector.Connect(ctx)
+	interrupted := interrupting.release()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w - %w", ctx.Err(), err)
+		}
+		return nil, err
+	}
+	if interrupted {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	return conn, nil
+}
+
+// ctxDialer dials for lib/pq, which passes the context of Connect to the
+// dial but not to the startup handshake. The sockets it dials are therefore
+// interrupted when that context is done, until release.
+type ctxDialer struct {
+	// dialer - The Dialer of the driver, if any
+	dialer pq.Dialer
+	mu     sync.Mutex
+	stops  []func() bool
+}
+
+func (d *ctxDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *ctxDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d *ctxDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	d.mu.Lock()
+	d.stops = append(d.stops, stop)
+	d.mu.Unlock()
+	return conn, nil
+}
+
+// dial dials with the Dialer of the driver, bounded by ctx as far as it
+// allows.
+func (d *ctxDialer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	switch dialer := d.dialer.(type) {
+	case nil:
+		var nd net.Dialer
+		return nd.DialContext(ctx, network, address)
+	case pq.DialerContext:
+		return dialer.DialContext(ctx, network, address)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		return d.dialer.DialTimeout(network, address, time.Until(deadline))
+	}
+	return d.dialer.Dial(network, address)
+}
+
+// release stops interrupting the sockets dialed, returning whether any of
+// them was interrupted already.
+func (d *ctxDialer) release() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	interrupted := false
+	for _, stop := range d.stops {
+		if !stop() {
+			interrupted = true
+		}
+	}
+	d.stops = nil
+	return interrupted
+}
+
+// sqlState returns the SQLSTATE code carried by the error, which may come
+// from lib/pq or from any backend whose errors have a SQLState method, like
+// the *pgconn.PgError of pgx.
+func sqlState(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code), true
+	}
+	var coded interface{ SQLState() string }
+	if errors.As(err, &coded) {
+		return coded.SQLState(), true
+	}
+	return "", false
+}
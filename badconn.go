@@ -0,0 +1,72 @@
+package gopqr
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+)
+
+// BadConnPolicy decides which failures of Open are reported as
+// driver.ErrBadConn, which makes database/sql retry the operation on another
+// connection, rather than surfacing them to the caller. database/sql retries
+// a couple of times before it gives up, so a retry only helps when the next
+// attempt stands a chance, like after a refresh of the credentials landed.
+// The zero value surfaces every failure, which is the safe default.
+type BadConnPolicy uint
+
+const (
+	// BadConnOnAuthExhausted - Report every credential failing
+	// authentication as driver.ErrBadConn. The refresh requested by the
+	// failure may have landed by the time database/sql retries.
+	BadConnOnAuthExhausted BadConnPolicy = 1 << iota
+	// BadConnOnNetworkError - Report network failures while connecting, like
+	// a refused or reset connection, as driver.ErrBadConn
+	BadConnOnNetworkError
+)
+
+// badConnError reports the failure it carries as driver.ErrBadConn, while
+// errors.Is and errors.As keep matching the failure itself.
+type badConnError struct {
+	err error
+}
+
+func (e *badConnError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the failure.
+func (e *badConnError) Unwrap() error {
+	return e.err
+}
+
+// Is makes errors.Is(err, driver.ErrBadConn) match.
+func (e *badConnError) Is(target error) bool {
+	return target == driver.ErrBadConn
+}
+
+// authExhausted applies the BadConnPolicy to the failure of every credential.
+func (d *Driver) authExhausted(err error) error {
+	if d.BadConnPolicy&BadConnOnAuthExhausted != 0 {
+		return &badConnError{err: err}
+	}
+	return err
+}
+
+// connectFailed applies the BadConnPolicy to any other failure to connect.
+func (d *Driver) connectFailed(err error) error {
+	if d.BadConnPolicy&BadConnOnNetworkError != 0 && isNetworkError(err) {
+		return &badConnError{err: err}
+	}
+	return err
+}
+
+// isNetworkError reports whether err is the network failing rather than the
+// server refusing the connection.
+func isNetworkError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,77 @@
+package gopqr_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+)
+
+const testDSN = "postgres://db.internal:5432/mydb?sslmode=disable"
+
+func TestBadConnOnAuthExhaustedRetries(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	// the first Open fails with both credentials, the retry of database/sql
+	// gets through
+	backend.FailNext(gopqrtest.AuthError("app_odd"))
+	backend.FailNext(gopqrtest.AuthError("app_even"))
+	d, _ := testDriver(backend)
+	d.BadConnPolicy = gopqr.BadConnOnAuthExhausted
+	db := sql.OpenDB(d.Connector(testDSN))
+	defer db.Close()
+
+	if err := db.PingContext(context.Background()); err != nil {
+		t.Fatalf("Ping failed, want database/sql to retry - %v", err)
+	}
+	attempts := backend.Attempts()
+	if len(attempts) < 3 || attempts[len(attempts)-1].Err != nil {
+		t.Fatalf("attempts = %+v, want the failures of both credentials and a retry that connected", attempts)
+	}
+}
+
+func TestBadConnZeroPolicySurfacesFailure(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	backend.FailNext(gopqrtest.AuthError("app_odd"))
+	backend.FailNext(gopqrtest.AuthError("app_even"))
+	d, _ := testDriver(backend)
+	db := sql.OpenDB(d.Connector(testDSN))
+	defer db.Close()
+
+	err := db.PingContext(context.Background())
+	if !errors.Is(err, gopqr.ErrAllCredentialsFailed) {
+		t.Fatalf("Ping = %v, want ErrAllCredentialsFailed", err)
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("Ping = %v, which matches driver.ErrBadConn under the zero policy", err)
+	}
+	if n := len(backend.Attempts()); n != 2 {
+		t.Errorf("%v attempts, want 2 without a retry", n)
+	}
+}
+
+func TestBadConnErrorMatchesFailure(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	d, _ := testDriver(backend)
+	d.BadConnPolicy = gopqr.BadConnOnAuthExhausted
+
+	_, err := d.Open(testDSN)
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("Open = %v, want driver.ErrBadConn", err)
+	}
+	if !errors.Is(err, gopqr.ErrAllCredentialsFailed) || !errors.Is(err, gopqr.ErrBothCredentialsFailed) {
+		t.Errorf("Open = %v, want ErrAllCredentialsFailed and ErrBothCredentialsFailed", err)
+	}
+	var exhausted *gopqr.AuthExhaustedError
+	if !errors.As(err, &exhausted) {
+		t.Fatalf("Open = %v, want an *AuthExhaustedError", err)
+	}
+	if len(exhausted.Slots) != 2 || len(exhausted.Errs) != 2 {
+		t.Errorf("AuthExhaustedError = %+v, want the failures of both slots", exhausted)
+	}
+}
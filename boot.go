@@ -0,0 +1,144 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BootConfig holds the settings of Boot.
+type BootConfig struct {
+	// DSN - The DSN sans credentials, like "postgres://1.2.3.4:5432/mydb?sslmode=verify-full"
+	DSN string
+	// RequireAllSlots - Fail the boot when a standby credential does not
+	// authenticate. The active credential must always authenticate.
+	RequireAllSlots bool
+	// WarmConnections - Number of connections opened before Boot returns
+	WarmConnections int
+	// MaxOpenConns - Passed on to SetMaxOpenConns when set
+	MaxOpenConns int
+	// MaxIdleConns - Passed on to SetMaxIdleConns when set. It is raised to
+	// WarmConnections so that warmed connections stay in the pool.
+	MaxIdleConns int
+	// ConnMaxLifetime - Passed on to SetConnMaxLifetime when set
+	ConnMaxLifetime time.Duration
+	// RefreshInterval - When set, the credentials are refreshed in the
+	// background on this interval
+	RefreshInterval time.Duration
+	// RefreshJitter - Random duration of up to this much added to every
+	// RefreshInterval, see StartAutoRefresh
+	RefreshJitter time.Duration
+	// RegisterHealthCheck func, when set, is handed the health check of the
+	// booted database to register with the health framework of the application
+	RegisterHealthCheck func(name string, check func(context.Context) error)
+}
+
+// Booted is the handle returned by Boot. Close it to stop the background
+// refresh and close the database.
+type Booted struct {
+	// DB - The database opened over the driver
+	DB *sql.DB
+	// Driver - The driver that was booted
+	Driver *Driver
+	// SlotErrors - Outcome of validating each credential at boot, keyed by
+	// the name of its slot. Slots that authenticated map to nil.
+	SlotErrors map[string]error
+
+	stopRefresh func()
+	once        sync.Once
+}
+
+// Boot packages the recommended production setup into one call. It fetches
+// the credentials, validates every credential slot against the database,
+// opens the database over the driver and warms its pool, registers the
+// health check and starts refreshing the credentials in the background.
+func Boot(ctx context.Context, d *Driver, cfg BootConfig) (*Booted, error) {
+	if d.Provider != nil || d.CredentialRefresher != nil {
+		if err := d.refreshCredentials(); err != nil {
+			return nil, fmt.Errorf("Failed to fetch credentials at boot - %w", err)
+		}
+	}
+	active, err := d.currentActive()
+	if err != nil {
+		return nil, err
+	}
+	b := &Booted{
+		Driver:     d,
+		SlotErrors: make(map[string]error),
+	}
+	for _, slot := range d.current().ring {
+		err := d.checkSlot(ctx, cfg.DSN, slot.Name)
+		b.SlotErrors[slot.Name] = err
+		if err != nil && (slot.Name == active || cfg.RequireAllSlots) {
+			return nil, fmt.Errorf("Credential %v failed validation at boot - %w", slot.Name, err)
+		}
+	}
+
+	db := sql.OpenDB(&connector{d: d, dsn: cfg.DSN})
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 || cfg.WarmConnections > 0 {
+		idle := cfg.MaxIdleConns
+		if idle < cfg.WarmConnections {
+			idle = cfg.WarmConnections
+		}
+		db.SetMaxIdleConns(idle)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if err := warm(ctx, db, cfg.WarmConnections); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to warm connections at boot - %w", err)
+	}
+	b.DB = db
+
+	if cfg.RegisterHealthCheck != nil {
+		cfg.RegisterHealthCheck("gopqr", b.Health)
+	}
+	if cfg.RefreshInterval > 0 {
+		b.stopRefresh = d.StartAutoRefresh(cfg.RefreshInterval, cfg.RefreshJitter)
+	}
+	return b, nil
+}
+
+// warm opens n connections at once and hands them back to the idle pool.
+func warm(ctx context.Context, db *sql.DB, n int) error {
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		c, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, c)
+		if err := c.PingContext(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Health checks that the booted database can be reached.
+func (b *Booted) Health(ctx context.Context) error {
+	return b.DB.PingContext(ctx)
+}
+
+// Close stops the background refresh and closes the database.
+func (b *Booted) Close() error {
+	var err error
+	b.once.Do(func() {
+		if b.stopRefresh != nil {
+			b.stopRefresh()
+		}
+		err = b.DB.Close()
+	})
+	return err
+}
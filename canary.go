@@ -0,0 +1,199 @@
+package gopqr
+
+import (
+	"log/slog"
+	"sync"
+)
+
+const (
+	//DEFAULTCANARYPERCENT - default share, in percent, of the new connections
+	//a Canary routes to a newly installed credential at first
+	DEFAULTCANARYPERCENT = 10
+	//DEFAULTCANARYSUCCESSES - default number of connections in a row a newly
+	//installed credential opens before a Canary doubles its share
+	DEFAULTCANARYSUCCESSES = 20
+)
+
+// Canary rolls a credential the refresher installs as the new active
+// credential out to a share of the new connections first, the others going
+// on with the credential that was active before. The share doubles every
+// time the new credential opened Successes connections in a row, until all
+// of them use it. Once it fails authentication, the rollout is reverted and
+// the previous credential is made active again, so that a botched rotation
+// shows up on a few connections of the fleet rather than as an outage. It is
+// meant for rotation policies that keep the active credential between Opens,
+// like OnAuthFailure and OnSecretVersionChange, and counts as a risky
+// feature, which an exhausted ErrorBudget turns off, aborting the rollout in
+// progress. The zero value is ready
+// to use with DEFAULTCANARYPERCENT and DEFAULTCANARYSUCCESSES.
+type Canary struct {
+	// Percent - Share of the new connections routed to the new credential at first, defaults to DEFAULTCANARYPERCENT
+	Percent int
+	// Successes - Connections in a row the new credential opens before its share doubles, defaults to DEFAULTCANARYSUCCESSES
+	Successes int
+	// OnDone func is invoked once a rollout ends, with the slot of the new
+	// credential and whether it was promoted to all connections, rather than
+	// reverted or aborted
+	OnDone func(slot string, promoted bool)
+
+	mu        sync.Mutex
+	slot      string
+	previous  string
+	percent   int
+	successes int
+}
+
+// start starts a rollout of the slot, holding back on the previous one.
+func (c *Canary) start(slot, previous string) {
+	if c == nil {
+		return
+	}
+	percent := c.Percent
+	if percent <= 0 {
+		percent = DEFAULTCANARYPERCENT
+	}
+	c.mu.Lock()
+	c.slot, c.previous, c.percent, c.successes = slot, previous, percent, 0
+	c.mu.Unlock()
+}
+
+// Rollout returns the slot being rolled out and its share of the new
+// connections in percent, or an empty slot when none is.
+func (c *Canary) Rollout() (slot string, percent int) {
+	if c == nil {
+		return "", 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slot == "" {
+		return "", 0
+	}
+	return c.slot, c.percent
+}
+
+// route returns the previous slot when the connection about to be opened
+// with the active one is held back from the rollout.
+func (c *Canary) route(ring []Credential, active string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slot == "" || c.slot != active || c.percent >= 100 || randInt63n(100) < int64(c.percent) {
+		return "", false
+	}
+	for _, cred := range ring {
+		if cred.Name == c.previous && !cred.LastResort {
+			return c.previous, true
+		}
+	}
+	return "", false
+}
+
+// observe records whether the slot authenticated. It returns the previous
+// slot when that ended the rollout, reverted tells how.
+func (c *Canary) observe(slot string, authenticated bool) (previous string, done, reverted bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slot == "" || c.slot != slot {
+		return "", false, false
+	}
+	previous = c.previous
+	if !authenticated {
+		c.slot = ""
+		return previous, true, true
+	}
+	c.successes++
+	successes := c.Successes
+	if successes <= 0 {
+		successes = DEFAULTCANARYSUCCESSES
+	}
+	if c.successes < successes {
+		return "", false, false
+	}
+	c.successes = 0
+	if c.percent *= 2; c.percent < 100 {
+		return "", false, false
+	}
+	c.slot = ""
+	return previous, true, false
+}
+
+// abort ends the rollout in progress, if any, returning its slot.
+func (c *Canary) abort() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := c.slot
+	c.slot = ""
+	return slot
+}
+
+// canaryStarts starts a rollout of the credential a refresh made active, the
+// first install of the driver aside.
+func (d *Driver) canaryStarts(from, to string, installs uint64) {
+	if d.Canary == nil || installs < 2 || from == "" || from == to || !d.RiskyFeaturesAllowed() {
+		return
+	}
+	d.Canary.start(to, from)
+	d.event(slog.LevelInfo, "canary rollout started", "slot", to, "previous", from)
+}
+
+// canaryRoute returns the slot an Open holds back on while the active
+// credential is rolled out.
+func (d *Driver) canaryRoute(ring []Credential, active string) (string, bool) {
+	if !d.RiskyFeaturesAllowed() {
+		return "", false
+	}
+	return d.Canary.route(ring, active)
+}
+
+// canaryObserved hands the outcome of opening a connection with the slot at
+// index active to the rollout, reverting to the previous credential when the
+// new one failed authentication, unless in grace mode. Other failures tell
+// nothing of the credential.
+func (d *Driver) canaryObserved(ring []Credential, active int, connErr error) {
+	if d.Canary == nil || (connErr != nil && !d.isAuthFailure(connErr)) {
+		return
+	}
+	slot := ring[active].Name
+	previous, done, reverted := d.Canary.observe(slot, connErr == nil)
+	if !done {
+		return
+	}
+	switch {
+	case reverted && d.InGrace():
+		// grace mode never changes the active credential, so the rollout
+		// ends without a revert
+		d.logf("canary rollout of %v failed authentication, keeping it active in grace mode", slot)
+		d.event(slog.LevelWarn, "canary rollout ended in grace mode", "slot", slot, "previous", previous)
+	case reverted:
+		d.logf("canary rollout of %v failed authentication, reverting to %v", slot, previous)
+		d.event(slog.LevelWarn, "canary rollout reverted", "slot", slot, "previous", previous)
+		if err := d.swapActive(active, previous); err != nil {
+			d.logf("reverting the canary rollout of %v failed - %v", slot, err)
+		}
+	default:
+		d.event(slog.LevelInfo, "canary rollout promoted", "slot", slot)
+	}
+	if d.Canary.OnDone != nil {
+		d.Canary.OnDone(slot, !reverted)
+	}
+}
+
+// canaryAborted aborts the rollout in progress, if any, once the error
+// budget is exhausted. The active credential stays as it is, leaving every
+// new connection on it rather than risk a revert during an incident.
+func (d *Driver) canaryAborted() {
+	slot := d.Canary.abort()
+	if slot == "" {
+		return
+	}
+	d.logf("canary rollout of %v aborted, the error budget is exhausted", slot)
+	d.event(slog.LevelWarn, "canary rollout aborted", "slot", slot)
+	if d.Canary.OnDone != nil {
+		d.Canary.OnDone(slot, false)
+	}
+}
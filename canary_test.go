@@ -0,0 +1,39 @@
+package gopqr
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestCanaryFailureInGraceKeepsActive(t *testing.T) {
+	var done []bool
+	d := &Driver{
+		Slots: []Credential{
+			{Name: "odd", Username: "app_odd", Password: "odd-pw"},
+			{Name: "even", Username: "app_even", Password: "even-pw"},
+		},
+		ActiveCredential: "even",
+		Grace:            true,
+		Canary:           &Canary{OnDone: func(slot string, promoted bool) { done = append(done, promoted) }},
+	}
+	d.Canary.start("even", "odd")
+	ring := d.current().ring
+	d.canaryObserved(ring, slotIndex(ring, "even"), &pq.Error{Code: "28P01"})
+	if active := d.current().active; active != "even" {
+		t.Errorf("active credential = %v after the canary failed in grace mode, want even", active)
+	}
+	if slot, _ := d.Canary.Rollout(); slot != "" {
+		t.Errorf("rollout of %q after the canary failed, want it ended", slot)
+	}
+	if len(done) != 1 || done[0] {
+		t.Errorf("OnDone called with %v, want once and not promoted", done)
+	}
+
+	d.Grace = false
+	d.Canary.start("even", "odd")
+	d.canaryObserved(ring, slotIndex(ring, "even"), &pq.Error{Code: "28P01"})
+	if active := d.current().active; active != "odd" {
+		t.Errorf("active credential = %v after the canary failed, want it reverted to odd", active)
+	}
+}
@@ -0,0 +1,343 @@
+package chaos
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/lib/pq"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package chaos injects the failures of a rotation gone wrong into a
+github.com/chandranarreddy/gopqr driver on a schedule - connections failing
+authentication, a secret store that is down and refreshes that take long - so
+that teams can rehearse them in staging and check that their alerts fire and
+their services ride them out, before a real rotation goes wrong. It is meant
+for staging and tests only, and does nothing unless installed.
+
+Usage:
+	c := chaos.New(chaos.Config{Faults: []chaos.Fault{
+		// every hour, the opens as the odd user fail authentication for 5 minutes
+		{Kind: chaos.AuthFailure, After: 10 * time.Minute, For: 5 * time.Minute, Every: time.Hour, Usernames: []string{"app_odd"}},
+		// then the secret store is down for 2 minutes, and slow for 10 after
+		{Kind: chaos.StoreOutage, After: 15 * time.Minute, For: 2 * time.Minute, Every: time.Hour},
+		{Kind: chaos.SlowRefresh, After: 17 * time.Minute, For: 10 * time.Minute, Every: time.Hour, Delay: 20 * time.Second},
+	}})
+	pqrDriver, err := gopqr.New(gopqr.WithProvider(p))
+	...
+	c.Install(pqrDriver)
+	sql.Register("pqr-chaos", pqrDriver)
+
+The schedule runs from New. Install wraps the Provider and the Backend of the
+driver, so it must be called before the driver is used. No fault strikes a
+driver it is installed on while the ErrorBudget of that driver is exhausted,
+see gopqr.Driver.RiskyFeaturesAllowed, so that rehearsals cannot amplify a
+real incident.
+*/
+
+// Kind is the kind of failure a Fault injects.
+type Kind int
+
+const (
+	// AuthFailure - Opens fail with the invalid_password error of Postgres,
+	// as they do once the credential they use was rotated
+	AuthFailure Kind = iota + 1
+	// StoreOutage - Current and Refresh of the provider fail with
+	// ErrStoreOutage, as they do while the secret store is down
+	StoreOutage
+	// SlowRefresh - Refresh of the provider is held up by the Delay of the
+	// fault, or until its context is done
+	SlowRefresh
+)
+
+// String returns the name of the kind.
+func (k Kind) String() string {
+	switch k {
+	case AuthFailure:
+		return "auth_failure"
+	case StoreOutage:
+		return "store_outage"
+	case SlowRefresh:
+		return "slow_refresh"
+	}
+	return "unknown"
+}
+
+// ErrStoreOutage is returned by the provider while a StoreOutage is on.
+var ErrStoreOutage = errors.New("chaos: injected secret store outage")
+
+// Fault is one failure on the schedule.
+type Fault struct {
+	// Kind - What fails
+	Kind Kind
+	// After - When the fault starts, counted from New
+	After time.Duration
+	// For - How long the fault lasts, 0 for as long as the Chaos is used
+	For time.Duration
+	// Every - When set, the fault starts again every interval after the
+	// first, lasting For every time
+	Every time.Duration
+	// Usernames - AuthFailure only, the users whose opens fail, all of them
+	// when empty
+	Usernames []string
+	// Rate - The fraction of the opens or calls that fail while the fault
+	// is on, all of them when 0. The draws come from math/rand, so that
+	// none fail in strict FIPS mode unless Rate is 0 or 1
+	Rate float64
+	// Delay - SlowRefresh only, how long every Refresh is held up
+	Delay time.Duration
+}
+
+// on reports whether the fault is on, elapsed after New.
+func (f Fault) on(elapsed time.Duration) bool {
+	elapsed -= f.After
+	if elapsed < 0 {
+		return false
+	}
+	if f.Every > 0 {
+		elapsed %= f.Every
+	}
+	return f.For <= 0 || elapsed < f.For
+}
+
+// Config is the schedule of a Chaos.
+type Config struct {
+	// Faults - The failures to inject
+	Faults []Fault
+	// Seed - Seed of the draws of the Rate, so that runs can be repeated
+	Seed int64
+	// OnInject - When set, called with every failure injected, like to log
+	// it next to the alerts it should set off
+	OnInject func(f Fault, err error)
+}
+
+// Chaos injects the faults of its schedule into the drivers it is
+// installed on.
+type Chaos struct {
+	cfg   Config
+	start time.Time
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	injected map[Kind]int
+}
+
+// New returns a Chaos running the schedule of the config from now on.
+func New(cfg Config) *Chaos {
+	return &Chaos{
+		cfg:      cfg,
+		start:    time.Now(),
+		rng:      rand.New(rand.NewSource(cfg.Seed)),
+		injected: make(map[Kind]int),
+	}
+}
+
+// Install wraps the Provider and the Backend of the driver so that they
+// fail as scheduled, as long as the driver allows risky features. A driver
+// without a Backend gets its DefaultBackend wrapped, so that it still dials
+// with its Dialer. Call it before the driver is used.
+func (c *Chaos) Install(d *gopqr.Driver) {
+	if d.Provider != nil {
+		d.Provider = &provider{chaos: c, inner: d.Provider, driver: d}
+	}
+	inner := d.Backend
+	if inner == nil {
+		inner = d.DefaultBackend()
+	}
+	d.Backend = &backend{chaos: c, inner: inner, driver: d}
+}
+
+// Active returns the kinds of the faults that are on right now.
+func (c *Chaos) Active() []Kind {
+	var kinds []Kind
+	for _, f := range c.cfg.Faults {
+		if f.on(time.Since(c.start)) {
+			kinds = append(kinds, f.Kind)
+		}
+	}
+	return kinds
+}
+
+// Injected returns how many failures of the kind were injected so far.
+func (c *Chaos) Injected(kind Kind) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.injected[kind]
+}
+
+// strike returns the fault of the kind that strikes now, if any, matching
+// the filter. Nothing strikes the driver, when there is one, while it does
+// not allow risky features, and faults with a Rate never strike in strict
+// FIPS mode.
+func (c *Chaos) strike(d *gopqr.Driver, kind Kind, match func(Fault) bool) (Fault, bool) {
+	if d != nil && !d.RiskyFeaturesAllowed() {
+		return Fault{}, false
+	}
+	elapsed := time.Since(c.start)
+	for _, f := range c.cfg.Faults {
+		if f.Kind != kind || !f.on(elapsed) || (match != nil && !match(f)) {
+			continue
+		}
+		hit := f.Rate <= 0 || f.Rate >= 1
+		// the draws come from math/rand, which strict FIPS mode refuses
+		if !hit && gopqr.CheckPrimitive(gopqr.PrimitiveMathRand) == nil {
+			c.mu.Lock()
+			hit = c.rng.Float64() < f.Rate
+			c.mu.Unlock()
+		}
+		if hit {
+			return f, true
+		}
+	}
+	return Fault{}, false
+}
+
+func (c *Chaos) inject(f Fault, err error) {
+	c.mu.Lock()
+	c.injected[f.Kind]++
+	c.mu.Unlock()
+	if c.cfg.OnInject != nil {
+		c.cfg.OnInject(f, err)
+	}
+}
+
+// Provider returns the provider failing as scheduled, with StoreOutage and
+// SlowRefresh faults. Unlike Install, it knows no driver whose error budget
+// could stop the faults.
+func (c *Chaos) Provider(p gopqr.CredentialProvider) gopqr.CredentialProvider {
+	return &provider{chaos: c, inner: p}
+}
+
+type provider struct {
+	chaos *Chaos
+	inner gopqr.CredentialProvider
+	// driver - The driver the provider was installed on, if any
+	driver *gopqr.Driver
+}
+
+func (p *provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	if f, ok := p.chaos.strike(p.driver, StoreOutage, nil); ok {
+		p.chaos.inject(f, ErrStoreOutage)
+		return gopqr.Credentials{}, ErrStoreOutage
+	}
+	return p.inner.Current(ctx)
+}
+
+func (p *provider) Refresh(ctx context.Context) error {
+	if f, ok := p.chaos.strike(p.driver, StoreOutage, nil); ok {
+		p.chaos.inject(f, ErrStoreOutage)
+		return ErrStoreOutage
+	}
+	if f, ok := p.chaos.strike(p.driver, SlowRefresh, nil); ok && f.Delay > 0 {
+		p.chaos.inject(f, nil)
+		t := time.NewTimer(f.Delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return p.inner.Refresh(ctx)
+}
+
+// Close closes the wrapped provider when it has a Close method, so that
+// Close of the driver still reaches it.
+func (p *provider) Close() error {
+	if closer, ok := p.inner.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Backend returns the backend failing as scheduled, with AuthFailure
+// faults. A nil backend stands for lib/pq, dialed without the Dialer of any
+// driver. Unlike Install, it knows no driver whose error budget could stop
+// the faults.
+func (c *Chaos) Backend(b driver.Driver) driver.Driver {
+	return &backend{chaos: c, inner: b}
+}
+
+type backend struct {
+	chaos *Chaos
+	inner driver.Driver
+	// driver - The driver the backend was installed on, if any
+	driver *gopqr.Driver
+}
+
+var _ driver.DriverContext = (*backend)(nil)
+
+// authFailure returns the error of an AuthFailure striking the open of the
+// DSN, if any.
+func (b *backend) authFailure(dsn string) error {
+	cfg, err := pq.NewConfig(dsn)
+	if err != nil {
+		return nil
+	}
+	f, ok := b.chaos.strike(b.driver, AuthFailure, func(f Fault) bool {
+		if len(f.Usernames) == 0 {
+			return true
+		}
+		for _, u := range f.Usernames {
+			if u == cfg.User {
+				return true
+			}
+		}
+		return false
+	})
+	if !ok {
+		return nil
+	}
+	err = &pq.Error{Severity: "FATAL", Code: "28P01", Message: `password authentication failed for user "` + cfg.User + `"`}
+	b.chaos.inject(f, err)
+	return err
+}
+
+func (b *backend) Open(dsn string) (driver.Conn, error) {
+	c, err := b.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+func (b *backend) OpenConnector(dsn string) (driver.Connector, error) {
+	return &connector{backend: b, dsn: dsn}, nil
+}
+
+type connector struct {
+	backend *backend
+	dsn     string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := c.backend.authFailure(c.dsn); err != nil {
+		return nil, err
+	}
+	switch inner := c.backend.inner.(type) {
+	case nil:
+		pc, err := pq.NewConnector(c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		return pc.Connect(ctx)
+	case driver.DriverContext:
+		ic, err := inner.OpenConnector(c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		return ic.Connect(ctx)
+	default:
+		return inner.Open(c.dsn)
+	}
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.backend
+}
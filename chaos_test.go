@@ -0,0 +1,63 @@
+package gopqr_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/chaos"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+)
+
+func TestChaosStopsOnceBudgetExhausted(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	backend.Allow("app_even", "even-pw")
+	d, _ := testDriver(backend)
+	d.ErrorBudget = &gopqr.ErrorBudget{MaxFailures: 1, OnExhausted: func(int, error) {}}
+	c := chaos.New(chaos.Config{Faults: []chaos.Fault{{Kind: chaos.AuthFailure}}})
+	c.Install(d)
+
+	if _, err := d.Open(testDSN); !errors.Is(err, gopqr.ErrAllCredentialsFailed) {
+		t.Fatalf("Open = %v while the fault is on, want ErrAllCredentialsFailed", err)
+	}
+	if d.RiskyFeaturesAllowed() {
+		t.Fatal("risky features allowed after the failures, want the budget exhausted")
+	}
+	injected := c.Injected(chaos.AuthFailure)
+	conn, err := d.Open(testDSN)
+	if err != nil {
+		t.Fatalf("Open = %v with the budget exhausted, want the fault to stop striking", err)
+	}
+	conn.Close()
+	if n := c.Injected(chaos.AuthFailure); n != injected {
+		t.Errorf("%v failures injected with the budget exhausted, want none", n-injected)
+	}
+}
+
+// refusingDialer counts its dials and refuses them all.
+type refusingDialer struct {
+	dials atomic.Int32
+}
+
+var errRefused = errors.New("dial refused by the test")
+
+func (d *refusingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.dials.Add(1)
+	return nil, errRefused
+}
+
+func TestChaosInstallKeepsDialer(t *testing.T) {
+	dialer := &refusingDialer{}
+	d := &gopqr.Driver{Provider: gopqrtest.New(t), Dialer: gopqr.DialFunc(dialer.DialContext)}
+	chaos.New(chaos.Config{}).Install(d)
+	if _, err := d.Open(testDSN); !errors.Is(err, errRefused) {
+		t.Errorf("Open = %v, want the error of the Dialer of the driver", err)
+	}
+	if dialer.dials.Load() == 0 {
+		t.Error("the Dialer of the driver was not used once chaos was installed")
+	}
+}
@@ -0,0 +1,129 @@
+package gopqr
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	//DEFAULTBREAKERTHRESHOLD - default number of consecutive Opens failing with every credential that opens a CircuitBreaker
+	DEFAULTBREAKERTHRESHOLD = 5
+	//DEFAULTBREAKERCOOLDOWN - default duration a CircuitBreaker fails Opens fast for
+	DEFAULTBREAKERCOOLDOWN = 30 * time.Second
+)
+
+// ErrCircuitOpen is matched by errors.Is when Open failed fast because the
+// CircuitBreaker of the driver is open. The failure that opened it is
+// wrapped as well.
+var ErrCircuitOpen = errors.New("Circuit breaker is open after repeated authentication failures")
+
+// CircuitBreaker makes Open fail fast once every credential has failed
+// authentication for Threshold Opens in a row. Each such Open costs a full
+// TCP, TLS and authentication handshake per credential, and enough of them
+// can trip lockouts on the server. The breaker stays open for the CoolDown,
+// after which a single Open is let through as a trial - closing the breaker
+// when it authenticates and opening it again when it does not. A successful
+// refresh of the credentials closes it right away. The zero value is ready
+// to use with DEFAULTBREAKERTHRESHOLD and DEFAULTBREAKERCOOLDOWN.
+type CircuitBreaker struct {
+	// Threshold - Consecutive Opens failing with every credential that open
+	// the breaker, defaults to DEFAULTBREAKERTHRESHOLD
+	Threshold int
+	// CoolDown - How long the breaker fails Opens fast before the trial,
+	// defaults to DEFAULTBREAKERCOOLDOWN
+	CoolDown time.Duration
+	// OnOpen func, when set, is invoked every time the breaker opens, with
+	// the consecutive failures and the last of them
+	OnOpen func(failures int, last error)
+
+	mu          sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	last        error
+	trial       bool
+}
+
+// allow returns an error matching ErrCircuitOpen while the breaker is open.
+// Past the cool down, it lets a single Open through as the trial.
+func (b *CircuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return fmt.Errorf("%w until %v - %w", ErrCircuitOpen, b.openUntil.Format(time.RFC3339), b.last)
+	}
+	b.trial = true
+	return nil
+}
+
+// record notes the outcome of an Open that was let through. Only failures
+// of every credential count, as network failures are not what the breaker
+// protects the server from.
+func (b *CircuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.trial = false
+	if err == nil {
+		b.consecutive, b.openUntil, b.last = 0, time.Time{}, nil
+		b.mu.Unlock()
+		return
+	}
+	if !errors.Is(err, ErrAllCredentialsFailed) {
+		b.mu.Unlock()
+		return
+	}
+	threshold, coolDown := b.Threshold, b.CoolDown
+	if threshold <= 0 {
+		threshold = DEFAULTBREAKERTHRESHOLD
+	}
+	if coolDown <= 0 {
+		coolDown = DEFAULTBREAKERCOOLDOWN
+	}
+	b.consecutive++
+	b.last = err
+	opened := b.consecutive >= threshold
+	if opened {
+		b.openUntil = time.Now().Add(coolDown)
+	}
+	n := b.consecutive
+	b.mu.Unlock()
+	if opened && b.OnOpen != nil {
+		b.OnOpen(n, err)
+	}
+}
+
+// tune changes the Threshold and CoolDown of the breaker in use.
+func (b *CircuitBreaker) tune(threshold int, coolDown time.Duration) {
+	b.mu.Lock()
+	b.Threshold, b.CoolDown = threshold, coolDown
+	b.mu.Unlock()
+}
+
+// IsOpen reports whether the breaker fails Opens fast right now.
+func (b *CircuitBreaker) IsOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openUntil.IsZero() && time.Now().Before(b.openUntil)
+}
+
+// Reset closes the breaker and forgets the failures counted so far.
+func (b *CircuitBreaker) Reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.consecutive, b.openUntil, b.last, b.trial = 0, time.Time{}, nil, false
+	b.mu.Unlock()
+}
@@ -0,0 +1,64 @@
+package cloudsqlgopqr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"cloud.google.com/go/cloudsqlconn"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package cloudsqlgopqr dials GCP Cloud SQL instances through the Cloud SQL Go
+connector, cloud.google.com/go/cloudsqlconn, while
+github.com/chandranarreddy/gopqr supplies the rotating database user and
+password. The connector authorizes the connection with IAM and encrypts it
+with TLS of its own, so instances are named by their connection name rather
+than an address, and lib/pq must not negotiate TLS on top: use DSN, or
+sslmode=disable, and leave the SSLMode of the credentials empty. It lives
+apart from the driver so that the driver does not depend on the Google Cloud
+libraries.
+
+Usage:
+	option, closeDialer, err := cloudsqlgopqr.New(ctx, "project:region:instance")
+	...
+	defer closeDialer()
+	pqrDriver, err := gopqr.New(gopqr.WithProvider(p), option)
+	...
+	db := gopqr.OpenDB(cloudsqlgopqr.DSN("project:region:instance", "mydb"), pqrDriver)
+*/
+
+// New builds a cloudsqlconn.Dialer with the options and returns the
+// gopqr.Option that has the driver dial the instance with it, along with a
+// func closing the dialer once the driver is done with it.
+func New(ctx context.Context, instance string, opts ...cloudsqlconn.Option) (gopqr.Option, func() error, error) {
+	dialer, err := cloudsqlconn.NewDialer(ctx, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Building the Cloud SQL dialer failed - %v", err)
+	}
+	return gopqr.WithDialer(Dial(dialer, instance)), dialer.Close, nil
+}
+
+// Dial returns the gopqr.DialFunc that dials the instance, named by its
+// connection name like "project:region:instance", through the dialer,
+// whatever address lib/pq asks for. The options apply to every connection,
+// like cloudsqlconn.WithPrivateIP().
+func Dial(dialer *cloudsqlconn.Dialer, instance string, opts ...cloudsqlconn.DialOption) gopqr.DialFunc {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.Dial(ctx, instance, opts...)
+	}
+}
+
+// DSN returns the DSN of the database of the instance, for a driver dialing
+// with Dial. The host only names the instance in errors and logs, and TLS is
+// left to the dialer.
+func DSN(instance, dbname string) string {
+	return fmt.Sprintf("host='%v' port=5432 dbname='%v' sslmode=disable", quote(instance), quote(dbname))
+}
+
+// quote escapes a value of a key=value DSN for single quotes.
+var quote = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/awssm"
+	"github.com/chandranarreddy/gopqr/providers/vaultkv"
+	"github.com/chandranarreddy/gopqr/rotator"
+	_ "github.com/lib/pq"
+)
+
+/*
+Author: Chandrakanth Narreddy
+gopqr-rotate answers "which credential is live right now?" for the rotating
+credentials of github.com/chandranarreddy/gopqr, without ad hoc psql scripts.
+Passwords are never printed.
+
+Usage:
+	gopqr-rotate inspect SOURCE
+		Prints the credential slots of the secret, which one is active and
+		their versions and expiry.
+	gopqr-rotate test -dsn DSN SOURCE
+		Connects with every credential of the secret and tells which of
+		them authenticate. Exits with status 1 when any does not.
+	gopqr-rotate rotate -dsn DSN -admin-dsn ADMINDSN -secret-file FILE [-scram]
+		Rotates the standby credential with the rotator package - a new
+		password set with ALTER ROLE over ADMINDSN, written back to the file
+		as the active credential - and then verifies it like test does.
+		With -scram, the server is sent the SCRAM-SHA-256 verifier of the
+		password rather than the password.
+
+SOURCE is one of
+	-secret-file FILE
+	-aws-secret-id ID [-aws-region REGION] [-aws-version-stage STAGE]
+	-vault-path PATH [-vault-mount MOUNT]   (VAULT_ADDR, VAULT_TOKEN as usual)
+*/
+
+// source is where the secret is read from.
+type source interface {
+	gopqr.CredentialProvider
+	Fetch(ctx context.Context) (*gopqr.Secret, error)
+}
+
+// sourceFlags are the flags selecting the source of the secret.
+type sourceFlags struct {
+	secretFile      string
+	awsSecretID     string
+	awsRegion       string
+	awsVersionStage string
+	vaultPath       string
+	vaultMount      string
+}
+
+func (f *sourceFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.secretFile, "secret-file", "", "file holding the rotating credentials document")
+	fs.StringVar(&f.awsSecretID, "aws-secret-id", "", "ID of the AWS Secrets Manager secret")
+	fs.StringVar(&f.awsRegion, "aws-region", os.Getenv("AWS_REGION"), "AWS region of the secret")
+	fs.StringVar(&f.awsVersionStage, "aws-version-stage", "", "version stage of the AWS secret, defaults to AWSCURRENT")
+	fs.StringVar(&f.vaultPath, "vault-path", "", "path of the Vault KV version 2 secret")
+	fs.StringVar(&f.vaultMount, "vault-mount", "", "mount of the Vault KV version 2 secrets engine")
+}
+
+func (f *sourceFlags) open() (source, error) {
+	switch {
+	case f.secretFile != "":
+		return &fileSource{path: f.secretFile}, nil
+	case f.awsSecretID != "":
+		return awssm.New(awssm.Config{Region: f.awsRegion, SecretID: f.awsSecretID, VersionStage: f.awsVersionStage})
+	case f.vaultPath != "":
+		return vaultkv.New(vaultkv.Config{Path: f.vaultPath, Mount: f.vaultMount})
+	}
+	return nil, errors.New("no source given, please set -secret-file, -aws-secret-id or -vault-path")
+}
+
+// fileSource reads the rotating credentials document from a file, and
+// writes it back as the SecretStore of the rotator.
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) Fetch(ctx context.Context) (*gopqr.Secret, error) {
+	raw, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return gopqr.ParseSecret(raw)
+}
+
+func (s *fileSource) Current(ctx context.Context) (gopqr.Credentials, error) {
+	secret, err := s.Fetch(ctx)
+	if err != nil {
+		return gopqr.Credentials{}, err
+	}
+	return secret.Credentials(), nil
+}
+
+func (s *fileSource) Refresh(ctx context.Context) error {
+	return nil
+}
+
+func (s *fileSource) Get(ctx context.Context) (*gopqr.Secret, error) {
+	return s.Fetch(ctx)
+}
+
+func (s *fileSource) Put(ctx context.Context, secret *gopqr.Secret) error {
+	raw, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	// the fields of the document gopqr does not know about are kept as
+	// they are
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	rotated, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rotated, &fields); err != nil {
+		return err
+	}
+	for name, value := range fields {
+		if _, ok := doc[name]; ok || string(value) != `""` {
+			doc[name] = value
+		}
+	}
+	if raw, err = json.MarshalIndent(doc, "", "  "); err != nil {
+		return err
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".gopqr-rotate"
+	if err := ioutil.WriteFile(tmp, append(raw, '\n'), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "inspect":
+		os.Exit(inspect(os.Args[2:]))
+	case "test":
+		os.Exit(test(os.Args[2:]))
+	case "rotate":
+		os.Exit(rotate(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gopqr-rotate inspect SOURCE | test -dsn DSN SOURCE | rotate -dsn DSN -admin-dsn ADMINDSN -secret-file FILE")
+	os.Exit(2)
+}
+
+func inspect(args []string) int {
+	var src sourceFlags
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	src.register(fs)
+	fs.Parse(args)
+	s, err := src.open()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	secret, err := s.Fetch(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	creds := secret.Credentials()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SLOT\tUSERNAME\tACTIVE\tVERSION\tVALID UNTIL")
+	for i, c := range creds.Slots {
+		active := ""
+		if i == creds.Active {
+			active = "*"
+		}
+		until := ""
+		if !c.ValidUntil.IsZero() {
+			until = c.ValidUntil.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", c.Name, c.Username, active, c.Version, until)
+	}
+	w.Flush()
+	if creds.Host != "" || creds.Port != "" {
+		fmt.Printf("endpoint overridden to host %q port %q\n", creds.Host, creds.Port)
+	}
+	return 0
+}
+
+func test(args []string) int {
+	var src sourceFlags
+	var dsn string
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.StringVar(&dsn, "dsn", "", "DSN of the target database, without credentials")
+	src.register(fs)
+	fs.Parse(args)
+	if dsn == "" {
+		fmt.Fprintln(os.Stderr, "usage: gopqr-rotate test -dsn DSN SOURCE")
+		return 2
+	}
+	s, err := src.open()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return verify(&gopqr.Driver{Provider: s, Sticky: true}, dsn)
+}
+
+// verify reports which credentials of the driver authenticate.
+func verify(d *gopqr.Driver, dsn string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	report, err := d.ValidateCredentials(ctx, dsn)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SLOT\tACTIVE\tAUTHENTICATES\tTOOK\tERROR")
+	for _, slot := range report.Slots {
+		active, errText := "", ""
+		if slot.Active {
+			active = "*"
+		}
+		if slot.Err != nil {
+			errText = slot.Err.Error()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", slot.Slot, active, slot.Authenticated, slot.Duration.Round(time.Millisecond), errText)
+	}
+	w.Flush()
+	switch {
+	case err != nil && len(report.Slots) == 0:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	case !report.ActiveAuthenticated():
+		fmt.Println("FAIL - the active credential does not authenticate")
+		return 1
+	case err != nil:
+		fmt.Println("FAIL - a standby credential does not authenticate")
+		return 1
+	}
+	fmt.Println("PASS - every credential authenticates")
+	return 0
+}
+
+func rotate(args []string) int {
+	var dsn, adminDSN, secretFile string
+	var useSCRAM bool
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	fs.StringVar(&dsn, "dsn", "", "DSN of the target database, without credentials")
+	fs.StringVar(&adminDSN, "admin-dsn", "", "DSN with the credentials of a role allowed to ALTER ROLE")
+	fs.StringVar(&secretFile, "secret-file", "", "file holding the rotating credentials document, rewritten by the rotation")
+	fs.BoolVar(&useSCRAM, "scram", false, "send the SCRAM-SHA-256 verifier of the new password to the server rather than the password")
+	fs.Parse(args)
+	if dsn == "" || adminDSN == "" || secretFile == "" {
+		fmt.Fprintln(os.Stderr, "usage: gopqr-rotate rotate -dsn DSN -admin-dsn ADMINDSN -secret-file FILE [-scram]")
+		return 2
+	}
+	admin, err := sql.Open("postgres", adminDSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, gopqr.RedactDSN(err.Error()))
+		return 1
+	}
+	defer admin.Close()
+	store := &fileSource{path: secretFile}
+	d := &gopqr.Driver{Provider: store, Sticky: true}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	r := rotator.New(d, admin, store)
+	r.SCRAM = useSCRAM
+	res, err := r.Rotate(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, gopqr.RedactDSN(err.Error()))
+		return 1
+	}
+	fmt.Printf("rotated the password of %v (slot %v), which is now active in place of %v\n", res.Username, res.Rotated, res.Previous)
+	return verify(d, dsn)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/chandranarreddy/gopqr/passwordgen"
+)
+
+// genPassword prints a password generated with the policy of the flags.
+func genPassword(args []string) int {
+	fs := flag.NewFlagSet("genpassword", flag.ExitOnError)
+	length := fs.Int("length", passwordgen.DEFAULTLENGTH, "length of the password")
+	symbols := fs.String("symbols", "", "symbols to draw from as well, like "+passwordgen.SAFESYMBOLS)
+	exclude := fs.String("exclude", "", "characters never to use")
+	noAmbiguous := fs.Bool("no-ambiguous", false, "leave out characters easily mistaken for one another")
+	fs.Parse(args)
+	p := passwordgen.Policy{Length: *length, Symbols: *symbols, Exclude: *exclude}
+	if *noAmbiguous {
+		p.Exclude += passwordgen.AMBIGUOUS
+	}
+	password, err := passwordgen.Generate(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(password)
+	return 0
+}
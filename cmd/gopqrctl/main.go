@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+/*
+Author: Chandrakanth Narreddy
+gopqrctl is the command line companion of github.com/chandranarreddy/gopqr.
+
+Usage:
+	gopqrctl version
+		Prints the version of the driver and how gopqrctl was built.
+	gopqrctl schema
+		Prints the JSON Schema of the rotating credentials document.
+	gopqrctl validate-secret [file]
+		Validates the rotating credentials document in the file, or on the
+		standard input when no file or "-" is given. Exits with status 1 when
+		the document is invalid, which makes it fit for deploy pipelines -
+			aws secretsmanager get-secret-value --secret-id mydb \
+				--query SecretString --output text | gopqrctl validate-secret
+	gopqrctl genpassword [-length 32] [-symbols -_.~] [-no-ambiguous]
+		Prints a password fit for rotated credentials, which needs no
+		escaping in DSNs.
+	gopqrctl soak -dsn DSN -secret-file FILE [-duration 2h] [-qps 50]
+		Opens connections and runs probe queries against the database
+		continuously while an operator performs real rotations, rereading
+		the rotating credentials document from the file. Prints a pass/fail
+		report with the rotation events and an error timeline at the end,
+		or on an interrupt, and exits with status 1 when more probes failed
+		than -max-errors allows.
+*/
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "version":
+		b := gopqr.BuildInfo()
+		fmt.Printf("gopqr %v\nmodule %v\n%v\n", b.Version, b.ModuleVersion, b.GoVersion)
+	case "schema":
+		os.Stdout.Write(gopqr.SecretSchema())
+	case "validate-secret":
+		os.Exit(validateSecret(os.Args[2:]))
+	case "genpassword":
+		os.Exit(genPassword(os.Args[2:]))
+	case "soak":
+		os.Exit(soak(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gopqrctl version | schema | validate-secret [file] | genpassword | soak -dsn DSN -secret-file FILE")
+	os.Exit(2)
+}
+
+func validateSecret(args []string) int {
+	var raw []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		raw, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		raw, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := gopqr.ValidateSecret(raw); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("secret is valid")
+	return 0
+}
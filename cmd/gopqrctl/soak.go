@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+// soakConfig holds the flags of the soak subcommand.
+type soakConfig struct {
+	dsn             string
+	secretFile      string
+	duration        time.Duration
+	qps             int
+	query           string
+	refreshInterval time.Duration
+	maxErrors       int
+	reuse           bool
+}
+
+// soakWindow is a run of consecutive failed probes.
+type soakWindow struct {
+	start, end time.Time
+	failures   int
+	first      error
+}
+
+// soakEvent is a rotation related event of the driver observed while soaking.
+type soakEvent struct {
+	at   time.Time
+	what string
+}
+
+// soakRecorder collects the outcomes of the probes and the driver events.
+type soakRecorder struct {
+	mu       sync.Mutex
+	probes   int
+	failures int
+	windows  []*soakWindow
+	open     *soakWindow
+	events   []soakEvent
+}
+
+func (r *soakRecorder) probe(at time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.probes++
+	if err == nil {
+		r.open = nil
+		return
+	}
+	r.failures++
+	if r.open == nil {
+		r.open = &soakWindow{start: at, first: err}
+		r.windows = append(r.windows, r.open)
+	}
+	r.open.end = at
+	r.open.failures++
+}
+
+func (r *soakRecorder) event(format string, v ...interface{}) {
+	r.mu.Lock()
+	r.events = append(r.events, soakEvent{at: time.Now(), what: fmt.Sprintf(format, v...)})
+	r.mu.Unlock()
+}
+
+// secretFileProvider serves the rotating credentials document kept in a
+// file, which the operator or a secret mount updates during the soak.
+type secretFileProvider struct {
+	path string
+
+	mu      sync.Mutex
+	current *gopqr.Credentials
+}
+
+func (p *secretFileProvider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	c := p.current
+	p.mu.Unlock()
+	if c == nil {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		c = p.current
+		p.mu.Unlock()
+	}
+	return *c, nil
+}
+
+func (p *secretFileProvider) Refresh(ctx context.Context) error {
+	raw, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	s, err := gopqr.ParseSecret(raw)
+	if err != nil {
+		return err
+	}
+	c := s.Credentials()
+	p.mu.Lock()
+	p.current = &c
+	p.mu.Unlock()
+	return nil
+}
+
+func soak(args []string) int {
+	var cfg soakConfig
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	fs.StringVar(&cfg.dsn, "dsn", "", "DSN of the target database, without credentials")
+	fs.StringVar(&cfg.secretFile, "secret-file", "", "file holding the rotating credentials document, reread on every refresh")
+	fs.DurationVar(&cfg.duration, "duration", time.Hour, "how long to soak")
+	fs.IntVar(&cfg.qps, "qps", 10, "probe queries per second")
+	fs.StringVar(&cfg.query, "query", "SELECT 1", "probe query")
+	fs.DurationVar(&cfg.refreshInterval, "refresh-interval", 30*time.Second, "how often the secret file is reread")
+	fs.IntVar(&cfg.maxErrors, "max-errors", 0, "failed probes tolerated before the soak fails")
+	fs.BoolVar(&cfg.reuse, "reuse", false, "reuse pooled connections instead of opening one per probe")
+	fs.Parse(args)
+	if cfg.dsn == "" || cfg.secretFile == "" || cfg.qps <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: gopqrctl soak -dsn DSN -secret-file FILE [-duration 2h] [-qps 50]")
+		return 2
+	}
+
+	rec := &soakRecorder{}
+	d := &gopqr.Driver{
+		Sticky:         true,
+		Provider:       &secretFileProvider{path: cfg.secretFile},
+		OnRotate:       func(from, to string) { rec.event("active credential %v -> %v", from, to) },
+		OnAuthFallback: func(err error) { rec.event("fallback after %v", err) },
+		OnRefreshDone: func(err error) {
+			if err != nil {
+				rec.event("refresh failed - %v", err)
+			}
+		},
+	}
+	stopRefresh := d.StartAutoRefresh(cfg.refreshInterval, 0)
+	defer stopRefresh()
+	db := sql.OpenDB(d.Connector(cfg.dsn))
+	defer db.Close()
+	if !cfg.reuse {
+		// every probe opens a connection of its own so that each one goes
+		// through the rotation logic of the driver
+		db.SetMaxIdleConns(-1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	started := time.Now()
+	fmt.Printf("soaking for %v at %v qps\n", cfg.duration, cfg.qps)
+	ticker := time.NewTicker(time.Second / time.Duration(cfg.qps))
+	defer ticker.Stop()
+	var wg sync.WaitGroup
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case at := <-ticker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pctx, pcancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer pcancel()
+				var v interface{}
+				err := db.QueryRowContext(pctx, cfg.query).Scan(&v)
+				if errors.Is(err, sql.ErrNoRows) {
+					err = nil
+				}
+				rec.probe(at, err)
+			}()
+		}
+	}
+	wg.Wait()
+	return rec.report(os.Stdout, started, cfg.maxErrors)
+}
+
+// report writes the pass/fail report with the error timeline, returning the
+// exit status.
+func (r *soakRecorder) report(w io.Writer, started time.Time, maxErrors int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	passed := r.failures <= maxErrors
+	verdict := "PASS"
+	if !passed {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(w, "\n%v - %v probes, %v failed, over %v\n", verdict, r.probes, r.failures, time.Since(started).Round(time.Second))
+	if len(r.events) > 0 {
+		fmt.Fprintln(w, "\nrotation events:")
+		for _, e := range r.events {
+			fmt.Fprintf(w, "  +%-10v %v\n", e.at.Sub(started).Round(time.Millisecond), e.what)
+		}
+	}
+	if len(r.windows) > 0 {
+		fmt.Fprintln(w, "\nerror timeline:")
+		for _, win := range r.windows {
+			fmt.Fprintf(w, "  +%-10v for %-10v %v failed - %v\n", win.start.Sub(started).Round(time.Millisecond),
+				win.end.Sub(win.start).Round(time.Millisecond), win.failures, win.first)
+		}
+	}
+	if passed {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,218 @@
+package gopqr
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidConfig is matched by errors.Is for the errors of NewDriver.
+var ErrInvalidConfig = errors.New("Invalid driver configuration")
+
+// Config holds the settings NewDriver validates before it builds a driver.
+// The fields mean the same as the fields of Driver of the same name, which
+// can still be set on the returned driver for everything not listed here.
+type Config struct {
+	// OddUsername, OddPassword, EvenUsername and EvenPassword - The odd and
+	// even credential, unless Slots or a Provider is set
+	OddUsername  string
+	OddPassword  string
+	EvenUsername string
+	EvenPassword string
+	// ActiveCredential - "odd"/"even", or the Name of one of the Slots
+	ActiveCredential string
+	// Slots - A ring of credentials in place of the odd and even credential
+	Slots []Credential
+	// Sticky - Keep using the active credential until it fails authentication,
+	// which is what NewDriver does unless Alternating is set
+	Sticky bool
+	// Alternating - Alternate between the credentials on every new
+	// connection, the default of a Driver built by hand, rather than stick
+	// to the active one
+	Alternating bool
+	// RotationPolicy - Decides when the active credential flips in place of Sticky
+	RotationPolicy RotationPolicy
+	// Grace - Never change the active credential on Open, see StartGrace
+	Grace bool
+	// NoFallback - Fail closed when the active credential fails authentication
+	NoFallback bool
+	// TagApplicationName - Append the credential slot to the application_name
+	TagApplicationName bool
+	// KeepReplacedConns - Keep pooled connections of replaced credentials
+	KeepReplacedConns bool
+	// Host, Port and SSLMode - Override the endpoint of the DSN
+	Host    string
+	Port    string
+	SSLMode string
+	// SSLRootCert, SSLCert and SSLKey - Override the TLS settings of the DSN
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// Provider - Source of the credentials, in place of the ones above
+	Provider CredentialProvider
+	// CredentialRefresher - Refreshes the credentials set above
+	//
+	// Deprecated: Set a Provider instead.
+	CredentialRefresher func(*Driver)
+	// PasswordSource - Generates the passwords at connect time, in place of
+	// the passwords above
+	PasswordSource func(hostport, username string) (string, error)
+	// RefreshTimeout - Give up on a refresh taking longer, zero waits forever
+	RefreshTimeout time.Duration
+	// RefreshInterval - Interval of StartAutoRefresh in place of the one it
+	// is started with, see UpdateConfig
+	RefreshInterval time.Duration
+	// AuthFailureCodes - SQLSTATE codes treated as authentication failures
+	AuthFailureCodes []string
+	// CircuitBreaker - Fails Opens fast after repeated authentication failures
+	CircuitBreaker *CircuitBreaker
+	// Logger and EventLogger - Where the driver writes its notices and events
+	Logger      *log.Logger
+	EventLogger *slog.Logger
+	// DSN and Pool - The database and the pool hints Open builds the
+	// *sql.DB with, not used by NewDriver
+	DSN  string
+	Pool PoolConfig
+}
+
+// NewDriver validates the configuration and returns a driver built from it.
+// Unlike setting the fields of a Driver directly, mistakes that would
+// otherwise go unnoticed are reported up front, like an ActiveCredential of
+// "Even", which selects the odd credential forever as names are matched
+// exactly. The error lists every problem found and matches
+// ErrInvalidConfig. The driver is sticky unless the configuration is
+// Alternating.
+func NewDriver(cfg Config) (*Driver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		OddUsername:         cfg.OddUsername,
+		OddPassword:         cfg.OddPassword,
+		EvenUsername:        cfg.EvenUsername,
+		EvenPassword:        cfg.EvenPassword,
+		ActiveCredential:    cfg.ActiveCredential,
+		Slots:               append([]Credential(nil), cfg.Slots...),
+		Sticky:              cfg.sticky(),
+		RotationPolicy:      cfg.RotationPolicy,
+		Grace:               cfg.Grace,
+		NoFallback:          cfg.NoFallback,
+		TagApplicationName:  cfg.TagApplicationName,
+		KeepReplacedConns:   cfg.KeepReplacedConns,
+		Host:                cfg.Host,
+		Port:                cfg.Port,
+		SSLMode:             cfg.SSLMode,
+		SSLRootCert:         cfg.SSLRootCert,
+		SSLCert:             cfg.SSLCert,
+		SSLKey:              cfg.SSLKey,
+		Provider:            cfg.Provider,
+		CredentialRefresher: cfg.CredentialRefresher,
+		PasswordSource:      cfg.PasswordSource,
+		RefreshTimeout:      cfg.RefreshTimeout,
+		AuthFailureCodes:    append([]string(nil), cfg.AuthFailureCodes...),
+		CircuitBreaker:      cfg.CircuitBreaker,
+		Logger:              cfg.Logger,
+		EventLogger:         cfg.EventLogger,
+	}
+	d.refreshInterval.Store(int64(cfg.RefreshInterval))
+	// publish the snapshot of the credentials before the driver is shared
+	d.AcquireLock()
+	d.ReleaseLock()
+	return d, nil
+}
+
+// Validate checks the configuration, returning an error that lists every
+// problem found.
+func (cfg Config) Validate() error {
+	var problems []string
+	credentialsSet := cfg.OddUsername != "" || cfg.OddPassword != "" || cfg.EvenUsername != "" ||
+		cfg.EvenPassword != "" || cfg.ActiveCredential != "" || len(cfg.Slots) > 0
+	switch {
+	case cfg.Provider != nil:
+		if credentialsSet {
+			problems = append(problems, "credentials must not be set along with a Provider, which supplies them")
+		}
+		if cfg.CredentialRefresher != nil {
+			problems = append(problems, "CredentialRefresher must not be set along with a Provider")
+		}
+	case cfg.CredentialRefresher == nil && cfg.PasswordSource == nil:
+		problems = append(problems, "a Provider, CredentialRefresher or PasswordSource is required to refresh the credentials")
+		fallthrough
+	default:
+		problems = append(problems, cfg.credentialProblems()...)
+	}
+	if cfg.Port != "" {
+		if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("Port %q is not a valid port", cfg.Port))
+		}
+	}
+	if cfg.RefreshTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("RefreshTimeout %v must not be negative", cfg.RefreshTimeout))
+	}
+	if cfg.RefreshInterval < 0 {
+		problems = append(problems, fmt.Sprintf("RefreshInterval %v must not be negative", cfg.RefreshInterval))
+	}
+	if cfg.Sticky && cfg.Alternating {
+		problems = append(problems, "Sticky and Alternating must not both be set")
+	}
+	if cfg.SSLMode != "" && !sslModes[cfg.SSLMode] {
+		problems = append(problems, fmt.Sprintf("SSLMode %q is not a valid sslmode", cfg.SSLMode))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w - %v", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// sticky reports whether a driver of the configuration sticks to the
+// active credential, which it does unless Alternating.
+func (cfg Config) sticky() bool {
+	return cfg.Sticky || !cfg.Alternating
+}
+
+// credentialProblems checks the credentials set on the configuration.
+func (cfg Config) credentialProblems() []string {
+	var problems []string
+	needPasswords := cfg.PasswordSource == nil
+	var names []string
+	if len(cfg.Slots) > 0 {
+		seen := make(map[string]bool, len(cfg.Slots))
+		for i, slot := range cfg.Slots {
+			if slot.Name == "" || slot.Username == "" || (needPasswords && slot.PasswordText() == "" && slot.Keytab == "" && !slot.CertOnly) {
+				problems = append(problems, fmt.Sprintf("Slots[%v] needs a Name, Username and Password, Keytab or CertOnly", i))
+			}
+			if slot.CertOnly && (slot.SSLCert == "" || slot.SSLKey == "") {
+				problems = append(problems, fmt.Sprintf("Slots[%v] is CertOnly but lacks its SSLCert or SSLKey", i))
+			}
+			if seen[slot.Name] {
+				problems = append(problems, fmt.Sprintf("Slots[%v] repeats the Name %q", i, slot.Name))
+			}
+			seen[slot.Name] = true
+			names = append(names, slot.Name)
+		}
+	} else {
+		if cfg.OddUsername == "" || cfg.EvenUsername == "" {
+			problems = append(problems, "OddUsername and EvenUsername are required")
+		}
+		if needPasswords && (cfg.OddPassword == "" || cfg.EvenPassword == "") {
+			problems = append(problems, "OddPassword and EvenPassword are required")
+		}
+		names = []string{oddCredential.String(), evenCredential.String()}
+	}
+	for _, name := range names {
+		if cfg.ActiveCredential == name {
+			return problems
+		}
+	}
+	problem := fmt.Sprintf("ActiveCredential %q must be one of %q", cfg.ActiveCredential, names)
+	for _, name := range names {
+		if strings.EqualFold(cfg.ActiveCredential, name) {
+			problem += fmt.Sprintf(", did you mean %q?", name)
+		}
+	}
+	return append(problems, problem)
+}
@@ -0,0 +1,43 @@
+package gopqr_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/testsupport"
+)
+
+func TestNewIsStickyByDefault(t *testing.T) {
+	store := testsupport.NewSecretStore(gopqr.Secret{
+		OddUsername: "app_odd", OddPassword: "odd-pw",
+		EvenUsername: "app_even", EvenPassword: "even-pw",
+		ActiveCredential: "odd",
+	})
+	tests := []struct {
+		name       string
+		opts       []gopqr.Option
+		wantSticky bool
+	}{
+		{"default", nil, true},
+		{"sticky", []gopqr.Option{gopqr.WithSticky()}, true},
+		{"alternating", []gopqr.Option{gopqr.WithAlternating()}, false},
+		{"sticky then alternating", []gopqr.Option{gopqr.WithSticky(), gopqr.WithAlternating()}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := gopqr.New(append([]gopqr.Option{gopqr.WithProvider(store)}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Sticky != tt.wantSticky {
+				t.Errorf("Sticky = %v, want %v", d.Sticky, tt.wantSticky)
+			}
+		})
+	}
+
+	_, err := gopqr.NewDriver(gopqr.Config{Provider: store, Sticky: true, Alternating: true})
+	if !errors.Is(err, gopqr.ErrInvalidConfig) {
+		t.Errorf("NewDriver with Sticky and Alternating = %v, want ErrInvalidConfig", err)
+	}
+}
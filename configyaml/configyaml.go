@@ -0,0 +1,36 @@
+package configyaml
+
+import (
+	"encoding/json"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package configyaml has gopqr.LoadConfig read configuration files ending in
+".yaml" or ".yml" as YAML, with gopkg.in/yaml.v3. The fields are named like
+those of the JSON files. It lives apart from the driver so that the driver
+does not depend on a YAML library.
+
+Usage:
+	import _ "github.com/chandranarreddy/gopqr/configyaml"
+	...
+	cfg, err := gopqr.LoadConfig("/etc/myapp/db.yaml")
+*/
+
+func init() {
+	gopqr.RegisterConfigFormat(".yaml", toJSON)
+	gopqr.RegisterConfigFormat(".yml", toJSON)
+}
+
+// toJSON converts a YAML document to JSON.
+func toJSON(raw []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
@@ -0,0 +1,48 @@
+package gopqr_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+	"github.com/chandranarreddy/gopqr/testsupport"
+)
+
+// testDriver returns a driver rotating app_odd and app_even on the backend,
+// with the odd credential active, and the store it reads them from.
+func testDriver(backend *gopqrtest.Backend) (*gopqr.Driver, *testsupport.SecretStore) {
+	store := testsupport.NewSecretStore(gopqr.Secret{
+		OddUsername:      "app_odd",
+		OddPassword:      "odd-pw",
+		EvenUsername:     "app_even",
+		EvenPassword:     "even-pw",
+		ActiveCredential: "odd",
+	})
+	return &gopqr.Driver{Provider: store, Backend: backend}, store
+}
+
+func BenchmarkOpen(b *testing.B) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	d, _ := testDriver(backend)
+	const dsn = "postgres://db.internal:5432/mydb?sslmode=disable"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		conn, err := d.Open(dsn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn.Close()
+	}
+}
+
+func TestPasswordSourceErrorIsWrapped(t *testing.T) {
+	errNoToken := errors.New("no token")
+	backend := gopqrtest.NewBackend()
+	d, _ := testDriver(backend)
+	d.PasswordSource = func(hostport, username string) (string, error) { return "", errNoToken }
+	if _, err := d.Open(testDSN); !errors.Is(err, errNoToken) {
+		t.Errorf("Open = %v, want the error of the PasswordSource wrapped", err)
+	}
+}
@@ -0,0 +1,164 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	nurl "net/url"
+	"sort"
+	"sync/atomic"
+)
+
+// Connector binds the driver to the DSN so that a *sql.DB can be built with
+// sql.OpenDB without registering the driver under a name -
+//
+//	db := sql.OpenDB(pqrDriver.Connector(dsn))
+//
+// Connections made by the connector honor the context passed to them.
+func (d *Driver) Connector(dsn string) driver.Connector {
+	return &connector{d: d, dsn: dsn}
+}
+
+// OpenDB opens a database over the driver without registering it with
+// sql.Register, so that packages of the same binary cannot clash over a
+// driver name -
+//
+//	db := gopqr.OpenDB(dsn, pqrDriver)
+//
+// Like sql.OpenDB, no connection is made until one is needed.
+func OpenDB(dsn string, d *Driver) *sql.DB {
+	return sql.OpenDB(d.Connector(dsn))
+}
+
+// ConnectorConfig - What sets the connections of a connector apart from the
+// other connections of the driver, so that one driver serves pools with
+// different roles in the same process
+type ConnectorConfig struct {
+	// Slot - Slot the connector tries first, whatever the active
+	// credential. The others are still tried when it fails authentication.
+	// Ignored when the driver has no such slot or GOPQR_FORCE_SLOT is set.
+	Slot string
+	// RotationPolicy - Consulted by the connections of the connector
+	// instead of the RotationPolicy of the driver
+	RotationPolicy RotationPolicy
+	// Settings - Run-time parameters of the server set on every session of
+	// the connector, like "default_transaction_read_only" or
+	// "statement_timeout". They are added to the DSN, which lib/pq sends as
+	// startup parameters.
+	Settings map[string]string
+}
+
+// ConnectorWith is Connector with the slot, RotationPolicy and session
+// settings of the config -
+//
+//	reporting := sql.OpenDB(pqrDriver.ConnectorWith(dsn, gopqr.ConnectorConfig{
+//		Slot:     "replica",
+//		Settings: map[string]string{"statement_timeout": "5min"},
+//	}))
+func (d *Driver) ConnectorWith(dsn string, cfg ConnectorConfig) driver.Connector {
+	cfg.Settings = copyExtra(cfg.Settings)
+	return &connector{d: d, dsn: dsn, cfg: &cfg}
+}
+
+// ReadOnlyConnector is a Connector whose sessions default to read only
+// transactions, for the pools of read paths.
+func (d *Driver) ReadOnlyConnector(dsn string) driver.Connector {
+	return d.ConnectorWith(dsn, ConnectorConfig{
+		Settings: map[string]string{"default_transaction_read_only": "on"},
+	})
+}
+
+// MigrationConnector is a Connector for schema migrations, which stays on
+// the active credential until it fails authentication so that a migration
+// does not flip between roles, whatever the RotationPolicy of the driver.
+func (d *Driver) MigrationConnector(dsn string) driver.Connector {
+	return d.ConnectorWith(dsn, ConnectorConfig{RotationPolicy: OnAuthFailure()})
+}
+
+// OpenConnector implements driver.DriverContext, so that every database
+// sql.Open opens over the driver gets a connector of its own, whose
+// connections ForceReconnect retires.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	return d.Connector(dsn), nil
+}
+
+// connector binds the driver to a DSN.
+type connector struct {
+	d   *Driver
+	dsn string
+	cfg *ConnectorConfig
+	// reconnects - ForceReconnects of the database of the connector
+	reconnects atomic.Uint64
+}
+
+// Connect implements driver.Connector.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	epoch := c.reconnects.Load()
+	conn, err := c.d.openContext(ctx, c.dsn, c.cfg)
+	if rc, ok := conn.(*rotatingConn); ok {
+		rc.reconnects, rc.epoch = &c.reconnects, epoch
+	}
+	return conn, err
+}
+
+// Driver implements driver.Connector.
+func (c *connector) Driver() driver.Driver {
+	return c.d
+}
+
+// slot returns the name of the slot to try first and why it was chosen.
+func (c *ConnectorConfig) slot(s *snapshot) (string, string) {
+	active := s.activeSlot()
+	if forced := flags().forceSlot; forced != "" && active == forced {
+		return active, ENVFORCESLOT
+	}
+	if c != nil && c.Slot != "" {
+		for _, cred := range s.ring {
+			if cred.Name == c.Slot {
+				return c.Slot, "connector"
+			}
+		}
+	}
+	return active, "active credential"
+}
+
+// policy returns the RotationPolicy the connections of the connector
+// consult.
+func (c *ConnectorConfig) policy(d *Driver) RotationPolicy {
+	if c != nil && c.RotationPolicy != nil && !flags().disableRotation && !d.InGrace() {
+		return c.RotationPolicy
+	}
+	return d.policy()
+}
+
+// withSettings adds the Settings to the DSN.
+func (c *ConnectorConfig) withSettings(dsn string) (string, error) {
+	if c == nil || len(c.Settings) == 0 {
+		return dsn, nil
+	}
+	keys := make([]string, 0, len(c.Settings))
+	for k := range c.Settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if isURLDSN(dsn) {
+		u, err := nurl.Parse(dsn)
+		if err != nil {
+			return "", ErrInvalidDSN
+		}
+		q := u.Query()
+		for _, k := range keys {
+			q.Set(k, c.Settings[k])
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	settings, err := parseKeyValueDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range keys {
+		settings = setDSN(settings, k, c.Settings[k])
+	}
+	return formatKeyValueDSN(settings), nil
+}
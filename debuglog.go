@@ -0,0 +1,119 @@
+package gopqr
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	nurl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULTDEBUGOPENINTERVAL - Least time between two lines of the debug log
+// of Open, lines in between are counted and dropped
+const DEFAULTDEBUGOPENINTERVAL = time.Second
+
+// debugLimiter drops the debug lines of Open written too close together.
+type debugLimiter struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// allow reports whether a line may be written now and how many lines were
+// dropped since the last one written.
+func (l *debugLimiter) allow(interval time.Duration) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() && now.Sub(l.last) < interval {
+		l.suppressed++
+		return false, 0
+	}
+	suppressed := l.suppressed
+	l.last, l.suppressed = now, 0
+	return true, suppressed
+}
+
+// debugOpens reports whether Open logs its decisions, which DebugOpens or
+// GOPQR_DEBUG turn on.
+func (d *Driver) debugOpens() bool {
+	return d.DebugOpens || flags().debug
+}
+
+// debugOpen logs the slot Open tried first and why, the host it connected
+// to and the outcome, at most once every DebugOpenInterval. It names slots
+// and hosts only, and the error is redacted like all the output of the
+// driver.
+func (d *Driver) debugOpen(dsn, slot, reason string, used OpenOutcome, err error) {
+	interval := d.DebugOpenInterval
+	if interval == 0 {
+		interval = DEFAULTDEBUGOPENINTERVAL
+	}
+	ok, suppressed := d.debugLimit.allow(interval)
+	if !ok {
+		return
+	}
+	outcome := "opened"
+	switch {
+	case err != nil:
+		outcome = "failed"
+	case used.Fallback:
+		outcome = "opened on fallback"
+	}
+	args := []interface{}{"slot", slot, "reason", reason, "host", d.dsnHost(dsn), "outcome", outcome}
+	if used.Slot != "" {
+		args = append(args, "used", used.Slot)
+	}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	if d.EventLogger != nil {
+		d.EventLogger.Log(context.Background(), slog.LevelInfo, "gopqr: open", redactArgs(args)...)
+		return
+	}
+	var line strings.Builder
+	for i := 0; i < len(args); i += 2 {
+		fmt.Fprintf(&line, " %v=%v", args[i], args[i+1])
+	}
+	d.logf("open%v", line.String())
+}
+
+// dsnHost returns the host the DSN connects to, with the Host and Port
+// overrides of the driver applied.
+func (d *Driver) dsnHost(dsn string) string {
+	host, port := "", ""
+	if isURLDSN(dsn) {
+		if u, err := nurl.Parse(dsn); err == nil {
+			host, port = u.Hostname(), u.Port()
+		}
+	} else if settings, err := parseKeyValueDSN(dsn); err == nil {
+		for _, s := range settings {
+			switch s.key {
+			case "host":
+				host = s.value
+			case "port":
+				port = s.value
+			}
+		}
+	}
+	if s := d.current(); s.host != "" || s.port != "" {
+		if s.host != "" {
+			host = s.host
+		}
+		if s.port != "" {
+			port = s.port
+		}
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if port != "" {
+		return host + ":" + port
+	}
+	return host
+}
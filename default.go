@@ -0,0 +1,104 @@
+package gopqr
+
+import (
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoDefault is returned by the package level functions when no default
+// driver was set with SetDefault.
+var ErrNoDefault = errors.New("No default driver is set, please call gopqr.SetDefault")
+
+var defaultDriver atomic.Pointer[Driver]
+
+// DriverStatus is a snapshot of what the driver is doing, for health
+// endpoints and dashboards.
+type DriverStatus struct {
+	RotationState
+	// LastRefresh - When the credentials were last refreshed successfully,
+	// the zero time if they never were
+	LastRefresh time.Time
+	// RefreshQueueDepth - Opens waiting for a refresh in flight
+	RefreshQueueDepth int
+	// RiskyFeaturesAllowed - Whether the error budget of the driver allows
+	// its optional risky features
+	RiskyFeaturesAllowed bool
+	// EnvFlags - The GOPQR_* environment variables in effect
+	EnvFlags map[string]string
+	// ActiveVersion and ActiveValidUntil - The metadata of the active
+	// credential, when known, telling which version of the secret is live
+	ActiveVersion    string
+	ActiveValidUntil time.Time
+	// NoFallback - Whether Open fails closed rather than fall back to the
+	// other credentials
+	NoFallback bool
+	// PinnedSlot - The slot new connections are pinned to with PinSlot, if
+	// any
+	PinnedSlot string
+	// Version - Semantic version of the driver, as returned by Version
+	Version string
+	// Policy - The RotationPolicy in effect, like "per_open" or
+	// "on_auth_failure", or "custom" for policies of the application
+	Policy string
+}
+
+// Status returns a snapshot of what the driver is doing.
+func (d *Driver) Status() DriverStatus {
+	s := DriverStatus{
+		RotationState:        d.rotationState(),
+		LastRefresh:          d.LastRefresh(),
+		RefreshQueueDepth:    d.RefreshQueueDepth(),
+		RiskyFeaturesAllowed: d.RiskyFeaturesAllowed(),
+		EnvFlags:             EnvFlags(),
+		NoFallback:           d.noFallback(),
+		PinnedSlot:           d.PinnedSlot(),
+		Version:              version,
+		Policy:               policyName(d.policy()),
+	}
+	snap := d.current()
+	active := snap.ring[slotIndex(snap.ring, snap.activeSlot())]
+	s.ActiveVersion, s.ActiveValidUntil = active.Version, active.ValidUntil
+	return s
+}
+
+// SetDefault makes the driver the default one used by the package level
+// Open, Refresh and Status, for small applications with one database.
+// Everything else should hold on to its driver instead.
+func SetDefault(d *Driver) {
+	defaultDriver.Store(d)
+}
+
+// Default returns the driver set with SetDefault, or nil.
+func Default() *Driver {
+	return defaultDriver.Load()
+}
+
+// Open opens a database over the default driver. The DSN carries no
+// credentials, like for the Open of the driver.
+func Open(dsn string) (*sql.DB, error) {
+	d := Default()
+	if d == nil {
+		return nil, ErrNoDefault
+	}
+	return OpenDB(dsn, d), nil
+}
+
+// Refresh refreshes the credentials of the default driver.
+func Refresh() error {
+	d := Default()
+	if d == nil {
+		return ErrNoDefault
+	}
+	return d.Refresh()
+}
+
+// Status returns a snapshot of what the default driver is doing.
+func Status() (DriverStatus, error) {
+	d := Default()
+	if d == nil {
+		return DriverStatus{}, ErrNoDefault
+	}
+	return d.Status(), nil
+}
@@ -0,0 +1,31 @@
+package gopqr
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DialFunc adapts a context aware dial func to the pq.Dialer of the Dialer
+// of the driver, like the DialContext of a *net.Dialer with settings of its
+// own, of a SOCKS proxy of golang.org/x/net/proxy or of an SSH client -
+//
+//	pqrDriver.Dialer = gopqr.DialFunc(sshClient.DialContext)
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Dial dials the address.
+func (f DialFunc) Dial(network, address string) (net.Conn, error) {
+	return f(context.Background(), network, address)
+}
+
+// DialTimeout dials the address, giving up after the timeout.
+func (f DialFunc) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return f(ctx, network, address)
+}
+
+// DialContext dials the address, giving up when ctx is done.
+func (f DialFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
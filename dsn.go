@@ -0,0 +1,365 @@
+package gopqr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	nurl "net/url"
+	"strings"
+	"unicode"
+)
+
+// ErrCredentialsInDSN is returned by Open when the DSN carries a username or
+// password. The driver supplies the credentials, and ones in the DSN would
+// otherwise be dropped silently, making for confusing authentication
+// failures.
+var ErrCredentialsInDSN = errors.New("Credentials must not be passed in the DSN")
+
+// DSNCredentialPolicy is what Open does with credentials embedded in the DSN.
+type DSNCredentialPolicy int
+
+const (
+	// RejectDSNCredentials - Open fails with ErrCredentialsInDSN
+	RejectDSNCredentials DSNCredentialPolicy = iota
+	// FallbackToDSNCredentials - The credential in the DSN is tried as the
+	// last resort, after every credential of the driver failed authentication
+	FallbackToDSNCredentials
+)
+
+// dsnCredential is the name of the slot holding the credential of the DSN.
+const dsnCredential = "dsn"
+
+// checkDSNCredentials looks for a credential embedded in the DSN. It is
+// rejected, or returned to be used as the last fallback, as per the
+// DSNCredentials policy of the driver.
+func (d *Driver) checkDSNCredentials(dsn string) (*Credential, error) {
+	p := d.parseDSN(dsn)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.embedded == nil {
+		return nil, nil
+	}
+	if d.DSNCredentials != FallbackToDSNCredentials {
+		return nil, ErrCredentialsInDSN
+	}
+	cred := *p.embedded
+	return &cred, nil
+}
+
+// isURLDSN reports whether the DSN is a URL rather than a libpq key=value
+// string like "host=db1 dbname=app sslmode=verify-full". The scheme is case
+// insensitive, as it is to lib/pq.
+func isURLDSN(dsn string) bool {
+	return hasPrefixFold(dsn, "postgres://") || hasPrefixFold(dsn, "postgresql://")
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// isUnixSocket reports whether the host is the directory of a unix socket
+// rather than a hostname or address.
+func isUnixSocket(host string) bool {
+	return strings.HasPrefix(host, "/") || strings.HasPrefix(host, "@")
+}
+
+// queryHostURLDSN rewrites a URL DSN whose host lib/pq cannot take as it
+// is into the postgres:///app?host=...&port=... form, which it can. These
+// are a percent encoded socket directory, like
+// postgres://%2Fvar%2Frun%2Fpostgresql/app, which Go does not parse, and a
+// list of hosts for client side failover, like
+// postgres://h1:5432,h2:5433/app, which lib/pq would take for a single host.
+// Other DSNs are returned as they are.
+func queryHostURLDSN(dsn string) string {
+	i := strings.Index(dsn, "://") + 3
+	end := strings.IndexAny(dsn[i:], "/?#")
+	if end < 0 {
+		end = len(dsn) - i
+	}
+	authority := dsn[i : i+end]
+	userinfo, hostport := "", authority
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		userinfo, hostport = authority[:at+1], authority[at+1:]
+	}
+	var hosts, ports []string
+	switch {
+	case hasPrefixFold(hostport, "%2F"):
+		host, port := hostport, ""
+		if c := strings.LastIndex(hostport, ":"); c >= 0 && validPort(hostport[c+1:]) {
+			host, port = hostport[:c], hostport[c+1:]
+		}
+		socket, err := nurl.PathUnescape(host)
+		if err != nil {
+			return dsn
+		}
+		hosts = []string{socket}
+		if port != "" {
+			ports = []string{port}
+		}
+	case strings.Contains(hostport, ","):
+		anyPort := false
+		for _, hp := range strings.Split(hostport, ",") {
+			host, port := hp, ""
+			if h, p, err := net.SplitHostPort(hp); err == nil {
+				host, port = h, p
+				anyPort = true
+			}
+			hosts = append(hosts, strings.Trim(host, "[]"))
+			ports = append(ports, port)
+		}
+		if !anyPort {
+			ports = nil
+		}
+		for k := range ports {
+			if ports[k] == "" {
+				ports[k] = "5432"
+			}
+		}
+	default:
+		return dsn
+	}
+	rest := dsn[i+end:]
+	query := "host=" + nurl.QueryEscape(strings.Join(hosts, ","))
+	if len(ports) > 0 {
+		query += "&port=" + nurl.QueryEscape(strings.Join(ports, ","))
+	}
+	switch q, f := strings.Index(rest, "?"), strings.Index(rest, "#"); {
+	case q >= 0:
+		rest = rest[:q+1] + query + "&" + rest[q+1:]
+	case f >= 0:
+		rest = rest[:f] + "?" + query + rest[f:]
+	default:
+		rest += "?" + query
+	}
+	return dsn[:i] + userinfo + rest
+}
+
+// validPorts reports whether the text is a port number, or a comma
+// separated list of them for a list of hosts.
+func validPorts(text string) bool {
+	for {
+		port, rest, more := strings.Cut(text, ",")
+		if !validPort(strings.TrimSpace(port)) {
+			return false
+		}
+		if !more {
+			return true
+		}
+		text = rest
+	}
+}
+
+// firstOfList returns the first of a comma separated list of hosts or ports.
+func firstOfList(list string) string {
+	first, _, _ := strings.Cut(list, ",")
+	return strings.TrimSpace(first)
+}
+
+// dialAddress is the address the credential is used against, for the
+// PasswordSource - host:port, or the socket directory for a unix socket. Of
+// a list of hosts for failover, it is the first.
+func dialAddress(host, port string) string {
+	host, port = strings.Trim(firstOfList(host), "[]"), firstOfList(port)
+	if isUnixSocket(host) {
+		return host
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "5432"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// dsnSetting is one key=value pair of a DSN.
+type dsnSetting struct {
+	key   string
+	value string
+}
+
+// parseKeyValueDSN splits a libpq key=value DSN into its settings, the way
+// lib/pq reads it. Values may be single quoted, and a backslash escapes the
+// character following it.
+func parseKeyValueDSN(dsn string) ([]dsnSetting, error) {
+	var settings []dsnSetting
+	r := []rune(dsn)
+	i := 0
+	skipSpace := func() {
+		for i < len(r) && unicode.IsSpace(r[i]) {
+			i++
+		}
+	}
+	for {
+		skipSpace()
+		if i >= len(r) {
+			return settings, nil
+		}
+		start := i
+		for i < len(r) && r[i] != '=' && !unicode.IsSpace(r[i]) {
+			i++
+		}
+		key := string(r[start:i])
+		skipSpace()
+		if key == "" || i >= len(r) || r[i] != '=' {
+			return nil, fmt.Errorf("%w - expected key=value", ErrInvalidDSN)
+		}
+		i++
+		skipSpace()
+		var value []rune
+		if i < len(r) && r[i] == '\'' {
+			i++
+			for {
+				if i >= len(r) {
+					return nil, fmt.Errorf("%w - unterminated quoted value", ErrInvalidDSN)
+				}
+				if r[i] == '\'' {
+					i++
+					break
+				}
+				if r[i] == '\\' {
+					i++
+					if i >= len(r) {
+						return nil, fmt.Errorf("%w - unterminated quoted value", ErrInvalidDSN)
+					}
+				}
+				value = append(value, r[i])
+				i++
+			}
+		} else {
+			for i < len(r) && !unicode.IsSpace(r[i]) {
+				if r[i] == '\\' {
+					i++
+					if i >= len(r) {
+						break
+					}
+				}
+				value = append(value, r[i])
+				i++
+			}
+		}
+		settings = append(settings, dsnSetting{key: key, value: string(value)})
+	}
+}
+
+// formatKeyValueDSN joins the settings into a key=value DSN, quoting every
+// value so that passwords with spaces, quotes or backslashes survive.
+func formatKeyValueDSN(settings []dsnSetting) string {
+	var b strings.Builder
+	// the quotes and the separators, escapes are rare
+	size := 0
+	for _, s := range settings {
+		size += len(s.key) + len(s.value) + 4
+	}
+	b.Grow(size)
+	for i, s := range settings {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s.key)
+		b.WriteString("='")
+		for _, c := range s.value {
+			if c == '\'' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(c)
+		}
+		b.WriteByte('\'')
+	}
+	return b.String()
+}
+
+// setDSN replaces the value of the key among the settings, appending it when
+// it is not there.
+func setDSN(settings []dsnSetting, key, value string) []dsnSetting {
+	for i := range settings {
+		if settings[i].key == key {
+			settings[i].value = value
+			return settings
+		}
+	}
+	return append(settings, dsnSetting{key: key, value: value})
+}
+
+// unsetDSN removes the key from the settings.
+func unsetDSN(settings []dsnSetting, key string) []dsnSetting {
+	for i := range settings {
+		if settings[i].key == key {
+			return append(settings[:i], settings[i+1:]...)
+		}
+	}
+	return settings
+}
+
+// getDSN returns the value of the key among the settings.
+func getDSN(settings []dsnSetting, key string) string {
+	for _, s := range settings {
+		if s.key == key {
+			return s.value
+		}
+	}
+	return ""
+}
+
+// keyValueDSNWith is dsnWith for libpq key=value DSNs, injecting user= and
+// password= along with the endpoint overrides.
+func (d *Driver) keyValueDSNWith(dsn string, cred Credential) (string, error) {
+	p := d.parseDSN(dsn)
+	if p.err != nil {
+		return "", p.err
+	}
+	// room for the settings added below, so that they do not grow it again
+	settings := append(make([]dsnSetting, 0, len(p.settings)+8), p.settings...)
+	params, err := paramsFor(cred)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range params {
+		settings = setDSN(settings, s.key, s.value)
+	}
+	host, port, sslmode := d.endpointFor(cred)
+	if host != "" {
+		// libpq takes IPv6 addresses bare in key=value DSNs
+		settings = setDSN(settings, "host", strings.Trim(host, "[]"))
+	}
+	if port != "" {
+		settings = setDSN(settings, "port", port)
+	}
+	if sslmode != "" {
+		settings = setDSN(settings, "sslmode", sslmode)
+	}
+	host, port = getDSN(settings, "host"), getDSN(settings, "port")
+	if port != "" && !validPorts(port) {
+		return "", fmt.Errorf("%w - port %q is not a number", ErrInvalidDSN, port)
+	}
+	user, password, err := d.credentialFor(cred, dialAddress(host, port))
+	if err != nil {
+		return "", err
+	}
+	get := func(k string) string { return getDSN(settings, k) }
+	certs, err := d.tlsSettings(cred, get)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range certs {
+		settings = setDSN(settings, s.key, s.value)
+	}
+	gss, err := d.gssSettings(cred, dialAddress(host, port), get)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range gss {
+		settings = setDSN(settings, s.key, s.value)
+	}
+	if key, name := d.applicationNameFor(get, cred.Name); key != "" {
+		settings = setDSN(settings, key, name)
+	}
+	settings = setDSN(settings, "user", user)
+	if cred.CertOnly {
+		settings = unsetDSN(settings, "password")
+	} else {
+		settings = setDSN(settings, "password", password)
+	}
+	return formatKeyValueDSN(settings), nil
+}
@@ -0,0 +1,218 @@
+package gopqr
+
+import (
+	"container/list"
+	"fmt"
+	nurl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DEFAULTDSNCACHESIZE - Distinct DSNs a driver keeps parsed, DSNs beyond it
+// are parsed on every Open. Each keeps up to as many of its queries encoded.
+const DEFAULTDSNCACHESIZE = 64
+
+// parsedDSN is a DSN as parsed once, so that Open only substitutes the
+// credentials rather than parse the DSN and encode its query every time.
+// Nothing in it is modified after parsing, but for its queries.
+type parsedDSN struct {
+	err error
+	// embedded - The credential carried by the DSN, if any
+	embedded *Credential
+	isURL    bool
+	// host, hostname, port, path, rawPath and query - The parts of a URL
+	// DSN, rawPath keeping escapes like %2F that path decodes
+	host     string
+	hostname string
+	port     string
+	path     string
+	rawPath  string
+	query    nurl.Values
+	// queries - The encoded queries of a URL DSN by the settings set on it
+	queries queryCache
+	// settings - The settings of a key=value DSN
+	settings []dsnSetting
+}
+
+// dsnCache holds the parsed DSNs of a driver.
+type dsnCache struct {
+	m  sync.Map
+	mu sync.Mutex
+	n  int
+}
+
+// parseDSN returns the DSN parsed, from the cache of the driver when it was
+// parsed before.
+func (d *Driver) parseDSN(dsn string) *parsedDSN {
+	if p, ok := d.dsns.m.Load(dsn); ok {
+		return p.(*parsedDSN)
+	}
+	p := parseDSN(dsn)
+	d.dsns.mu.Lock()
+	if d.dsns.n < DEFAULTDSNCACHESIZE {
+		if _, loaded := d.dsns.m.LoadOrStore(dsn, p); !loaded {
+			d.dsns.n++
+		}
+	}
+	d.dsns.mu.Unlock()
+	return p
+}
+
+// drop drops the queries encoded with the settings of the snapshot of the
+// generation, which may hold the inline client key of a retired credential.
+func (c *dsnCache) drop(gen uint64) {
+	c.m.Range(func(_, p interface{}) bool {
+		p.(*parsedDSN).queries.drop(gen)
+		return true
+	})
+}
+
+// queryCache keeps the most recently used DEFAULTDSNCACHESIZE encoded
+// queries of a URL DSN, by the generation of the snapshot whose settings
+// they carry and the settings, so that rotated client certificates passed
+// inline neither grow it without bound nor stay in it once retired.
+type queryCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru - The entries, the most recently used first
+	lru list.List
+}
+
+type queryEntry struct {
+	key     string
+	gen     uint64
+	encoded string
+}
+
+func (c *queryCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*queryEntry).encoded, true
+}
+
+// put keeps the query encoded with the settings of the snapshot, unless the
+// snapshot was retired meanwhile, evicting the least recently used one when
+// full.
+func (c *queryCache) put(s *snapshot, key, encoded string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// sweep retires the snapshot before dropping its queries, so that one
+	// kept after the check below is dropped along with them
+	if s.retired.Load() {
+		return
+	}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+	c.entries[key] = c.lru.PushFront(&queryEntry{key: key, gen: s.gen, encoded: encoded})
+	if c.lru.Len() > DEFAULTDSNCACHESIZE {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*queryEntry).key)
+	}
+}
+
+func (c *queryCache) drop(gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if entry := e.Value.(*queryEntry); entry.gen == gen {
+			c.lru.Remove(e)
+			delete(c.entries, entry.key)
+		}
+		e = next
+	}
+}
+
+func (c *queryCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func parseDSN(dsn string) *parsedDSN {
+	p := &parsedDSN{isURL: isURLDSN(dsn)}
+	if !p.isURL {
+		p.settings, p.err = parseKeyValueDSN(dsn)
+		if p.err != nil {
+			return p
+		}
+		user, password := getDSN(p.settings, "user"), getDSN(p.settings, "password")
+		if user != "" || password != "" {
+			p.embedded = &Credential{Name: dsnCredential, Username: user, Password: password}
+		}
+		return p
+	}
+	u, err := nurl.Parse(queryHostURLDSN(dsn))
+	if err != nil {
+		p.err = ErrInvalidDSN
+		return p
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		p.embedded = &Credential{Name: dsnCredential, Username: u.User.Username(), Password: password}
+	}
+	if u.Opaque != "" || u.Fragment != "" {
+		p.err = fmt.Errorf("%w - a URL DSN takes the form postgres://host:port/dbname?key=value", ErrInvalidDSN)
+		return p
+	}
+	if u.Port() != "" && !validPort(u.Port()) {
+		p.err = fmt.Errorf("%w - port %q is not a number", ErrInvalidDSN, u.Port())
+		return p
+	}
+	// url.Query drops the pairs it cannot decode, which would go missing
+	// from every DSN built on this one
+	if p.query, err = nurl.ParseQuery(u.RawQuery); err != nil {
+		p.err = fmt.Errorf("%w - %v", ErrInvalidDSN, err)
+		return p
+	}
+	if port := p.query.Get("port"); port != "" && !validPorts(port) {
+		p.err = fmt.Errorf("%w - port %q is not a number", ErrInvalidDSN, port)
+		return p
+	}
+	p.host, p.hostname, p.port, p.path, p.rawPath = u.Host, u.Hostname(), u.Port(), u.Path, u.RawPath
+	return p
+}
+
+// encodedQuery returns the query of a URL DSN encoded, with the settings of
+// the snapshot set on it.
+func (p *parsedDSN) encodedQuery(snap *snapshot, settings []dsnSetting) string {
+	var key strings.Builder
+	size := 20
+	for _, s := range settings {
+		size += len(s.key) + len(s.value) + 2
+	}
+	key.Grow(size)
+	key.WriteString(strconv.FormatUint(snap.gen, 10))
+	key.WriteByte(0)
+	for _, s := range settings {
+		key.WriteString(s.key)
+		key.WriteByte(0)
+		key.WriteString(s.value)
+		key.WriteByte(0)
+	}
+	cacheKey := key.String()
+	if q, ok := p.queries.get(cacheKey); ok {
+		return q
+	}
+	q := make(nurl.Values, len(p.query)+len(settings))
+	for k, v := range p.query {
+		q[k] = v
+	}
+	for _, s := range settings {
+		q.Set(s.key, s.value)
+	}
+	encoded := q.Encode()
+	p.queries.put(snap, cacheKey, encoded)
+	return encoded
+}
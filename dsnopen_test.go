@@ -0,0 +1,146 @@
+package gopqr_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+	"github.com/chandranarreddy/gopqr/testsupport"
+	"github.com/lib/pq"
+)
+
+// recordingBackend is a gopqrtest.Backend noting the DSNs the driver opens
+// connections with.
+type recordingBackend struct {
+	*gopqrtest.Backend
+	mu   sync.Mutex
+	dsns []string
+}
+
+func (b *recordingBackend) Open(dsn string) (driver.Conn, error) {
+	b.mu.Lock()
+	b.dsns = append(b.dsns, dsn)
+	b.mu.Unlock()
+	return b.Backend.Open(dsn)
+}
+
+// opened opens a connection with the DSN and returns the config lib/pq
+// parses from the DSN the driver generated for it.
+func opened(t *testing.T, d *gopqr.Driver, dsn string) pq.Config {
+	t.Helper()
+	backend := &recordingBackend{Backend: gopqrtest.NewBackend()}
+	for _, slot := range d.Snapshot().Slots {
+		backend.Allow(slot.Username, slot.Password)
+	}
+	d.Backend = backend
+	conn, err := d.Open(dsn)
+	if err != nil {
+		t.Fatalf("Open(%q) failed - %v", dsn, err)
+	}
+	conn.Close()
+	generated := backend.dsns[len(backend.dsns)-1]
+	cfg, err := pq.NewConfig(generated)
+	if err != nil {
+		t.Fatalf("Open(%q) generated %q, which lib/pq cannot parse - %v", dsn, gopqr.RedactDSN(generated), err)
+	}
+	return cfg
+}
+
+// secretDriver returns a driver serving the secret, primed so that the
+// credentials are installed.
+func secretDriver(t *testing.T, secret gopqr.Secret) *gopqr.Driver {
+	t.Helper()
+	d := &gopqr.Driver{Provider: testsupport.NewSecretStore(secret)}
+	if err := d.Refresh(); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	return d
+}
+
+var quotedSecret = gopqr.Secret{
+	OddUsername:      "app_odd",
+	OddPassword:      `it's a p@ss\word`,
+	EvenUsername:     "app_even",
+	EvenPassword:     "even-pw",
+	ActiveCredential: "odd",
+}
+
+func TestOpenKeyValueDSN(t *testing.T) {
+	d := secretDriver(t, quotedSecret)
+	cfg := opened(t, d, "host=db.internal port=5433 dbname='my db' sslmode=disable connect_timeout=5")
+	if cfg.User != "app_odd" || cfg.Password != quotedSecret.OddPassword {
+		t.Errorf("credentials = %q/%q, want app_odd and the password as is", cfg.User, cfg.Password)
+	}
+	if cfg.Host != "db.internal" || cfg.Port != 5433 || cfg.Database != "my db" {
+		t.Errorf("endpoint = %v:%v/%v, want db.internal:5433/my db", cfg.Host, cfg.Port, cfg.Database)
+	}
+}
+
+func TestOpenUnixSocketDSN(t *testing.T) {
+	for _, dsn := range []string{
+		"postgres:///mydb?host=/var/run/postgresql&sslmode=disable",
+		"postgres://%2Fvar%2Frun%2Fpostgresql/mydb?sslmode=disable",
+		"host=/var/run/postgresql dbname=mydb sslmode=disable",
+	} {
+		cfg := opened(t, secretDriver(t, quotedSecret), dsn)
+		if cfg.Host != "/var/run/postgresql" || cfg.Database != "mydb" || cfg.User != "app_odd" {
+			t.Errorf("Open(%q) connected to %v/%v as %v, want the socket directory /var/run/postgresql", dsn, cfg.Host, cfg.Database, cfg.User)
+		}
+	}
+
+	// a socket directory overriding the host of the DSN
+	d := secretDriver(t, quotedSecret)
+	d.AcquireLock()
+	d.Host = "/tmp"
+	d.ReleaseLock()
+	if cfg := opened(t, d, "postgres://db.internal/mydb?sslmode=disable"); cfg.Host != "/tmp" {
+		t.Errorf("Open connected to %v with the host overridden, want the socket directory /tmp", cfg.Host)
+	}
+}
+
+func TestOpenMultiHostDSN(t *testing.T) {
+	for _, dsn := range []string{
+		"postgres://h1:5432,h2:5433/mydb?target_session_attrs=read-write&sslmode=disable",
+		"host=h1,h2 port=5432,5433 dbname=mydb target_session_attrs=read-write sslmode=disable",
+	} {
+		cfg := opened(t, secretDriver(t, quotedSecret), dsn)
+		if cfg.Host != "h1" || cfg.Port != 5432 {
+			t.Errorf("Open(%q) tries %v:%v first, want h1:5432", dsn, cfg.Host, cfg.Port)
+		}
+		if len(cfg.Multi) != 1 || cfg.Multi[0].Host != "h2" || cfg.Multi[0].Port != 5433 {
+			t.Errorf("Open(%q) fails over to %+v, want h2:5433", dsn, cfg.Multi)
+		}
+		if cfg.User != "app_odd" || cfg.Password != quotedSecret.OddPassword {
+			t.Errorf("Open(%q) authenticated as %q/%q, want the odd credential", dsn, cfg.User, cfg.Password)
+		}
+	}
+}
+
+func TestOpenPerSlotParams(t *testing.T) {
+	secret := quotedSecret
+	secret.OddParams = map[string]string{"statement_timeout": "5000", "application_name": "orders-odd"}
+	secret.EvenParams = map[string]string{"statement_timeout": "9000"}
+	for _, dsn := range []string{
+		"postgres://db.internal/mydb?sslmode=disable&statement_timeout=1000",
+		"host=db.internal dbname=mydb sslmode=disable statement_timeout=1000",
+	} {
+		cfg := opened(t, secretDriver(t, secret), dsn)
+		if got := cfg.Runtime["statement_timeout"]; got != "5000" {
+			t.Errorf("Open(%q) statement_timeout = %q, want 5000 of the odd slot over that of the DSN", dsn, got)
+		}
+		if cfg.ApplicationName != "orders-odd" {
+			t.Errorf("Open(%q) application_name = %q, want orders-odd", dsn, cfg.ApplicationName)
+		}
+	}
+
+	reserved := quotedSecret
+	reserved.OddParams = map[string]string{"user": "postgres"}
+	d := secretDriver(t, reserved)
+	d.Backend = gopqrtest.NewBackend()
+	if _, err := d.Open("postgres://db.internal/mydb?sslmode=disable"); !errors.Is(err, gopqr.ErrInvalidDSN) {
+		t.Errorf("Open with a slot parameter setting the user = %v, want ErrInvalidDSN", err)
+	}
+}
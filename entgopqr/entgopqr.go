@@ -0,0 +1,59 @@
+package entgopqr
+
+import (
+	"sync/atomic"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package entgopqr opens the ent dialect driver of entgo.io/ent over a
+github.com/chandranarreddy/gopqr driver, so that services on ent connect with
+rotating credentials. It lives apart from the driver so that the driver does
+not depend on ent.
+
+Connections of a credential that was replaced are retired by database/sql
+itself the next time they are taken from or returned to the pool, ent or
+not. ReconnectOnRefresh retires all of them at once instead, as soon as a
+refresh installs new credentials.
+
+Usage:
+	drv := entgopqr.Open(pqrDriver, "postgres://1.2.3.4:5432/mydb?sslmode=verify-full")
+	entgopqr.ReconnectOnRefresh(pqrDriver, drv)
+	client := ent.NewClient(ent.Driver(drv))
+*/
+
+// Open returns an ent driver of the postgres dialect over a database opened
+// with the connector of the gopqr driver, like entsql.OpenDB. The DSN carries
+// no credentials, and no connection is made until one is needed. Size the
+// pool through the DB of the returned driver.
+func Open(d *gopqr.Driver, dsn string) *entsql.Driver {
+	return entsql.OpenDB(dialect.Postgres, gopqr.OpenDB(dsn, d))
+}
+
+// ReconnectOnRefresh makes every connection of the ent driver be established
+// afresh with gopqr.ForceReconnect once a refresh of the gopqr driver
+// installs new credentials, so that no connection is left on a credential
+// about to be revoked. It chains the OnRefreshDone hook of the gopqr driver,
+// so call it before the driver is used. Credentials a Provider hands out
+// between refreshes, like a watched file, are still retired lazily.
+func ReconnectOnRefresh(d *gopqr.Driver, drv *entsql.Driver) {
+	var generation atomic.Uint64
+	generation.Store(d.State().Generation)
+	next := d.OnRefreshDone
+	d.OnRefreshDone = func(err error) {
+		if next != nil {
+			next(err)
+		}
+		if err != nil {
+			return
+		}
+		if g := d.State().Generation; generation.Swap(g) != g {
+			gopqr.ForceReconnect(drv.DB())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package gopqr
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+// The GOPQR_* environment variables recognized by every driver of the
+// process, so that operators can change the behavior of the driver in an
+// emergency without deploying code. They are read once, when the first
+// driver of the process needs them.
+const (
+	// ENVDISABLEROTATION - When true, the active credential never changes,
+	// whatever the RotationPolicy. Open still falls back to the other
+	// credentials for the connection at hand.
+	ENVDISABLEROTATION = "GOPQR_DISABLE_ROTATION"
+	// ENVFORCESLOT - Name of the slot Open connects with first, whatever
+	// the active credential. Names not in the ring of a driver are ignored.
+	ENVFORCESLOT = "GOPQR_FORCE_SLOT"
+	// ENVDEBUG - When true, the events of the driver are written to its
+	// Logger even without an EventLogger. Events are redacted like all the
+	// output of the driver.
+	ENVDEBUG = "GOPQR_DEBUG"
+)
+
+type envFlags struct {
+	disableRotation bool
+	forceSlot       string
+	debug           bool
+	// set - The recognized variables that were set, for Status
+	set map[string]string
+}
+
+var (
+	envOnce sync.Once
+	env     envFlags
+)
+
+// flags returns the GOPQR_* environment variables, reading them the first
+// time.
+func flags() *envFlags {
+	envOnce.Do(func() {
+		env.set = make(map[string]string)
+		for _, name := range []string{ENVDISABLEROTATION, ENVFORCESLOT, ENVDEBUG} {
+			if v, ok := os.LookupEnv(name); ok {
+				env.set[name] = v
+			}
+		}
+		env.disableRotation, _ = strconv.ParseBool(env.set[ENVDISABLEROTATION])
+		env.forceSlot = env.set[ENVFORCESLOT]
+		env.debug, _ = strconv.ParseBool(env.set[ENVDEBUG])
+	})
+	return &env
+}
+
+// EnvFlags returns the GOPQR_* environment variables that are set, as read
+// by the drivers of the process.
+func EnvFlags() map[string]string {
+	return copyExtra(flags().set)
+}
+
+type frozen struct{}
+
+func (frozen) RotateOnOpen(RotationState) bool   { return false }
+func (frozen) SwapOnFallback(RotationState) bool { return false }
+
+// activeSlot returns the name of the slot Open connects with first, which is
+// the active credential unless GOPQR_FORCE_SLOT names another slot of the
+// ring.
+func (d *Driver) activeSlot(ring []Credential) string {
+	return activeSlotOf(ring, d.ActiveCredential)
+}
+
+// activeSlotOf is activeSlot for the ring and active credential given.
+func activeSlotOf(ring []Credential, active string) string {
+	if forced := flags().forceSlot; forced != "" {
+		for _, cred := range ring {
+			if cred.Name == forced {
+				return forced
+			}
+		}
+	}
+	return active
+}
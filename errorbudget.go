@@ -0,0 +1,130 @@
+package gopqr
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	//DEFAULTBUDGETWINDOW - default rolling window of an ErrorBudget
+	DEFAULTBUDGETWINDOW = 5 * time.Minute
+	//DEFAULTBUDGETFAILURES - default number of failures an ErrorBudget tolerates within its window
+	DEFAULTBUDGETFAILURES = 10
+)
+
+// ErrorBudget tracks rotation related failures, like credentials failing
+// authentication or refreshes failing, over a rolling window. Once more
+// failures than the budget allows happen within the window, the budget is
+// exhausted and the optional risky features of the driver, such as chaos
+// mode and canary cutover, are turned off so that they cannot amplify a real
+// incident. They stay off until Reset is called. The zero value is ready to
+// use with DEFAULTBUDGETWINDOW and DEFAULTBUDGETFAILURES.
+type ErrorBudget struct {
+	// Window - The rolling window failures are counted over, defaults to DEFAULTBUDGETWINDOW
+	Window time.Duration
+	// MaxFailures - Failures tolerated within the window, defaults to DEFAULTBUDGETFAILURES
+	MaxFailures int
+	// OnExhausted func is invoked once when the budget is exhausted, with the
+	// last failure, to raise an alert. When it is not set, the Logger of the
+	// driver is written to, or the standard logger for failures recorded
+	// with Record.
+	OnExhausted func(failures int, last error)
+
+	mu        sync.Mutex
+	failures  []time.Time
+	exhausted bool
+}
+
+// Record counts a rotation related failure against the budget.
+func (b *ErrorBudget) Record(err error) {
+	if n, window, exhausted := b.record(err); exhausted {
+		b.alert(n, window, err, func(format string, v ...interface{}) {
+			log.Printf("gopqr: "+format, v...)
+		})
+	}
+}
+
+// record counts the failure, reporting whether it exhausted the budget along
+// with the failures within the window.
+func (b *ErrorBudget) record(err error) (n int, window time.Duration, exhausted bool) {
+	if b == nil || err == nil {
+		return 0, 0, false
+	}
+	window, max := b.Window, b.MaxFailures
+	if window <= 0 {
+		window = DEFAULTBUDGETWINDOW
+	}
+	if max <= 0 {
+		max = DEFAULTBUDGETFAILURES
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = append(b.failures, now)
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(b.failures) && b.failures[i].Before(cutoff) {
+		i++
+	}
+	b.failures = b.failures[i:]
+	n = len(b.failures)
+	if b.exhausted || n <= max {
+		return n, window, false
+	}
+	b.exhausted = true
+	return n, window, true
+}
+
+// alert raises the alert of the exhausted budget, through OnExhausted or
+// logf.
+func (b *ErrorBudget) alert(n int, window time.Duration, last error, logf func(string, ...interface{})) {
+	if b.OnExhausted != nil {
+		b.OnExhausted(n, last)
+		return
+	}
+	logf("error budget exhausted with %v rotation failures within %v, risky features are off - %v", n, window, last)
+}
+
+// recordFailure counts a rotation related failure against the error budget
+// of the driver. When that exhausts the budget, the alert goes to the Logger
+// of the driver unless OnExhausted is set, and the canary rollout in
+// progress, if any, is aborted.
+func (d *Driver) recordFailure(err error) {
+	n, window, exhausted := d.ErrorBudget.record(err)
+	if !exhausted {
+		return
+	}
+	d.canaryAborted()
+	d.ErrorBudget.alert(n, window, err, d.logf)
+}
+
+// Exhausted reports whether the budget has been exhausted since the last Reset.
+func (b *ErrorBudget) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exhausted
+}
+
+// Reset forgets the failures counted so far and turns the risky features
+// back on.
+func (b *ErrorBudget) Reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.failures = nil
+	b.exhausted = false
+	b.mu.Unlock()
+}
+
+// RiskyFeaturesAllowed reports whether optional risky features, like chaos
+// mode and canary cutover, may run. They are off once the error budget of the
+// driver is exhausted. Applications can gate their own rotation experiments
+// on it too.
+func (d *Driver) RiskyFeaturesAllowed() bool {
+	return !d.ErrorBudget.Exhausted()
+}
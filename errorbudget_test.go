@@ -0,0 +1,35 @@
+package gopqr
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestErrorBudgetExhaustedAbortsCanary(t *testing.T) {
+	var logged bytes.Buffer
+	var done []bool
+	d := &Driver{
+		ErrorBudget: &ErrorBudget{MaxFailures: 1},
+		Canary:      &Canary{OnDone: func(slot string, promoted bool) { done = append(done, promoted) }},
+		Logger:      log.New(&logged, "", 0),
+	}
+	d.Canary.start("even", "odd")
+	failure := errors.New("refresh failed")
+	d.recordFailure(failure)
+	if slot, _ := d.Canary.Rollout(); slot != "even" {
+		t.Fatalf("rollout of %q within the budget, want even", slot)
+	}
+	d.recordFailure(failure)
+	if slot, _ := d.Canary.Rollout(); slot != "" {
+		t.Errorf("rollout of %q once the budget is exhausted, want it aborted", slot)
+	}
+	if len(done) != 1 || done[0] {
+		t.Errorf("OnDone called with %v, want once and not promoted", done)
+	}
+	if !strings.Contains(logged.String(), "gopqr: error budget exhausted") {
+		t.Errorf("Logger of the driver got %q, want the alert of the budget", logged.String())
+	}
+}
@@ -0,0 +1,77 @@
+package gopqr
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrorClass tells who has to act on an error of the driver, so that
+// incidents can be routed without grepping the messages.
+type ErrorClass int
+
+const (
+	// ClassOther - Errors of no class below, like those of queries
+	ClassOther ErrorClass = iota
+	// ClassCredentials - The credentials are exhausted or could not be
+	// fetched, the concern of whoever owns the secrets
+	ClassCredentials
+	// ClassUnavailable - The network or the server is unavailable, the
+	// concern of whoever runs the database
+	ClassUnavailable
+	// ClassConfiguration - The DSN or the driver is misconfigured, which no
+	// retry fixes and should fail the startup
+	ClassConfiguration
+)
+
+// String returns the name of the class.
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassCredentials:
+		return "credentials"
+	case ClassUnavailable:
+		return "unavailable"
+	case ClassConfiguration:
+		return "configuration"
+	default:
+		return "other"
+	}
+}
+
+// Classify returns the class of an error of the driver, or of the server it
+// wraps. Credentials exhausted, a refresh timing out or panicking and the
+// server rejecting a credential are ClassCredentials. Network failures and
+// the server refusing connections, like while it starts up or has none left,
+// are ClassUnavailable. An invalid DSN, driver configuration or missing
+// secret or database is ClassConfiguration.
+func Classify(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ClassOther
+	case errors.Is(err, ErrInvalidDSN), errors.Is(err, ErrCredentialsInDSN),
+		errors.Is(err, ErrInvalidConfig), errors.Is(err, ErrNoDefault),
+		errors.Is(err, ErrNoDatabaseMapped), errors.Is(err, ErrSecretNotFound),
+		errors.Is(err, ErrNotFIPSApproved), errors.Is(err, ErrDriverRegistered):
+		return ClassConfiguration
+	case errors.Is(err, ErrAllCredentialsFailed), errors.Is(err, ErrFallbackForbidden),
+		errors.Is(err, ErrCredentialsInvalid), errors.Is(err, ErrCircuitOpen),
+		errors.Is(err, ErrRefreshTimeout), errors.Is(err, ErrRefreshPanicked):
+		return ClassCredentials
+	}
+	if state, ok := sqlState(err); ok {
+		switch {
+		case strings.HasPrefix(state, "28"):
+			return ClassCredentials
+		case state == "3D000":
+			// the database of the DSN does not exist
+			return ClassConfiguration
+		case strings.HasPrefix(state, "08"), strings.HasPrefix(state, "53"), strings.HasPrefix(state, "57P"):
+			return ClassUnavailable
+		}
+		return ClassOther
+	}
+	if isNetworkError(err) || errors.Is(err, context.DeadlineExceeded) {
+		return ClassUnavailable
+	}
+	return ClassOther
+}
@@ -0,0 +1,59 @@
+package gopqr
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrBothCredentialsFailed is matched by errors.Is when every credential
+	// of the driver failed authentication, be it the odd and even pair or a
+	// ring of more slots. The failures of the server are wrapped as well, so
+	// that errors.As finds the *pq.Error of each.
+	ErrBothCredentialsFailed = errors.New("Both the credentials failed")
+	// ErrAllCredentialsFailed matches the same errors as
+	// ErrBothCredentialsFailed, reading better for rings of more slots.
+	ErrAllCredentialsFailed = errors.New("All the credentials failed")
+	// ErrInvalidDSN is matched by errors.Is when the DSN passed to Open
+	// cannot be parsed.
+	ErrInvalidDSN = errors.New("Failed while parsing Rotating DSN")
+	// ErrCredentialsInvalid is matched by errors.Is when ValidateCredentials
+	// found credentials that do not authenticate.
+	ErrCredentialsInvalid = errors.New("Credentials failed validation")
+	// ErrFallbackForbidden is matched by errors.Is when the active credential
+	// failed authentication on a driver set to NoFallback. The failure of
+	// the server is wrapped as well.
+	ErrFallbackForbidden = errors.New("Credential failed and fallback is forbidden")
+)
+
+// AuthExhaustedError is returned by Open when every credential failed
+// authentication.
+type AuthExhaustedError struct {
+	// Slots - Names of the credential slots in the order they were tried
+	Slots []string
+	// Errs - The failure of each slot
+	Errs []error
+}
+
+func (e *AuthExhaustedError) Error() string {
+	msg := ErrAllCredentialsFailed.Error()
+	if len(e.Slots) == 2 {
+		msg = ErrBothCredentialsFailed.Error()
+	}
+	causes := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		causes[i] = e.Slots[i] + ": " + err.Error()
+	}
+	return msg + " - " + strings.Join(causes, "; ")
+}
+
+// Unwrap returns the failure of each slot.
+func (e *AuthExhaustedError) Unwrap() []error {
+	return e.Errs
+}
+
+// Is makes errors.Is(err, ErrBothCredentialsFailed) and
+// errors.Is(err, ErrAllCredentialsFailed) match.
+func (e *AuthExhaustedError) Is(target error) bool {
+	return target == ErrBothCredentialsFailed || target == ErrAllCredentialsFailed
+}
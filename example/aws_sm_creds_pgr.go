@@ -166,7 +166,6 @@ func buildPQRDriver(logger *log.Logger) (*gopqr.Driver, error) {
 			ActiveCredential string `json:"active_credential"`
 		}
 		err = json.Unmarshal([]byte(*result.SecretString), &s)
-		fmt.Printf("unmarshalled secretentry - %#v", s)
 		if err != nil {
 			logger.Print(fmt.Errorf("Unmarshalling secret failed while refreshing DB secret from SM - %v", err))
 			return
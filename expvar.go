@@ -0,0 +1,57 @@
+package gopqr
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// ErrExpvarPublished is matched by errors.Is when PublishExpvar is given a
+// name that expvar already publishes a variable under.
+var ErrExpvarPublished = errors.New("A variable is already published under the name")
+
+// PublishExpvar publishes the rotation counters and the active slot of the
+// driver with expvar under the name, so that scrapers of /debug/vars pick
+// them up without any other wiring -
+//
+//	"gopqr": {"active_slot": "even", "generation": 3, "rotations": 2, ...}
+//
+// The values are read anew on every scrape. Like expvar, it cannot be
+// undone, and unlike expvar.Publish, it returns an error matching
+// ErrExpvarPublished rather than panicking when the name is taken.
+func (d *Driver) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("%w - %v", ErrExpvarPublished, name)
+	}
+	expvar.Publish(name, expvar.Func(d.expvars))
+	return nil
+}
+
+// expvarMu serializes the PublishExpvar calls, so that the check for a
+// published name and the publishing happen at once.
+var expvarMu sync.Mutex
+
+// expvars returns the values PublishExpvar publishes.
+func (d *Driver) expvars() interface{} {
+	state := d.rotationState()
+	vars := map[string]interface{}{
+		"active_slot":      d.current().activeSlot(),
+		"active_since":     state.ActiveSince,
+		"generation":       state.Generation,
+		"degraded":         state.Degraded,
+		"rotations":        d.rotations.Load(),
+		"fallbacks":        d.fallbacks.Load(),
+		"failed_opens":     d.failedOpens.Load(),
+		"refreshes":        d.refreshes.Load(),
+		"refresh_failures": d.refreshFailures.Load(),
+		"live_conns":       d.LiveConns(),
+		"version":          version,
+	}
+	if last := d.LastRefresh(); !last.IsZero() {
+		vars["last_refresh"] = last
+	}
+	return vars
+}
@@ -0,0 +1,30 @@
+package gopqr
+
+// Extra returns the settings that came along with the credentials last
+// installed on the driver, like the fields of the secret that gopqr does not
+// know about. The returned map is a copy.
+func (d *Driver) Extra() map[string]string {
+	d.AcquireLock()
+	defer d.ReleaseLock()
+	return copyExtra(d.extra)
+}
+
+// passExtra hands a copy of the extra settings to the OnExtra hook, if one
+// is set. It is invoked outside of the lock so that the hook may use the
+// driver.
+func (d *Driver) passExtra(extra map[string]string) {
+	if d.OnExtra != nil {
+		d.OnExtra(copyExtra(extra))
+	}
+}
+
+func copyExtra(extra map[string]string) map[string]string {
+	if extra == nil {
+		return nil
+	}
+	c := make(map[string]string, len(extra))
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
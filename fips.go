@@ -0,0 +1,141 @@
+package gopqr
+
+import (
+	"context"
+	"crypto/fips140"
+	crand "crypto/rand"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrNotFIPSApproved is returned when strict FIPS mode is on and a component
+// of gopqr is asked to use a primitive that is not FIPS approved.
+var ErrNotFIPSApproved = errors.New("Primitive is not FIPS approved")
+
+// Primitive names a cryptographic primitive used by gopqr and its
+// subpackages, like the password generation of passwordgen or the signatures
+// of webhook requests.
+type Primitive string
+
+const (
+	// PrimitiveCSPRNG - crypto/rand backed random generation
+	PrimitiveCSPRNG Primitive = "crypto/rand"
+	// PrimitiveMathRand - math/rand backed random generation
+	PrimitiveMathRand Primitive = "math/rand"
+	// PrimitiveAESGCM - AES in GCM mode
+	PrimitiveAESGCM Primitive = "AES-GCM"
+	// PrimitiveChaCha20Poly1305 - ChaCha20-Poly1305 AEAD
+	PrimitiveChaCha20Poly1305 Primitive = "ChaCha20-Poly1305"
+	// PrimitiveSHA256 - SHA-256 digests
+	PrimitiveSHA256 Primitive = "SHA-256"
+	// PrimitiveHMACSHA256 - HMAC with SHA-256
+	PrimitiveHMACSHA256 Primitive = "HMAC-SHA-256"
+	// PrimitivePBKDF2 - PBKDF2 with HMAC-SHA-256, as used by SCRAM-SHA-256
+	PrimitivePBKDF2 Primitive = "PBKDF2-HMAC-SHA-256"
+	// PrimitiveMD5 - MD5 digests, as used by postgres md5 passwords
+	PrimitiveMD5 Primitive = "MD5"
+)
+
+var fipsApproved = map[Primitive]bool{
+	PrimitiveCSPRNG:     true,
+	PrimitiveAESGCM:     true,
+	PrimitiveSHA256:     true,
+	PrimitiveHMACSHA256: true,
+	PrimitivePBKDF2:     true,
+}
+
+// fipsMode is 1 when strict FIPS mode is on. Builds with the gopqr_fips tag
+// start with it on.
+var fipsMode = fipsBuild
+
+// FIPSMode reports whether strict FIPS mode is on, which it always is while
+// the FIPS 140-3 mode of the Go cryptography is, like under GODEBUG=fips140=on.
+func FIPSMode() bool {
+	return atomic.LoadInt32(&fipsMode) == 1 || fips140.Enabled()
+}
+
+// SetFIPSMode turns strict FIPS mode on or off at runtime. Binaries built
+// with the gopqr_fips build tag or running in the FIPS 140-3 mode of Go are
+// always in strict FIPS mode and turning it off returns an error.
+func SetFIPSMode(on bool) error {
+	if !on && fipsBuild == 1 {
+		return errors.New("Strict FIPS mode cannot be turned off in a gopqr_fips build")
+	}
+	if !on && fips140.Enabled() {
+		return errors.New("Strict FIPS mode cannot be turned off in the FIPS 140-3 mode of Go")
+	}
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&fipsMode, v)
+	return nil
+}
+
+// CheckPrimitive returns an error wrapping ErrNotFIPSApproved when strict
+// FIPS mode is on and the primitive is not FIPS approved. Components that
+// generate passwords or encrypt material locally call it before doing so.
+func CheckPrimitive(p Primitive) error {
+	if FIPSMode() && !fipsApproved[p] {
+		return fmt.Errorf("%v - %w", p, ErrNotFIPSApproved)
+	}
+	return nil
+}
+
+// randInt63n returns a random number in [0, n), drawn from math/rand unless
+// strict FIPS mode refuses it, in which case it is drawn from crypto/rand.
+// n must be positive.
+func randInt63n(n int64) int64 {
+	if CheckPrimitive(PrimitiveMathRand) == nil {
+		return rand.Int63n(n)
+	}
+	v, err := crand.Int(crand.Reader, big.NewInt(n))
+	if err != nil {
+		// crypto/rand does not fail on the platforms Go supports
+		panic(err)
+	}
+	return v.Int64()
+}
+
+// refuseMD5 closes the connection and returns an error wrapping
+// ErrNotFIPSApproved when strict FIPS mode is on and the server
+// authenticated it with an md5 password. lib/pq answers the md5 challenge of
+// the server on its own, so the connection is checked once open, with the
+// system_user of postgres 16 and later. Connections to older servers, or
+// through backends that cannot run queries, are let through unchecked.
+func (d *Driver) refuseMD5(ctx context.Context, conn driver.Conn) (driver.Conn, error) {
+	if !FIPSMode() {
+		return conn, nil
+	}
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return conn, nil
+	}
+	rows, err := queryer.QueryContext(ctx, "SELECT system_user", nil)
+	if err != nil {
+		return conn, nil
+	}
+	dest := make([]driver.Value, 1)
+	err = rows.Next(dest)
+	rows.Close()
+	if err != nil {
+		return conn, nil
+	}
+	var method string
+	switch v := dest[0].(type) {
+	case string:
+		method = v
+	case []byte:
+		method = string(v)
+	}
+	if strings.HasPrefix(method, "md5:") {
+		conn.Close()
+		return nil, fmt.Errorf("the server authenticated the connection with an md5 password - %w", CheckPrimitive(PrimitiveMD5))
+	}
+	return conn, nil
+}
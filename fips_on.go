@@ -0,0 +1,6 @@
+//go:build gopqr_fips
+// +build gopqr_fips
+
+package gopqr
+
+const fipsBuild int32 = 1
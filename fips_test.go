@@ -0,0 +1,102 @@
+package gopqr_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+	"github.com/chandranarreddy/gopqr/providers/shared"
+)
+
+// systemUserBackend is a gopqrtest.Backend whose connections answer
+// SELECT system_user with the method, like postgres 16 does.
+type systemUserBackend struct {
+	*gopqrtest.Backend
+	method string
+}
+
+func (b systemUserBackend) Open(dsn string) (driver.Conn, error) {
+	conn, err := b.Backend.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return systemUserConn{Conn: conn, method: b.method}, nil
+}
+
+type systemUserConn struct {
+	driver.Conn
+	method string
+}
+
+func (c systemUserConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &systemUserRows{method: c.method}, nil
+}
+
+type systemUserRows struct {
+	method string
+	read   bool
+}
+
+func (r *systemUserRows) Columns() []string { return []string{"system_user"} }
+func (r *systemUserRows) Close() error      { return nil }
+func (r *systemUserRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0] = r.method
+	return nil
+}
+
+func strictFIPS(t *testing.T) {
+	t.Helper()
+	if err := gopqr.SetFIPSMode(true); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { gopqr.SetFIPSMode(false) })
+}
+
+func TestFIPSRefusesMD5Connections(t *testing.T) {
+	strictFIPS(t)
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	d, _ := testDriver(backend)
+	d.Backend = systemUserBackend{Backend: backend, method: "md5:app_odd"}
+	if _, err := d.Open(testDSN); !errors.Is(err, gopqr.ErrNotFIPSApproved) {
+		t.Errorf("Open authenticated with md5 = %v, want ErrNotFIPSApproved", err)
+	}
+
+	d.Backend = systemUserBackend{Backend: backend, method: "scram-sha-256:app_odd"}
+	conn, err := d.Open(testDSN)
+	if err != nil {
+		t.Fatalf("Open authenticated with SCRAM failed - %v", err)
+	}
+	conn.Close()
+}
+
+func TestFIPSRefusesSharedSnapshot(t *testing.T) {
+	strictFIPS(t)
+	backend := gopqrtest.NewBackend()
+	_, store := testDriver(backend)
+	p := shared.New(store, shared.Config{Path: filepath.Join(t.TempDir(), "creds.json")})
+	if err := p.Refresh(context.Background()); !errors.Is(err, gopqr.ErrNotFIPSApproved) {
+		t.Errorf("Refresh = %v, want ErrNotFIPSApproved", err)
+	}
+	if _, err := p.Current(context.Background()); !errors.Is(err, gopqr.ErrNotFIPSApproved) {
+		t.Errorf("Current = %v, want ErrNotFIPSApproved", err)
+	}
+}
+
+func TestFIPSModeCannotBeTurnedOffUnderGoFIPS(t *testing.T) {
+	if !gopqr.FIPSMode() {
+		t.Skip("not running in the FIPS 140-3 mode of Go")
+	}
+	if err := gopqr.SetFIPSMode(false); err == nil {
+		t.Error("SetFIPSMode(false) succeeded in FIPS mode, want an error")
+	}
+}
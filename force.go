@@ -0,0 +1,34 @@
+package gopqr
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ForceRotate flips the active credential to the next slot of the ring right
+// away, whatever the RotationPolicy, for a rotation controller instructing
+// running services to switch rather than wait for an authentication failure.
+// New connections use the new active credential; pooled connections stay
+// until their lifetime ends, or until ForceReconnect. It returns the slot
+// now active.
+func (d *Driver) ForceRotate() (string, error) {
+	return d.rotate(slog.LevelInfo, "active credential rotated on demand")
+}
+
+// ForceRefresh refreshes the credentials right away, from the Provider or
+// with the CredentialRefresher, and reports the outcome. A refresh already
+// in flight is shared rather than run again. Unlike Refresh it gives up
+// waiting when ctx is done, in which case the refresh goes on in the
+// background for the Opens that follow.
+func (d *Driver) ForceRefresh(ctx context.Context) error {
+	c, leader := d.refresh.begin()
+	if leader {
+		go d.refresh.run(c, d.refreshRetried)
+	}
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
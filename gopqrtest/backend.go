@@ -0,0 +1,175 @@
+package gopqrtest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	nurl "net/url"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// Backend is an in-memory stand in for Postgres, to be set as the Backend
+// of a driver. It accepts the users and passwords it was told are valid and
+// rejects the others with the invalid_password error of Postgres, so the
+// driver falls back and refreshes just like against a server. Connections
+// accept every statement and return no rows.
+type Backend struct {
+	mu       sync.Mutex
+	valid    map[string]string
+	failures []error
+	attempts []Attempt
+}
+
+// Attempt is one connection the driver made to the Backend.
+type Attempt struct {
+	// Username - User the connection authenticated as
+	Username string
+	// Err - Why the connection was refused, nil if it was opened
+	Err error
+}
+
+var _ driver.Driver = (*Backend)(nil)
+
+// NewBackend returns a Backend accepting nobody.
+func NewBackend() *Backend {
+	return &Backend{valid: make(map[string]string)}
+}
+
+// Allow makes the password the valid one of the user.
+func (b *Backend) Allow(username, password string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.valid[username] = password
+}
+
+// Revoke makes the Backend reject the user whatever the password.
+func (b *Backend) Revoke(username string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.valid, username)
+}
+
+// FailNext makes the next connection fail with err, whatever its
+// credentials. Calls queue up, one failure per connection. Use AuthError
+// for a failed authentication.
+func (b *Backend) FailNext(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = append(b.failures, err)
+}
+
+// Attempts returns the connections made to the Backend so far.
+func (b *Backend) Attempts() []Attempt {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Attempt(nil), b.attempts...)
+}
+
+// AuthError returns the error Postgres fails authentication with.
+func AuthError(username string) error {
+	return &pq.Error{Severity: "FATAL", Code: "28P01", Message: `password authentication failed for user "` + username + `"`}
+}
+
+// Open implements driver.Driver.
+func (b *Backend) Open(dsn string) (driver.Conn, error) {
+	username, password, err := credentials(dsn)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case len(b.failures) > 0:
+		err = b.failures[0]
+		b.failures = b.failures[1:]
+	default:
+		if valid, ok := b.valid[username]; !ok || valid != password {
+			err = AuthError(username)
+		}
+	}
+	b.attempts = append(b.attempts, Attempt{Username: username, Err: err})
+	if err != nil {
+		return nil, err
+	}
+	return &conn{}, nil
+}
+
+// credentials returns the user and password of a URL or key=value DSN.
+func credentials(dsn string) (username, password string, err error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := nurl.Parse(dsn)
+		if err != nil {
+			return "", "", err
+		}
+		if u.User != nil {
+			password, _ = u.User.Password()
+			username = u.User.Username()
+		}
+		return username, password, nil
+	}
+	for rest := strings.TrimSpace(dsn); rest != ""; rest = strings.TrimSpace(rest) {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return "", "", errors.New("gopqrtest: malformed DSN")
+		}
+		key := strings.TrimSpace(rest[:eq])
+		rest = strings.TrimLeft(rest[eq+1:], " ")
+		var value strings.Builder
+		if strings.HasPrefix(rest, "'") {
+			i := 1
+			for ; i < len(rest) && rest[i] != '\''; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			rest = rest[min(i+1, len(rest)):]
+		} else {
+			end := strings.IndexAny(rest, " \t\n")
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(rest[:end])
+			rest = rest[end:]
+		}
+		switch key {
+		case "user":
+			username = value.String()
+		case "password":
+			password = value.String()
+		}
+	}
+	return username, password, nil
+}
+
+// conn is a connection to the Backend.
+type conn struct{}
+
+func (*conn) Prepare(query string) (driver.Stmt, error) { return stmt{}, nil }
+func (*conn) Close() error                              { return nil }
+func (*conn) Begin() (driver.Tx, error)                 { return tx{}, nil }
+
+// Ping implements driver.Pinger.
+func (*conn) Ping(ctx context.Context) error { return nil }
+
+type stmt struct{}
+
+func (stmt) Close() error                                    { return nil }
+func (stmt) NumInput() int                                   { return -1 }
+func (stmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (stmt) Query(args []driver.Value) (driver.Rows, error)  { return rows{}, nil }
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type rows struct{}
+
+func (rows) Columns() []string              { return nil }
+func (rows) Close() error                   { return nil }
+func (rows) Next(dest []driver.Value) error { return io.EOF }
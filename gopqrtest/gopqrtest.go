@@ -0,0 +1,255 @@
+package gopqrtest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package gopqrtest fakes Postgres for unit tests of code built on
+github.com/chandranarreddy/gopqr, so that the rotation and fallback behavior
+of an application can be tested without a server. A Fake holds a driver
+wired to an in-memory Backend that accepts the credentials it is told are
+valid, a secret store it provides the driver with, and a Recorder of the
+fallbacks and refreshes of the driver.
+
+Usage:
+	func TestSurvivesRotation(t *testing.T) {
+		f := gopqrtest.New(t)
+		f.Rotate("odd") // the driver still holds the old password of odd
+		db := f.DB()
+		if err := db.Ping(); err != nil {
+			t.Fatal(err)
+		}
+		f.Metrics.AssertFallbacks(t, 1)
+		f.Metrics.WaitRefreshes(t, 1, time.Second)
+	}
+
+Failures can be injected with Backend.FailNext, and credentials revoked
+outright with Fake.Revoke. The Backend is also usable on its own as the
+Backend of a driver built by the test.
+*/
+
+// DSN - DSN the Fake opens connections with
+const DSN = "postgres://gopqrtest:5432/app?sslmode=disable"
+
+// Fake is a gopqr driver against an in-memory Postgres. The Fake is the
+// CredentialProvider of its driver, handing out the credentials of its
+// secret store as of the last Refresh.
+type Fake struct {
+	// Driver - The driver under test, with the odd and even credential
+	// valid on the Backend
+	Driver *gopqr.Driver
+	// Backend - The fake Postgres the driver connects to
+	Backend *Backend
+	// Metrics - Records the outcomes of the driver
+	Metrics *Recorder
+
+	t         testing.TB
+	mu        sync.Mutex
+	secret    gopqr.Secret
+	refreshed gopqr.Secret
+}
+
+var _ gopqr.CredentialProvider = (*Fake)(nil)
+
+// New returns a Fake whose odd and even credential are both valid, with the
+// odd one active.
+func New(t testing.TB) *Fake {
+	f := &Fake{
+		Backend: NewBackend(),
+		Metrics: &Recorder{},
+		t:       t,
+		secret: gopqr.Secret{
+			OddUsername:      "odd_user",
+			OddPassword:      password(),
+			EvenUsername:     "even_user",
+			EvenPassword:     password(),
+			ActiveCredential: "odd",
+		},
+	}
+	f.refreshed = f.secret
+	f.Backend.Allow(f.secret.OddUsername, f.secret.OddPassword)
+	f.Backend.Allow(f.secret.EvenUsername, f.secret.EvenPassword)
+	f.Driver = &gopqr.Driver{Provider: f, Backend: f.Backend, Metrics: f.Metrics}
+	return f
+}
+
+// Current implements gopqr.CredentialProvider.
+func (f *Fake) Current(ctx context.Context) (gopqr.Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refreshed.Credentials(), nil
+}
+
+// Refresh implements gopqr.CredentialProvider, picking up what the secret
+// store holds now.
+func (f *Fake) Refresh(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refreshed = f.secret
+	return nil
+}
+
+// DB returns a database of the driver, closed when the test ends.
+func (f *Fake) DB() *sql.DB {
+	db := sql.OpenDB(f.Driver.Connector(DSN))
+	f.t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Rotate gives the user of the slot, "odd" or "even", a new password on the
+// Backend and in the secret store, the way a rotation does. The driver keeps
+// the old password until it refreshes. It returns the new password.
+func (f *Fake) Rotate(slot string) string {
+	pw := password()
+	f.mu.Lock()
+	user := f.secret.OddUsername
+	if slot == "even" {
+		user = f.secret.EvenUsername
+		f.secret.EvenPassword = pw
+	} else {
+		f.secret.OddPassword = pw
+	}
+	f.mu.Unlock()
+	f.Backend.Allow(user, pw)
+	return pw
+}
+
+// Revoke makes the Backend reject the user of the slot, "odd" or "even",
+// whatever the password.
+func (f *Fake) Revoke(slot string) {
+	f.mu.Lock()
+	user := f.secret.OddUsername
+	if slot == "even" {
+		user = f.secret.EvenUsername
+	}
+	f.mu.Unlock()
+	f.Backend.Revoke(user)
+}
+
+// Recorder is the gopqr.Metrics of a driver under test, counting its
+// connections, fallbacks, authentication failures and refreshes.
+type Recorder struct {
+	mu              sync.Mutex
+	opened          map[string]int
+	fallbacks       int
+	authFailures    map[string]int
+	refreshes       int
+	refreshFailures int
+}
+
+var _ gopqr.Metrics = (*Recorder)(nil)
+
+// ConnectionOpened implements gopqr.Metrics.
+func (r *Recorder) ConnectionOpened(slot string, fallback bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opened == nil {
+		r.opened = make(map[string]int)
+	}
+	r.opened[slot]++
+	if fallback {
+		r.fallbacks++
+	}
+}
+
+// AuthFailed implements gopqr.Metrics.
+func (r *Recorder) AuthFailed(slot string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.authFailures == nil {
+		r.authFailures = make(map[string]int)
+	}
+	r.authFailures[slot]++
+}
+
+// RefreshAttempted implements gopqr.Metrics.
+func (r *Recorder) RefreshAttempted(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshes++
+	if err != nil {
+		r.refreshFailures++
+	}
+}
+
+// Opened returns the connections opened with the slot.
+func (r *Recorder) Opened(slot string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.opened[slot]
+}
+
+// Fallbacks returns the connections opened on a fallback credential.
+func (r *Recorder) Fallbacks() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fallbacks
+}
+
+// AuthFailures returns the times the slot failed authentication.
+func (r *Recorder) AuthFailures(slot string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.authFailures[slot]
+}
+
+// Refreshes returns the attempts to refresh the credentials, and how many
+// of them failed.
+func (r *Recorder) Refreshes() (attempts, failed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.refreshes, r.refreshFailures
+}
+
+// AssertFallbacks fails the test unless want connections were opened on a
+// fallback credential.
+func (r *Recorder) AssertFallbacks(t testing.TB, want int) {
+	t.Helper()
+	if got := r.Fallbacks(); got != want {
+		t.Errorf("gopqrtest: %v fallbacks, want %v", got, want)
+	}
+}
+
+// AssertAuthFailures fails the test unless the slot failed authentication
+// want times.
+func (r *Recorder) AssertAuthFailures(t testing.TB, slot string, want int) {
+	t.Helper()
+	if got := r.AuthFailures(slot); got != want {
+		t.Errorf("gopqrtest: %v authentication failures of %v, want %v", got, slot, want)
+	}
+}
+
+// WaitRefreshes waits up to the timeout for at least want attempts to
+// refresh the credentials, which the driver runs in the background, and
+// fails the test if they do not happen.
+func (r *Recorder) WaitRefreshes(t testing.TB, want int, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		got, _ := r.Refreshes()
+		if got >= want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("gopqrtest: %v refreshes after %v, want %v", got, timeout, want)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func password() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
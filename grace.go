@@ -0,0 +1,34 @@
+package gopqr
+
+import "time"
+
+type grace struct{}
+
+func (grace) RotateOnOpen(RotationState) bool   { return false }
+func (grace) SwapOnFallback(RotationState) bool { return false }
+
+// StartGrace puts the driver in grace mode for the window, like for the
+// overlap of a rotation when both the old and the new credential work.
+// Open then tries the active credential and, when it fails authentication,
+// the others for that connection only. Nothing an Open does changes the
+// active credential, so that concurrent Opens do not keep flipping it
+// underneath each other. The RotationPolicy of the driver and of its
+// connectors is set aside until the window ends. A window of zero or less
+// ends grace mode, unless the Grace field is set.
+func (d *Driver) StartGrace(window time.Duration) {
+	if window <= 0 {
+		d.graceUntil.Store(0)
+		return
+	}
+	d.graceUntil.Store(time.Now().Add(window).UnixNano())
+}
+
+// InGrace reports whether the driver is in grace mode, because the Grace
+// field is set or the window of StartGrace has not ended yet.
+func (d *Driver) InGrace() bool {
+	if d.Grace {
+		return true
+	}
+	until := d.graceUntil.Load()
+	return until != 0 && time.Now().UnixNano() < until
+}
@@ -0,0 +1,141 @@
+package gopqr
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// GSSFunc builds the GSSAPI client a credential with a Keytab authenticates
+// with, logging the principal of its Username in with the keytab, see the
+// krbgopqr subpackage for one over Kerberos.
+type GSSFunc func(cred Credential, keytab []byte) (pq.GSS, error)
+
+// gssPrefix marks the krbspn of the connections of Keytab credentials, so
+// that the GSSAPI provider gopqr registers with lib/pq, which is told nothing
+// but the SPN, finds the credential the connection is made with.
+const gssPrefix = "gopqr-gss:"
+
+// gssTargets holds the credential of every slot of every driver a Keytab
+// credential connected with, by the id its krbspn carries.
+var gssTargets struct {
+	once sync.Once
+	mu   sync.Mutex
+	ids  map[gssKey]uint64
+	m    map[uint64]gssTarget
+}
+
+type gssKey struct {
+	d    *Driver
+	slot string
+}
+
+type gssTarget struct {
+	d    *Driver
+	cred Credential
+}
+
+// gssSettings returns the krbspn setting that has the connection with the
+// Keytab credential authenticate with GSSAPI as it, nothing for other
+// credentials. get returns the settings of the DSN, whose krbspn or
+// krbsrvname name the service as they would for lib/pq.
+func (d *Driver) gssSettings(cred Credential, hostport string, get func(string) string) ([]dsnSetting, error) {
+	if cred.Keytab == "" {
+		return nil, nil
+	}
+	if d.GSS == nil {
+		return nil, fmt.Errorf("Credential %v has a Keytab but the driver has no GSS to authenticate with it", cred.Name)
+	}
+	spn := get("krbspn")
+	if spn == "" {
+		service := get("krbsrvname")
+		if service == "" {
+			service = "postgres"
+		}
+		host, _, err := net.SplitHostPort(hostport)
+		if err != nil {
+			host = hostport
+		}
+		spn = service + "/" + host
+	}
+	gssTargets.once.Do(func() {
+		gssTargets.ids = make(map[gssKey]uint64)
+		gssTargets.m = make(map[uint64]gssTarget)
+		pq.RegisterGSSProvider(func() (pq.GSS, error) { return &gssRouter{}, nil })
+	})
+	gssTargets.mu.Lock()
+	key := gssKey{d: d, slot: cred.Name}
+	id, ok := gssTargets.ids[key]
+	if !ok {
+		id = uint64(len(gssTargets.ids)) + 1
+		gssTargets.ids[key] = id
+	}
+	gssTargets.m[id] = gssTarget{d: d, cred: cred}
+	gssTargets.mu.Unlock()
+	return []dsnSetting{{key: "krbspn", value: gssPrefix + strconv.FormatUint(id, 10) + ":" + spn}}, nil
+}
+
+// gssRouter is the GSSAPI provider gopqr registers with lib/pq. It hands the
+// authentication to the GSS of the driver of the credential the krbspn
+// names.
+type gssRouter struct {
+	gss pq.GSS
+}
+
+// GetInitToken is only invoked for connections without a krbspn, which are
+// not those of Keytab credentials.
+func (r *gssRouter) GetInitToken(host, service string) ([]byte, error) {
+	return nil, errors.New("Only credentials with a Keytab authenticate with GSSAPI through gopqr")
+}
+
+// GetInitTokenFromSpn starts the authentication as the credential the SPN
+// names.
+func (r *gssRouter) GetInitTokenFromSpn(spn string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(spn, gssPrefix)
+	if !ok {
+		return r.GetInitToken("", "")
+	}
+	idText, spn, _ := strings.Cut(rest, ":")
+	id, err := strconv.ParseUint(idText, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed krbspn %q", gssPrefix+rest)
+	}
+	gssTargets.mu.Lock()
+	target, ok := gssTargets.m[id]
+	gssTargets.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Malformed krbspn %q", gssPrefix+rest)
+	}
+	keytab, err := readKeytab(target.cred.Keytab)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the Keytab of %v - %w", target.cred.Name, err)
+	}
+	if r.gss, err = target.d.GSS(target.cred, keytab); err != nil {
+		return nil, err
+	}
+	return r.gss.GetInitTokenFromSpn(spn)
+}
+
+// Continue continues the authentication the GSS of the credential started.
+func (r *gssRouter) Continue(inToken []byte) (bool, []byte, error) {
+	if r.gss == nil {
+		return true, nil, errors.New("GSSAPI authentication was not started")
+	}
+	return r.gss.Continue(inToken)
+}
+
+// readKeytab returns the keytab the Keytab of a credential holds, the path
+// of a file or its contents base64 encoded behind "base64:".
+func readKeytab(keytab string) ([]byte, error) {
+	if encoded, ok := strings.CutPrefix(keytab, "base64:"); ok {
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	return os.ReadFile(keytab)
+}
@@ -0,0 +1,12 @@
+//go:build !plan9
+// +build !plan9
+
+package gopqr
+
+import (
+	"os"
+	"syscall"
+)
+
+// hangupSignal - The signal StartRefreshOnSignal listens for by default
+var hangupSignal os.Signal = syscall.SIGHUP
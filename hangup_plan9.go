@@ -0,0 +1,12 @@
+//go:build plan9
+// +build plan9
+
+package gopqr
+
+import (
+	"os"
+)
+
+// hangupSignal - Plan 9 has no SIGHUP, so StartRefreshOnSignal needs the
+// signals to be given
+var hangupSignal os.Signal
@@ -0,0 +1,115 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// DEFAULTHEALTHTIMEOUT - default time the connectivity check of the
+// HealthHandler may take
+const DEFAULTHEALTHTIMEOUT = 2 * time.Second
+
+const (
+	// HealthOK - Status of a healthy driver and database
+	HealthOK = "ok"
+	// HealthDegraded - Status while the driver is in the middle of a
+	// rotation or its last refresh failed, but the database answers
+	HealthDegraded = "degraded"
+	// HealthUnavailable - Status while the database does not answer
+	HealthUnavailable = "unavailable"
+)
+
+// HealthReport is the JSON body the HealthHandler answers with.
+type HealthReport struct {
+	// Status - HealthOK, HealthDegraded or HealthUnavailable
+	Status string `json:"status"`
+	// ActiveSlot, ActiveSince and Generation - The rotation state of the
+	// driver
+	ActiveSlot  string    `json:"active_slot"`
+	ActiveSince time.Time `json:"active_since"`
+	Generation  uint64    `json:"generation"`
+	// Degraded and Refreshing - See State
+	Degraded   bool `json:"degraded"`
+	Refreshing bool `json:"refreshing"`
+	// LastRefresh, LastRefreshAttempt and LastRefreshError - The outcome of
+	// the refreshes, the error redacted
+	LastRefresh        *time.Time `json:"last_refresh,omitempty"`
+	LastRefreshAttempt *time.Time `json:"last_refresh_attempt,omitempty"`
+	LastRefreshError   string     `json:"last_refresh_error,omitempty"`
+	// Fallbacks and FailedOpens - See State
+	Fallbacks   uint64 `json:"fallbacks"`
+	FailedOpens uint64 `json:"failed_opens"`
+	// Ping - "ok", the redacted failure of the connectivity check, or empty
+	// when there is no database to check
+	Ping string `json:"ping,omitempty"`
+	// PingMillis - How long the connectivity check took
+	PingMillis int64 `json:"ping_ms,omitempty"`
+}
+
+// HealthHandler returns an http.Handler reporting the rotation status of the
+// driver, the outcome of its last refresh and a live connectivity check of
+// the database, to be mounted at like /healthz/db -
+//
+//	http.Handle("/healthz/db", gopqr.HealthHandler(pqrDriver, db))
+//
+// It answers 200 with a HealthReport while the database answers a ping
+// within DEFAULTHEALTHTIMEOUT, with the status HealthDegraded in the middle
+// of a rotation or after a failed refresh, and 503 otherwise. The check is
+// skipped when db is nil. Like all the output of the driver, the report
+// names slots but never carries usernames or passwords.
+func HealthHandler(d *Driver, db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, code := d.health(r.Context(), db)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		if r.Method != http.MethodHead {
+			json.NewEncoder(w).Encode(report)
+		}
+	})
+}
+
+// health checks the driver and the database, returning the report and the
+// status code to answer with.
+func (d *Driver) health(ctx context.Context, db *sql.DB) (HealthReport, int) {
+	s := d.State()
+	report := HealthReport{
+		Status:      HealthOK,
+		ActiveSlot:  s.ActiveSlot,
+		ActiveSince: s.LastRotation,
+		Generation:  s.Generation,
+		Degraded:    s.Degraded,
+		Refreshing:  s.Refreshing,
+		Fallbacks:   s.Fallbacks,
+		FailedOpens: s.FailedOpens,
+	}
+	if !s.LastRefresh.IsZero() {
+		report.LastRefresh = &s.LastRefresh
+	}
+	if !s.LastRefreshAttempt.IsZero() {
+		report.LastRefreshAttempt = &s.LastRefreshAttempt
+	}
+	if s.LastRefreshError != nil {
+		report.LastRefreshError = s.LastRefreshError.Error()
+	}
+	if s.MidRotation() || !s.RefreshHealthy() {
+		report.Status = HealthDegraded
+	}
+	if db == nil {
+		return report, http.StatusOK
+	}
+	ctx, cancel := context.WithTimeout(ctx, DEFAULTHEALTHTIMEOUT)
+	defer cancel()
+	start := time.Now()
+	err := db.PingContext(ctx)
+	report.PingMillis = time.Since(start).Milliseconds()
+	if err != nil {
+		report.Status, report.Ping = HealthUnavailable, redact(err, d.current().ring).Error()
+		return report, http.StatusServiceUnavailable
+	}
+	report.Ping = "ok"
+	return report, http.StatusOK
+}
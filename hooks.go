@@ -0,0 +1,67 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// beforeConnect hands the run-time parameters of the connection to the
+// BeforeConnect hook of the driver, if it has one, returning the config with
+// the parameters the hook left as its Settings.
+func (d *Driver) beforeConnect(ctx context.Context, cfg *ConnectorConfig) (*ConnectorConfig, error) {
+	if d.BeforeConnect == nil {
+		return cfg, nil
+	}
+	var next ConnectorConfig
+	if cfg != nil {
+		next = *cfg
+	}
+	params := copyExtra(next.Settings)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	if err := d.BeforeConnect(ctx, params); err != nil {
+		return nil, fmt.Errorf("BeforeConnect failed - %w", err)
+	}
+	for k := range params {
+		if reservedParams[k] {
+			return nil, fmt.Errorf("%w - parameter %q set by BeforeConnect is set through the credentials", ErrInvalidDSN, k)
+		}
+	}
+	next.Settings = params
+	return &next, nil
+}
+
+// afterConnect hands the connection to the AfterConnect hook of the driver,
+// if it has one, closing it when the hook fails.
+func (d *Driver) afterConnect(ctx context.Context, conn driver.Conn) (driver.Conn, error) {
+	if d.AfterConnect == nil {
+		return conn, nil
+	}
+	if err := d.AfterConnect(ctx, conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("AfterConnect failed - %w", redact(err, d.current().ring))
+	}
+	return conn, nil
+}
+
+// SetRole returns an AfterConnect hook running SET ROLE to the role, like
+// the owner role shared by the users of the credentials so that the objects
+// they create belong to it whichever credential is active -
+//
+//	pqrDriver.AfterConnect = gopqr.SetRole("app_owner")
+func SetRole(role string) func(context.Context, driver.Conn) error {
+	query := "SET ROLE " + pq.QuoteIdentifier(role)
+	return func(ctx context.Context, conn driver.Conn) error {
+		execer, ok := conn.(driver.ExecerContext)
+		if !ok {
+			return errors.New("the connection cannot execute statements")
+		}
+		_, err := execer.ExecContext(ctx, query, nil)
+		return err
+	}
+}
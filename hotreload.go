@@ -0,0 +1,182 @@
+package gopqr
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//DEFAULTCONFIGWATCHINTERVAL - Interval WatchConfig checks the
+	//configuration file for changes at unless told otherwise
+	DEFAULTCONFIGWATCHINTERVAL = 10 * time.Second
+)
+
+// settings holds the settings UpdateConfig put in place of the fields of the
+// driver of the same name.
+type settings struct {
+	policy             RotationPolicy
+	sticky             bool
+	noFallback         bool
+	tagApplicationName bool
+	keepReplacedConns  bool
+	authFailureCodes   []string
+	refreshTimeout     time.Duration
+}
+
+// UpdateConfig puts the settings of the configuration that do not concern
+// the credentials in place on the running driver, without re-creating it or
+// the pools opened on it. They are the RotationPolicy and Sticky, which
+// is set unless the configuration is Alternating,
+// NoFallback, TagApplicationName, KeepReplacedConns, AuthFailureCodes,
+// RefreshTimeout, RefreshInterval and the Threshold and CoolDown of the
+// CircuitBreaker, which the driver must have been built with. Every one of
+// them is replaced, so the configuration should be complete, like one read
+// by LoadConfig. The credentials, the Provider, the endpoint and the loggers
+// are left alone. Opens in flight finish with the settings they started
+// with. A configuration with problems changes nothing, and the error matches
+// ErrInvalidConfig.
+func (d *Driver) UpdateConfig(cfg Config) error {
+	var problems []string
+	if cfg.RefreshTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("RefreshTimeout %v must not be negative", cfg.RefreshTimeout))
+	}
+	if cfg.RefreshInterval < 0 {
+		problems = append(problems, fmt.Sprintf("RefreshInterval %v must not be negative", cfg.RefreshInterval))
+	}
+	if cfg.CircuitBreaker != nil && d.CircuitBreaker == nil {
+		problems = append(problems, "the driver has no CircuitBreaker to update, build it with one")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w - %v", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	d.updated.Store(&settings{
+		policy:             cfg.RotationPolicy,
+		sticky:             cfg.sticky(),
+		noFallback:         cfg.NoFallback,
+		tagApplicationName: cfg.TagApplicationName,
+		keepReplacedConns:  cfg.KeepReplacedConns,
+		authFailureCodes:   append([]string(nil), cfg.AuthFailureCodes...),
+		refreshTimeout:     cfg.RefreshTimeout,
+	})
+	d.refreshInterval.Store(int64(cfg.RefreshInterval))
+	if cfg.CircuitBreaker != nil && cfg.CircuitBreaker != d.CircuitBreaker {
+		d.CircuitBreaker.tune(cfg.CircuitBreaker.Threshold, cfg.CircuitBreaker.CoolDown)
+	}
+	d.event(slog.LevelInfo, "runtime settings updated", "policy", policyName(d.policy()), "no_fallback", cfg.NoFallback)
+	return nil
+}
+
+// WatchConfig checks the configuration file read by LoadConfig every
+// interval (defaults to DEFAULTCONFIGWATCHINTERVAL) and hands it to
+// UpdateConfig once it changed, so that the rotation policy or the
+// thresholds of a running service can be changed by editing the file. Its
+// provider is not built again, as the credentials are not updated. A file
+// that cannot be read or has problems is logged and the settings in place
+// stay. It returns a func that stops watching.
+func (d *Driver) WatchConfig(path string, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DEFAULTCONFIGWATCHINTERVAL
+	}
+	var seen time.Time
+	if info, err := os.Stat(path); err == nil {
+		seen = info.ModTime()
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.runWorker("config_watch", true, func(ran func()) {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+					info, err := os.Stat(path)
+					ran()
+					if err != nil {
+						d.logf("watching the configuration failed - %v", err)
+						continue
+					}
+					if info.ModTime().Equal(seen) {
+						continue
+					}
+					seen = info.ModTime()
+					cfg, _, err := readConfigFile(path)
+					if err == nil {
+						err = d.UpdateConfig(cfg)
+					}
+					if err != nil {
+						d.logf("updating the settings from %v failed - %v", path, err)
+					}
+				}
+			}
+		})
+	}()
+	var once sync.Once
+	return d.tracked(func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	})
+}
+
+// configuredPolicy returns the RotationPolicy set on the driver, by
+// UpdateConfig or else by its field, and whether it is sticky.
+func (d *Driver) configuredPolicy() (RotationPolicy, bool) {
+	if s := d.updated.Load(); s != nil {
+		return s.policy, s.sticky
+	}
+	return d.RotationPolicy, d.Sticky
+}
+
+func (d *Driver) noFallback() bool {
+	if s := d.updated.Load(); s != nil {
+		return s.noFallback
+	}
+	return d.NoFallback
+}
+
+func (d *Driver) tagApplicationName() bool {
+	if s := d.updated.Load(); s != nil {
+		return s.tagApplicationName
+	}
+	return d.TagApplicationName
+}
+
+func (d *Driver) keepReplacedConns() bool {
+	if s := d.updated.Load(); s != nil {
+		return s.keepReplacedConns
+	}
+	return d.KeepReplacedConns
+}
+
+func (d *Driver) authFailureCodes() []string {
+	if s := d.updated.Load(); s != nil {
+		return s.authFailureCodes
+	}
+	return d.AuthFailureCodes
+}
+
+func (d *Driver) refreshTimeout() time.Duration {
+	if s := d.updated.Load(); s != nil {
+		return s.refreshTimeout
+	}
+	return d.RefreshTimeout
+}
+
+// autoRefreshInterval returns the RefreshInterval of the configuration when
+// one was set, and else the interval StartAutoRefresh was started with.
+func (d *Driver) autoRefreshInterval(started time.Duration) time.Duration {
+	if interval := time.Duration(d.refreshInterval.Load()); interval > 0 {
+		return interval
+	}
+	return started
+}
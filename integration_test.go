@@ -0,0 +1,43 @@
+package gopqr_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr/testsupport"
+)
+
+// The tests against real Postgres servers are skipped where docker is not
+// available, and with -short.
+
+func TestRotationMatrix(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs Postgres servers in docker")
+	}
+	testsupport.Matrix(t)
+}
+
+func TestHarnessRotationSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a Postgres server in docker")
+	}
+	h := testsupport.NewHarness(t, testsupport.DEFAULTVERSIONS[len(testsupport.DEFAULTVERSIONS)-1])
+	testsupport.RotationSuite(t, h.Server)
+
+	t.Run("harness rotation", func(t *testing.T) {
+		ctx := context.Background()
+		db := h.DB(t)
+		if err := db.PingContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+		h.Rotate(t, "even")
+		h.Activate(t, "even")
+		if err := h.Driver.Refresh(); err != nil {
+			t.Fatalf("Refresh failed - %v", err)
+		}
+		even := h.Username(t, "even")
+		if user, err := h.CurrentUser(ctx); err != nil || user != even {
+			t.Fatalf("connected as %q - %v after rotating the even role, want %v", user, err, even)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package krbgopqr
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/lib/pq"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package krbgopqr authenticates the credentials of a
+github.com/chandranarreddy/gopqr driver that carry a Keytab over GSSAPI
+(Kerberos), with github.com/jcmturner/gokrb5. The principal of a credential
+is its Username, in the default realm of the Kerberos configuration unless it
+names one after an @. The clients are kept by principal and keytab, so that
+a rotated keytab logs in anew while connections of the same keytab share
+their tickets. It lives apart from the driver so that the driver does not
+depend on gokrb5.
+
+Usage:
+	gss, err := krbgopqr.GSS("")	// KRB5_CONFIG, or /etc/krb5.conf
+	...
+	pqrDriver, err := gopqr.New(gopqr.WithProvider(p), gopqr.WithGSS(gss))
+*/
+
+const (
+	//DEFAULTKRB5CONFIG - Kerberos configuration read when neither the path
+	// nor KRB5_CONFIG name one
+	DEFAULTKRB5CONFIG = "/etc/krb5.conf"
+)
+
+// GSS returns the gopqr.GSSFunc logging the credentials in with their
+// keytab, with the Kerberos configuration at the path, or at KRB5_CONFIG or
+// DEFAULTKRB5CONFIG when empty.
+func GSS(krb5conf string) (gopqr.GSSFunc, error) {
+	if krb5conf == "" {
+		krb5conf = os.Getenv("KRB5_CONFIG")
+	}
+	if krb5conf == "" {
+		krb5conf = DEFAULTKRB5CONFIG
+	}
+	cfg, err := config.Load(krb5conf)
+	if err != nil {
+		return nil, fmt.Errorf("Loading the Kerberos configuration %v failed - %v", krb5conf, err)
+	}
+	return WithConfig(cfg), nil
+}
+
+// WithConfig returns the gopqr.GSSFunc logging the credentials in with their
+// keytab, with the Kerberos configuration given.
+func WithConfig(cfg *config.Config) gopqr.GSSFunc {
+	c := &clients{cfg: cfg, m: make(map[clientKey]*client.Client)}
+	return c.gss
+}
+
+type clientKey struct {
+	principal string
+	keytab    [sha256.Size]byte
+}
+
+// clients holds the logged in clients by principal and keytab.
+type clients struct {
+	cfg *config.Config
+	mu  sync.Mutex
+	m   map[clientKey]*client.Client
+}
+
+func (c *clients) gss(cred gopqr.Credential, kt []byte) (pq.GSS, error) {
+	cl, err := c.client(cred.Username, kt)
+	if err != nil {
+		return nil, fmt.Errorf("Kerberos login of %v failed - %v", cred.Name, err)
+	}
+	return &gss{cl: cl}, nil
+}
+
+// client returns the client of the principal logged in with the keytab,
+// destroying those of the keytabs the principal had before.
+func (c *clients) client(principal string, kt []byte) (*client.Client, error) {
+	key := clientKey{principal: principal, keytab: sha256.Sum256(kt)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cl, ok := c.m[key]; ok {
+		return cl, nil
+	}
+	username, realm, _ := strings.Cut(principal, "@")
+	if realm == "" {
+		realm = c.cfg.LibDefaults.DefaultRealm
+	}
+	parsed := keytab.New()
+	if err := parsed.Unmarshal(kt); err != nil {
+		return nil, err
+	}
+	cl := client.NewWithKeytab(username, realm, parsed, c.cfg, client.DisablePAFXFAST(true))
+	if err := cl.Login(); err != nil {
+		return nil, err
+	}
+	for k, old := range c.m {
+		if k.principal == principal {
+			old.Destroy()
+			delete(c.m, k)
+		}
+	}
+	c.m[key] = cl
+	return cl, nil
+}
+
+// gss is the pq.GSS of one connection, over SPNEGO like
+// github.com/lib/pq/auth/kerberos.
+type gss struct {
+	cl *client.Client
+}
+
+func (g *gss) GetInitToken(host, service string) ([]byte, error) {
+	return g.GetInitTokenFromSpn(service + "/" + strings.TrimSuffix(host, "."))
+}
+
+func (g *gss) GetInitTokenFromSpn(spn string) ([]byte, error) {
+	token, err := spnego.SPNEGOClient(g.cl, spn).InitSecContext()
+	if err != nil {
+		return nil, fmt.Errorf("Kerberos error initiating the context for %v - %v", spn, err)
+	}
+	return token.Marshal()
+}
+
+func (g *gss) Continue(inToken []byte) (bool, []byte, error) {
+	var t spnego.SPNEGOToken
+	if err := t.Unmarshal(inToken); err != nil {
+		return true, nil, fmt.Errorf("Kerberos error reading the response - %v", err)
+	}
+	if state := t.NegTokenResp.State(); state != spnego.NegStateAcceptCompleted {
+		return true, nil, errors.New("Kerberos negotiation was not completed by the server")
+	}
+	return true, nil, nil
+}
@@ -0,0 +1,166 @@
+package gopqr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"sync"
+)
+
+// logf writes a notice of the driver to its Logger, or as a warning to its
+// EventLogger, or to the standard logger when it has neither. DSNs quoted in
+// the arguments are redacted.
+func (d *Driver) logf(format string, v ...interface{}) {
+	v = redactArgs(v)
+	switch {
+	case d.Logger != nil:
+		d.Logger.Printf("gopqr: "+format, v...)
+	case d.EventLogger != nil:
+		d.EventLogger.Warn("gopqr: " + fmt.Sprintf(format, v...))
+	default:
+		log.Printf("gopqr: "+format, v...)
+	}
+}
+
+// event emits a structured event of the driver to its EventLogger, or to
+// its Logger when GOPQR_DEBUG is set and it has no EventLogger.
+func (d *Driver) event(level slog.Level, msg string, args ...interface{}) {
+	if d.EventLogger == nil {
+		if flags().debug {
+			d.logf("%v %v %v", level, msg, fmt.Sprint(redactArgs(args)...))
+		}
+		return
+	}
+	d.EventLogger.Log(context.Background(), level, "gopqr: "+msg, redactArgs(args)...)
+}
+
+// eventing reports whether an event of the level would be emitted, so that
+// the hot path of Open does not build the arguments of events nobody reads.
+func (d *Driver) eventing(level slog.Level) bool {
+	if d.EventLogger == nil {
+		return flags().debug
+	}
+	return d.EventLogger.Enabled(context.Background(), level)
+}
+
+// deprecated writes a deprecation notice for the feature, once per driver.
+func (d *Driver) deprecated(feature, instead string) {
+	if _, seen := d.deprecations.LoadOrStore(feature, true); !seen {
+		d.logf("%v is deprecated and will be removed in a future release, please use %v instead", feature, instead)
+	}
+}
+
+// noteDeprecations writes the deprecation notices for the legacy API the
+// driver is configured with.
+func (d *Driver) noteDeprecations() {
+	if d.Provider == nil && d.CredentialRefresher != nil {
+		d.deprecated("CredentialRefresher", "Provider (gopqr.FromRefresher adapts an existing CredentialRefresher, gopqr.FromRefreshFunc takes a func returning the credentials)")
+	}
+}
+
+// FromRefresher adapts a CredentialRefresher func to a CredentialProvider so
+// that existing refreshers keep working as the Provider of a driver -
+//
+//	pqrDriver := &gopqr.Driver{Provider: gopqr.FromRefresher(myRefresher)}
+//
+// The func is invoked on a scratch driver seeded with the credentials it
+// set last time, and whatever it sets there - the odd and even credential
+// or the Slots, ActiveCredential and the endpoint overrides - is what
+// Current hands out. A func that returns early without setting anything
+// thus keeps the credentials as they were, and a refresh in which no
+// credential has a username and a password fails. The func is never run
+// twice at once, however Current and Refresh are called.
+func FromRefresher(refresher func(*Driver)) CredentialProvider {
+	return &refresherProvider{refresher: refresher}
+}
+
+type refresherProvider struct {
+	refresher func(*Driver)
+	// calls - Held while the refresher runs
+	calls sync.Mutex
+
+	mu      sync.Mutex
+	current *Credentials
+}
+
+func (p *refresherProvider) Current(ctx context.Context) (Credentials, error) {
+	p.mu.Lock()
+	c := p.current
+	p.mu.Unlock()
+	if c == nil {
+		if err := p.Refresh(ctx); err != nil {
+			return Credentials{}, err
+		}
+		p.mu.Lock()
+		c = p.current
+		p.mu.Unlock()
+	}
+	return *c, nil
+}
+
+func (p *refresherProvider) Refresh(ctx context.Context) error {
+	p.calls.Lock()
+	defer p.calls.Unlock()
+	p.mu.Lock()
+	scratch := seededDriver(p.current)
+	p.mu.Unlock()
+	p.refresher(scratch)
+	scratch.AcquireLock()
+	ring := scratch.slots()
+	if !anyCredential(ring) {
+		scratch.ReleaseLock()
+		return errors.New("CredentialRefresher set no username and password")
+	}
+	c := &Credentials{
+		Slots:       append([]Credential(nil), ring...),
+		Active:      slotIndex(ring, scratch.ActiveCredential),
+		Host:        scratch.Host,
+		Port:        scratch.Port,
+		SSLMode:     scratch.SSLMode,
+		SSLRootCert: scratch.SSLRootCert,
+		SSLCert:     scratch.SSLCert,
+		SSLKey:      scratch.SSLKey,
+		Extra:       copyExtra(scratch.extra),
+	}
+	scratch.ReleaseLock()
+	p.mu.Lock()
+	p.current = c
+	p.mu.Unlock()
+	return nil
+}
+
+// seededDriver returns a scratch driver for a legacy refresher, carrying the
+// credentials c in the fields a refresher sets - the odd and even credential
+// when c is the default pair, the Slots otherwise.
+func seededDriver(c *Credentials) *Driver {
+	scratch := &Driver{}
+	if c == nil {
+		return scratch
+	}
+	if len(c.Slots) == 2 && c.Slots[0].Name == oddCredential.String() && c.Slots[1].Name == evenCredential.String() {
+		scratch.OddUsername, scratch.OddPassword = c.Slots[0].Username, c.Slots[0].Password
+		scratch.EvenUsername, scratch.EvenPassword = c.Slots[1].Username, c.Slots[1].Password
+	} else {
+		scratch.Slots = append([]Credential(nil), c.Slots...)
+	}
+	if c.Active >= 0 && c.Active < len(c.Slots) {
+		scratch.ActiveCredential = c.Slots[c.Active].Name
+	}
+	scratch.Host, scratch.Port, scratch.SSLMode = c.Host, c.Port, c.SSLMode
+	scratch.SSLRootCert, scratch.SSLCert, scratch.SSLKey = c.SSLRootCert, c.SSLCert, c.SSLKey
+	scratch.extra = copyExtra(c.Extra)
+	return scratch
+}
+
+// anyCredential reports whether a slot of the ring has both a username and
+// a password.
+func anyCredential(ring []Credential) bool {
+	for _, c := range ring {
+		if c.Username != "" && c.Password != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package gopqr_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+)
+
+// earlyReturnRefresher sets the credentials on its first call only, and
+// then returns early as if fetching the secret had failed, just like the
+// refresher of example/aws_sm_creds_pgr.go does.
+func earlyReturnRefresher(calls *int) func(*gopqr.Driver) {
+	return func(d *gopqr.Driver) {
+		*calls++
+		if *calls > 1 {
+			return
+		}
+		d.AcquireLock()
+		defer d.ReleaseLock()
+		d.OddUsername, d.OddPassword = "app_odd", "odd-pw"
+		d.EvenUsername, d.EvenPassword = "app_even", "even-pw"
+		d.ActiveCredential = "odd"
+	}
+}
+
+func TestFromRefresherEarlyReturnKeepsCredentials(t *testing.T) {
+	var calls int
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	d := &gopqr.Driver{Provider: gopqr.FromRefresher(earlyReturnRefresher(&calls)), Backend: backend}
+
+	conn, err := d.Open(testDSN)
+	if err != nil {
+		t.Fatalf("Open failed - %v", err)
+	}
+	conn.Close()
+	if err := d.Refresh(); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("refresher called %v times, want 2", calls)
+	}
+	creds, err := d.Provider.Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if odd := creds.Slots[0]; odd.Username != "app_odd" || odd.Password != "odd-pw" {
+		t.Errorf("odd credential = %q/%q after the early return, want app_odd/odd-pw", odd.Username, odd.Password)
+	}
+	conn, err = d.Open(testDSN)
+	if err != nil {
+		t.Fatalf("Open after the early return failed - %v", err)
+	}
+	conn.Close()
+}
+
+func TestFromRefresherNoCredentials(t *testing.T) {
+	provider := gopqr.FromRefresher(func(d *gopqr.Driver) {})
+	if err := provider.Refresh(context.Background()); err == nil {
+		t.Fatal("Refresh of a refresher that set nothing succeeded, want an error")
+	}
+	if _, err := provider.Current(context.Background()); err == nil {
+		t.Fatal("Current of a refresher that set nothing succeeded, want an error")
+	}
+	_, err := (&gopqr.Driver{Provider: provider, Backend: gopqrtest.NewBackend()}).Open(testDSN)
+	if err == nil || errors.Is(err, gopqr.ErrAllCredentialsFailed) {
+		t.Errorf("Open = %v, want the error of the refresh rather than a login attempt", err)
+	}
+}
+
+func TestCredentialRefresherNeverRunsConcurrently(t *testing.T) {
+	var running, overlaps atomic.Int32
+	refresher := func(d *gopqr.Driver) {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		defer running.Add(-1)
+		time.Sleep(time.Millisecond)
+		d.AcquireLock()
+		defer d.ReleaseLock()
+		d.OddUsername, d.OddPassword = "app_odd", "odd-pw"
+		d.EvenUsername, d.EvenPassword = "app_even", "even-pw"
+		d.ActiveCredential = "odd"
+	}
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	provider := gopqr.FromRefresher(refresher)
+	d := &gopqr.Driver{Provider: provider, Backend: backend}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			d.Refresh()
+		}()
+		go func() {
+			defer wg.Done()
+			provider.Refresh(context.Background())
+		}()
+		go func() {
+			defer wg.Done()
+			provider.Current(context.Background())
+		}()
+	}
+	wg.Wait()
+	if n := overlaps.Load(); n != 0 {
+		t.Errorf("CredentialRefresher ran concurrently %v times, want never", n)
+	}
+}
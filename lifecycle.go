@@ -0,0 +1,124 @@
+package gopqr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// ErrDriverClosed is returned by the Opens of a driver after Close.
+var ErrDriverClosed = errors.New("The driver is closed")
+
+// lifecycle holds what Close undoes - the context the refreshes run under
+// and the stop funcs of the background work started on the driver.
+type lifecycle struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	next   int
+	stops  map[int]func()
+}
+
+// Close shuts the driver down for a graceful shutdown, or at the end of a
+// test. It fails the Opens that follow with ErrDriverClosed, cancels the
+// refresh in flight and stops the background work started on the driver,
+// like by StartAutoRefresh, StartShadowValidation, WatchConfig or
+// ManagePool, waiting for it to finish until ctx is done. The Provider is
+// closed as well when it has a Close method, as its watchers run for the
+// driver, and the AuditSink and Metrics are flushed when they have a Flush
+// method. With WipeOnClose, the PasswordBytes of the credentials are zeroed
+// last. The pools opened on the driver are left to their own Close. Calling
+// it again does nothing.
+func (d *Driver) Close(ctx context.Context) error {
+	if !d.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	d.lifetime()
+	d.life.mu.Lock()
+	d.life.cancel()
+	ids := make([]int, 0, len(d.life.stops))
+	for id := range d.life.stops {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	pending := d.life.stops
+	d.life.stops = nil
+	d.life.mu.Unlock()
+
+	var errs []error
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		// newest first, as later work may rely on earlier work
+		for i := len(ids) - 1; i >= 0; i-- {
+			pending[ids[i]]()
+		}
+		d.refresh.wait(context.Background())
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("Waiting for the background work of the driver to stop - %w", ctx.Err()))
+	}
+	if closer, ok := d.Provider.(interface{ Close() error }); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("Closing the provider failed - %w", err))
+		}
+	}
+	for _, sink := range []any{d.AuditSink, d.Metrics} {
+		if flusher, ok := sink.(interface{ Flush() error }); ok {
+			if err := flusher.Flush(); err != nil {
+				errs = append(errs, fmt.Errorf("Flushing %T failed - %w", sink, err))
+			}
+		}
+	}
+	if d.WipeOnClose {
+		d.retireCurrent()
+	}
+	d.event(slog.LevelInfo, "driver closed")
+	return errors.Join(errs...)
+}
+
+// Closed reports whether Close was called on the driver.
+func (d *Driver) Closed() bool {
+	return d.closed.Load()
+}
+
+// lifetime returns the context the refreshes of the driver run under, which
+// Close cancels.
+func (d *Driver) lifetime() context.Context {
+	d.life.mu.Lock()
+	defer d.life.mu.Unlock()
+	if d.life.ctx == nil {
+		d.life.ctx, d.life.cancel = context.WithCancel(context.Background())
+	}
+	return d.life.ctx
+}
+
+// tracked registers the stop func of background work started on the driver
+// for Close, returning a func that stops it and unregisters it. Work started
+// after Close is stopped right away.
+func (d *Driver) tracked(stop func()) func() {
+	d.life.mu.Lock()
+	if d.closed.Load() {
+		d.life.mu.Unlock()
+		stop()
+		return stop
+	}
+	if d.life.stops == nil {
+		d.life.stops = make(map[int]func())
+	}
+	id := d.life.next
+	d.life.next++
+	d.life.stops[id] = stop
+	d.life.mu.Unlock()
+	return func() {
+		d.life.mu.Lock()
+		delete(d.life.stops, id)
+		d.life.mu.Unlock()
+		stop()
+	}
+}
@@ -0,0 +1,280 @@
+package gopqr
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Listener is a pq.Listener for LISTEN/NOTIFY whose connection is made with
+// the credentials of a Driver rather than the fixed DSN of pq.NewListener.
+// When a reconnect fails authentication, like once the credential it
+// connected with was rotated out, the refresh of the driver is triggered and
+// the connection is made anew with the active credential, or with the next
+// one of the ring when the active one is the credential that failed. The
+// channels listened on are listened on again, and a nil notification is sent
+// on the NotificationChannel, as pq does after a reconnect, since
+// notifications may have been missed in between.
+//
+// Like LISTEN itself, the connection is always made through lib/pq, whatever
+// the Backend of the driver, with its Dialer if set.
+type Listener struct {
+	d                          *Driver
+	dsn                        string
+	minReconnect, maxReconnect time.Duration
+	cb                         pq.EventCallbackType
+	notify                     chan *pq.Notification
+	done                       chan struct{}
+	forwarding                 sync.WaitGroup
+
+	mu       sync.Mutex
+	pl       *pq.Listener
+	gen      uint64
+	slot     string
+	channels map[string]struct{}
+	closed   bool
+}
+
+// NewListener returns a Listener connecting to the DSN with the credentials
+// of the driver. The DSN carries no credentials, like the one passed to
+// sql.Open, and minReconnect, maxReconnect and the callback mean the same as
+// for pq.NewListener. Errors the callback receives are redacted.
+func NewListener(d *Driver, dsn string, minReconnect, maxReconnect time.Duration, cb pq.EventCallbackType) (*Listener, error) {
+	if d == nil {
+		return nil, errors.New("NewListener needs a driver")
+	}
+	l := &Listener{
+		d:            d,
+		dsn:          dsn,
+		minReconnect: minReconnect,
+		maxReconnect: maxReconnect,
+		cb:           cb,
+		notify:       make(chan *pq.Notification, 32),
+		done:         make(chan struct{}),
+		channels:     make(map[string]struct{}),
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.connect(d.current().active, false); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// connect replaces the pq.Listener with one connecting with the credential
+// of the slot. It must be called with the lock held.
+func (l *Listener) connect(slot string, replacing bool) error {
+	slotDSN, err := l.d.dsnFor(l.dsn, slot)
+	if err != nil {
+		return err
+	}
+	l.gen++
+	gen := l.gen
+	cb := func(event pq.ListenerEventType, err error) {
+		l.event(gen, slot, replacing, event, err)
+	}
+	var pl *pq.Listener
+	if l.d.Dialer != nil {
+		pl = pq.NewDialListener(l.d.Dialer, slotDSN, l.minReconnect, l.maxReconnect, cb)
+	} else {
+		pl = pq.NewListener(slotDSN, l.minReconnect, l.maxReconnect, cb)
+	}
+	if l.pl != nil {
+		l.pl.Close()
+	}
+	l.pl, l.slot = pl, slot
+	l.forwarding.Add(1)
+	go l.forward(pl)
+	for channel := range l.channels {
+		// Listen waits for the connection, which the listener makes on its own
+		go pl.Listen(channel)
+	}
+	return nil
+}
+
+// forward passes the notifications of the pq.Listener on until it is
+// closed.
+func (l *Listener) forward(pl *pq.Listener) {
+	defer l.forwarding.Done()
+	for {
+		select {
+		case n, ok := <-pl.Notify:
+			if !ok {
+				return
+			}
+			select {
+			case l.notify <- n:
+			case <-l.done:
+				return
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// event handles an event of the pq.Listener of the generation, connected
+// with the credential of the slot.
+func (l *Listener) event(gen uint64, slot string, replacing bool, event pq.ListenerEventType, err error) {
+	if err != nil {
+		err = redact(err, l.d.current().ring)
+	}
+	switch {
+	case event == pq.ListenerEventConnected && replacing:
+		// to whoever listens, the listener reconnected
+		event = pq.ListenerEventReconnected
+		select {
+		case l.notify <- nil:
+		case <-l.done:
+		}
+	case event == pq.ListenerEventConnectionAttemptFailed && l.d.isAuthFailure(err):
+		l.d.metrics().AuthFailed(slot)
+		l.d.event(slog.LevelWarn, "listener credential failed authentication", "slot", slot)
+		// closing the pq.Listener from its own callback would deadlock
+		go l.reconnect(gen, slot)
+	}
+	if l.cb != nil {
+		l.cb(event, err)
+	}
+}
+
+// reconnect replaces the pq.Listener of the generation, whose credential of
+// the slot failed authentication.
+func (l *Listener) reconnect(gen uint64, failed string) {
+	l.d.startRefresh()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.gen != gen {
+		return
+	}
+	snap := l.d.current()
+	next := snap.active
+	if next == failed && !l.d.noFallback() {
+		if fallbacks := fallbackOrder(snap.ring, slotIndex(snap.ring, failed)); len(fallbacks) > 0 {
+			next = fallbacks[0].Name
+		}
+	}
+	if err := l.connect(next, true); err != nil {
+		l.d.logf("reconnecting listener failed - %v", redact(err, snap.ring))
+		return
+	}
+	l.d.event(slog.LevelInfo, "listener reconnecting with another credential", "from", failed, "to", next)
+}
+
+// current returns the pq.Listener of the moment.
+func (l *Listener) current() (*pq.Listener, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, net.ErrClosed
+	}
+	return l.pl, nil
+}
+
+// NotificationChannel returns the channel the notifications are received
+// on, which stays the same across reconnects. A nil notification tells that
+// the connection was made anew and notifications may have been missed.
+func (l *Listener) NotificationChannel() <-chan *pq.Notification {
+	return l.notify
+}
+
+// Slot returns the slot of the credential the listener connects with.
+func (l *Listener) Slot() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.slot
+}
+
+// Listen starts listening on the channel, waiting for the connection like
+// pq.Listener.Listen, and listening again after every reconnect.
+func (l *Listener) Listen(channel string) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return net.ErrClosed
+	}
+	if _, ok := l.channels[channel]; ok {
+		l.mu.Unlock()
+		return pq.ErrChannelAlreadyOpen
+	}
+	l.channels[channel] = struct{}{}
+	l.mu.Unlock()
+	for {
+		pl, err := l.current()
+		if err != nil {
+			return err
+		}
+		err = pl.Listen(channel)
+		if errors.Is(err, net.ErrClosed) || errors.Is(err, pq.ErrChannelAlreadyOpen) {
+			if next, _ := l.current(); next != nil && next != pl {
+				// replaced while waiting, the new one listens on it already
+				continue
+			}
+		}
+		if err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+			l.mu.Lock()
+			delete(l.channels, channel)
+			l.mu.Unlock()
+			return err
+		}
+		return nil
+	}
+}
+
+// Unlisten stops listening on the channel.
+func (l *Listener) Unlisten(channel string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return net.ErrClosed
+	}
+	if _, ok := l.channels[channel]; !ok {
+		return pq.ErrChannelNotOpen
+	}
+	delete(l.channels, channel)
+	if err := l.pl.Unlisten(channel); err != nil && !errors.Is(err, pq.ErrChannelNotOpen) {
+		return err
+	}
+	return nil
+}
+
+// UnlistenAll stops listening on every channel.
+func (l *Listener) UnlistenAll() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return net.ErrClosed
+	}
+	l.channels = make(map[string]struct{})
+	return l.pl.UnlistenAll()
+}
+
+// Ping checks the connection of the listener.
+func (l *Listener) Ping() error {
+	pl, err := l.current()
+	if err != nil {
+		return err
+	}
+	return pl.Ping()
+}
+
+// Close closes the connection of the listener and then the
+// NotificationChannel.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return net.ErrClosed
+	}
+	l.closed = true
+	close(l.done)
+	err := l.pl.Close()
+	l.mu.Unlock()
+	l.forwarding.Wait()
+	close(l.notify)
+	return err
+}
@@ -0,0 +1,69 @@
+package gopqr
+
+import (
+	"sync"
+	"time"
+)
+
+// liveConns holds the connections of a driver that are open.
+type liveConns struct {
+	mu sync.Mutex
+	m  map[*rotatingConn]struct{}
+}
+
+func (l *liveConns) add(c *rotatingConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.m == nil {
+		l.m = make(map[*rotatingConn]struct{})
+	}
+	l.m[c] = struct{}{}
+}
+
+func (l *liveConns) remove(c *rotatingConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.m, c)
+}
+
+// oldest returns when the oldest of the connections was opened, the zero
+// time when there are none.
+func (l *liveConns) oldest() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var oldest time.Time
+	for c := range l.m {
+		if oldest.IsZero() || c.opened.Before(oldest) {
+			oldest = c.opened
+		}
+	}
+	return oldest
+}
+
+// LiveConns returns the number of connections open, the ones in use and the
+// idle ones of every pool, by the user that authenticated them. Every user
+// of the ring is listed, with zero when it has none. They are counted by
+// user rather than by slot, since a refresh can put a new user in the slot
+// of the retiring one, so that a rotation can confirm the connections of
+// the retiring user have drained before its role is dropped -
+//
+//	if pqrDriver.LiveConns()["app_odd"] == 0 {
+//		// DROP ROLE app_odd
+//	}
+//
+// Connections are counted from the time Open returns them until database/sql
+// closes them.
+func (d *Driver) LiveConns() map[string]int {
+	live := make(map[string]int)
+	for _, c := range d.current().ring {
+		if c.Username != "" {
+			live[c.Username] = 0
+		}
+	}
+	d.live.mu.Lock()
+	defer d.live.mu.Unlock()
+	for c := range d.live.m {
+		live[c.cred.Username]++
+	}
+	return live
+}
@@ -0,0 +1,45 @@
+package gopqr_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+)
+
+func TestLiveConnsByUser(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	backend.Allow("app_odd_v2", "odd-pw-2")
+	d, store := testDriver(backend)
+	conn, err := d.Open(testDSN)
+	if err != nil {
+		t.Fatalf("Open failed - %v", err)
+	}
+
+	// the odd slot gets a new user, the connection of the retiring one
+	// stays open
+	ctx := context.Background()
+	secret, err := store.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.OddUsername, secret.OddPassword = "app_odd_v2", "odd-pw-2"
+	if err := store.Put(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Refresh(); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	live := d.LiveConns()
+	if live["app_odd"] != 1 || live["app_odd_v2"] != 0 {
+		t.Errorf("LiveConns = %v, want the connection counted for app_odd and none for app_odd_v2", live)
+	}
+	if _, ok := live["app_even"]; !ok {
+		t.Errorf("LiveConns = %v, want every user of the ring listed", live)
+	}
+	conn.Close()
+	if n := d.LiveConns()["app_odd"]; n != 0 {
+		t.Errorf("LiveConns of app_odd = %v once closed, want 0", n)
+	}
+}
@@ -0,0 +1,327 @@
+package gopqr
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProviderFactory builds a CredentialProvider out of the "params" of the
+// "provider" of a configuration file, see LoadConfig.
+type ProviderFactory func(params json.RawMessage) (CredentialProvider, error)
+
+// configRegistry holds the provider types and the file formats LoadConfig
+// knows, registered by the packages implementing them.
+var configRegistry struct {
+	mu        sync.RWMutex
+	providers map[string]ProviderFactory
+	formats   map[string]func([]byte) ([]byte, error)
+}
+
+// RegisterProviderType makes the provider type known to LoadConfig under the
+// name. The provider subpackages register theirs when imported, like
+// "awssm" or "file", so that a blank import is all a configuration file
+// naming them needs. Like sql.Register, it panics when the name is taken.
+func RegisterProviderType(name string, factory ProviderFactory) {
+	if factory == nil {
+		panic("gopqr: RegisterProviderType needs a factory")
+	}
+	configRegistry.mu.Lock()
+	defer configRegistry.mu.Unlock()
+	if configRegistry.providers == nil {
+		configRegistry.providers = make(map[string]ProviderFactory)
+	}
+	if _, taken := configRegistry.providers[name]; taken {
+		panic("gopqr: RegisterProviderType called twice for provider type " + name)
+	}
+	configRegistry.providers[name] = factory
+}
+
+// ProviderTypes returns the names of the provider types registered, sorted.
+func ProviderTypes() []string {
+	configRegistry.mu.RLock()
+	defer configRegistry.mu.RUnlock()
+	names := make([]string, 0, len(configRegistry.providers))
+	for name := range configRegistry.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfigProvider adapts the constructor of a provider taking a config
+// struct to a ProviderFactory. The params are unmarshalled into the config,
+// their names matched to its fields regardless of case, and durations may be
+// given as strings like "10s".
+func ConfigProvider[C any, P CredentialProvider](build func(C) (P, error)) ProviderFactory {
+	return func(params json.RawMessage) (CredentialProvider, error) {
+		var cfg C
+		if len(params) > 0 {
+			params, err := durationsAsNanos(params, reflect.TypeOf(cfg))
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(params, &cfg); err != nil {
+				return nil, err
+			}
+		}
+		p, err := build(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+// durationsAsNanos rewrites the params given as strings for the
+// time.Duration fields of the struct type t as the nanoseconds encoding/json
+// expects.
+func durationsAsNanos(params json.RawMessage, t reflect.Type) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if t.Kind() != reflect.Struct || json.Unmarshal(params, &fields) != nil {
+		return params, nil
+	}
+	durationType := reflect.TypeOf(time.Duration(0))
+	changed := false
+	for key, value := range fields {
+		field, ok := t.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) })
+		var text string
+		if !ok || field.Type != durationType || json.Unmarshal(value, &text) != nil {
+			continue
+		}
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return nil, fmt.Errorf("%v - %v", key, err)
+		}
+		fields[key] = json.RawMessage(strconv.FormatInt(int64(d), 10))
+		changed = true
+	}
+	if !changed {
+		return params, nil
+	}
+	return json.Marshal(fields)
+}
+
+// RegisterConfigFormat has LoadConfig read the files of the extension, like
+// ".yaml", by converting them to JSON first. The configyaml subpackage
+// registers YAML when imported.
+func RegisterConfigFormat(ext string, toJSON func([]byte) ([]byte, error)) {
+	configRegistry.mu.Lock()
+	defer configRegistry.mu.Unlock()
+	if configRegistry.formats == nil {
+		configRegistry.formats = make(map[string]func([]byte) ([]byte, error))
+	}
+	configRegistry.formats[strings.ToLower(ext)] = toJSON
+}
+
+// PoolConfig - Hints for the connection pool of the *sql.DB of a driver,
+// applied by Config.Open. Zero values leave the defaults of database/sql.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// configDuration is a duration of a configuration file, like "30s".
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalJSON(raw []byte) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return fmt.Errorf("durations are strings like \"30s\", not %s", raw)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(parsed)
+	return nil
+}
+
+// configFile is the document LoadConfig reads.
+type configFile struct {
+	DSN      string `json:"dsn"`
+	Provider *struct {
+		Type   string          `json:"type"`
+		Params json.RawMessage `json:"params"`
+	} `json:"provider"`
+	RotationPolicy     string         `json:"rotation_policy"`
+	RotationInterval   configDuration `json:"rotation_interval"`
+	Sticky             bool           `json:"sticky"`
+	Alternating        bool           `json:"alternating"`
+	NoFallback         bool           `json:"no_fallback"`
+	TagApplicationName bool           `json:"tag_application_name"`
+	KeepReplacedConns  bool           `json:"keep_replaced_conns"`
+	Host               string         `json:"host"`
+	Port               string         `json:"port"`
+	SSLMode            string         `json:"sslmode"`
+	SSLRootCert        string         `json:"sslrootcert"`
+	SSLCert            string         `json:"sslcert"`
+	SSLKey             string         `json:"sslkey"`
+	RefreshTimeout     configDuration `json:"refresh_timeout"`
+	RefreshInterval    configDuration `json:"refresh_interval"`
+	AuthFailureCodes   []string       `json:"auth_failure_codes"`
+	CircuitBreaker     *struct {
+		Threshold int            `json:"threshold"`
+		CoolDown  configDuration `json:"cool_down"`
+	} `json:"circuit_breaker"`
+	Pool struct {
+		MaxOpenConns    int            `json:"max_open_conns"`
+		MaxIdleConns    int            `json:"max_idle_conns"`
+		ConnMaxLifetime configDuration `json:"conn_max_lifetime"`
+		ConnMaxIdleTime configDuration `json:"conn_max_idle_time"`
+	} `json:"pool"`
+}
+
+// LoadConfig reads the configuration of a driver from a JSON file, or a
+// file of a format registered with RegisterConfigFormat, like YAML, so that
+// where the credentials come from is decided by deployment rather than in
+// code -
+//
+//	{
+//		"dsn": "postgres://mydb:5432/mydb?sslmode=verify-full",
+//		"provider": {"type": "awssm", "params": {"SecretID": "prod/mydb", "Region": "us-west-2"}},
+//		"rotation_policy": "on_auth_failure",
+//		"refresh_timeout": "10s",
+//		"pool": {"max_open_conns": 20, "conn_max_lifetime": "30m"}
+//	}
+//
+// The provider is built by the factory registered for its type, whose
+// package has to be imported. The rotation policy is one of "per_open",
+// "on_auth_failure", "on_interval" with a "rotation_interval" and
+// "on_secret_version_change". The endpoint, TLS and behavior settings are
+// named like the fields of Config in snake case. Unknown fields are
+// reported, to catch typos. The Config returned is validated, and Open
+// builds the driver and the pool out of it. WatchConfig updates the
+// settings of a running driver when the file changes.
+func LoadConfig(path string) (Config, error) {
+	cfg, f, err := readConfigFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	if f.Provider == nil || f.Provider.Type == "" {
+		return Config{}, fmt.Errorf("%w - %v names no provider type", ErrInvalidConfig, path)
+	}
+	configRegistry.mu.RLock()
+	factory := configRegistry.providers[f.Provider.Type]
+	configRegistry.mu.RUnlock()
+	if factory == nil {
+		return Config{}, fmt.Errorf("%w - provider type %q is not registered, import its package; registered are %q", ErrInvalidConfig, f.Provider.Type, ProviderTypes())
+	}
+	if cfg.Provider, err = factory(f.Provider.Params); err != nil {
+		return Config{}, fmt.Errorf("%w - building the %v provider failed - %v", ErrInvalidConfig, f.Provider.Type, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// readConfigFile reads the configuration file, leaving the provider it
+// names to be built.
+func readConfigFile(path string) (Config, configFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, configFile{}, fmt.Errorf("Reading the configuration %v failed - %v", path, err)
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	configRegistry.mu.RLock()
+	toJSON := configRegistry.formats[ext]
+	configRegistry.mu.RUnlock()
+	if toJSON != nil {
+		if raw, err = toJSON(raw); err != nil {
+			return Config{}, configFile{}, fmt.Errorf("Reading the configuration %v failed - %v", path, err)
+		}
+	} else if ext == ".yaml" || ext == ".yml" {
+		return Config{}, configFile{}, fmt.Errorf("Reading the configuration %v failed - import the configyaml subpackage to read YAML", path)
+	}
+	var f configFile
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return Config{}, configFile{}, fmt.Errorf("%w - parsing %v failed - %v", ErrInvalidConfig, path, err)
+	}
+	cfg := Config{
+		DSN:                f.DSN,
+		Sticky:             f.Sticky,
+		Alternating:        f.Alternating,
+		NoFallback:         f.NoFallback,
+		TagApplicationName: f.TagApplicationName,
+		KeepReplacedConns:  f.KeepReplacedConns,
+		Host:               f.Host,
+		Port:               f.Port,
+		SSLMode:            f.SSLMode,
+		SSLRootCert:        f.SSLRootCert,
+		SSLCert:            f.SSLCert,
+		SSLKey:             f.SSLKey,
+		RefreshTimeout:     time.Duration(f.RefreshTimeout),
+		RefreshInterval:    time.Duration(f.RefreshInterval),
+		AuthFailureCodes:   f.AuthFailureCodes,
+		Pool: PoolConfig{
+			MaxOpenConns:    f.Pool.MaxOpenConns,
+			MaxIdleConns:    f.Pool.MaxIdleConns,
+			ConnMaxLifetime: time.Duration(f.Pool.ConnMaxLifetime),
+			ConnMaxIdleTime: time.Duration(f.Pool.ConnMaxIdleTime),
+		},
+	}
+	if cfg.RotationPolicy, err = configPolicy(f.RotationPolicy, time.Duration(f.RotationInterval)); err != nil {
+		return Config{}, configFile{}, err
+	}
+	if f.CircuitBreaker != nil {
+		cfg.CircuitBreaker = &CircuitBreaker{Threshold: f.CircuitBreaker.Threshold, CoolDown: time.Duration(f.CircuitBreaker.CoolDown)}
+	}
+	return cfg, f, nil
+}
+
+// configPolicy returns the RotationPolicy of the name, nil for none.
+func configPolicy(name string, interval time.Duration) (RotationPolicy, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "per_open":
+		return PerOpen(), nil
+	case "on_auth_failure":
+		return OnAuthFailure(), nil
+	case "on_secret_version_change":
+		return OnSecretVersionChange(), nil
+	case "on_interval":
+		if interval <= 0 {
+			return nil, fmt.Errorf("%w - rotation_policy on_interval needs a rotation_interval", ErrInvalidConfig)
+		}
+		return OnInterval(interval), nil
+	}
+	return nil, fmt.Errorf("%w - unknown rotation_policy %q", ErrInvalidConfig, name)
+}
+
+// Open builds the driver of the configuration and a *sql.DB of its DSN over
+// it, with the hints of its Pool applied.
+func (cfg Config) Open() (*sql.DB, *Driver, error) {
+	d, err := NewDriver(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	db := OpenDB(cfg.DSN, d)
+	if cfg.Pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.Pool.MaxOpenConns)
+	}
+	if cfg.Pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.Pool.MaxIdleConns)
+	}
+	if cfg.Pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.Pool.ConnMaxLifetime)
+	}
+	if cfg.Pool.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.Pool.ConnMaxIdleTime)
+	}
+	return db, d, nil
+}
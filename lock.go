@@ -0,0 +1,154 @@
+package gopqr
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLockTimeout is returned when the credential lock of the driver could not
+// be acquired within the configured timeout. This usually means a
+// CredentialRefresher acquired the lock and never released it.
+var ErrLockTimeout = errors.New("Timed out acquiring the credential lock")
+
+// LockDiagnostic describes a credential lock that has been held longer than
+// the LockHeldThreshold of the driver.
+type LockDiagnostic struct {
+	// HeldFor - How long the lock had been held when the diagnostic was taken
+	HeldFor time.Duration
+	// HolderStack - Stack of the goroutine that acquired the lock, captured at
+	// the time of acquisition
+	HolderStack []byte
+	// Goroutines - Dump of all goroutines at the time the threshold was crossed
+	Goroutines []byte
+}
+
+// credentialLock is a mutex that supports acquisition with a timeout and
+// reports holders that keep it beyond a threshold.
+type credentialLock struct {
+	once sync.Once
+	sem  chan struct{}
+
+	// tracking - Above zero while refreshes run, which record the goroutine
+	// acquiring the lock in owner, so that the lock a panicking refresher
+	// left held can be told apart from that of another goroutine
+	tracking atomic.Int32
+
+	mu     sync.Mutex
+	gen    uint64
+	since  time.Time
+	holder []byte
+	timer  *time.Timer
+	owner  int64
+}
+
+func (l *credentialLock) init() {
+	l.once.Do(func() {
+		l.sem = make(chan struct{}, 1)
+	})
+}
+
+func (l *credentialLock) acquire(d *Driver, timeout time.Duration) error {
+	l.init()
+	if timeout <= 0 {
+		l.sem <- struct{}{}
+	} else {
+		t := time.NewTimer(timeout)
+		select {
+		case l.sem <- struct{}{}:
+			t.Stop()
+		case <-t.C:
+			return ErrLockTimeout
+		}
+	}
+	if d.snap.Load() == nil {
+		// the credentials as they were before the holder changes any,
+		// for Open to read meanwhile, see current
+		d.publish()
+	}
+	if l.tracking.Load() > 0 {
+		owner := goroutineID()
+		l.mu.Lock()
+		l.owner = owner
+		l.mu.Unlock()
+	}
+	l.watch(d)
+	return nil
+}
+
+// heldBy reports whether the lock is held by the goroutine, as far as it is
+// tracked.
+func (l *credentialLock) heldBy(goroutine int64) bool {
+	l.init()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.owner == goroutine && len(l.sem) == 1
+}
+
+// goroutineID returns the id of the calling goroutine, read off its stack.
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf, _ = bytes.CutPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseInt(string(buf), 10, 64)
+	return id
+}
+
+func (l *credentialLock) release() {
+	l.init()
+	l.mu.Lock()
+	l.gen++
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.holder = nil
+	l.owner = 0
+	l.mu.Unlock()
+	select {
+	case <-l.sem:
+	default:
+		panic("gopqr: release of unlocked credential lock")
+	}
+}
+
+// watch arms the held-too-long diagnostic for the current holder.
+func (l *credentialLock) watch(d *Driver) {
+	if d.LockHeldThreshold <= 0 {
+		return
+	}
+	buf := make([]byte, 8<<10)
+	buf = buf[:runtime.Stack(buf, false)]
+	l.mu.Lock()
+	l.gen++
+	gen := l.gen
+	l.since = time.Now()
+	l.holder = buf
+	l.timer = time.AfterFunc(d.LockHeldThreshold, func() {
+		l.mu.Lock()
+		if l.gen != gen {
+			l.mu.Unlock()
+			return
+		}
+		diag := LockDiagnostic{
+			HeldFor:     time.Since(l.since),
+			HolderStack: l.holder,
+		}
+		l.mu.Unlock()
+		all := make([]byte, 1<<20)
+		diag.Goroutines = all[:runtime.Stack(all, true)]
+		if d.OnLockHeldTooLong != nil {
+			d.OnLockHeldTooLong(diag)
+			return
+		}
+		d.logf("credential lock held for %v, acquired at -\n%s", diag.HeldFor, diag.HolderStack)
+	})
+	l.mu.Unlock()
+}
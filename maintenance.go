@@ -0,0 +1,61 @@
+package gopqr
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// maintenance returns the driver of the maintenance credentials, building it
+// on first use. It sources its credentials from the MaintenanceProvider and
+// is refreshed on its own, so that the application credentials and the
+// maintenance credentials are never mixed.
+func (d *Driver) maintenance() (*Driver, error) {
+	d.maintenanceMu.Lock()
+	defer d.maintenanceMu.Unlock()
+	if d.MaintenanceProvider == nil {
+		return nil, errors.New("No MaintenanceProvider is set on the driver")
+	}
+	if d.maintenanceDriver == nil || d.maintenanceDriver.Provider != d.MaintenanceProvider {
+		d.maintenanceDriver = &Driver{
+			Provider:         d.MaintenanceProvider,
+			Sticky:           true,
+			LockTimeout:      d.LockTimeout,
+			AuthFailureCodes: d.authFailureCodes(),
+			AuthFailure:      d.AuthFailure,
+			RefreshRetry:     d.RefreshRetry,
+			RefreshTimeout:   d.refreshTimeout(),
+			Logger:           d.Logger,
+		}
+	}
+	return d.maintenanceDriver, nil
+}
+
+// OpenMaintenance opens a connection with the maintenance credentials, like
+// a superuser used by the rotator or by migrations. Open never uses them.
+func (d *Driver) OpenMaintenance(dsn string) (driver.Conn, error) {
+	m, err := d.maintenance()
+	if err != nil {
+		return nil, err
+	}
+	return m.Open(dsn)
+}
+
+// MaintenanceDB opens a database over the maintenance credentials, to be
+// handed to the rotator or to migrations. Please close it when done.
+func (d *Driver) MaintenanceDB(dsn string) (*sql.DB, error) {
+	m, err := d.maintenance()
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(&connector{d: m, dsn: dsn}), nil
+}
+
+// RefreshMaintenance refreshes the maintenance credentials.
+func (d *Driver) RefreshMaintenance() error {
+	m, err := d.maintenance()
+	if err != nil {
+		return err
+	}
+	return m.refreshCredentials()
+}
@@ -0,0 +1,179 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	//DEFAULTMANAGERMAXOPENCONNS - Connections the pool of a Manager opens
+	//at most unless its config says otherwise
+	DEFAULTMANAGERMAXOPENCONNS = 10
+	//DEFAULTMANAGERMAXIDLECONNS - Idle connections the pool of a Manager
+	//keeps unless its config says otherwise
+	DEFAULTMANAGERMAXIDLECONNS = 5
+	//DEFAULTMANAGERCONNMAXLIFETIME - Lifetime of the connections of a
+	//Manager without a rotation window, so that the pool still moves on to
+	//the credential that is active
+	DEFAULTMANAGERCONNMAXLIFETIME = 30 * time.Minute
+)
+
+// ManagerConfig - How a Manager assembles the driver, the pool and the
+// background refresh
+type ManagerConfig struct {
+	// RegisterAs - Name the driver is registered under with Register, so
+	// that other code can sql.Open it, when set
+	RegisterAs string
+	// MaxOpenConns - Connections the pool opens at most,
+	// DEFAULTMANAGERMAXOPENCONNS unless set. Negative is unlimited.
+	MaxOpenConns int
+	// MaxIdleConns - Idle connections the pool keeps,
+	// DEFAULTMANAGERMAXIDLECONNS unless set. Negative keeps none.
+	MaxIdleConns int
+	// RotationWindow - Time between rotations of the credentials, which the
+	// lifetimes of the pooled connections are kept within by ManagePool.
+	// Taken from an OnInterval RotationPolicy of the driver when 0, and
+	// when there is none either, connections are recycled after
+	// DEFAULTMANAGERCONNMAXLIFETIME.
+	RotationWindow time.Duration
+	// RefreshInterval - Interval of StartAutoRefresh, which is not run when
+	// 0
+	RefreshInterval time.Duration
+	// RefreshJitter - Jitter of StartAutoRefresh
+	RefreshJitter time.Duration
+	// ExpiryRefresh - Run StartExpiryRefresh with its defaults as well, for
+	// credentials that expire
+	ExpiryRefresh bool
+	// SkipPing - Do not ping the database in Start, like when it may not be
+	// up yet
+	SkipPing bool
+}
+
+// Manager owns a Driver, the *sql.DB opened over it, the pool settings that
+// keep connections from outliving the credentials they were made with, and
+// the background refresh of the credentials. It is built with NewManager,
+// started with Start and torn down with Close -
+//
+//	m, err := gopqr.NewManager(dsn, pqrDriver, gopqr.ManagerConfig{RefreshInterval: 5 * time.Minute})
+//	...
+//	if err := m.Start(ctx); err != nil { ... }
+//	defer m.Close()
+//	rows, err := m.DB().QueryContext(ctx, "SELECT 1")
+type Manager struct {
+	d   *Driver
+	db  *sql.DB
+	cfg ManagerConfig
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	stops   []func()
+}
+
+// NewManager registers the driver when the config says so and opens the
+// pool over the DSN, which carries no credentials. Like sql.OpenDB, no
+// connection is made until Start or the first query.
+func NewManager(dsn string, d *Driver, cfg ManagerConfig) (*Manager, error) {
+	if d == nil {
+		return nil, errors.New("NewManager needs a driver")
+	}
+	if cfg.RotationWindow < 0 || cfg.RefreshInterval < 0 || cfg.RefreshJitter < 0 {
+		return nil, errors.New("The durations of a ManagerConfig cannot be negative")
+	}
+	if cfg.RegisterAs != "" {
+		if err := Register(cfg.RegisterAs, d); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MaxOpenConns == 0 {
+		cfg.MaxOpenConns = DEFAULTMANAGERMAXOPENCONNS
+	}
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = DEFAULTMANAGERMAXIDLECONNS
+	}
+	if cfg.MaxOpenConns > 0 && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		cfg.MaxIdleConns = cfg.MaxOpenConns
+	}
+	db := OpenDB(dsn, d)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(DEFAULTMANAGERCONNMAXLIFETIME)
+	return &Manager{d: d, db: db, cfg: cfg}, nil
+}
+
+// Driver returns the driver of the manager.
+func (m *Manager) Driver() *Driver {
+	return m.d
+}
+
+// DB returns the pool of the manager, which stays open until Close.
+func (m *Manager) DB() *sql.DB {
+	return m.db
+}
+
+// Start fetches the credentials of the driver, starts keeping the pool
+// within the rotation window and the background refresh, and pings the
+// database unless the config says not to. When any of it fails, what was
+// started is stopped again and Start can be retried.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errors.New("The manager is closed")
+	}
+	if m.started {
+		return nil
+	}
+	if err := m.d.Prime(ctx); err != nil {
+		return err
+	}
+	var stops []func()
+	stopAll := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+	window := m.cfg.RotationWindow
+	policy, _ := m.d.configuredPolicy()
+	if _, ok := policy.(onInterval); window > 0 || ok {
+		stop, err := m.d.ManagePool(m.db, window)
+		if err != nil {
+			return err
+		}
+		stops = append(stops, stop)
+	}
+	if m.cfg.RefreshInterval > 0 {
+		stops = append(stops, m.d.StartAutoRefresh(m.cfg.RefreshInterval, m.cfg.RefreshJitter))
+	}
+	if m.cfg.ExpiryRefresh {
+		stops = append(stops, m.d.StartExpiryRefresh(0, 0))
+	}
+	if !m.cfg.SkipPing {
+		if err := m.db.PingContext(ctx); err != nil {
+			stopAll()
+			return err
+		}
+	}
+	m.stops, m.started = stops, true
+	return nil
+}
+
+// Close stops the background work of the manager, newest first, and closes
+// the pool. The driver stays registered, since database/sql cannot forget a
+// driver.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	for i := len(m.stops) - 1; i >= 0; i-- {
+		m.stops[i]()
+	}
+	m.stops = nil
+	return m.db.Close()
+}
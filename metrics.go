@@ -0,0 +1,104 @@
+package gopqr
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Metrics receives the outcomes of the rotation decisions and refreshes of
+// the driver, so that they can be counted by a metrics system. The
+// promgopqr package adapts it to Prometheus. Implementations must be safe
+// for concurrent use.
+type Metrics interface {
+	// ConnectionOpened is invoked for every connection Open made, with the
+	// credential slot that authenticated and whether that was a fallback
+	// after the active credential failed authentication.
+	ConnectionOpened(slot string, fallback bool)
+	// AuthFailed is invoked whenever a credential slot fails authentication.
+	AuthFailed(slot string)
+	// RefreshAttempted is invoked after every attempt to refresh the
+	// credentials, with its failure if any.
+	RefreshAttempted(err error)
+}
+
+const (
+	// ConnectSuccess - Outcome of connecting with the active credential
+	ConnectSuccess = "success"
+	// ConnectAuthFallback - Outcome of connecting with a fallback after the
+	// active credential failed authentication
+	ConnectAuthFallback = "auth_fallback"
+	// ConnectFailure - Outcome of failing to connect with a credential, be
+	// it authentication or anything else
+	ConnectFailure = "failure"
+)
+
+// ConnectLatencyMetrics is implemented by the Metrics that also record how
+// long connecting with each credential slot takes, like for histograms. A
+// rotation to a user with different pg_hba or TLS requirements shows up as
+// a change of latency. The outcome is ConnectSuccess, ConnectAuthFallback
+// or ConnectFailure.
+type ConnectLatencyMetrics interface {
+	ConnectDuration(slot, outcome string, took time.Duration)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) ConnectionOpened(string, bool) {}
+func (noMetrics) AuthFailed(string)             {}
+func (noMetrics) RefreshAttempted(error)        {}
+
+// metrics returns the Metrics of the driver, or one discarding everything.
+func (d *Driver) metrics() Metrics {
+	if d.Metrics != nil {
+		return d.Metrics
+	}
+	return noMetrics{}
+}
+
+// connectDone hands the duration of an attempt to connect with the slot to
+// the Metrics of the driver, if they record it.
+func (d *Driver) connectDone(slot string, fallback bool, err error, start time.Time) {
+	m, ok := d.metrics().(ConnectLatencyMetrics)
+	if !ok {
+		return
+	}
+	outcome := ConnectSuccess
+	switch {
+	case err != nil:
+		outcome = ConnectFailure
+	case fallback:
+		outcome = ConnectAuthFallback
+	}
+	m.ConnectDuration(slot, outcome, time.Since(start))
+}
+
+// recordRefresh notes the outcome of an attempt to refresh the credentials.
+func (d *Driver) recordRefresh(err error) {
+	d.lastAttempt.Store(&refreshAttempt{at: time.Now(), err: redact(err, d.current().ring)})
+	if err == nil {
+		d.lastRefresh.Store(time.Now().UnixNano())
+		d.CircuitBreaker.Reset()
+		d.event(slog.LevelInfo, "credentials refreshed", "generation", d.generation.Load())
+	} else {
+		d.event(slog.LevelWarn, "refreshing credentials failed", "error", err)
+	}
+	d.recordFailure(err)
+	d.metrics().RefreshAttempted(err)
+	if err == nil {
+		d.refreshes.Add(1)
+		d.audit(AuditRecord{Event: AuditRefresh, Outcome: AuditSuccess})
+	} else {
+		d.refreshFailures.Add(1)
+		d.audit(AuditRecord{Event: AuditRefresh, Outcome: AuditFailure, Error: redact(err, d.current().ring).Error()})
+	}
+	d.statusChanged()
+}
+
+// LastRefresh returns when the credentials were last refreshed successfully,
+// or the zero time if they never were.
+func (d *Driver) LastRefresh() time.Time {
+	if n := d.lastRefresh.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
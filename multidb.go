@@ -0,0 +1,161 @@
+package gopqr
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNoDatabaseMapped is matched by errors.Is when a MultiDriver is given a
+// DSN of a database it has no driver mapped for and no Default.
+var ErrNoDatabaseMapped = errors.New("No driver is mapped for the database of the DSN")
+
+// MultiDriver serves the DSNs of several databases under one registered
+// name, each with a Driver of its own, which holds the credentials and the
+// refresher of that database. The Driver of a DSN is found by the host and
+// the dbname of the DSN, so that a service talking to a dozen clusters
+// registers a single driver -
+//
+//	multi := gopqr.NewMultiDriver()
+//	multi.Map("orders.db.internal", "orders", ordersDriver)
+//	multi.Map("users.db.internal", "", usersDriver)
+//	gopqr.MustRegisterMulti("postgresrotating", multi)
+//	orders, err := sql.Open("postgresrotating", "postgres://orders.db.internal:5432/orders")
+type MultiDriver struct {
+	// Default - Serves the DSNs no Driver is mapped for, when set
+	Default *Driver
+
+	mu      sync.RWMutex
+	drivers map[string]*Driver
+}
+
+// NewMultiDriver returns a MultiDriver with no databases mapped.
+func NewMultiDriver() *MultiDriver {
+	return &MultiDriver{drivers: make(map[string]*Driver)}
+}
+
+// Map maps the database of the host to the driver. An empty dbname maps
+// every database of the host that is not mapped on its own. Hosts are
+// matched without their port and regardless of case, and a list of hosts
+// for failover is matched as a whole.
+func (m *MultiDriver) Map(host, dbname string, d *Driver) error {
+	if d == nil {
+		return errors.New("Map needs a driver")
+	}
+	if host == "" {
+		return errors.New("Map needs the host of the database")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.drivers == nil {
+		m.drivers = make(map[string]*Driver)
+	}
+	m.drivers[databaseKey(host, dbname)] = d
+	return nil
+}
+
+// Unmap removes the mapping of the database of the host.
+func (m *MultiDriver) Unmap(host, dbname string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.drivers, databaseKey(host, dbname))
+}
+
+// Databases returns the host/dbname keys of the mapped databases, sorted. A
+// key ending in / maps every database of the host.
+func (m *MultiDriver) Databases() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0, len(m.drivers))
+	for k := range m.drivers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Driver returns the driver serving the DSN - the one mapped for its host
+// and dbname, else the one mapped for every database of its host, else the
+// Default.
+func (m *MultiDriver) Driver(dsn string) (*Driver, error) {
+	host, dbname, err := DatabaseOf(dsn)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	d, ok := m.drivers[databaseKey(host, dbname)]
+	if !ok {
+		d, ok = m.drivers[databaseKey(host, "")]
+	}
+	m.mu.RUnlock()
+	if ok {
+		return d, nil
+	}
+	if m.Default != nil {
+		return m.Default, nil
+	}
+	return nil, fmt.Errorf("%w - %v", ErrNoDatabaseMapped, databaseKey(host, dbname))
+}
+
+// Open opens a connection with the driver serving the DSN.
+func (m *MultiDriver) Open(dsn string) (driver.Conn, error) {
+	d, err := m.Driver(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return d.Open(dsn)
+}
+
+// OpenConnector returns the Connector of the driver serving the DSN, so
+// that sql.Open looks the driver up once rather than on every connection.
+func (m *MultiDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	d, err := m.Driver(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &multiConnector{Connector: d.Connector(dsn), m: m}, nil
+}
+
+// multiConnector is the Connector of a mapped driver that reports the
+// MultiDriver as its Driver, like database/sql expects of a DriverContext.
+type multiConnector struct {
+	driver.Connector
+	m *MultiDriver
+}
+
+func (c *multiConnector) Driver() driver.Driver {
+	return c.m
+}
+
+// DatabaseOf returns the host and the dbname of a URL or key=value DSN, which
+// a MultiDriver finds the driver of the DSN by. The host is that of the URL,
+// or the host setting, like a socket directory or a list of hosts.
+func DatabaseOf(dsn string) (host, dbname string, err error) {
+	p := parseDSN(dsn)
+	if p.err != nil {
+		return "", "", p.err
+	}
+	if !p.isURL {
+		return getDSN(p.settings, "host"), getDSN(p.settings, "dbname"), nil
+	}
+	host, dbname = p.hostname, strings.TrimPrefix(p.path, "/")
+	if host == "" {
+		host = p.query.Get("host")
+	}
+	if dbname == "" {
+		dbname = p.query.Get("dbname")
+	}
+	return host, dbname, nil
+}
+
+// databaseKey is the key a database is mapped under.
+func databaseKey(host, dbname string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil && !strings.Contains(host, ",") {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]")) + "/" + dbname
+}
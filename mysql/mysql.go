@@ -0,0 +1,87 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/chandranarreddy/gopqr"
+
+	gomysql "github.com/go-sql-driver/mysql"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package mysql brings the rotating credentials of github.com/chandranarreddy/gopqr
+to MySQL on top of github.com/go-sql-driver/mysql, so that a stack with both
+Postgres and MySQL has one rotation story. The driver alternates between the
+odd and even credential (or the Slots) just like it does for Postgres, and an
+access denied error (1045) triggers the fallback and the refresh.
+
+Usage:
+	pqrDriver := mysql.Wrap(&gopqr.Driver{Provider: p, Sticky: true})
+	sql.Register("mysqlrotating", pqrDriver)
+	db, err := sql.Open("mysqlrotating", "tcp(1.2.3.4:3306)/mydb?tls=true")
+	Please donot pass any credentials in the DSN above.
+*/
+
+// ERACCESSDENIED - MySQL error number of a rejected credential
+const ERACCESSDENIED = 1045
+
+// Wrap makes the gopqr driver connect to MySQL. The DSN passed to Open is a
+// go-sql-driver/mysql DSN sans the credentials. The Host and Port overrides
+// and the PasswordSource of the driver are honored, and the AuthFailure
+// predicate is set to match access denied errors unless it is already set.
+func Wrap(d *gopqr.Driver) *gopqr.Driver {
+	d.Backend = &gomysql.MySQLDriver{}
+	d.FormatDSN = func(dsn string, cred gopqr.Credential) (string, error) {
+		return formatDSN(d, dsn, cred)
+	}
+	if d.AuthFailure == nil {
+		d.AuthFailure = IsAccessDenied
+	}
+	return d
+}
+
+// IsAccessDenied reports whether err is MySQL rejecting the credential.
+func IsAccessDenied(err error) bool {
+	var myErr *gomysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == ERACCESSDENIED
+}
+
+// formatDSN injects the credential into the DSN.
+func formatDSN(d *gopqr.Driver, dsn string, cred gopqr.Credential) (string, error) {
+	cfg, err := gomysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("%w - %w", gopqr.ErrInvalidDSN, err)
+	}
+	if cfg.User != "" || cfg.Passwd != "" {
+		return "", gopqr.ErrCredentialsInDSN
+	}
+	if d.Host != "" || d.Port != "" {
+		host, port, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			host, port = cfg.Addr, "3306"
+		}
+		if d.Host != "" {
+			host = d.Host
+		}
+		if d.Port != "" {
+			port = d.Port
+		}
+		cfg.Addr = net.JoinHostPort(host, port)
+	}
+	cfg.User, cfg.Passwd = cred.Username, cred.PasswordText()
+	if d.PasswordSource != nil {
+		addr := cfg.Addr
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "3306")
+		}
+		token, err := d.PasswordSource(addr, cred.Username)
+		if err != nil {
+			return "", fmt.Errorf("Failed to generate password for %v - %w", cred.Username, err)
+		}
+		cfg.Passwd = token
+	}
+	return cfg.FormatDSN(), nil
+}
@@ -0,0 +1,125 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	//DEFAULTOPENRETRYWAIT - default time an Open waits for the refresh it
+	//started to install new credentials before giving up
+	DEFAULTOPENRETRYWAIT = 2 * time.Second
+	//DEFAULTOPENRETRYBACKOFF - default delay before an Open first checks for
+	//new credentials, doubled for every check after
+	DEFAULTOPENRETRYBACKOFF = 50 * time.Millisecond
+)
+
+// OpenRetry has an Open that failed authentication with every credential it
+// may use wait for the refresh it started, and try once more when the refresh
+// installs new credentials in time. Without it, an Open racing a rotation
+// fails right away while the credentials that would have worked are on their
+// way, and the request it served fails with it. The Open gives up as soon as
+// the refresh finishes without new credentials, as trying the same ones
+// again would only fail authentication again. The zero value is ready to use
+// with DEFAULTOPENRETRYWAIT and DEFAULTOPENRETRYBACKOFF.
+//
+// With Synchronous set, the Open runs the refresh itself once every
+// credential failed, and gives it up to the Wait, rather than starting it in
+// the background. A service that opens connections rarely may not get
+// another Open for minutes, and would stay broken until then were the
+// refresh only of help to the next Open. A refresh that takes longer goes on
+// in the background. An authentication failure followed by a successful
+// fallback still refreshes in the background.
+type OpenRetry struct {
+	// Wait - Longest an Open waits for new credentials, defaults to DEFAULTOPENRETRYWAIT
+	Wait time.Duration
+	// Backoff - Delay before the first check for new credentials, doubled for every check after, defaults to DEFAULTOPENRETRYBACKOFF
+	Backoff time.Duration
+	// Synchronous - When set, the Open refreshes the credentials itself
+	Synchronous bool
+}
+
+// retries reports whether the failure of an Open is one OpenRetry retries.
+func (r *OpenRetry) retries(err error) bool {
+	return r != nil && (errors.Is(err, ErrAllCredentialsFailed) || errors.Is(err, ErrFallbackForbidden))
+}
+
+// awaitInstall waits until credentials other than those of the generation
+// are installed on the driver, and reports whether they were before the
+// refresh finished without them, the Wait ran out or the context was done.
+func (r *OpenRetry) awaitInstall(ctx context.Context, d *Driver, generation uint64) bool {
+	backoff := r.Backoff
+	if backoff <= 0 {
+		backoff = DEFAULTOPENRETRYBACKOFF
+	}
+	deadline := time.Now().Add(r.wait())
+	for {
+		if d.generation.Load() != generation {
+			return true
+		}
+		if !d.refresh.inFlight() {
+			return false
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return false
+		}
+		t := time.NewTimer(min(backoff, left))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return false
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (r *OpenRetry) wait() time.Duration {
+	if r.Wait <= 0 {
+		return DEFAULTOPENRETRYWAIT
+	}
+	return r.Wait
+}
+
+// refreshNow refreshes the credentials within the Wait, and reports whether
+// credentials other than those of the generation were installed by then.
+func (r *OpenRetry) refreshNow(ctx context.Context, d *Driver, generation uint64) bool {
+	ctx, cancel := context.WithTimeout(ctx, r.wait())
+	defer cancel()
+	if err := d.ForceRefresh(ctx); err != nil {
+		d.logf("refreshing credentials for the failed open failed - %v", err)
+	}
+	return d.generation.Load() != generation
+}
+
+// openRetried opens the connection, trying once more with the credentials
+// the refresh it started installs when the OpenRetry of the driver tells so.
+func (d *Driver) openRetried(ctx context.Context, dsn string, cfg *ConnectorConfig, outcome *OpenOutcome) (driver.Conn, error) {
+	r := d.OpenRetry
+	generation := d.generation.Load()
+	if r == nil || !r.Synchronous {
+		conn, err := d.open(dsn, cfg, d.dialContext(ctx), d.startRefresh, outcome)
+		if !r.retries(err) || !r.awaitInstall(ctx, d, generation) {
+			return conn, err
+		}
+	} else {
+		// the refresh is left to this Open when every credential fails
+		refreshNeeded := false
+		conn, err := d.open(dsn, cfg, d.dialContext(ctx), func() { refreshNeeded = true }, outcome)
+		if !r.retries(err) {
+			if refreshNeeded {
+				d.startRefresh()
+			}
+			return conn, err
+		}
+		if !r.refreshNow(ctx, d, generation) {
+			return conn, err
+		}
+	}
+	d.event(slog.LevelInfo, "retrying open with refreshed credentials")
+	return d.open(dsn, cfg, d.dialContext(ctx), d.startRefresh, outcome)
+}
@@ -0,0 +1,268 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql/driver"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Option configures a driver built by New.
+type Option func(*options)
+
+type options struct {
+	cfg   Config
+	apply []func(*Driver)
+	// finish - Run on the driver once it is configured, failing New
+	finish []func(*Driver) error
+}
+
+// New builds a driver from the options, validating the result the same way
+// NewDriver does. Setting the exported fields of a Driver keeps working,
+// but drivers built by New are fully configured before anything can use
+// them, which makes them safe to share right away -
+//
+//	pqrDriver, err := gopqr.New(
+//		gopqr.WithProvider(p),
+//		gopqr.WithRotationPolicy(gopqr.OnAuthFailure()),
+//		gopqr.WithEventLogger(logger),
+//	)
+func New(opts ...Option) (*Driver, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	d, err := NewDriver(o.cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, apply := range o.apply {
+		apply(d)
+	}
+	// the options may have changed what NewDriver published
+	d.AcquireLock()
+	d.ReleaseLock()
+	for _, finish := range o.finish {
+		if err := finish(d); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// WithCredentials sets the ring of credentials, naming the active one. Name
+// the credentials "odd" and "even" for the classic pair.
+func WithCredentials(active string, creds ...Credential) Option {
+	return func(o *options) {
+		o.cfg.ActiveCredential = active
+		o.cfg.Slots = append([]Credential(nil), creds...)
+	}
+}
+
+// WithProvider sets the source of the credentials.
+func WithProvider(p CredentialProvider) Option {
+	return func(o *options) { o.cfg.Provider = p }
+}
+
+// WithRefresher sets a CredentialRefresher func, adapted to a Provider by
+// FromRefresher. The refresher supplies the credentials, so it does not
+// combine with WithCredentials.
+func WithRefresher(refresher func(*Driver)) Option {
+	return func(o *options) { o.cfg.Provider = FromRefresher(refresher) }
+}
+
+// WithRefreshFunc sets a RefreshFunc, adapted to a Provider by
+// FromRefreshFunc. The func supplies the credentials, so it does not combine
+// with WithCredentials.
+func WithRefreshFunc(fn RefreshFunc) Option {
+	return func(o *options) { o.cfg.Provider = FromRefreshFunc(fn) }
+}
+
+// WithPasswordSource sets the func generating passwords at connect time.
+func WithPasswordSource(source func(hostport, username string) (string, error)) Option {
+	return func(o *options) { o.cfg.PasswordSource = source }
+}
+
+// WithRefreshTimeout gives up on a refresh of the credentials that has not
+// finished within the timeout, keeping the credentials in use.
+func WithRefreshTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.cfg.RefreshTimeout = timeout }
+}
+
+// WithOpenRetry has an Open failing authentication with every credential
+// wait up to the wait for the refresh it started, and try once more with the
+// new credentials, see OpenRetry.
+func WithOpenRetry(wait time.Duration) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.OpenRetry = &OpenRetry{Wait: wait} })
+	}
+}
+
+// WithSynchronousRefresh has an Open failing authentication with every
+// credential refresh the credentials itself, giving the refresh up to the
+// deadline, and try once more with the new credentials, see OpenRetry.
+func WithSynchronousRefresh(deadline time.Duration) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.OpenRetry = &OpenRetry{Wait: deadline, Synchronous: true} })
+	}
+}
+
+// WithRotationPolicy sets when the active credential flips.
+func WithRotationPolicy(policy RotationPolicy) Option {
+	return func(o *options) { o.cfg.RotationPolicy = policy }
+}
+
+// WithSlotSelector sets what picks the active slot of newly installed
+// credentials, like ByStage("AWSCURRENT").
+func WithSlotSelector(s SlotSelector) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.SelectActive = s })
+	}
+}
+
+// WithGrace keeps the driver in grace mode, see StartGrace.
+func WithGrace() Option {
+	return func(o *options) { o.cfg.Grace = true }
+}
+
+// WithSticky keeps using the active credential until it fails
+// authentication, which drivers built by New do unless WithAlternating.
+func WithSticky() Option {
+	return func(o *options) { o.cfg.Sticky, o.cfg.Alternating = true, false }
+}
+
+// WithAlternating alternates between the credentials on every new
+// connection, like a Driver built by hand does, rather than stick to the
+// active one.
+func WithAlternating() Option {
+	return func(o *options) { o.cfg.Sticky, o.cfg.Alternating = false, true }
+}
+
+// WithNoFallback makes Open fail closed when the active credential fails
+// authentication, rather than use the other credentials.
+func WithNoFallback() Option {
+	return func(o *options) { o.cfg.NoFallback = true }
+}
+
+// WithTaggedApplicationName appends the credential slot a connection opens
+// with to its application_name.
+func WithTaggedApplicationName() Option {
+	return func(o *options) { o.cfg.TagApplicationName = true }
+}
+
+// WithRDSProxy has the driver sit safely in front of an AWS RDS Proxy. The
+// proxy multiplexes the client connections over database connections of its
+// own, pinning a client to one when its session state differs, and checks
+// the credentials against a Secrets Manager secret it caches. So the active
+// credential stays until it fails authentication rather than flip on every
+// Open, unless a RotationPolicy is set. Only 28P01 and the codes given, if
+// any, fall back to the other credentials, since the proxy answers 28000
+// when it refuses a client for reasons like missing TLS. The slot is not
+// appended to the application_name, which would split the clients of the
+// proxy by startup parameters, while pg_stat_activity only shows the
+// connections of the proxy anyway.
+func WithRDSProxy(authFailureCodes ...string) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) {
+			d.RDSProxy = true
+			d.AuthFailureCodes = append(d.AuthFailureCodes, authFailureCodes...)
+		})
+	}
+}
+
+// WithEndpoint overrides the host, port and sslmode of the DSN. Empty values
+// leave those of the DSN.
+func WithEndpoint(host, port, sslmode string) Option {
+	return func(o *options) {
+		o.cfg.Host, o.cfg.Port, o.cfg.SSLMode = host, port, sslmode
+	}
+}
+
+// WithTLS overrides the root certificate, the client certificate and its
+// private key of the DSN, each the path of a file or PEM encoded. Empty
+// values leave those of the DSN.
+func WithTLS(rootCert, cert, key string) Option {
+	return func(o *options) {
+		o.cfg.SSLRootCert, o.cfg.SSLCert, o.cfg.SSLKey = rootCert, cert, key
+	}
+}
+
+// WithLogger sets where the driver writes its notices.
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) { o.cfg.Logger = logger }
+}
+
+// WithEventLogger sets where the driver emits its structured events.
+func WithEventLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.cfg.EventLogger = logger }
+}
+
+// WithBackend sets the driver connections are made through.
+func WithBackend(backend driver.Driver) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.Backend = backend })
+	}
+}
+
+// WithDialer sets what dials the sockets of the lib/pq connections.
+func WithDialer(dialer pq.Dialer) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.Dialer = dialer })
+	}
+}
+
+// WithGSS sets what builds the GSSAPI client of the credentials with a
+// Keytab.
+func WithGSS(gss GSSFunc) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.GSS = gss })
+	}
+}
+
+// WithBeforeConnect sets what is invoked with the run-time parameters of
+// every connection before it is opened.
+func WithBeforeConnect(hook func(ctx context.Context, params map[string]string) error) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.BeforeConnect = hook })
+	}
+}
+
+// WithAfterConnect sets what is invoked with every connection opened, like
+// SetRole.
+func WithAfterConnect(hook func(ctx context.Context, conn driver.Conn) error) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.AfterConnect = hook })
+	}
+}
+
+// WithMetrics sets what receives the connection and refresh outcomes.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.Metrics = m })
+	}
+}
+
+// WithAuditSink sets what receives the audit trail of the driver.
+func WithAuditSink(s AuditSink) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.AuditSink = s })
+	}
+}
+
+// WithExpvar publishes the rotation counters and the active slot of the
+// driver with expvar under the name, see PublishExpvar.
+func WithExpvar(name string) Option {
+	return func(o *options) {
+		o.finish = append(o.finish, func(d *Driver) error { return d.PublishExpvar(name) })
+	}
+}
+
+// WithTracer sets what brackets every Open and refresh.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.apply = append(o.apply, func(d *Driver) { d.Tracer = t })
+	}
+}
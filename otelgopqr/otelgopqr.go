@@ -0,0 +1,131 @@
+package otelgopqr
+
+import (
+	"context"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package otelgopqr instruments github.com/chandranarreddy/gopqr with
+OpenTelemetry, so that latency anomalies can be correlated with the rotation
+of credentials in one trace view. It lives apart from the driver so that the
+driver does not depend on OpenTelemetry.
+
+Usage:
+	otelgopqr.Trace(pqrDriver, nil)
+	otelgopqr.AnnotateQueries(pqrDriver)
+	db := sql.OpenDB(pqrDriver.Connector(dsn))
+	...
+	rows, err := db.QueryContext(ctx, "SELECT ...")
+	...
+	resp, err := client.Do(req.WithContext(otelgopqr.WithBaggage(ctx, pqrDriver)))
+*/
+
+// Keys of the attributes set on spans and of the baggage members.
+const (
+	// ActiveCredentialKey - Name of the active credential
+	ActiveCredentialKey = attribute.Key("gopqr.active_credential")
+	// GenerationKey - Generation of the credentials installed on the driver
+	GenerationKey = attribute.Key("gopqr.generation")
+	// DegradedKey - Whether the driver gets by on a fallback credential
+	DegradedKey = attribute.Key("gopqr.degraded")
+	// SlotKey - Credential slot an Open connected with
+	SlotKey = attribute.Key("gopqr.slot")
+	// FallbackKey - Whether an Open fell back from the active credential
+	FallbackKey = attribute.Key("gopqr.fallback")
+)
+
+// instrumentationName names the tracer of the package.
+const instrumentationName = "github.com/chandranarreddy/gopqr/otelgopqr"
+
+// Attributes returns the rotation state as span attributes.
+func Attributes(state gopqr.RotationState) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		ActiveCredentialKey.String(state.Active),
+		GenerationKey.Int64(int64(state.Generation)),
+		DegradedKey.Bool(state.Degraded),
+	}
+}
+
+// AnnotateQueries sets the QueryHook of the driver to attach its rotation
+// state as attributes to the span in the context of every query and
+// statement executed on its connections.
+func AnnotateQueries(d *gopqr.Driver) {
+	d.QueryHook = func(ctx context.Context, state gopqr.RotationState) {
+		span := trace.SpanFromContext(ctx)
+		if span.IsRecording() {
+			span.SetAttributes(Attributes(state)...)
+		}
+	}
+}
+
+// WithBaggage returns a copy of the context whose baggage carries the
+// rotation state of the driver, so that it is propagated to the downstream
+// services called with the context.
+func WithBaggage(ctx context.Context, d *gopqr.Driver) context.Context {
+	b := baggage.FromContext(ctx)
+	for _, attr := range Attributes(d.RotationState()) {
+		m, err := baggage.NewMember(string(attr.Key), attr.Value.Emit())
+		if err != nil {
+			continue
+		}
+		if merged, err := b.SetMember(m); err == nil {
+			b = merged
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, b)
+}
+
+// tracer is the gopqr.Tracer recording spans with OpenTelemetry.
+type tracer struct {
+	driver *gopqr.Driver
+	tracer trace.Tracer
+}
+
+// Trace sets the Tracer of the driver to record a "gopqr.Open" span for
+// every Open, carrying the credential slot used and whether it was a
+// fallback, and a "gopqr.Refresh" span for every refresh of the credentials.
+// A nil provider stands for the global TracerProvider.
+func Trace(d *gopqr.Driver, tp trace.TracerProvider) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	d.Tracer = &tracer{driver: d, tracer: tp.Tracer(instrumentationName)}
+}
+
+// StartOpen implements gopqr.Tracer.
+func (t *tracer) StartOpen(ctx context.Context) (context.Context, func(gopqr.OpenOutcome, error)) {
+	ctx, span := t.tracer.Start(ctx, "gopqr.Open", trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, func(outcome gopqr.OpenOutcome, err error) {
+		span.SetAttributes(Attributes(t.driver.RotationState())...)
+		if outcome.Slot != "" {
+			span.SetAttributes(SlotKey.String(outcome.Slot), FallbackKey.Bool(outcome.Fallback))
+		}
+		end(span, err)
+	}
+}
+
+// StartRefresh implements gopqr.Tracer.
+func (t *tracer) StartRefresh(ctx context.Context) func(error) {
+	_, span := t.tracer.Start(ctx, "gopqr.Refresh")
+	return func(err error) {
+		span.SetAttributes(Attributes(t.driver.RotationState())...)
+		end(span, err)
+	}
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,194 @@
+package passwordgen
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package passwordgen generates passwords for the credentials rotated through a
+github.com/chandranarreddy/gopqr driver. The zero Policy gives DEFAULTLENGTH
+letters and digits, and symbols are opt-in, limited to SAFESYMBOLS unless
+AllowUnsafe is set. So a generated password needs no escaping in the
+userinfo of a URL DSN nor quoting in a libpq key=value DSN, which is where
+home grown generators usually get bitten.
+
+Usage:
+	password, err := passwordgen.Generate(passwordgen.Policy{})
+	password, err := passwordgen.Generate(passwordgen.Policy{
+		Length:  40,
+		Symbols: passwordgen.SAFESYMBOLS,
+		Exclude: passwordgen.AMBIGUOUS,
+	})
+*/
+
+const (
+	//DEFAULTLENGTH - default length of a generated password
+	DEFAULTLENGTH = 32
+	//SAFESYMBOLS - symbols that need no escaping in a URL nor quoting in a
+	//libpq key=value DSN
+	SAFESYMBOLS = "-_.~"
+	//UNSAFESYMBOLS - symbols that break DSNs unless escaped or quoted
+	UNSAFESYMBOLS = " \t'\"\\@:/?#[]%&+=,;$!*()<>{}|^`"
+	//AMBIGUOUS - characters easily mistaken for one another when read out
+	AMBIGUOUS = "0O1lI"
+
+	lower  = "abcdefghijklmnopqrstuvwxyz"
+	upper  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digits = "0123456789"
+)
+
+// ErrUnsafeSymbols is matched by errors.Is when the Symbols of a Policy
+// include UNSAFESYMBOLS without AllowUnsafe.
+var ErrUnsafeSymbols = errors.New("symbols would need escaping in DSNs")
+
+// Policy describes the passwords to generate. The zero value is DEFAULTLENGTH
+// lower and upper case letters and digits.
+type Policy struct {
+	// Length - Length of the password, defaults to DEFAULTLENGTH
+	Length int
+	// NoLower, NoUpper and NoDigits - Leave out the class of characters
+	NoLower  bool
+	NoUpper  bool
+	NoDigits bool
+	// Symbols - Symbols the password also draws from, none when empty
+	Symbols string
+	// MinPerClass - Characters the password has at least of every class
+	// drawn from, defaults to 1
+	MinPerClass int
+	// Exclude - Characters never used, like AMBIGUOUS
+	Exclude string
+	// AllowUnsafe - Allow Symbols that need escaping in DSNs
+	AllowUnsafe bool
+}
+
+// classes returns the characters of every class the policy draws from,
+// without the excluded ones.
+func (p Policy) classes() ([]string, error) {
+	if !p.AllowUnsafe {
+		if i := strings.IndexAny(p.Symbols, UNSAFESYMBOLS); i >= 0 {
+			return nil, fmt.Errorf("%w - %q", ErrUnsafeSymbols, p.Symbols[i])
+		}
+	}
+	for _, r := range p.Symbols {
+		if r < '!' || r > '~' {
+			return nil, fmt.Errorf("symbol %q is not printable ASCII", r)
+		}
+	}
+	var classes []string
+	for _, c := range []struct {
+		chars string
+		off   bool
+	}{{lower, p.NoLower}, {upper, p.NoUpper}, {digits, p.NoDigits}, {p.Symbols, p.Symbols == ""}} {
+		if c.off {
+			continue
+		}
+		chars := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(p.Exclude, r) {
+				return -1
+			}
+			return r
+		}, c.chars)
+		if chars == "" {
+			return nil, fmt.Errorf("every character of %q is excluded", c.chars)
+		}
+		classes = append(classes, chars)
+	}
+	if len(classes) == 0 {
+		return nil, errors.New("the policy leaves no characters to draw from")
+	}
+	return classes, nil
+}
+
+// Validate checks that passwords can be generated with the policy.
+func (p Policy) Validate() error {
+	classes, err := p.classes()
+	if err != nil {
+		return err
+	}
+	length, min := p.lengths()
+	if length < len(classes)*min {
+		return fmt.Errorf("a length of %d cannot hold %d characters of each of %d classes", length, min, len(classes))
+	}
+	return nil
+}
+
+func (p Policy) lengths() (length, min int) {
+	length, min = p.Length, p.MinPerClass
+	if length <= 0 {
+		length = DEFAULTLENGTH
+	}
+	if min <= 0 {
+		min = 1
+	}
+	return length, min
+}
+
+// Generate returns a password of the policy drawn from crypto/rand, with
+// MinPerClass characters of every class at least. It checks crypto/rand with
+// gopqr.CheckPrimitive first, for strict FIPS mode.
+func Generate(p Policy) (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	if err := gopqr.CheckPrimitive(gopqr.PrimitiveCSPRNG); err != nil {
+		return "", err
+	}
+	classes, _ := p.classes()
+	length, min := p.lengths()
+	all := strings.Join(classes, "")
+	password := make([]byte, 0, length)
+	for _, class := range classes {
+		for i := 0; i < min; i++ {
+			c, err := pick(class)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c)
+		}
+	}
+	for len(password) < length {
+		c, err := pick(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	// the characters guaranteed of each class are spread over the password
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+// Generator returns a func generating passwords of the policy, like for the
+// GeneratePassword of the rotator.
+func Generator(p Policy) func() (string, error) {
+	return func() (string, error) { return Generate(p) }
+}
+
+func pick(chars string) (byte, error) {
+	i, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("reading random bytes failed - %v", err)
+	}
+	return int(i.Int64()), nil
+}
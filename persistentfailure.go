@@ -0,0 +1,43 @@
+package gopqr
+
+import (
+	"sync"
+	"time"
+)
+
+// failureStreak counts the Opens that failed in a row, for the
+// OnPersistentFailure hook.
+type failureStreak struct {
+	mu    sync.Mutex
+	n     int
+	since time.Time
+	fired bool
+}
+
+// noteOpen records the outcome of an Open and invokes OnPersistentFailure
+// once per streak of failures, when the streak first reaches the
+// PersistentFailureThreshold or lasts the PersistentFailureAfter.
+func (d *Driver) noteOpen(err error) {
+	s := &d.streak
+	s.mu.Lock()
+	if err == nil {
+		s.n, s.since, s.fired = 0, time.Time{}, false
+		s.mu.Unlock()
+		return
+	}
+	if s.n == 0 {
+		s.since = time.Now()
+	}
+	s.n++
+	n := s.n
+	fire := !s.fired && d.OnPersistentFailure != nil &&
+		((d.PersistentFailureThreshold > 0 && n >= d.PersistentFailureThreshold) ||
+			(d.PersistentFailureAfter > 0 && time.Since(s.since) >= d.PersistentFailureAfter))
+	if fire {
+		s.fired = true
+	}
+	s.mu.Unlock()
+	if fire {
+		d.OnPersistentFailure(n, redact(err, d.current().ring))
+	}
+}
@@ -0,0 +1,95 @@
+package gopqr
+
+import (
+	"fmt"
+	"log/slog"
+	nurl "net/url"
+	"strings"
+)
+
+const (
+	// DSNPINSLOT - DSN parameter naming the slot the connections of the DSN
+	// are pinned to, like "postgres://db:5432/mydb?gopqr_slot=even". It is
+	// taken off the DSN before it reaches the server.
+	DSNPINSLOT = "gopqr_slot"
+)
+
+// PinSlot pins the new connections of the driver to the named slot, whatever
+// the active credential and the RotationPolicy, to debug an incident or to
+// validate a freshly rotated credential before making it active. A pinned
+// slot is never fallen back from, so that its failures are returned rather
+// than hidden, and Opens leave the rotation state alone. An empty name
+// unpins. The DSNPINSLOT parameter of a DSN pins the connections of that DSN
+// alone.
+func (d *Driver) PinSlot(slot string) error {
+	if slot == "" {
+		if d.pinned.Swap(nil) != nil {
+			d.event(slog.LevelInfo, "slot unpinned")
+		}
+		return nil
+	}
+	if !hasSlot(d.current().ring, slot) {
+		return fmt.Errorf("%w - no slot is named %v", ErrInvalidConfig, slot)
+	}
+	d.pinned.Store(&slot)
+	d.logf("new connections are pinned to slot %v", slot)
+	d.event(slog.LevelWarn, "slot pinned", "slot", slot)
+	return nil
+}
+
+// PinnedSlot returns the slot the driver is pinned to, if any.
+func (d *Driver) PinnedSlot() string {
+	if slot := d.pinned.Load(); slot != nil {
+		return *slot
+	}
+	return ""
+}
+
+// pinnedSlot returns the slot the connections of the DSN are pinned to, by
+// its DSNPINSLOT parameter or else by PinSlot, and the DSN without the
+// parameter.
+func (d *Driver) pinnedSlot(dsn string) (string, string, error) {
+	if !strings.Contains(dsn, DSNPINSLOT) {
+		return d.PinnedSlot(), dsn, nil
+	}
+	var slot string
+	if isURLDSN(dsn) {
+		u, err := nurl.Parse(dsn)
+		if err != nil {
+			return "", "", ErrInvalidDSN
+		}
+		q := u.Query()
+		slot = q.Get(DSNPINSLOT)
+		q.Del(DSNPINSLOT)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		settings, err := parseKeyValueDSN(dsn)
+		if err != nil {
+			return "", "", err
+		}
+		kept := settings[:0]
+		for _, s := range settings {
+			if s.key == DSNPINSLOT {
+				slot = s.value
+				continue
+			}
+			kept = append(kept, s)
+		}
+		dsn = formatKeyValueDSN(kept)
+	}
+	if slot == "" {
+		slot = d.PinnedSlot()
+	}
+	return slot, dsn, nil
+}
+
+// hasSlot reports whether the ring has a slot of the name.
+func hasSlot(ring []Credential, name string) bool {
+	for _, cred := range ring {
+		if cred.Name == name {
+			return true
+		}
+	}
+	return false
+}
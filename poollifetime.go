@@ -0,0 +1,75 @@
+package gopqr
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	//DEFAULTLIFETIMEFRACTION - Fraction of the rotation window ManagePool
+	//sets the ConnMaxLifetime and ConnMaxIdleTime of the pool to
+	DEFAULTLIFETIMEFRACTION = 0.5
+)
+
+// ManagePool keeps the connections of the pool from outliving a rotation of
+// the credentials. Given the window between rotations, it sets the
+// ConnMaxLifetime and ConnMaxIdleTime of the pool to DEFAULTLIFETIMEFRACTION
+// of it, so that a connection is recycled well before the credential it was
+// made with is rotated out, and sets them again every tick in case other
+// code changed them. Every tick it also warns when a connection of the
+// driver is older than the window, meaning it still runs on a credential
+// that may be rotated out already. A window of 0 is taken from the
+// RotationPolicy of the driver when that is OnInterval. The pool must be
+// opened on the driver, and ManagePool returns a func that stops it.
+func (d *Driver) ManagePool(db *sql.DB, window time.Duration) (stop func(), err error) {
+	if db == nil || db.Driver() != d {
+		return nil, errors.New("The pool is not opened on this driver")
+	}
+	if policy, _ := d.configuredPolicy(); window <= 0 {
+		if p, ok := policy.(onInterval); ok {
+			window = p.interval
+		}
+	}
+	if window <= 0 {
+		return nil, errors.New("ManagePool needs the rotation window, or an OnInterval RotationPolicy")
+	}
+	lifetime := time.Duration(float64(window) * DEFAULTLIFETIMEFRACTION)
+	apply := func() {
+		db.SetConnMaxLifetime(lifetime)
+		db.SetConnMaxIdleTime(lifetime)
+		if oldest := d.live.oldest(); !oldest.IsZero() && time.Since(oldest) > window {
+			d.logf("a connection has outlived the rotation window of %v, opened %v ago", window, time.Since(oldest).Round(time.Second))
+			d.event(slog.LevelWarn, "connection outlived the rotation window", "window", window, "age", time.Since(oldest))
+		}
+	}
+	apply()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.runWorker("pool_lifetime", true, func(ran func()) {
+			t := time.NewTicker(lifetime / 2)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+					apply()
+					ran()
+				}
+			}
+		})
+	}()
+	var once sync.Once
+	return d.tracked(func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}), nil
+}
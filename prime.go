@@ -0,0 +1,26 @@
+package gopqr
+
+import (
+	"context"
+)
+
+// Prime fetches the credentials from the Provider of the driver and installs
+// them, unless that was done already, so that a service can fail at startup
+// rather than on its first query. There is no need to fetch them at startup
+// otherwise, as the first Open primes the driver itself. A provider holding
+// no credentials until it is refreshed is refreshed, sharing the refresh
+// with the other Opens waiting on it. Drivers without a Provider are primed
+// from the start.
+func (d *Driver) Prime(ctx context.Context) error {
+	if d.Provider == nil || d.primed.Load() {
+		return nil
+	}
+	creds, err := d.Provider.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if len(creds.Slots) > 0 {
+		return d.syncProvider(ctx)
+	}
+	return d.ForceRefresh(ctx)
+}
@@ -0,0 +1,218 @@
+package promgopqr
+
+import (
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package promgopqr exports the rotation behavior of a
+github.com/chandranarreddy/gopqr driver to Prometheus - the connections
+opened per credential slot and the time connecting took, the authentication
+failures and fallbacks, the refresh attempts and failures, and the time
+since the last successful refresh. It lives apart from the driver so that
+the driver does not depend on the Prometheus client.
+
+Usage:
+	c := promgopqr.Instrument(pqrDriver, prometheus.Labels{"db": "orders"})
+	prometheus.MustRegister(c)
+*/
+
+const namespace = "gopqr"
+
+// Collector is a prometheus.Collector and the gopqr.Metrics of one driver.
+type Collector struct {
+	driver *gopqr.Driver
+
+	opened          *prometheus.CounterVec
+	authFailures    *prometheus.CounterVec
+	connectDuration *prometheus.HistogramVec
+	refreshes       prometheus.Counter
+	refreshFailures prometheus.Counter
+	sinceRefresh    *prometheus.Desc
+	generation      *prometheus.Desc
+	degraded        *prometheus.Desc
+	noFallback      *prometheus.Desc
+	queued          *prometheus.Desc
+	buildInfo       *prometheus.Desc
+	workersLive     *prometheus.Desc
+	workerLastRun   *prometheus.Desc
+	workerRestarts  *prometheus.Desc
+	liveConns       *prometheus.Desc
+	started         time.Time
+}
+
+var (
+	_ prometheus.Collector        = (*Collector)(nil)
+	_ gopqr.Metrics               = (*Collector)(nil)
+	_ gopqr.ConnectLatencyMetrics = (*Collector)(nil)
+)
+
+// New returns a Collector for the driver without installing it, for when
+// the Metrics of the driver fan out to more than one system. The labels are
+// added to every metric, like to tell several drivers apart.
+func New(d *gopqr.Driver, labels prometheus.Labels) *Collector {
+	return &Collector{
+		driver: d,
+		opened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "connections_opened_total",
+			Help:        "Connections opened, by the credential slot that authenticated and whether it was a fallback.",
+			ConstLabels: labels,
+		}, []string{"slot", "fallback"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "auth_failures_total",
+			Help:        "Credential slots failing authentication.",
+			ConstLabels: labels,
+		}, []string{"slot"}),
+		connectDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "connect_duration_seconds",
+			Help:        "Time connecting with a credential slot took, by the slot and the outcome - success, auth_fallback or failure.",
+			ConstLabels: labels,
+			Buckets:     []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"slot", "outcome"}),
+		refreshes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "refresh_attempts_total",
+			Help:        "Attempts to refresh the credentials.",
+			ConstLabels: labels,
+		}),
+		refreshFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "refresh_failures_total",
+			Help:        "Attempts to refresh the credentials that failed.",
+			ConstLabels: labels,
+		}),
+		sinceRefresh: prometheus.NewDesc(namespace+"_seconds_since_last_refresh",
+			"Seconds since the credentials were last refreshed successfully, or since the collector was created if they never were.",
+			nil, labels),
+		generation: prometheus.NewDesc(namespace+"_credentials_generation",
+			"Times new credentials were installed on the driver.",
+			nil, labels),
+		degraded: prometheus.NewDesc(namespace+"_degraded",
+			"1 while the driver gets by on a fallback credential or its error budget is exhausted.",
+			nil, labels),
+		noFallback: prometheus.NewDesc(namespace+"_no_fallback",
+			"1 while Open fails closed rather than fall back to the other credentials.",
+			nil, labels),
+		queued: prometheus.NewDesc(namespace+"_refresh_queue_depth",
+			"Opens waiting for a refresh in flight.",
+			nil, labels),
+		buildInfo: prometheus.NewDesc(namespace+"_build_info",
+			"The version of the driver, the Go version it was built with and its rotation policy.",
+			[]string{"version", "go_version", "policy"}, labels),
+		workersLive: prometheus.NewDesc(namespace+"_workers_live",
+			"Background goroutines of the driver running, by their kind.",
+			[]string{"worker"}, labels),
+		workerLastRun: prometheus.NewDesc(namespace+"_worker_last_run_timestamp_seconds",
+			"Unix time a background goroutine of the kind last did its work.",
+			[]string{"worker"}, labels),
+		workerRestarts: prometheus.NewDesc(namespace+"_worker_restarts_total",
+			"Background goroutines restarted after they panicked, by their kind.",
+			[]string{"worker"}, labels),
+		liveConns: prometheus.NewDesc(namespace+"_live_connections",
+			"Connections open, by the user that authenticated them.",
+			[]string{"user"}, labels),
+		started: time.Now(),
+	}
+}
+
+// Instrument returns a Collector for the driver and installs it as the
+// Metrics of the driver. Register the Collector to export the metrics.
+func Instrument(d *gopqr.Driver, labels prometheus.Labels) *Collector {
+	c := New(d, labels)
+	d.Metrics = c
+	return c
+}
+
+// ConnectionOpened implements gopqr.Metrics.
+func (c *Collector) ConnectionOpened(slot string, fallback bool) {
+	f := "false"
+	if fallback {
+		f = "true"
+	}
+	c.opened.WithLabelValues(slot, f).Inc()
+}
+
+// AuthFailed implements gopqr.Metrics.
+func (c *Collector) AuthFailed(slot string) {
+	c.authFailures.WithLabelValues(slot).Inc()
+}
+
+// ConnectDuration implements gopqr.ConnectLatencyMetrics.
+func (c *Collector) ConnectDuration(slot, outcome string, took time.Duration) {
+	c.connectDuration.WithLabelValues(slot, outcome).Observe(took.Seconds())
+}
+
+// RefreshAttempted implements gopqr.Metrics.
+func (c *Collector) RefreshAttempted(err error) {
+	c.refreshes.Inc()
+	if err != nil {
+		c.refreshFailures.Inc()
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.opened.Describe(ch)
+	c.authFailures.Describe(ch)
+	c.connectDuration.Describe(ch)
+	c.refreshes.Describe(ch)
+	c.refreshFailures.Describe(ch)
+	ch <- c.sinceRefresh
+	ch <- c.generation
+	ch <- c.degraded
+	ch <- c.noFallback
+	ch <- c.queued
+	ch <- c.buildInfo
+	ch <- c.workersLive
+	ch <- c.workerLastRun
+	ch <- c.workerRestarts
+	ch <- c.liveConns
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.opened.Collect(ch)
+	c.authFailures.Collect(ch)
+	c.connectDuration.Collect(ch)
+	c.refreshes.Collect(ch)
+	c.refreshFailures.Collect(ch)
+
+	last := c.driver.LastRefresh()
+	if last.IsZero() {
+		last = c.started
+	}
+	state := c.driver.RotationState()
+	degraded := 0.0
+	if state.Degraded {
+		degraded = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.sinceRefresh, prometheus.GaugeValue, time.Since(last).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.generation, prometheus.GaugeValue, float64(state.Generation))
+	ch <- prometheus.MustNewConstMetric(c.degraded, prometheus.GaugeValue, degraded)
+	noFallback := 0.0
+	if c.driver.NoFallback {
+		noFallback = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.noFallback, prometheus.GaugeValue, noFallback)
+	ch <- prometheus.MustNewConstMetric(c.queued, prometheus.GaugeValue, float64(c.driver.RefreshQueueDepth()))
+	build := gopqr.BuildInfo()
+	ch <- prometheus.MustNewConstMetric(c.buildInfo, prometheus.GaugeValue, 1, build.Version, build.GoVersion, c.driver.Status().Policy)
+	for _, w := range c.driver.Workers() {
+		ch <- prometheus.MustNewConstMetric(c.workersLive, prometheus.GaugeValue, float64(w.Live), w.Name)
+		if !w.LastRun.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.workerLastRun, prometheus.GaugeValue, float64(w.LastRun.UnixNano())/1e9, w.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(c.workerRestarts, prometheus.CounterValue, float64(w.Restarts), w.Name)
+	}
+	for user, n := range c.driver.LiveConns() {
+		ch <- prometheus.MustNewConstMetric(c.liveConns, prometheus.GaugeValue, float64(n), user)
+	}
+}
@@ -0,0 +1,324 @@
+package gopqr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSecretNotFound is matched by errors.Is for the errors of providers that
+// could not find the secret they are configured to read, which almost always
+// means the provider is misconfigured rather than the store being unhealthy.
+var ErrSecretNotFound = errors.New("Secret not found")
+
+// SecretNotFoundError is returned by providers when the secret they are
+// configured to read does not exist.
+type SecretNotFoundError struct {
+	// Source - The store that was read, like "AWS Secrets Manager"
+	Source string
+	// ID - The identifier of the secret that was not found
+	ID string
+	// Err - The error returned by the store
+	Err error
+}
+
+func (e *SecretNotFoundError) Error() string {
+	return fmt.Sprintf("Secret %v not found in %v - %v", e.ID, e.Source, e.Err)
+}
+
+// Unwrap returns the error returned by the store.
+func (e *SecretNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrSecretNotFound) match.
+func (e *SecretNotFoundError) Is(target error) bool {
+	return target == ErrSecretNotFound
+}
+
+// Credential is a username and password pair occupying one slot of the
+// ring of credentials the driver rotates through.
+type Credential struct {
+	// Name - Name of the slot, like "odd"/"even" or "blue"/"green"/"canary"
+	Name     string
+	Username string
+	Password string
+	// PasswordBytes - When set, the password in place of Password, held in
+	// a slice the driver zeroes once a refresh replaces the credential. Only
+	// a provider that never holds the password as a string keeps it out of
+	// the heap and core dumps that way - Secret.Credentials and the bundled
+	// providers fill Password.
+	PasswordBytes []byte
+	// ValidFrom and ValidUntil - When known, the window the credential is
+	// valid in, like the VALID UNTIL of the role or the lease of a Vault
+	// credential. Zero values stand for no bound.
+	ValidFrom  time.Time
+	ValidUntil time.Time
+	// Version - When known, the version of the secret the credential came
+	// from, like the version id of a Secrets Manager secret or a KV version
+	Version string
+	// LastResort - When set, the credential is only tried once the others
+	// failed authentication, and never becomes the active one, like the
+	// credential of the previous version of a secret
+	LastResort bool
+	// Stages - When known, the labels of the version of the secret the
+	// credential came from, like AWSCURRENT and AWSPREVIOUS, which a
+	// SlotSelector like ByStage picks the active slot by
+	Stages []string
+	// Host, Port and SSLMode - When set, override the endpoint of the DSN
+	// and of the driver for this credential only, like a new credential that
+	// must use verify-full against the new endpoint of a migrated cluster
+	Host    string
+	Port    string
+	SSLMode string
+	// SSLCert and SSLKey - When set, the client certificate and its private
+	// key this credential authenticates with, like certificates rotated on
+	// a schedule along with the passwords. Each holds either the path of a
+	// file or the PEM encoded material itself.
+	SSLCert string
+	SSLKey  string
+	// Keytab - When set, the credential authenticates with GSSAPI
+	// (Kerberos) as the principal of Username, logging in with this keytab
+	// rather than sending a password, so that rotating keytabs rotates the
+	// credential. Holds the path of the keytab file, or its contents base64
+	// encoded behind "base64:". Needs the GSS of the driver.
+	Keytab string
+	// CertOnly - When set, the credential authenticates with its SSLCert and
+	// SSLKey alone, like against the cert method of pg_hba.conf. No password
+	// goes into the DSN, not even one of the DSN or of the PasswordSource,
+	// so that rotating the certificates rotates the credential.
+	CertOnly bool
+	// Params - When set, query parameters merged into the DSN for this
+	// credential only, over those of the DSN, like options=-c role=app_rw
+	// for a user whose default role differs from that of the other slots.
+	// The user, password, host and port cannot be set through them.
+	Params map[string]string
+}
+
+// endpointFor returns the host, port and sslmode overrides in effect for the
+// credential, its own taking precedence over those of the driver.
+func (d *Driver) endpointFor(cred Credential) (host, port, sslmode string) {
+	s := d.current()
+	host, port, sslmode = s.host, s.port, s.sslmode
+	if cred.Host != "" {
+		host = cred.Host
+	}
+	if cred.Port != "" {
+		port = cred.Port
+	}
+	if cred.SSLMode != "" {
+		sslmode = cred.SSLMode
+	}
+	return host, port, sslmode
+}
+
+// ValidAt reports whether t falls in the window the credential is valid in.
+func (c Credential) ValidAt(t time.Time) bool {
+	return (c.ValidFrom.IsZero() || !t.Before(c.ValidFrom)) && (c.ValidUntil.IsZero() || t.Before(c.ValidUntil))
+}
+
+// same reports whether the credentials are the same, metadata included.
+func (c Credential) same(o Credential) bool {
+	return c.Name == o.Name && c.Username == o.Username && c.PasswordText() == o.PasswordText() &&
+		c.ValidFrom.Equal(o.ValidFrom) && c.ValidUntil.Equal(o.ValidUntil) && c.Version == o.Version &&
+		sameStrings(c.Stages, o.Stages) && c.LastResort == o.LastResort &&
+		c.Host == o.Host && c.Port == o.Port && c.SSLMode == o.SSLMode &&
+		c.SSLCert == o.SSLCert && c.SSLKey == o.SSLKey && c.Keytab == o.Keytab && c.CertOnly == o.CertOnly && sameParams(c.Params, o.Params)
+}
+
+// Credentials is the set of rotating credentials handed out by a
+// CredentialProvider. Use OddEven to build the default pair of odd and even
+// credentials.
+type Credentials struct {
+	// Slots - The ring of credentials in the order they are rotated through
+	Slots []Credential
+	// Active - Index of the active credential in Slots
+	Active int
+	// Host, Port and SSLMode - When set, override the endpoint of the DSN
+	Host    string
+	Port    string
+	SSLMode string
+	// SSLRootCert, SSLCert and SSLKey - When set, override the TLS settings
+	// of the DSN, the paths of files or PEM encoded
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// Extra - Any other settings that came along with the credentials, like
+	// the fields of a Secret that gopqr does not know about
+	Extra map[string]string
+	// Targets - The credentials of several databases, when the provider
+	// serves a Secret carrying Targets, see NewMultiDriverFor
+	Targets []TargetCredentials
+}
+
+// CredentialProvider is a source of rotating credentials that the driver
+// consults instead of relying on its exported credential fields being set
+// under AcquireLock/ReleaseLock by a CredentialRefresher. Providers can be
+// unit tested on their own and hold no reference to the driver.
+type CredentialProvider interface {
+	// Current returns the credentials the provider holds right now. It is
+	// called on every Open and is expected to be cheap, like returning what
+	// was fetched by the last Refresh.
+	Current(ctx context.Context) (Credentials, error)
+	// Refresh fetches the latest credentials from the backing store. The
+	// driver calls it when a credential fails authentication.
+	Refresh(ctx context.Context) error
+}
+
+// Credentials returns the credentials held in the secret.
+func (s *Secret) Credentials() Credentials {
+	var c Credentials
+	if len(s.Slots) > 0 {
+		c.Slots = make([]Credential, len(s.Slots))
+		for i, slot := range s.Slots {
+			c.Slots[i] = Credential{
+				Name:       slot.Name,
+				Username:   slot.Username,
+				Password:   slot.Password,
+				ValidFrom:  slot.ValidFrom,
+				ValidUntil: slot.ValidUntil,
+				Version:    slot.Version,
+				Stages:     append([]string(nil), slot.Stages...),
+				Host:       slot.Host,
+				Port:       slot.Port.String(),
+				SSLMode:    slot.SSLMode,
+				SSLCert:    slot.SSLCert,
+				SSLKey:     slot.SSLKey,
+				Keytab:     slot.Keytab,
+				CertOnly:   slot.CertOnly,
+				Params:     copyExtra(slot.Params),
+			}
+		}
+		c.Active = slotIndex(c.Slots, s.ActiveCredential)
+	} else {
+		c = OddEven(
+			Credential{Username: s.OddUsername, Password: s.OddPassword, Params: copyExtra(s.OddParams)},
+			Credential{Username: s.EvenUsername, Password: s.EvenPassword, Params: copyExtra(s.EvenParams)},
+			s.ActiveCredential,
+		)
+	}
+	c.Host = s.Host
+	c.Port = s.Port.String()
+	c.SSLMode = s.SSLMode
+	c.SSLRootCert, c.SSLCert, c.SSLKey = s.SSLRootCert, s.SSLCert, s.SSLKey
+	c.Extra = copyExtra(s.Extra)
+	for _, t := range s.Targets {
+		c.Targets = append(c.Targets, TargetCredentials{Host: t.Host, DBName: t.DBName, Credentials: t.Secret.Credentials()})
+	}
+	return c
+}
+
+// syncProvider consults the provider of the driver, if it has one, and
+// installs its credentials on the driver when they differ from what was last
+// installed. The active credential is only reset from the provider when the
+// credentials change, so that the driver keeps alternating between them.
+func (d *Driver) syncProvider(ctx context.Context) error {
+	if d.Provider == nil {
+		return nil
+	}
+	creds, err := d.Provider.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if last := d.installed.Load(); last != nil && last.equal(creds) {
+		// the common case of a refresh that changed nothing, which need
+		// not wait for the lock Opens are swapping the active slot under
+		return nil
+	}
+	if err := d.mux.acquire(d, d.LockTimeout); err != nil {
+		return err
+	}
+	if last := d.installed.Load(); last != nil && last.equal(creds) {
+		d.mux.release()
+		return nil
+	}
+	if len(creds.Slots) == 0 || creds.Active < 0 || creds.Active >= len(creds.Slots) {
+		d.mux.release()
+		return errors.New("Provider returned no credentials or an active index out of range")
+	}
+	from := d.ActiveCredential
+	active := d.selectActive(creds.Slots, creds.Slots[creds.Active].Name)
+	d.Slots = append([]Credential(nil), creds.Slots...)
+	d.ActiveCredential = active
+	d.Host = creds.Host
+	d.Port = creds.Port
+	d.SSLMode = creds.SSLMode
+	d.SSLRootCert, d.SSLCert, d.SSLKey = creds.SSLRootCert, creds.SSLCert, creds.SSLKey
+	d.extra = copyExtra(creds.Extra)
+	creds.Slots = d.Slots
+	creds.Extra = d.extra
+	d.installed.Store(&creds)
+	d.primed.Store(true)
+	d.credentialsInstalled(from, active)
+	to := d.ActiveCredential
+	d.publish()
+	d.mux.release()
+	d.passExtra(creds.Extra)
+	d.rotated(from, to)
+	return nil
+}
+
+// Refresh refreshes the credentials of the driver right away, from its
+// Provider or with its CredentialRefresher. A refresh already in flight is
+// shared rather than run again.
+func (d *Driver) Refresh() error {
+	return d.refreshCredentials()
+}
+
+// refreshCredentials refreshes the provider of the driver and installs what
+// it fetched, or invokes the CredentialRefresher when there is no provider.
+// Concurrent calls share a single refresh, which is retried as per the
+// RefreshRetry of the driver.
+func (d *Driver) refreshCredentials() error {
+	return d.refresh.do(d.refreshRetried)
+}
+
+// refreshRetried runs the refresh registered with refreshFlight, retried
+// as per the RefreshRetry of the driver.
+func (d *Driver) refreshRetried() error {
+	if d.OnRefreshStart != nil {
+		d.OnRefreshStart()
+	}
+	end := d.tracer().StartRefresh(context.Background())
+	err := d.RefreshRetry.do(func() error {
+		err := d.runRefresh()
+		d.recordRefresh(err)
+		return err
+	})
+	end(err)
+	if d.OnRefreshDone != nil {
+		d.OnRefreshDone(err)
+	}
+	return err
+}
+
+func (d *Driver) runRefresh() error {
+	ctx, cancel := d.refreshContext()
+	defer cancel()
+	if d.Provider != nil {
+		if err := d.withinDeadline(ctx, func() error { return d.Provider.Refresh(ctx) }); err != nil {
+			return err
+		}
+		return d.withinDeadline(ctx, func() error { return d.syncProvider(ctx) })
+	}
+	if d.CredentialRefresher == nil {
+		// passwords generated on demand have nothing to refresh
+		if d.PasswordSource != nil {
+			return nil
+		}
+		return errors.New("No CredentialRefresher is set on the driver")
+	}
+	// an invocation given up on still holds the mutex until it returns, and
+	// the refreshes meanwhile fail rather than pile up behind it
+	if d.refreshTimeout() <= 0 {
+		d.refresherMu.Lock()
+	} else if !d.refresherMu.TryLock() {
+		return d.refreshTimedOut(errors.New("the previous invocation of the CredentialRefresher has not returned"))
+	}
+	return d.withinDeadline(ctx, func() error {
+		defer d.refresherMu.Unlock()
+		d.CredentialRefresher(d)
+		return nil
+	})
+}
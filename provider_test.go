@@ -0,0 +1,125 @@
+package gopqr_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+)
+
+func TestRefreshUnchangedSkipsLock(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	d, _ := testDriver(backend)
+	if err := d.Refresh(); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	d.LockTimeout = 50 * time.Millisecond
+	d.AcquireLock()
+	defer d.ReleaseLock()
+	if err := d.Refresh(); err != nil {
+		t.Errorf("Refresh of unchanged credentials = %v while the lock is held, want it not to wait for the lock", err)
+	}
+}
+
+// stubProvider serves the credentials of inner, running refresh, when set,
+// in place of refreshing them.
+type stubProvider struct {
+	inner   gopqr.CredentialProvider
+	refresh func(ctx context.Context) error
+}
+
+func (p *stubProvider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	return p.inner.Current(ctx)
+}
+
+func (p *stubProvider) Refresh(ctx context.Context) error {
+	if p.refresh != nil {
+		return p.refresh(ctx)
+	}
+	return p.inner.Refresh(ctx)
+}
+
+func TestRefreshPanicOfProviderIsRecovered(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	d, store := testDriver(backend)
+	provider := &stubProvider{inner: store}
+	d.Provider = provider
+	if err := d.Refresh(); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	provider.refresh = func(context.Context) error { panic("secret store client bug") }
+	if err := d.Refresh(); !errors.Is(err, gopqr.ErrRefreshPanicked) {
+		t.Fatalf("Refresh = %v, want ErrRefreshPanicked", err)
+	}
+	conn, err := d.Open(testDSN)
+	if err != nil {
+		t.Fatalf("Open after the panic failed - %v", err)
+	}
+	conn.Close()
+}
+
+func TestRefreshPanicReleasesLock(t *testing.T) {
+	calls := 0
+	refresher := func(d *gopqr.Driver) {
+		calls++
+		d.AcquireLock()
+		if calls > 1 {
+			d.OddPassword = "half-written"
+			panic("refresher bug")
+		}
+		d.OddUsername, d.OddPassword = "app_odd", "odd-pw"
+		d.EvenUsername, d.EvenPassword = "app_even", "even-pw"
+		d.ActiveCredential = "odd"
+		d.ReleaseLock()
+	}
+	d := &gopqr.Driver{CredentialRefresher: refresher, Backend: gopqrtest.NewBackend(), LockTimeout: time.Second}
+	if err := d.Refresh(); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	if err := d.Refresh(); !errors.Is(err, gopqr.ErrRefreshPanicked) {
+		t.Fatalf("Refresh = %v, want ErrRefreshPanicked", err)
+	}
+	if got := d.Snapshot().Slots[0].Password; got != "odd-pw" {
+		t.Errorf("odd password = %q after the panic, want the one published before", got)
+	}
+	if err := d.AcquireLockTimeout(time.Second); err != nil {
+		t.Fatalf("AcquireLockTimeout after the panic = %v, want the lock released", err)
+	}
+	d.ReleaseLock()
+}
+
+func TestRefreshTimeoutGivesUpOnHungProvider(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	d, store := testDriver(backend)
+	release := make(chan struct{})
+	defer close(release)
+	provider := &stubProvider{inner: store}
+	d.Provider = provider
+	if err := d.Refresh(); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	// a store client ignoring the context hangs until released
+	provider.refresh = func(context.Context) error {
+		<-release
+		return nil
+	}
+	d.RefreshTimeout = 20 * time.Millisecond
+	start := time.Now()
+	if err := d.Refresh(); !errors.Is(err, gopqr.ErrRefreshTimeout) {
+		t.Fatalf("Refresh = %v, want ErrRefreshTimeout", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Refresh gave up after %v, want about the RefreshTimeout", waited)
+	}
+	conn, err := d.Open(testDSN)
+	if err != nil {
+		t.Fatalf("Open after the timeout failed - %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,565 @@
+package awssm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package awssm sources the rotating credentials for github.com/chandranarreddy/gopqr
+from AWS Secrets Manager. The secret string is expected to be the gopqr
+rotating credentials document -
+	{
+		"odd_username": "myOddUserName",
+		"odd_password": "myOddPassword",
+		"even_username": "myEvenUserName",
+		"even_password": "myEvenPassword",
+		"active_credential": "even"
+	}
+
+The single "username" and "password" secret kept by the managed rotation of
+Secrets Manager is recognized as well, and used as the single credential of
+the driver.
+
+With PreviousFallback, the AWSPREVIOUS version is fetched as well, and its
+credentials are tried as a last resort once the current ones failed
+authentication, like while the rotation Lambda has yet to set the new
+password on the database.
+
+With RDSManaged, the secret kept by the managed single-user rotation of RDS
+works out of the box - its AWSCURRENT version is the active slot "current"
+and its AWSPREVIOUS version the standby slot "previous", so that
+connections fall back to the previous password while the new one is being
+set on the database.
+
+With ReplicaRegions, a secret replicated to other regions is read from the
+replicas in order whenever reading it from the primary region fails, so that
+an outage of Secrets Manager in one region leaves the refreshes working.
+
+With a RoleARN, the provider assumes the role through STS before reading the
+secret, with the ExternalID and SessionTags given, for secrets kept in an
+account other than the one of the application. The credentials of the role
+are renewed before they expire.
+
+For isolated VPC deployments the provider can be pointed at a PrivateLink
+interface endpoint, trust a custom CA (such as the one of a TLS inspecting
+proxy) and send its requests through a proxy, all without setting any
+environment variables.
+
+Usage:
+	p, err := awssm.New(awssm.Config{
+		Region:   "us-west-2",
+		SecretID: "mysecretmanagerentry",
+		Endpoint: "https://vpce-0123-abcd.secretsmanager.us-west-2.vpce.amazonaws.com",
+		CABundle: "/etc/pki/proxy-ca.pem",
+		Proxy:    "http://proxy.internal:3128",
+	})
+	...
+	pqrDriver := &gopqr.Driver{Provider: p}
+*/
+
+const (
+	//DEFAULTVERSIONSTAGE - default version stage of the secret that is read
+	DEFAULTVERSIONSTAGE = "AWSCURRENT"
+	//PREVIOUSVERSIONSTAGE - version stage of the secret Secrets Manager
+	//moves the last current version to
+	PREVIOUSVERSIONSTAGE = "AWSPREVIOUS"
+	//RDSPREVIOUSSLOT - name of the standby slot holding the AWSPREVIOUS
+	//version of an RDSManaged secret, the active one being gopqr.SINGLESLOT
+	RDSPREVIOUSSLOT = "previous"
+	//DEFAULTTIMEOUT - default deadline for a refresh triggered by the driver
+	DEFAULTTIMEOUT = time.Minute
+)
+
+// Config holds the settings of the AWS Secrets Manager provider.
+type Config struct {
+	// Region - AWS region the secret is stored in
+	Region string
+	// SecretID - Name or ARN of the secret
+	SecretID string
+	// VersionStage - Version stage to read, defaults to DEFAULTVERSIONSTAGE
+	VersionStage string
+	// Endpoint - Overrides the Secrets Manager endpoint, like the DNS name of a
+	// PrivateLink interface endpoint
+	Endpoint string
+	// CABundle - Path to a PEM file of CA certificates trusted in addition to
+	// the system roots and the AWS_CA_BUNDLE of the environment, like the CA
+	// of a TLS inspecting proxy
+	CABundle string
+	// CABundlePEM - PEM encoded CA certificates, used in place of CABundle
+	CABundlePEM []byte
+	// Proxy - URL of the proxy requests are sent through. Leave empty to honor
+	// the usual proxy environment variables.
+	Proxy string
+	// Credentials - AWS credentials used to call Secrets Manager. Leave nil to
+	// use the default credential chain of the AWS SDK.
+	Credentials *credentials.Credentials
+	// Format - Format of the secret, detected from its fields unless set
+	Format gopqr.SecretFormat
+	// Timeout - Deadline for each refresh invoked by the driver, defaults to DEFAULTTIMEOUT
+	Timeout time.Duration
+	// NegativeCacheTTL - How long a not found secret is remembered before the
+	// store is asked again, defaults to providers.DEFAULTNEGATIVECACHETTL. A
+	// negative value turns negative caching off.
+	NegativeCacheTTL time.Duration
+	// OnNotFound func is invoked with the gopqr.SecretNotFoundError when the
+	// store reports that the secret does not exist, so that misconfiguration
+	// can be flagged right away.
+	OnNotFound func(error)
+	// PreviousFallback - When set, the credentials of the AWSPREVIOUS
+	// version are fetched along with the current ones and tried once all of
+	// those failed authentication, for the race where the application sees
+	// a new version before the rotation Lambda finished setSecret on the
+	// database
+	PreviousFallback bool
+	// RDSManaged - When set, the secret is the "username" and "password" of
+	// the managed rotation of RDS, whose AWSCURRENT version is the active
+	// slot and AWSPREVIOUS version the standby slot RDSPREVIOUSSLOT
+	RDSManaged bool
+	// ReplicaRegions - Regions the secret is replicated to, read in order
+	// when reading it from the Region fails for reasons other than the
+	// secret not existing. The Endpoint only applies to the Region.
+	ReplicaRegions []string
+	// OnRegionFailover func, when set, is invoked with the replica region
+	// that served the secret and the failure of the Region before it
+	OnRegionFailover func(region string, err error)
+	// RoleARN - Role assumed through STS to read the secret, like of the
+	// account keeping the secrets, with the Credentials or the default
+	// credential chain of the AWS SDK
+	RoleARN string
+	// ExternalID - External ID the trust policy of the RoleARN requires
+	ExternalID string
+	// RoleSessionName - Name of the role session, defaults to one the AWS SDK picks
+	RoleSessionName string
+	// SessionTags - Tags of the role session, for attribute based access control
+	SessionTags map[string]string
+	// STSEndpoint - Overrides the STS endpoint, like the DNS name of a
+	// PrivateLink interface endpoint
+	STSEndpoint string
+}
+
+// replica is the secret replicated to another region.
+type replica struct {
+	region string
+	sm     *secretsmanager.SecretsManager
+	id     string
+}
+
+// Provider fetches the rotating credentials document from AWS Secrets Manager.
+type Provider struct {
+	sm      *secretsmanager.SecretsManager
+	id      string
+	stage   string
+	timeout time.Duration
+	format  gopqr.SecretFormat
+
+	negative   providers.NegativeCache
+	onNotFound func(error)
+	// withPrevious - Whether the AWSPREVIOUS version is fetched as well
+	withPrevious bool
+	// rdsManaged - Whether the AWSPREVIOUS version is the standby slot
+	// rather than a last resort
+	rdsManaged bool
+	replicas   []replica
+	onFailover func(region string, err error)
+
+	mu       sync.Mutex
+	current  *gopqr.Secret
+	previous *gopqr.Secret
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "awssm" of gopqr.LoadConfig, whose params
+// are the fields of Config.
+func init() {
+	gopqr.RegisterProviderType("awssm", gopqr.ConfigProvider(New))
+}
+
+// New returns a Provider for the configured secret.
+func New(cfg Config) (*Provider, error) {
+	if cfg.Region == "" || cfg.SecretID == "" {
+		return nil, errors.New("Region and SecretID are required for the Secrets Manager provider")
+	}
+	if cfg.VersionStage == "" {
+		cfg.VersionStage = DEFAULTVERSIONSTAGE
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DEFAULTTIMEOUT
+	}
+	if cfg.RDSManaged {
+		if cfg.Format != gopqr.FormatAuto && cfg.Format != gopqr.FormatSinglePair {
+			return nil, fmt.Errorf("RDSManaged secrets are of the %v format, not %v", gopqr.FormatSinglePair, cfg.Format)
+		}
+		cfg.Format = gopqr.FormatSinglePair
+	}
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer pinRoots(httpClient)()
+	awsConfig := &aws.Config{
+		Region:     aws.String(cfg.Region),
+		HTTPClient: httpClient,
+	}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+	if cfg.Credentials != nil {
+		awsConfig.Credentials = cfg.Credentials
+	}
+	if cfg.RoleARN != "" {
+		roleCreds, err := assumeRole(cfg, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		awsConfig.Credentials = roleCreds
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session - %w", err)
+	}
+	var replicas []replica
+	for _, region := range cfg.ReplicaRegions {
+		replicaConfig := &aws.Config{
+			Region:      aws.String(region),
+			HTTPClient:  httpClient,
+			Credentials: awsConfig.Credentials,
+		}
+		replicaSess, err := session.NewSession(replicaConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AWS session for replica region %v - %w", region, err)
+		}
+		replicas = append(replicas, replica{region: region, sm: secretsmanager.New(replicaSess), id: replicaSecretID(cfg.SecretID, region)})
+	}
+	return &Provider{
+		sm:      secretsmanager.New(sess),
+		id:      cfg.SecretID,
+		stage:   cfg.VersionStage,
+		timeout: cfg.Timeout,
+		format:  cfg.Format,
+
+		negative:     providers.NegativeCache{TTL: cfg.NegativeCacheTTL},
+		onNotFound:   cfg.OnNotFound,
+		withPrevious: cfg.PreviousFallback || cfg.RDSManaged,
+		rdsManaged:   cfg.RDSManaged,
+		replicas:     replicas,
+		onFailover:   cfg.OnRegionFailover,
+	}, nil
+}
+
+// assumeRole returns the credentials of the RoleARN of the configuration,
+// assumed through STS and renewed before they expire.
+func assumeRole(cfg Config, httpClient *http.Client) (*credentials.Credentials, error) {
+	stsConfig := &aws.Config{
+		Region:      aws.String(cfg.Region),
+		HTTPClient:  httpClient,
+		Credentials: cfg.Credentials,
+	}
+	if cfg.STSEndpoint != "" {
+		stsConfig.Endpoint = aws.String(cfg.STSEndpoint)
+	}
+	sess, err := session.NewSession(stsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session for STS - %w", err)
+	}
+	return stscreds.NewCredentials(sess, cfg.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		if cfg.ExternalID != "" {
+			p.ExternalID = aws.String(cfg.ExternalID)
+		}
+		if cfg.RoleSessionName != "" {
+			p.RoleSessionName = cfg.RoleSessionName
+		}
+		keys := make([]string, 0, len(cfg.SessionTags))
+		for key := range cfg.SessionTags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			p.Tags = append(p.Tags, &sts.Tag{Key: aws.String(key), Value: aws.String(cfg.SessionTags[key])})
+		}
+	}), nil
+}
+
+// replicaSecretID returns the ID of the secret in the replica region. A
+// replica keeps the name of its primary, while its ARN names its region.
+func replicaSecretID(id, region string) string {
+	if !strings.HasPrefix(id, "arn:") {
+		return id
+	}
+	parts := strings.SplitN(id, ":", 5)
+	if len(parts) < 5 {
+		return id
+	}
+	parts[3] = region
+	return strings.Join(parts, ":")
+}
+
+// newHTTPClient builds the HTTP client carrying the CA and proxy settings.
+func newHTTPClient(cfg Config) (*http.Client, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %v - %w", cfg.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	pem := cfg.CABundlePEM
+	if pem == nil && cfg.CABundle != "" {
+		b, err := ioutil.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle - %w", err)
+		}
+		pem = b
+	}
+	if pem != nil {
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no CA certificates found in the CA bundle")
+		}
+		// the AWS SDK trusts the AWS_CA_BUNDLE of the environment, and so does
+		// the CA bundle
+		if path := os.Getenv("AWS_CA_BUNDLE"); path != "" {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read AWS_CA_BUNDLE - %w", err)
+			}
+			roots.AppendCertsFromPEM(b)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// pinRoots returns the func restoring the roots the HTTP client trusts. Every
+// AWS session replaces them with the AWS_CA_BUNDLE of the environment, which
+// would drop the CA bundle of the config.
+func pinRoots(httpClient *http.Client) func() {
+	tlsConfig := httpClient.Transport.(*http.Transport).TLSClientConfig
+	if tlsConfig == nil {
+		return func() {}
+	}
+	roots := tlsConfig.RootCAs
+	return func() { tlsConfig.RootCAs = roots }
+}
+
+// Fetch reads and parses the rotating credentials document from Secrets
+// Manager. A secret that does not exist results in a
+// *gopqr.SecretNotFoundError, which is remembered for the NegativeCacheTTL.
+func (p *Provider) Fetch(ctx context.Context) (*gopqr.Secret, error) {
+	if err := p.negative.Check(); err != nil {
+		return nil, err
+	}
+	result, err := p.getStage(ctx, p.stage)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+				return nil, p.notFound(aerr)
+			}
+			return nil, fmt.Errorf("failed to fetch secret %v from Secrets Manager - %v: %v (%w)", p.id, aerr.Code(), aerr.Message(), err)
+		}
+		return nil, fmt.Errorf("failed to fetch secret %v from Secrets Manager - %w", p.id, err)
+	}
+	p.negative.Observe(nil)
+	if result.SecretString == nil {
+		return nil, fmt.Errorf("secret %v in Secrets Manager has no secret string", p.id)
+	}
+	return gopqr.ParseSecretAs([]byte(*result.SecretString), p.format)
+}
+
+// FetchPrevious reads and parses the AWSPREVIOUS version of the secret. It
+// returns nil without an error when the secret has no previous version,
+// like before its first rotation.
+func (p *Provider) FetchPrevious(ctx context.Context) (*gopqr.Secret, error) {
+	result, err := p.getStage(ctx, PREVIOUSVERSIONSTAGE)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch the previous version of secret %v from Secrets Manager - %w", p.id, err)
+	}
+	if result.SecretString == nil {
+		return nil, fmt.Errorf("previous version of secret %v in Secrets Manager has no secret string", p.id)
+	}
+	return gopqr.ParseSecretAs([]byte(*result.SecretString), p.format)
+}
+
+// getStage reads the version of the secret at the stage, from the replica
+// regions in order when the primary region fails. The failure of the
+// primary region is returned when every region failed.
+func (p *Provider) getStage(ctx context.Context, stage string) (*secretsmanager.GetSecretValueOutput, error) {
+	result, err := getSecretValue(ctx, p.sm, p.id, stage)
+	if err == nil || len(p.replicas) == 0 {
+		return result, err
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return nil, err
+	}
+	for _, r := range p.replicas {
+		if ctx.Err() != nil {
+			break
+		}
+		if result, rerr := getSecretValue(ctx, r.sm, r.id, stage); rerr == nil {
+			if p.onFailover != nil {
+				p.onFailover(r.region, err)
+			}
+			return result, nil
+		}
+	}
+	return nil, err
+}
+
+func getSecretValue(ctx context.Context, sm *secretsmanager.SecretsManager, id, stage string) (*secretsmanager.GetSecretValueOutput, error) {
+	return sm.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(id),
+		VersionStage: aws.String(stage),
+	})
+}
+
+func (p *Provider) notFound(err error) error {
+	nf := &gopqr.SecretNotFoundError{Source: "AWS Secrets Manager", ID: p.id, Err: err}
+	p.negative.Observe(nf)
+	if p.onNotFound != nil {
+		p.onNotFound(nf)
+	}
+	return nf
+}
+
+// Current returns the credentials fetched by the last Refresh, fetching them
+// first if they have not been fetched yet. With PreviousFallback, the
+// credentials of the AWSPREVIOUS version that differ from the current ones
+// follow them as last resort slots, named after their slot with a
+// "-previous" suffix. With RDSManaged, the previous credential is the standby
+// slot RDSPREVIOUSSLOT instead.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	s, previous := p.current, p.previous
+	p.mu.Unlock()
+	if s == nil {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		s, previous = p.current, p.previous
+		p.mu.Unlock()
+	}
+	creds := s.Credentials()
+	for i := range creds.Slots {
+		creds.Slots[i].Stages = []string{p.stage}
+	}
+	if previous == nil {
+		return creds, nil
+	}
+	current := creds.Slots
+	for _, c := range previous.Credentials().Slots {
+		if known(current, c) {
+			continue
+		}
+		c.Stages = []string{PREVIOUSVERSIONSTAGE}
+		if p.rdsManaged {
+			c.Name = RDSPREVIOUSSLOT
+			creds.Slots = append(creds.Slots, c)
+			continue
+		}
+		c.Name += "-previous"
+		c.LastResort = true
+		creds.Slots = append(creds.Slots, c)
+	}
+	return creds, nil
+}
+
+// known reports whether the credentials hold the username and password.
+func known(creds []gopqr.Credential, c gopqr.Credential) bool {
+	for _, k := range creds {
+		if k.Username == c.Username && k.Password == c.Password {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh fetches the secret from Secrets Manager, along with its previous
+// version with PreviousFallback or RDSManaged. Failing to fetch the previous version only
+// leaves out its credentials.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	var previous *gopqr.Secret
+	if p.withPrevious {
+		previous, _ = p.FetchPrevious(ctx)
+	}
+	p.mu.Lock()
+	p.current, p.previous = s, previous
+	p.mu.Unlock()
+	return nil
+}
+
+// NewDriver fetches the secret and returns a sticky gopqr driver sourcing
+// its credentials from the provider. The notices of the driver, like failed
+// refreshes, are written to the logger.
+func (p *Provider) NewDriver(ctx context.Context, logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Refresher returns a CredentialRefresher func that refetches the secret
+// from Secrets Manager and resets the credentials on the driver. Failures
+// are reported to the logger, if one is supplied, and leave the driver as is.
+func (p *Provider) Refresher(logger *log.Logger) func(*gopqr.Driver) {
+	return func(pqrDriver *gopqr.Driver) {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+		s, err := p.Fetch(ctx)
+		if err != nil {
+			if logger != nil {
+				logger.Print(fmt.Errorf("refreshing DB secret from Secrets Manager failed - %w", err))
+			}
+			return
+		}
+		s.Apply(pqrDriver)
+		return
+	}
+}
@@ -0,0 +1,202 @@
+package awssm_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/awssm"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+const document = `{"odd_username": "app_odd", "odd_password": "odd-pw", "even_username": "app_even", "even_password": "even-pw", "active_credential": "even"}`
+
+// fakeSM serves GetSecretValue of Secrets Manager from the secret strings
+// of its stages.
+type fakeSM struct {
+	mu       sync.Mutex
+	stages   map[string]string
+	requests []map[string]string
+}
+
+func (sm *fakeSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var input map[string]string
+	json.NewDecoder(r.Body).Decode(&input)
+	sm.mu.Lock()
+	sm.requests = append(sm.requests, input)
+	secret, ok := sm.stages[input["VersionStage"]]
+	sm.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "ResourceNotFoundException", "message": "Secrets Manager can't find the specified secret."})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"Name": input["SecretId"], "SecretString": secret, "VersionId": "v-" + input["VersionStage"]})
+}
+
+func (sm *fakeSM) requested() []map[string]string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return append([]map[string]string(nil), sm.requests...)
+}
+
+func newProvider(t *testing.T, sm *fakeSM, cfg awssm.Config) *awssm.Provider {
+	t.Helper()
+	srv := httptest.NewServer(sm)
+	t.Cleanup(srv.Close)
+	cfg.Region, cfg.SecretID, cfg.Endpoint = "us-west-2", "mysecretmanagerentry", srv.URL
+	cfg.Credentials = credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", "")
+	p, err := awssm.New(cfg)
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	return p
+}
+
+func TestCurrentReadsDocument(t *testing.T) {
+	sm := &fakeSM{stages: map[string]string{awssm.DEFAULTVERSIONSTAGE: document}}
+	creds, err := newProvider(t, sm, awssm.Config{}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Slots[0].Username != "app_odd" || creds.Slots[1].Password != "even-pw" || creds.Active != 1 {
+		t.Errorf("Current = %+v, want app_odd and app_even with even active", creds)
+	}
+	if stages := creds.Slots[0].Stages; len(stages) != 1 || stages[0] != awssm.DEFAULTVERSIONSTAGE {
+		t.Errorf("Stages = %v, want AWSCURRENT", stages)
+	}
+	requests := sm.requested()
+	if len(requests) != 1 || requests[0]["SecretId"] != "mysecretmanagerentry" {
+		t.Errorf("requests = %v, want a single GetSecretValue of mysecretmanagerentry", requests)
+	}
+}
+
+func TestPreviousFallbackAddsLastResortSlots(t *testing.T) {
+	sm := &fakeSM{stages: map[string]string{
+		awssm.DEFAULTVERSIONSTAGE:  `{"odd_username": "app_odd", "odd_password": "new-odd-pw", "even_username": "app_even", "even_password": "even-pw", "active_credential": "odd"}`,
+		awssm.PREVIOUSVERSIONSTAGE: document,
+	}}
+	creds, err := newProvider(t, sm, awssm.Config{PreviousFallback: true}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 3 {
+		t.Fatalf("slots = %+v, want the current two and the changed odd credential of AWSPREVIOUS", creds.Slots)
+	}
+	last := creds.Slots[2]
+	if last.Name != "odd-previous" || last.Password != "odd-pw" || !last.LastResort {
+		t.Errorf("previous slot = %+v, want the last resort odd-previous", last)
+	}
+}
+
+func TestRDSManagedSecret(t *testing.T) {
+	sm := &fakeSM{stages: map[string]string{
+		awssm.DEFAULTVERSIONSTAGE:  `{"username": "app", "password": "new-pw", "engine": "postgres"}`,
+		awssm.PREVIOUSVERSIONSTAGE: `{"username": "app", "password": "old-pw", "engine": "postgres"}`,
+	}}
+	creds, err := newProvider(t, sm, awssm.Config{RDSManaged: true}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Active != 0 {
+		t.Fatalf("Current = %+v, want the current and previous slots with current active", creds)
+	}
+	if current, previous := creds.Slots[0], creds.Slots[1]; current.Name != gopqr.SINGLESLOT || current.Password != "new-pw" ||
+		previous.Name != awssm.RDSPREVIOUSSLOT || previous.Password != "old-pw" || previous.LastResort {
+		t.Errorf("slots = %+v, want new-pw as %v and old-pw as the standby %v", creds.Slots, gopqr.SINGLESLOT, awssm.RDSPREVIOUSSLOT)
+	}
+}
+
+func TestMissingSecretIsNotFoundAndCached(t *testing.T) {
+	sm := &fakeSM{}
+	var reported []error
+	p := newProvider(t, sm, awssm.Config{OnNotFound: func(err error) { reported = append(reported, err) }})
+	for i := 0; i < 2; i++ {
+		if _, err := p.Fetch(context.Background()); !errors.Is(err, gopqr.ErrSecretNotFound) {
+			t.Fatalf("Fetch of a missing secret = %v, want ErrSecretNotFound", err)
+		}
+	}
+	if n := len(sm.requested()); n != 1 {
+		t.Errorf("%v requests to Secrets Manager, want the second fetch answered by the negative cache", n)
+	}
+	if len(reported) != 1 {
+		t.Errorf("OnNotFound called %v times, want once", len(reported))
+	}
+}
+
+// otherCA returns the PEM of a CA certificate unrelated to the test servers.
+func otherCA(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "corporate root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCABundleTrustsEndpoint(t *testing.T) {
+	// the CA bundle of the config is kept when the environment names one too
+	envBundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(envBundle, otherCA(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CA_BUNDLE", envBundle)
+	sm := &fakeSM{stages: map[string]string{awssm.DEFAULTVERSIONSTAGE: document}}
+	srv := httptest.NewTLSServer(sm)
+	defer srv.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	p, err := awssm.New(awssm.Config{
+		Region:      "us-west-2",
+		SecretID:    "mysecretmanagerentry",
+		Endpoint:    srv.URL,
+		CABundlePEM: ca,
+		Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""),
+	})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	if _, err := p.Fetch(context.Background()); err != nil {
+		t.Errorf("Fetch over TLS signed by the CA bundle failed - %v", err)
+	}
+	if _, err := awssm.New(awssm.Config{Region: "us-west-2", SecretID: "mysecretmanagerentry", CABundlePEM: []byte("not a certificate")}); err == nil {
+		t.Error("New with a CA bundle holding no certificate succeeded, want an error")
+	}
+}
+
+func TestRegionAndSecretIDRequired(t *testing.T) {
+	for _, cfg := range []awssm.Config{{Region: "us-west-2"}, {SecretID: "mysecretmanagerentry"}} {
+		if _, err := awssm.New(cfg); err == nil {
+			t.Errorf("New(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package awssmv2
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package awssmv2 sources the rotating credentials for github.com/chandranarreddy/gopqr
+from AWS Secrets Manager through aws-sdk-go-v2, for applications that have
+moved off the deprecated aws-sdk-go the awssm provider is built on. It reads
+the same secrets, and offers the same settings, as awssm.
+
+Its AWS configuration comes from config.LoadDefaultConfig, whose credential
+chain covers the environment, the shared config files, the web identity
+tokens of EKS IAM Roles for Service Accounts (AWS_ROLE_ARN and
+AWS_WEB_IDENTITY_TOKEN_FILE) and the instance roles of EC2, read through
+IMDSv2. Set AWSConfig to use an aws.Config loaded elsewhere.
+
+With PreviousFallback, the AWSPREVIOUS version is fetched as well, and its
+credentials are tried as a last resort once the current ones failed
+authentication. With RDSManaged, the secret of the managed single-user
+rotation of RDS has its AWSCURRENT version as the active slot "current" and
+its AWSPREVIOUS version as the standby slot "previous". With ReplicaRegions,
+the secret is read from its replicas when reading it from the primary
+region fails. With a RoleARN, the provider assumes the role through STS
+before reading the secret.
+
+Usage:
+	p, err := awssmv2.New(ctx, awssmv2.Config{
+		Region:   "us-west-2",
+		SecretID: "mysecretmanagerentry",
+	})
+	...
+	pqrDriver := &gopqr.Driver{Provider: p}
+*/
+
+const (
+	//DEFAULTVERSIONSTAGE - default version stage of the secret that is read
+	DEFAULTVERSIONSTAGE = "AWSCURRENT"
+	//PREVIOUSVERSIONSTAGE - version stage of the secret Secrets Manager
+	//moves the last current version to
+	PREVIOUSVERSIONSTAGE = "AWSPREVIOUS"
+	//RDSPREVIOUSSLOT - name of the standby slot holding the AWSPREVIOUS
+	//version of an RDSManaged secret, the active one being gopqr.SINGLESLOT
+	RDSPREVIOUSSLOT = "previous"
+)
+
+// Config holds the settings of the AWS Secrets Manager provider.
+type Config struct {
+	// Region - AWS region the secret is stored in, defaults to the one of
+	// the default configuration, like of AWS_REGION
+	Region string
+	// SecretID - Name or ARN of the secret
+	SecretID string
+	// VersionStage - Version stage to read, defaults to DEFAULTVERSIONSTAGE
+	VersionStage string
+	// AWSConfig - AWS configuration to use in place of the one loaded by
+	// config.LoadDefaultConfig
+	AWSConfig *aws.Config
+	// Credentials - AWS credentials used to call Secrets Manager. Leave nil to
+	// use the default credential chain of the AWS SDK.
+	Credentials aws.CredentialsProvider
+	// Endpoint - Overrides the Secrets Manager endpoint, like the DNS name of a
+	// PrivateLink interface endpoint
+	Endpoint string
+	// CABundle - Path to a PEM file of CA certificates trusted in addition to
+	// the system roots and the AWS_CA_BUNDLE of the environment, like the CA
+	// of a TLS inspecting proxy
+	CABundle string
+	// CABundlePEM - PEM encoded CA certificates, used in place of CABundle
+	CABundlePEM []byte
+	// Proxy - URL of the proxy requests are sent through. Leave empty to honor
+	// the usual proxy environment variables.
+	Proxy string
+	// Format - Format of the secret, detected from its fields unless set
+	Format gopqr.SecretFormat
+	// NegativeCacheTTL - How long a not found secret is remembered before the
+	// store is asked again, defaults to providers.DEFAULTNEGATIVECACHETTL. A
+	// negative value turns negative caching off.
+	NegativeCacheTTL time.Duration
+	// OnNotFound func is invoked with the gopqr.SecretNotFoundError when the
+	// store reports that the secret does not exist
+	OnNotFound func(error)
+	// PreviousFallback - When set, the credentials of the AWSPREVIOUS
+	// version are fetched along with the current ones and tried once all of
+	// those failed authentication
+	PreviousFallback bool
+	// RDSManaged - When set, the secret is the "username" and "password" of
+	// the managed rotation of RDS, whose AWSCURRENT version is the active
+	// slot and AWSPREVIOUS version the standby slot RDSPREVIOUSSLOT
+	RDSManaged bool
+	// ReplicaRegions - Regions the secret is replicated to, read in order
+	// when reading it from the Region fails for reasons other than the
+	// secret not existing. The Endpoint only applies to the Region.
+	ReplicaRegions []string
+	// OnRegionFailover func, when set, is invoked with the replica region
+	// that served the secret and the failure of the Region before it
+	OnRegionFailover func(region string, err error)
+	// RoleARN - Role assumed through STS to read the secret, like of the
+	// account keeping the secrets
+	RoleARN string
+	// ExternalID - External ID the trust policy of the RoleARN requires
+	ExternalID string
+	// RoleSessionName - Name of the role session, defaults to one the AWS SDK picks
+	RoleSessionName string
+	// SessionTags - Tags of the role session, for attribute based access control
+	SessionTags map[string]string
+	// STSEndpoint - Overrides the STS endpoint, like the DNS name of a
+	// PrivateLink interface endpoint
+	STSEndpoint string
+}
+
+// replica is the secret replicated to another region.
+type replica struct {
+	region string
+	sm     *secretsmanager.Client
+	id     string
+}
+
+// Provider fetches the rotating credentials document from AWS Secrets Manager.
+type Provider struct {
+	sm     *secretsmanager.Client
+	id     string
+	stage  string
+	format gopqr.SecretFormat
+
+	negative   providers.NegativeCache
+	onNotFound func(error)
+	// withPrevious - Whether the AWSPREVIOUS version is fetched as well
+	withPrevious bool
+	// rdsManaged - Whether the AWSPREVIOUS version is the standby slot
+	// rather than a last resort
+	rdsManaged bool
+	replicas   []replica
+	onFailover func(region string, err error)
+
+	mu       sync.Mutex
+	current  *gopqr.Secret
+	previous *gopqr.Secret
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "awssmv2" of gopqr.LoadConfig, whose
+// params are the fields of Config.
+func init() {
+	gopqr.RegisterProviderType("awssmv2", gopqr.ConfigProvider(func(cfg Config) (*Provider, error) {
+		return New(context.Background(), cfg)
+	}))
+}
+
+// New returns a Provider for the configured secret, loading the default AWS
+// configuration unless the AWSConfig is set.
+func New(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.SecretID == "" {
+		return nil, errors.New("SecretID is required for the Secrets Manager provider")
+	}
+	if cfg.VersionStage == "" {
+		cfg.VersionStage = DEFAULTVERSIONSTAGE
+	}
+	if cfg.RDSManaged {
+		if cfg.Format != gopqr.FormatAuto && cfg.Format != gopqr.FormatSinglePair {
+			return nil, fmt.Errorf("RDSManaged secrets are of the %v format, not %v", gopqr.FormatSinglePair, cfg.Format)
+		}
+		cfg.Format = gopqr.FormatSinglePair
+	}
+	awsConfig, err := loadConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if awsConfig.Region == "" {
+		return nil, errors.New("Region is required for the Secrets Manager provider, set it or AWS_REGION")
+	}
+	if cfg.RoleARN != "" {
+		stsClient := sts.NewFromConfig(awsConfig, func(o *sts.Options) {
+			if cfg.STSEndpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.STSEndpoint)
+			}
+		})
+		awsConfig.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
+			}
+			if cfg.RoleSessionName != "" {
+				o.RoleSessionName = cfg.RoleSessionName
+			}
+			o.Tags = sessionTags(cfg.SessionTags)
+		}))
+	}
+	var replicas []replica
+	for _, region := range cfg.ReplicaRegions {
+		replicas = append(replicas, replica{
+			region: region,
+			sm:     secretsmanager.NewFromConfig(awsConfig, func(o *secretsmanager.Options) { o.Region = region }),
+			id:     replicaSecretID(cfg.SecretID, region),
+		})
+	}
+	return &Provider{
+		sm: secretsmanager.NewFromConfig(awsConfig, func(o *secretsmanager.Options) {
+			if cfg.Endpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.Endpoint)
+			}
+		}),
+		id:     cfg.SecretID,
+		stage:  cfg.VersionStage,
+		format: cfg.Format,
+
+		negative:     providers.NegativeCache{TTL: cfg.NegativeCacheTTL},
+		onNotFound:   cfg.OnNotFound,
+		withPrevious: cfg.PreviousFallback || cfg.RDSManaged,
+		rdsManaged:   cfg.RDSManaged,
+		replicas:     replicas,
+		onFailover:   cfg.OnRegionFailover,
+	}, nil
+}
+
+// loadConfig returns the AWSConfig of the configuration, or else loads the
+// default configuration with its region, credentials, CA and proxy.
+func loadConfig(ctx context.Context, cfg Config) (aws.Config, error) {
+	if cfg.AWSConfig != nil {
+		awsConfig := cfg.AWSConfig.Copy()
+		if cfg.Region != "" {
+			awsConfig.Region = cfg.Region
+		}
+		if cfg.Credentials != nil {
+			awsConfig.Credentials = cfg.Credentials
+		}
+		return awsConfig, nil
+	}
+	var opts []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, config.WithRegion(cfg.Region))
+	}
+	if cfg.Credentials != nil {
+		opts = append(opts, config.WithCredentialsProvider(cfg.Credentials))
+	}
+	if cfg.Proxy != "" || cfg.CABundle != "" || cfg.CABundlePEM != nil {
+		httpClient, err := newHTTPClient(cfg)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		opts = append(opts, config.WithHTTPClient(httpClient))
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load the AWS configuration - %w", err)
+	}
+	return awsConfig, nil
+}
+
+// newHTTPClient builds the HTTP client carrying the CA and proxy settings. It
+// is built on the client of the AWS SDK, which adds the AWS_CA_BUNDLE of the
+// environment to the roots it trusts.
+func newHTTPClient(cfg Config) (*awshttp.BuildableClient, error) {
+	var proxy func(*http.Request) (*url.URL, error)
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %v - %w", cfg.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	pem := cfg.CABundlePEM
+	if pem == nil && cfg.CABundle != "" {
+		b, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle - %w", err)
+		}
+		pem = b
+	}
+	var roots *x509.CertPool
+	if pem != nil {
+		var err error
+		roots, err = x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no CA certificates found in the CA bundle")
+		}
+	}
+	return awshttp.NewBuildableClient().WithTransportOptions(func(transport *http.Transport) {
+		if proxy != nil {
+			transport.Proxy = proxy
+		}
+		if roots != nil {
+			if transport.TLSClientConfig == nil {
+				transport.TLSClientConfig = &tls.Config{}
+			}
+			transport.TLSClientConfig.RootCAs = roots
+		}
+	}), nil
+}
+
+// sessionTags returns the tags of the role session, sorted by key.
+func sessionTags(tags map[string]string) []ststypes.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var out []ststypes.Tag
+	for _, key := range keys {
+		out = append(out, ststypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return out
+}
+
+// replicaSecretID returns the ID of the secret in the replica region. A
+// replica keeps the name of its primary, while its ARN names its region.
+func replicaSecretID(id, region string) string {
+	if !strings.HasPrefix(id, "arn:") {
+		return id
+	}
+	parts := strings.SplitN(id, ":", 5)
+	if len(parts) < 5 {
+		return id
+	}
+	parts[3] = region
+	return strings.Join(parts, ":")
+}
+
+// Fetch reads and parses the rotating credentials document from Secrets
+// Manager. A secret that does not exist results in a
+// *gopqr.SecretNotFoundError, which is remembered for the NegativeCacheTTL.
+func (p *Provider) Fetch(ctx context.Context) (*gopqr.Secret, error) {
+	if err := p.negative.Check(); err != nil {
+		return nil, err
+	}
+	result, err := p.getStage(ctx, p.stage)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, p.notFound(err)
+		}
+		return nil, fmt.Errorf("failed to fetch secret %v from Secrets Manager - %w", p.id, err)
+	}
+	p.negative.Observe(nil)
+	if result.SecretString == nil {
+		return nil, fmt.Errorf("secret %v in Secrets Manager has no secret string", p.id)
+	}
+	return gopqr.ParseSecretAs([]byte(*result.SecretString), p.format)
+}
+
+// FetchPrevious reads and parses the AWSPREVIOUS version of the secret. It
+// returns nil without an error when the secret has no previous version,
+// like before its first rotation.
+func (p *Provider) FetchPrevious(ctx context.Context) (*gopqr.Secret, error) {
+	result, err := p.getStage(ctx, PREVIOUSVERSIONSTAGE)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch the previous version of secret %v from Secrets Manager - %w", p.id, err)
+	}
+	if result.SecretString == nil {
+		return nil, fmt.Errorf("previous version of secret %v in Secrets Manager has no secret string", p.id)
+	}
+	return gopqr.ParseSecretAs([]byte(*result.SecretString), p.format)
+}
+
+// getStage reads the version of the secret at the stage, from the replica
+// regions in order when the primary region fails. The failure of the
+// primary region is returned when every region failed.
+func (p *Provider) getStage(ctx context.Context, stage string) (*secretsmanager.GetSecretValueOutput, error) {
+	result, err := getSecretValue(ctx, p.sm, p.id, stage)
+	if err == nil || len(p.replicas) == 0 || isNotFound(err) {
+		return result, err
+	}
+	for _, r := range p.replicas {
+		if ctx.Err() != nil {
+			break
+		}
+		if result, rerr := getSecretValue(ctx, r.sm, r.id, stage); rerr == nil {
+			if p.onFailover != nil {
+				p.onFailover(r.region, err)
+			}
+			return result, nil
+		}
+	}
+	return nil, err
+}
+
+func getSecretValue(ctx context.Context, sm *secretsmanager.Client, id, stage string) (*secretsmanager.GetSecretValueOutput, error) {
+	return sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(id),
+		VersionStage: aws.String(stage),
+	})
+}
+
+// isNotFound reports whether Secrets Manager reported that the secret, or
+// the version of it, does not exist.
+func isNotFound(err error) bool {
+	var notFound *smtypes.ResourceNotFoundException
+	return errors.As(err, &notFound)
+}
+
+func (p *Provider) notFound(err error) error {
+	nf := &gopqr.SecretNotFoundError{Source: "AWS Secrets Manager", ID: p.id, Err: err}
+	p.negative.Observe(nf)
+	if p.onNotFound != nil {
+		p.onNotFound(nf)
+	}
+	return nf
+}
+
+// Current returns the credentials fetched by the last Refresh, fetching them
+// first if they have not been fetched yet. With PreviousFallback, the
+// credentials of the AWSPREVIOUS version that differ from the current ones
+// follow them as last resort slots, named after their slot with a
+// "-previous" suffix. With RDSManaged, the previous credential is the standby
+// slot RDSPREVIOUSSLOT instead.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	s, previous := p.current, p.previous
+	p.mu.Unlock()
+	if s == nil {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		s, previous = p.current, p.previous
+		p.mu.Unlock()
+	}
+	creds := s.Credentials()
+	for i := range creds.Slots {
+		creds.Slots[i].Stages = []string{p.stage}
+	}
+	if previous == nil {
+		return creds, nil
+	}
+	current := creds.Slots
+	for _, c := range previous.Credentials().Slots {
+		if known(current, c) {
+			continue
+		}
+		c.Stages = []string{PREVIOUSVERSIONSTAGE}
+		if p.rdsManaged {
+			c.Name = RDSPREVIOUSSLOT
+			creds.Slots = append(creds.Slots, c)
+			continue
+		}
+		c.Name += "-previous"
+		c.LastResort = true
+		creds.Slots = append(creds.Slots, c)
+	}
+	return creds, nil
+}
+
+// known reports whether the credentials hold the username and password.
+func known(creds []gopqr.Credential, c gopqr.Credential) bool {
+	for _, k := range creds {
+		if k.Username == c.Username && k.Password == c.Password {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh fetches the secret from Secrets Manager, along with its previous
+// version with PreviousFallback or RDSManaged. Failing to fetch the previous
+// version only leaves out its credentials.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	var previous *gopqr.Secret
+	if p.withPrevious {
+		previous, _ = p.FetchPrevious(ctx)
+	}
+	p.mu.Lock()
+	p.current, p.previous = s, previous
+	p.mu.Unlock()
+	return nil
+}
+
+// NewDriver fetches the secret and returns a sticky gopqr driver sourcing
+// its credentials from the provider. The notices of the driver, like failed
+// refreshes, are written to the logger.
+func (p *Provider) NewDriver(ctx context.Context, logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
@@ -0,0 +1,240 @@
+package awssmv2_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/awssmv2"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+const document = `{"odd_username": "app_odd", "odd_password": "odd-pw", "even_username": "app_even", "even_password": "even-pw", "active_credential": "even"}`
+
+// request is a GetSecretValue received by the fakeSM.
+type request struct {
+	SecretID      string `json:"SecretId"`
+	VersionStage  string
+	Authorization string `json:"-"`
+}
+
+// fakeSM serves GetSecretValue of Secrets Manager from the secret strings
+// of its stages, or denies every request when it is down.
+type fakeSM struct {
+	mu       sync.Mutex
+	stages   map[string]string
+	down     bool
+	requests []request
+}
+
+func (sm *fakeSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	json.NewDecoder(r.Body).Decode(&req)
+	req.Authorization = r.Header.Get("Authorization")
+	sm.mu.Lock()
+	sm.requests = append(sm.requests, req)
+	secret, ok := sm.stages[req.VersionStage]
+	down := sm.down
+	sm.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch {
+	case down:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "AccessDeniedException", "message": "Access to KMS is not allowed"})
+	case !ok:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "ResourceNotFoundException", "message": "Secrets Manager can't find the specified secret."})
+	default:
+		json.NewEncoder(w).Encode(map[string]string{"Name": req.SecretID, "SecretString": secret, "VersionId": "v-" + req.VersionStage})
+	}
+}
+
+func (sm *fakeSM) requested() []request {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return append([]request(nil), sm.requests...)
+}
+
+func serve(t *testing.T, sm *fakeSM) string {
+	t.Helper()
+	srv := httptest.NewServer(sm)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func newProvider(t *testing.T, endpoint string, cfg awssmv2.Config) *awssmv2.Provider {
+	t.Helper()
+	if cfg.SecretID == "" {
+		cfg.SecretID = "mysecretmanagerentry"
+	}
+	cfg.Region, cfg.Endpoint = "us-west-2", endpoint
+	cfg.Credentials = credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "secret", "")
+	p, err := awssmv2.New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	return p
+}
+
+func TestCurrentReadsDocument(t *testing.T) {
+	sm := &fakeSM{stages: map[string]string{awssmv2.DEFAULTVERSIONSTAGE: document}}
+	creds, err := newProvider(t, serve(t, sm), awssmv2.Config{}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Slots[0].Username != "app_odd" || creds.Slots[1].Password != "even-pw" || creds.Active != 1 {
+		t.Errorf("Current = %+v, want app_odd and app_even with even active", creds)
+	}
+	requests := sm.requested()
+	if len(requests) != 1 || requests[0].SecretID != "mysecretmanagerentry" || requests[0].VersionStage != awssmv2.DEFAULTVERSIONSTAGE {
+		t.Errorf("requests = %+v, want a single GetSecretValue of the AWSCURRENT version", requests)
+	}
+}
+
+func TestPreviousFallbackAddsLastResortSlots(t *testing.T) {
+	sm := &fakeSM{stages: map[string]string{
+		awssmv2.DEFAULTVERSIONSTAGE:  `{"odd_username": "app_odd", "odd_password": "new-odd-pw", "even_username": "app_even", "even_password": "even-pw", "active_credential": "odd"}`,
+		awssmv2.PREVIOUSVERSIONSTAGE: document,
+	}}
+	creds, err := newProvider(t, serve(t, sm), awssmv2.Config{PreviousFallback: true}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 3 {
+		t.Fatalf("slots = %+v, want the current two and the changed odd credential of AWSPREVIOUS", creds.Slots)
+	}
+	if last := creds.Slots[2]; last.Name != "odd-previous" || last.Password != "odd-pw" || !last.LastResort {
+		t.Errorf("previous slot = %+v, want the last resort odd-previous", last)
+	}
+}
+
+func TestRDSManagedSecret(t *testing.T) {
+	sm := &fakeSM{stages: map[string]string{
+		awssmv2.DEFAULTVERSIONSTAGE:  `{"username": "app", "password": "new-pw", "engine": "postgres"}`,
+		awssmv2.PREVIOUSVERSIONSTAGE: `{"username": "app", "password": "old-pw", "engine": "postgres"}`,
+	}}
+	creds, err := newProvider(t, serve(t, sm), awssmv2.Config{RDSManaged: true}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Active != 0 {
+		t.Fatalf("Current = %+v, want the current and previous slots with current active", creds)
+	}
+	if current, previous := creds.Slots[0], creds.Slots[1]; current.Name != gopqr.SINGLESLOT || current.Password != "new-pw" ||
+		previous.Name != awssmv2.RDSPREVIOUSSLOT || previous.Password != "old-pw" || previous.LastResort {
+		t.Errorf("slots = %+v, want new-pw as %v and old-pw as the standby %v", creds.Slots, gopqr.SINGLESLOT, awssmv2.RDSPREVIOUSSLOT)
+	}
+}
+
+func TestMissingSecretIsNotFoundAndCached(t *testing.T) {
+	sm := &fakeSM{}
+	var reported []error
+	p := newProvider(t, serve(t, sm), awssmv2.Config{OnNotFound: func(err error) { reported = append(reported, err) }})
+	for i := 0; i < 2; i++ {
+		if _, err := p.Fetch(context.Background()); !errors.Is(err, gopqr.ErrSecretNotFound) {
+			t.Fatalf("Fetch of a missing secret = %v, want ErrSecretNotFound", err)
+		}
+	}
+	if n := len(sm.requested()); n != 1 {
+		t.Errorf("%v requests to Secrets Manager, want the second fetch answered by the negative cache", n)
+	}
+	if len(reported) != 1 {
+		t.Errorf("OnNotFound called %v times, want once", len(reported))
+	}
+}
+
+func TestReplicaRegionServesWhenRegionFails(t *testing.T) {
+	primary := &fakeSM{down: true}
+	replica := &fakeSM{stages: map[string]string{awssmv2.DEFAULTVERSIONSTAGE: document}}
+	// the replicas are reached through the endpoint of the environment, the
+	// Endpoint only applies to the Region
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", serve(t, replica))
+	var failedOver []string
+	p := newProvider(t, serve(t, primary), awssmv2.Config{
+		SecretID:         "arn:aws:secretsmanager:us-west-2:123456789012:secret:mydb-AbCdEf",
+		ReplicaRegions:   []string{"eu-west-1"},
+		OnRegionFailover: func(region string, err error) { failedOver = append(failedOver, region) },
+	})
+	if _, err := p.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch failed, want the replica to serve - %v", err)
+	}
+	if len(failedOver) != 1 || failedOver[0] != "eu-west-1" {
+		t.Errorf("OnRegionFailover called with %v, want eu-west-1", failedOver)
+	}
+	requests := replica.requested()
+	if len(requests) != 1 {
+		t.Fatalf("%v requests to the replica, want 1", len(requests))
+	}
+	if got := requests[0].SecretID; got != "arn:aws:secretsmanager:eu-west-1:123456789012:secret:mydb-AbCdEf" {
+		t.Errorf("replica read %v, want the ARN of the replica region", got)
+	}
+	if !strings.Contains(requests[0].Authorization, "/eu-west-1/secretsmanager/") {
+		t.Errorf("replica request signed with %q, want it signed for eu-west-1", requests[0].Authorization)
+	}
+}
+
+// otherCA returns the PEM of a CA certificate unrelated to the test servers.
+func otherCA(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "corporate root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCABundleTrustsEndpoint(t *testing.T) {
+	// the CA bundle of the config is kept when the environment names one too
+	envBundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(envBundle, otherCA(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CA_BUNDLE", envBundle)
+	sm := &fakeSM{stages: map[string]string{awssmv2.DEFAULTVERSIONSTAGE: document}}
+	srv := httptest.NewTLSServer(sm)
+	defer srv.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	p := newProvider(t, srv.URL, awssmv2.Config{CABundlePEM: ca})
+	if _, err := p.Fetch(context.Background()); err != nil {
+		t.Errorf("Fetch over TLS signed by the CA bundle failed - %v", err)
+	}
+	if _, err := awssmv2.New(context.Background(), awssmv2.Config{Region: "us-west-2", SecretID: "mysecretmanagerentry", CABundlePEM: []byte("not a certificate")}); err == nil {
+		t.Error("New with a CA bundle holding no certificate succeeded, want an error")
+	}
+}
+
+func TestSecretIDRequired(t *testing.T) {
+	if _, err := awssmv2.New(context.Background(), awssmv2.Config{Region: "us-west-2"}); err == nil {
+		t.Error("New without a SecretID succeeded, want an error")
+	}
+}
@@ -0,0 +1,279 @@
+package azurekv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package azurekv sources the rotating credentials for github.com/chandranarreddy/gopqr
+from an Azure Key Vault secret using the managed identity of the host. The
+secret value is expected to be the gopqr rotating credentials document -
+	{
+		"odd_username": "myOddUserName",
+		"odd_password": "myOddPassword",
+		"even_username": "myEvenUserName",
+		"even_password": "myEvenPassword",
+		"active_credential": "even"
+	}
+
+Usage:
+	p, err := azurekv.New(azurekv.Config{
+		VaultURL:   "https://myvault.vault.azure.net/",
+		SecretName: "mypostgrescreds",
+	})
+	...
+	pqrDriver, err := p.NewDriver(ctx, logger)
+	...
+	sql.Register("postgresrotating", pqrDriver)
+
+The Provider also implements gopqr.CredentialProvider, so it can be set as
+the Provider of a driver instead -
+	pqrDriver := &gopqr.Driver{Provider: p}
+*/
+
+const (
+	//DEFAULTMAXRETRIES - default number of retries of a throttled or failed Key Vault call
+	DEFAULTMAXRETRIES = 5
+	//DEFAULTRETRYDELAY - default initial delay between retries when Key Vault does not send Retry-After
+	DEFAULTRETRYDELAY = time.Second
+	//DEFAULTMAXRETRYDELAY - default cap on the delay between retries
+	DEFAULTMAXRETRYDELAY = 30 * time.Second
+	//DEFAULTTIMEOUT - default deadline for a refresh triggered by the driver
+	DEFAULTTIMEOUT = 2 * time.Minute
+)
+
+// retryStatusCodes are the Key Vault responses that are retried. 429 is what
+// Key Vault returns when the vault or subscription is being throttled.
+var retryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Config holds the settings of the Azure Key Vault provider.
+type Config struct {
+	// VaultURL - URL of the vault, like "https://myvault.vault.azure.net/"
+	VaultURL string
+	// SecretName - Name of the secret holding the rotating credentials document
+	SecretName string
+	// SecretVersion - Version of the secret to read, empty reads the latest
+	SecretVersion string
+	// ClientID - Client ID of a user-assigned managed identity. Leave empty
+	// to use the system-assigned identity of the host.
+	ClientID string
+	// MaxRetries - Number of retries of throttled or failed calls, defaults to DEFAULTMAXRETRIES
+	MaxRetries int32
+	// RetryDelay - Initial backoff between retries, defaults to DEFAULTRETRYDELAY
+	RetryDelay time.Duration
+	// MaxRetryDelay - Cap on the backoff between retries, defaults to DEFAULTMAXRETRYDELAY
+	MaxRetryDelay time.Duration
+	// Format - Format of the secret, detected from its fields unless set
+	Format gopqr.SecretFormat
+	// Timeout - Deadline for each refresh invoked by the driver, defaults to DEFAULTTIMEOUT
+	Timeout time.Duration
+	// NegativeCacheTTL - How long a not found secret is remembered before the
+	// store is asked again, defaults to providers.DEFAULTNEGATIVECACHETTL. A
+	// negative value turns negative caching off.
+	NegativeCacheTTL time.Duration
+	// OnNotFound func is invoked with the gopqr.SecretNotFoundError when the
+	// store reports that the secret does not exist, so that misconfiguration
+	// can be flagged right away.
+	OnNotFound func(error)
+}
+
+// Provider fetches the rotating credentials document from Azure Key Vault.
+type Provider struct {
+	client  *azsecrets.Client
+	name    string
+	version string
+	timeout time.Duration
+	format  gopqr.SecretFormat
+
+	negative   providers.NegativeCache
+	onNotFound func(error)
+
+	mu      sync.Mutex
+	current *gopqr.Secret
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "azurekv" of gopqr.LoadConfig, whose params
+// are the fields of Config.
+func init() {
+	gopqr.RegisterProviderType("azurekv", gopqr.ConfigProvider(New))
+}
+
+// New returns a Provider authenticated with the managed identity of the host.
+func New(cfg Config) (*Provider, error) {
+	if cfg.VaultURL == "" || cfg.SecretName == "" {
+		return nil, errors.New("VaultURL and SecretName are required for the Azure Key Vault provider")
+	}
+	var miOpts azidentity.ManagedIdentityCredentialOptions
+	if cfg.ClientID != "" {
+		miOpts.ID = azidentity.ClientID(cfg.ClientID)
+	}
+	cred, err := azidentity.NewManagedIdentityCredential(&miOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create managed identity credential - %w", err)
+	}
+	return NewWithCredential(cfg, cred)
+}
+
+// NewWithCredential returns a Provider that authenticates to Key Vault with
+// the supplied token credential instead of the managed identity of the host.
+func NewWithCredential(cfg Config, cred azcore.TokenCredential) (*Provider, error) {
+	return newProvider(cfg, cred, nil)
+}
+
+// newProvider returns a Provider sending its requests to Key Vault through
+// the transport, the default HTTP client of the Azure SDK when nil.
+func newProvider(cfg Config, cred azcore.TokenCredential, transport policy.Transporter) (*Provider, error) {
+	if cfg.VaultURL == "" || cfg.SecretName == "" {
+		return nil, errors.New("VaultURL and SecretName are required for the Azure Key Vault provider")
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DEFAULTMAXRETRIES
+	}
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = DEFAULTRETRYDELAY
+	}
+	if cfg.MaxRetryDelay == 0 {
+		cfg.MaxRetryDelay = DEFAULTMAXRETRYDELAY
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DEFAULTTIMEOUT
+	}
+	client, err := azsecrets.NewClient(cfg.VaultURL, cred, &azsecrets.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{
+				MaxRetries:    cfg.MaxRetries,
+				RetryDelay:    cfg.RetryDelay,
+				MaxRetryDelay: cfg.MaxRetryDelay,
+				StatusCodes:   retryStatusCodes,
+			},
+			Transport: transport,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Key Vault client - %w", err)
+	}
+	return &Provider{
+		client:  client,
+		name:    cfg.SecretName,
+		version: cfg.SecretVersion,
+		timeout: cfg.Timeout,
+		format:  cfg.Format,
+
+		negative:   providers.NegativeCache{TTL: cfg.NegativeCacheTTL},
+		onNotFound: cfg.OnNotFound,
+	}, nil
+}
+
+// Fetch reads and parses the rotating credentials document from Key Vault.
+// Throttled calls are retried with backoff honoring the Retry-After header
+// sent by Key Vault. A secret that does not exist results in a
+// *gopqr.SecretNotFoundError, which is remembered for the NegativeCacheTTL.
+func (p *Provider) Fetch(ctx context.Context) (*gopqr.Secret, error) {
+	if err := p.negative.Check(); err != nil {
+		return nil, err
+	}
+	resp, err := p.client.GetSecret(ctx, p.name, p.version, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, p.notFound(err)
+		}
+		return nil, fmt.Errorf("failed to fetch secret %v from Key Vault - %w", p.name, err)
+	}
+	p.negative.Observe(nil)
+	if resp.Value == nil {
+		return nil, fmt.Errorf("secret %v in Key Vault has no value", p.name)
+	}
+	return gopqr.ParseSecretAs([]byte(*resp.Value), p.format)
+}
+
+func (p *Provider) notFound(err error) error {
+	nf := &gopqr.SecretNotFoundError{Source: "Azure Key Vault", ID: p.name, Err: err}
+	p.negative.Observe(nf)
+	if p.onNotFound != nil {
+		p.onNotFound(nf)
+	}
+	return nf
+}
+
+// Current returns the credentials fetched by the last Refresh, fetching them
+// first if they have not been fetched yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	s := p.current
+	p.mu.Unlock()
+	if s == nil {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		s = p.current
+		p.mu.Unlock()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh fetches the secret from Key Vault.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.current = s
+	p.mu.Unlock()
+	return nil
+}
+
+// NewDriver fetches the secret and returns a sticky gopqr driver sourcing
+// its credentials from the provider. The notices of the driver, like failed
+// refreshes, are written to the logger.
+func (p *Provider) NewDriver(ctx context.Context, logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Refresher returns a CredentialRefresher func that refetches the secret
+// from Key Vault and resets the credentials on the driver. Failures are
+// reported to the logger, if one is supplied, and leave the driver as is.
+func (p *Provider) Refresher(logger *log.Logger) func(*gopqr.Driver) {
+	return func(pqrDriver *gopqr.Driver) {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+		s, err := p.Fetch(ctx)
+		if err != nil {
+			if logger != nil {
+				logger.Print(fmt.Errorf("refreshing DB secret from Key Vault failed - %w", err))
+			}
+			return
+		}
+		s.Apply(pqrDriver)
+		return
+	}
+}
@@ -0,0 +1,139 @@
+package azurekv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const document = `{"odd_username": "app_odd", "odd_password": "odd-pw", "even_username": "app_even", "even_password": "even-pw", "active_credential": "even"}`
+
+// staticToken is a token credential that always has a valid token.
+type staticToken struct{}
+
+func (staticToken) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeVault answers the requests of the Key Vault client. Unauthenticated
+// requests get the challenge of Key Vault, the others the next of the
+// statuses, or else the secret.
+type fakeVault struct {
+	mu       sync.Mutex
+	value    string
+	statuses []int
+	paths    []string
+}
+
+func (v *fakeVault) Do(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{Request: req, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}
+	if req.Header.Get("Authorization") == "" {
+		resp.StatusCode = http.StatusUnauthorized
+		resp.Header.Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+		return resp, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.paths = append(v.paths, req.URL.Path)
+	resp.StatusCode = http.StatusOK
+	if len(v.statuses) > 0 {
+		resp.StatusCode, v.statuses = v.statuses[0], v.statuses[1:]
+	} else if v.value == "" {
+		resp.StatusCode = http.StatusNotFound
+	}
+	resp.Header.Set("Content-Type", "application/json")
+	var body interface{} = map[string]string{"value": v.value, "id": "https://myvault.vault.azure.net/secrets/mypostgrescreds/v1"}
+	if resp.StatusCode != http.StatusOK {
+		body = map[string]interface{}{"error": map[string]string{"code": http.StatusText(resp.StatusCode), "message": "fake vault"}}
+	}
+	b, _ := json.Marshal(body)
+	resp.Body = io.NopCloser(strings.NewReader(string(b)))
+	return resp, nil
+}
+
+func (v *fakeVault) requested() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.paths...)
+}
+
+func newFakeProvider(t *testing.T, vault *fakeVault, cfg Config) *Provider {
+	t.Helper()
+	cfg.VaultURL, cfg.SecretName = "https://myvault.vault.azure.net/", "mypostgrescreds"
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay, cfg.MaxRetryDelay = time.Millisecond, time.Millisecond
+	}
+	p, err := newProvider(cfg, staticToken{}, vault)
+	if err != nil {
+		t.Fatalf("newProvider failed - %v", err)
+	}
+	return p
+}
+
+func TestCurrentReadsSecret(t *testing.T) {
+	vault := &fakeVault{value: document}
+	creds, err := newFakeProvider(t, vault, Config{SecretVersion: "v1"}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Slots[0].Username != "app_odd" || creds.Active != 1 {
+		t.Errorf("Current = %+v, want app_odd and app_even with even active", creds)
+	}
+	if paths := vault.requested(); len(paths) != 1 || paths[0] != "/secrets/mypostgrescreds/v1" {
+		t.Errorf("requested %v, want version v1 of mypostgrescreds", paths)
+	}
+}
+
+func TestFetchRetriesThrottledCalls(t *testing.T) {
+	vault := &fakeVault{value: document, statuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}}
+	if _, err := newFakeProvider(t, vault, Config{}).Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch failed, want the throttled calls retried - %v", err)
+	}
+	if n := len(vault.requested()); n != 3 {
+		t.Errorf("%v requests, want the two throttled ones retried", n)
+	}
+
+	vault = &fakeVault{value: document, statuses: []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}}
+	if _, err := newFakeProvider(t, vault, Config{MaxRetries: 1}).Fetch(context.Background()); err == nil {
+		t.Error("Fetch succeeded, want it to give up after MaxRetries")
+	}
+	if n := len(vault.requested()); n != 2 {
+		t.Errorf("%v requests, want 2 with MaxRetries 1", n)
+	}
+}
+
+func TestMissingSecretIsNotFoundAndCached(t *testing.T) {
+	vault := &fakeVault{}
+	var reported []error
+	p := newFakeProvider(t, vault, Config{OnNotFound: func(err error) { reported = append(reported, err) }})
+	for i := 0; i < 2; i++ {
+		if _, err := p.Fetch(context.Background()); !errors.Is(err, gopqr.ErrSecretNotFound) {
+			t.Fatalf("Fetch of a missing secret = %v, want ErrSecretNotFound", err)
+		}
+	}
+	if n := len(vault.requested()); n != 1 {
+		t.Errorf("%v requests to Key Vault, want the second fetch answered by the negative cache", n)
+	}
+	if len(reported) != 1 {
+		t.Errorf("OnNotFound called %v times, want once", len(reported))
+	}
+}
+
+func TestVaultURLAndSecretNameRequired(t *testing.T) {
+	for _, cfg := range []Config{{VaultURL: "https://myvault.vault.azure.net/"}, {SecretName: "mypostgrescreds"}} {
+		if _, err := NewWithCredential(cfg, staticToken{}); err == nil {
+			t.Errorf("NewWithCredential(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
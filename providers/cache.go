@@ -0,0 +1,71 @@
+package providers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+// DEFAULTCACHETTL - default duration a refresh is reused for by CacheProvider
+const DEFAULTCACHETTL = 30 * time.Second
+
+// CacheProvider wraps a provider so that refreshes within TTL of the last
+// successful one reuse what it fetched instead of calling the backing store
+// again. A bad rotation makes every pod refresh on every failed
+// authentication, and without the cache each of those is a billed call to
+// the store. Failed refreshes are not cached, see NegativeCache for those.
+type CacheProvider struct {
+	provider gopqr.CredentialProvider
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	refreshed time.Time
+	hits      uint64
+}
+
+// Cache returns the provider wrapped in a CacheProvider reusing refreshes
+// for the TTL, DEFAULTCACHETTL when it is zero.
+func Cache(p gopqr.CredentialProvider, ttl time.Duration) *CacheProvider {
+	if ttl == 0 {
+		ttl = DEFAULTCACHETTL
+	}
+	return &CacheProvider{provider: p, ttl: ttl}
+}
+
+// Current returns the credentials of the wrapped provider.
+func (c *CacheProvider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	return c.provider.Current(ctx)
+}
+
+// Refresh refreshes the wrapped provider, unless it was refreshed within the
+// TTL. Concurrent refreshes share one call to the wrapped provider.
+func (c *CacheProvider) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.refreshed.IsZero() && time.Since(c.refreshed) < c.ttl {
+		c.hits++
+		return nil
+	}
+	if err := c.provider.Refresh(ctx); err != nil {
+		return err
+	}
+	c.refreshed = time.Now()
+	return nil
+}
+
+// Invalidate makes the next Refresh call the wrapped provider, like after a
+// rotation is known to have happened.
+func (c *CacheProvider) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshed = time.Time{}
+}
+
+// Hits returns the number of refreshes served from the cache.
+func (c *CacheProvider) Hits() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits
+}
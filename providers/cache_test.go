@@ -0,0 +1,61 @@
+package providers_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr/providers"
+)
+
+func TestCacheReusesRefreshWithinTTL(t *testing.T) {
+	store := &fakeProvider{username: "app_odd"}
+	cache := providers.Cache(store, 50*time.Millisecond)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cache.Refresh(ctx); err != nil {
+				t.Errorf("Refresh failed - %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := store.refreshes.Load(); n != 1 {
+		t.Errorf("store refreshed %v times, want the refreshes within the TTL to share one", n)
+	}
+	if hits := cache.Hits(); hits != 7 {
+		t.Errorf("Hits = %v, want 7", hits)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	cache.Refresh(ctx)
+	if n := store.refreshes.Load(); n != 2 {
+		t.Errorf("store refreshed %v times after the TTL, want 2", n)
+	}
+	cache.Invalidate()
+	cache.Refresh(ctx)
+	if n := store.refreshes.Load(); n != 3 {
+		t.Errorf("store refreshed %v times after Invalidate, want 3", n)
+	}
+}
+
+func TestCacheDoesNotCacheFailures(t *testing.T) {
+	store := &fakeProvider{username: "app_odd", err: errors.New("throttled")}
+	cache := providers.Cache(store, time.Minute)
+	ctx := context.Background()
+	if err := cache.Refresh(ctx); err == nil {
+		t.Fatal("Refresh succeeded, want the error of the store")
+	}
+	store.err = nil
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	if n := store.refreshes.Load(); n != 2 {
+		t.Errorf("store refreshed %v times, want the failed refresh retried", n)
+	}
+}
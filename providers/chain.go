@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+// ChainProvider is a CredentialProvider drawing on several sources in order,
+// like Secrets Manager, then a local cache file, then environment variables.
+// Every Refresh starts over at the first source and stops at the first one
+// that refreshes, which then serves Current. So while the primary store is
+// down, the driver keeps refreshing from a secondary one, and it returns to
+// the primary as soon as that is back.
+type ChainProvider struct {
+	providers []gopqr.CredentialProvider
+	// OnFallback - When set, invoked whenever the source serving Current
+	// changes, with the indexes of the sources and the error of the one
+	// that was passed over, nil when returning to a preferred source
+	OnFallback func(from, to int, err error)
+
+	mu     sync.Mutex
+	active int
+}
+
+// Chain returns a ChainProvider falling back from each of the providers to
+// the next, the first being the primary.
+func Chain(providers ...gopqr.CredentialProvider) *ChainProvider {
+	return &ChainProvider{providers: providers}
+}
+
+// Current returns the credentials of the source that last refreshed. When
+// that source fails, the ones after it are consulted in order.
+func (c *ChainProvider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	if len(c.providers) == 0 {
+		return gopqr.Credentials{}, errors.New("the chain has no providers")
+	}
+	start := c.Active()
+	var errs []error
+	for i := start; i < len(c.providers); i++ {
+		creds, err := c.providers[i].Current(ctx)
+		if err == nil {
+			c.setActive(i, errors.Join(errs...))
+			return creds, nil
+		}
+		errs = append(errs, fmt.Errorf("provider %d - %w", i, err))
+	}
+	return gopqr.Credentials{}, fmt.Errorf("no provider of the chain has credentials - %w", errors.Join(errs...))
+}
+
+// Refresh refreshes the sources in order until one succeeds, and makes that
+// one serve Current. It fails only when every source failed, with all of
+// their errors joined.
+func (c *ChainProvider) Refresh(ctx context.Context) error {
+	if len(c.providers) == 0 {
+		return errors.New("the chain has no providers")
+	}
+	var errs []error
+	for i, p := range c.providers {
+		err := p.Refresh(ctx)
+		if err == nil {
+			c.setActive(i, errors.Join(errs...))
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("provider %d - %w", i, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fmt.Errorf("every provider of the chain failed to refresh - %w", errors.Join(errs...))
+}
+
+// Active returns the index of the source serving Current.
+func (c *ChainProvider) Active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+func (c *ChainProvider) setActive(i int, err error) {
+	c.mu.Lock()
+	from := c.active
+	c.active = i
+	c.mu.Unlock()
+	if from != i && c.OnFallback != nil {
+		c.OnFallback(from, i, err)
+	}
+}
@@ -0,0 +1,85 @@
+package providers_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers"
+)
+
+// fakeProvider serves credentials of the username, and fails while err is
+// set.
+type fakeProvider struct {
+	username  string
+	err       error
+	refreshes atomic.Int32
+}
+
+func (p *fakeProvider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	if p.err != nil {
+		return gopqr.Credentials{}, p.err
+	}
+	return gopqr.Credentials{Slots: []gopqr.Credential{{Name: "odd", Username: p.username, Password: "pw"}}}, nil
+}
+
+func (p *fakeProvider) Refresh(ctx context.Context) error {
+	p.refreshes.Add(1)
+	return p.err
+}
+
+func TestChainFallsBackAndReturns(t *testing.T) {
+	outage := errors.New("secrets manager is down")
+	primary, secondary := &fakeProvider{username: "primary"}, &fakeProvider{username: "secondary"}
+	chain := providers.Chain(primary, secondary)
+	type fallback struct {
+		from, to int
+		err      error
+	}
+	var fallbacks []fallback
+	chain.OnFallback = func(from, to int, err error) { fallbacks = append(fallbacks, fallback{from, to, err}) }
+	ctx := context.Background()
+
+	primary.err = outage
+	if err := chain.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed, want the secondary to refresh - %v", err)
+	}
+	creds, err := chain.Current(ctx)
+	if err != nil || creds.Slots[0].Username != "secondary" || chain.Active() != 1 {
+		t.Fatalf("Current = %+v, %v with the primary down, want the secondary serving", creds, err)
+	}
+	if len(fallbacks) != 1 || fallbacks[0].from != 0 || fallbacks[0].to != 1 || !errors.Is(fallbacks[0].err, outage) {
+		t.Errorf("fallbacks = %+v, want one from 0 to 1 with the outage", fallbacks)
+	}
+
+	primary.err = nil
+	if err := chain.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	if creds, _ := chain.Current(ctx); creds.Slots[0].Username != "primary" || chain.Active() != 0 {
+		t.Errorf("Current = %+v once the primary is back, want the primary serving", creds)
+	}
+	if len(fallbacks) != 2 || fallbacks[1].from != 1 || fallbacks[1].to != 0 || fallbacks[1].err != nil {
+		t.Errorf("fallbacks = %+v, want the return from 1 to 0 without an error", fallbacks)
+	}
+	if n := secondary.refreshes.Load(); n != 1 {
+		t.Errorf("secondary refreshed %v times, want it passed over once the primary refreshes", n)
+	}
+}
+
+func TestChainAllFail(t *testing.T) {
+	first, second := errors.New("first down"), errors.New("second down")
+	chain := providers.Chain(&fakeProvider{err: first}, &fakeProvider{err: second})
+	err := chain.Refresh(context.Background())
+	if !errors.Is(err, first) || !errors.Is(err, second) {
+		t.Errorf("Refresh = %v, want the errors of every provider", err)
+	}
+	if _, err := chain.Current(context.Background()); !errors.Is(err, first) || !errors.Is(err, second) {
+		t.Errorf("Current = %v, want the errors of every provider", err)
+	}
+	if err := providers.Chain().Refresh(context.Background()); err == nil {
+		t.Error("Refresh of an empty chain succeeded, want an error")
+	}
+}
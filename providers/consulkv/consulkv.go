@@ -0,0 +1,257 @@
+package consulkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package consulkv sources the rotating credentials for github.com/chandranarreddy/gopqr
+from a key of the Consul KV store holding the rotating credentials document.
+The key is watched with blocking queries, so a rotation written to Consul
+reaches the driver within moments instead of on the first failed
+authentication.
+
+Usage:
+	p, err := consulkv.New(consulkv.Config{Key: "postgres/myapp/credentials"})
+	...
+	pqrDriver, err := p.NewDriver(ctx, logger)
+	...
+	if err := p.Watch(pqrDriver, logger); err != nil {
+		...
+	}
+	defer p.Close()
+	sql.Register("postgresrotating", pqrDriver)
+
+The Provider also implements gopqr.CredentialProvider. When it is set as the
+Provider of a driver, call Watch with a nil driver to keep it current -
+	pqrDriver := &gopqr.Driver{Provider: p}
+	err := p.Watch(nil, logger)
+*/
+
+const (
+	//DEFAULTWAITTIME - default longest time a blocking query waits for a change
+	DEFAULTWAITTIME = 5 * time.Minute
+	//DEFAULTRETRYINTERVAL - default wait before watching again after a failure
+	DEFAULTRETRYINTERVAL = 5 * time.Second
+)
+
+// Config holds the settings of the Consul KV provider.
+type Config struct {
+	// Client - Consul client to use. Leave nil to build one from the usual
+	// CONSUL_HTTP_ADDR, CONSUL_HTTP_TOKEN and related environment variables.
+	Client *consul.Client
+	// Key - Key of the rotating credentials document
+	Key string
+	// Format - Format of the document, detected unless set
+	Format gopqr.SecretFormat
+	// WaitTime - Longest time a blocking query waits, defaults to DEFAULTWAITTIME
+	WaitTime time.Duration
+	// RetryInterval - Wait before watching again after a failure, defaults
+	// to DEFAULTRETRYINTERVAL
+	RetryInterval time.Duration
+}
+
+// Provider reads and watches the rotating credentials in a Consul key.
+type Provider struct {
+	kv            *consul.KV
+	key           string
+	format        gopqr.SecretFormat
+	waitTime      time.Duration
+	retryInterval time.Duration
+
+	mu     sync.Mutex
+	loaded bool
+	last   gopqr.Secret
+	index  uint64
+	cancel context.CancelFunc
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "consulkv" of gopqr.LoadConfig, whose params
+// are the fields of Config.
+func init() {
+	gopqr.RegisterProviderType("consulkv", gopqr.ConfigProvider(New))
+}
+
+// New returns a Provider for the configured key.
+func New(cfg Config) (*Provider, error) {
+	if cfg.Key == "" {
+		return nil, errors.New("Key is required for the Consul KV provider")
+	}
+	if cfg.WaitTime <= 0 {
+		cfg.WaitTime = DEFAULTWAITTIME
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DEFAULTRETRYINTERVAL
+	}
+	client := cfg.Client
+	if client == nil {
+		var err error
+		client, err = consul.NewClient(consul.DefaultConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Consul client - %w", err)
+		}
+	}
+	return &Provider{
+		kv:            client.KV(),
+		key:           cfg.Key,
+		format:        cfg.Format,
+		waitTime:      cfg.WaitTime,
+		retryInterval: cfg.RetryInterval,
+	}, nil
+}
+
+// Fetch reads the rotating credentials from the key. With a non zero index,
+// it is a blocking query waiting up to the WaitTime for the key to change
+// past that index. It returns the index the key was read at.
+func (p *Provider) Fetch(ctx context.Context, index uint64) (*gopqr.Secret, uint64, error) {
+	opts := (&consul.QueryOptions{WaitIndex: index, WaitTime: p.waitTime}).WithContext(ctx)
+	pair, meta, err := p.kv.Get(p.key, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch key %v from Consul - %w", p.key, err)
+	}
+	if pair == nil {
+		return nil, meta.LastIndex, &gopqr.SecretNotFoundError{Source: "Consul", ID: p.key}
+	}
+	s, err := gopqr.ParseSecretAs(pair.Value, p.format)
+	if err != nil {
+		return nil, meta.LastIndex, err
+	}
+	return s, meta.LastIndex, nil
+}
+
+// Current returns the credentials last read from the key, reading them
+// first if they have not been read yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	loaded, s := p.loaded, p.last
+	p.mu.Unlock()
+	if !loaded {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		s = p.last
+		p.mu.Unlock()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh rereads the credentials from the key.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, index, err := p.Fetch(ctx, 0)
+	if err != nil {
+		return err
+	}
+	p.apply(nil, s, index, true)
+	return nil
+}
+
+// NewDriver reads the credentials and returns a sticky gopqr driver sourcing
+// its credentials from the provider. The notices of the driver are written
+// to the logger.
+func (p *Provider) NewDriver(ctx context.Context, logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Watch starts watching the key with blocking queries and applies the
+// credentials to the driver whenever they change. Failures are written to
+// the logger and the watch is resumed after the RetryInterval. The driver
+// may be nil when the Provider is set as the Provider of the driver. Call
+// Close to stop watching.
+func (p *Provider) Watch(pqrDriver *gopqr.Driver, logger *log.Logger) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel != nil {
+		return errors.New("Consul key is already being watched")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	if pqrDriver != nil {
+		pqrDriver.Go("consulkv_watch", func(ran func()) { p.loop(ctx, pqrDriver, logger, ran) })
+	} else {
+		go p.loop(ctx, pqrDriver, logger, func() {})
+	}
+	return nil
+}
+
+// Close stops watching the key.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+	return nil
+}
+
+func (p *Provider) loop(ctx context.Context, pqrDriver *gopqr.Driver, logger *log.Logger, ran func()) {
+	for ctx.Err() == nil {
+		p.mu.Lock()
+		index := p.index
+		p.mu.Unlock()
+		s, next, err := p.Fetch(ctx, index)
+		ran()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logf(logger, "watching DB secret in Consul failed - %v", err)
+			// a key deleted and written again starts over at a lower index
+			if next < index {
+				p.mu.Lock()
+				p.index = 0
+				p.mu.Unlock()
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(p.retryInterval):
+			}
+			continue
+		}
+		if next < index {
+			next = 0
+		}
+		p.apply(pqrDriver, s, next, false)
+	}
+}
+
+// apply records the secret as the current one along with the index it was
+// read at, and assigns it to the driver, if there is one. Unless forced, a
+// secret that is identical to the last one applied is skipped.
+func (p *Provider) apply(pqrDriver *gopqr.Driver, s *gopqr.Secret, index uint64, force bool) {
+	p.mu.Lock()
+	p.index = index
+	if !force && p.loaded && reflect.DeepEqual(p.last, *s) {
+		p.mu.Unlock()
+		return
+	}
+	p.loaded = true
+	p.last = *s
+	p.mu.Unlock()
+	if pqrDriver != nil {
+		s.Apply(pqrDriver)
+	}
+}
+
+func logf(logger *log.Logger, format string, v ...interface{}) {
+	if logger != nil {
+		logger.Print(fmt.Errorf(format, v...))
+	}
+}
@@ -0,0 +1,138 @@
+package consulkv_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/consulkv"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+const document = `{"odd_username": "app_odd", "odd_password": "odd-pw", "even_username": "app_even", "even_password": "even-pw", "active_credential": "even"}`
+
+// fakeKV serves a single key over the KV endpoint of the Consul HTTP API,
+// holding blocking queries until the key changes past their index.
+type fakeKV struct {
+	mu      sync.Mutex
+	value   []byte
+	index   uint64
+	changed chan struct{}
+}
+
+func newFakeKV(t *testing.T, value string) (*fakeKV, *consul.Client) {
+	kv := &fakeKV{index: 1, changed: make(chan struct{})}
+	if value != "" {
+		kv.value = []byte(value)
+	}
+	srv := httptest.NewServer(kv)
+	t.Cleanup(srv.Close)
+	client, err := consul.NewClient(&consul.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kv, client
+}
+
+func (kv *fakeKV) put(value string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.value = []byte(value)
+	kv.index++
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	kv.mu.Lock()
+	if wait > 0 && wait >= kv.index {
+		changed := kv.changed
+		kv.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		kv.mu.Lock()
+	}
+	value, index := kv.value, kv.index
+	kv.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	if value == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode([]consul.KVPair{{Key: "postgres/myapp/credentials", Value: value, ModifyIndex: index}})
+}
+
+func TestCurrentReadsKey(t *testing.T) {
+	_, client := newFakeKV(t, document)
+	p, err := consulkv.New(consulkv.Config{Client: client, Key: "postgres/myapp/credentials"})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	creds, err := p.Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Slots[0].Username != "app_odd" || creds.Active != 1 {
+		t.Errorf("Current = %+v, want app_odd and app_even with even active", creds)
+	}
+}
+
+func TestMissingKeyIsNotFound(t *testing.T) {
+	_, client := newFakeKV(t, "")
+	p, err := consulkv.New(consulkv.Config{Client: client, Key: "postgres/myapp/credentials"})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	if _, err := p.Current(context.Background()); !errors.Is(err, gopqr.ErrSecretNotFound) {
+		t.Errorf("Current of a missing key = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestNewRequiresKey(t *testing.T) {
+	if _, err := consulkv.New(consulkv.Config{}); err == nil {
+		t.Error("New without a Key succeeded, want an error")
+	}
+}
+
+func TestWatchAppliesChange(t *testing.T) {
+	kv, client := newFakeKV(t, document)
+	p, err := consulkv.New(consulkv.Config{Client: client, Key: "postgres/myapp/credentials", RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	ctx := context.Background()
+	if err := p.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	if err := p.Watch(nil, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("Watch failed - %v", err)
+	}
+	defer p.Close()
+	kv.put(`{"odd_username": "app_odd", "odd_password": "rotated", "even_username": "app_even", "even_password": "even-pw", "active_credential": "odd"}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		creds, err := p.Current(ctx)
+		if err == nil && creds.Slots[0].Password == "rotated" && creds.Active == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Current = %+v, %v after the key changed, want the rotated credentials", creds, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
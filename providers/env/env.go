@@ -0,0 +1,254 @@
+package env
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package env sources the rotating credentials for github.com/chandranarreddy/gopqr
+from environment variables, for 12-factor applications whose operators roll
+out rotated credentials through the environment -
+	GOPQR_ODD_USERNAME
+	GOPQR_ODD_PASSWORD
+	GOPQR_EVEN_USERNAME
+	GOPQR_EVEN_PASSWORD
+	GOPQR_ACTIVE_CREDENTIAL
+
+and optionally GOPQR_HOST, GOPQR_PORT, GOPQR_SSLMODE, GOPQR_SSLROOTCERT,
+GOPQR_SSLCERT and GOPQR_SSLKEY. GOPQR_SECRET may hold the whole rotating
+credentials document instead, like to rotate through slots. The variables are
+read again when the process receives SIGHUP or Reload is called, like after
+a supervisor changed the environment of the process.
+
+Usage:
+	p := env.New()
+	pqrDriver, err := p.NewDriver(logger)
+	...
+	if err := p.WatchSignals(pqrDriver, logger); err != nil {
+		...
+	}
+	defer p.Close()
+	sql.Register("postgresrotating", pqrDriver)
+
+The Provider also implements gopqr.CredentialProvider. When it is set as the
+Provider of a driver, call WatchSignals with a nil driver to keep it current -
+	pqrDriver := &gopqr.Driver{Provider: p}
+	err := p.WatchSignals(nil, logger)
+*/
+
+// DEFAULTPREFIX - Prefix of the environment variables read by New
+const DEFAULTPREFIX = "GOPQR_"
+
+var keys = [...]string{"ODD_USERNAME", "ODD_PASSWORD", "EVEN_USERNAME", "EVEN_PASSWORD", "ACTIVE_CREDENTIAL"}
+
+// Provider reads the rotating credentials from environment variables.
+type Provider struct {
+	prefix string
+
+	mu     sync.Mutex
+	loaded bool
+	last   gopqr.Secret
+	driver *gopqr.Driver
+	stop   chan struct{}
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "env" of gopqr.LoadConfig, whose params
+// are the Prefix of the variables, DEFAULTPREFIX unless set.
+func init() {
+	gopqr.RegisterProviderType("env", gopqr.ConfigProvider(func(cfg struct{ Prefix string }) (*Provider, error) {
+		if cfg.Prefix == "" {
+			return New(), nil
+		}
+		return NewWithPrefix(cfg.Prefix), nil
+	}))
+}
+
+// New returns a Provider reading the variables prefixed with DEFAULTPREFIX.
+func New() *Provider {
+	return NewWithPrefix(DEFAULTPREFIX)
+}
+
+// NewWithPrefix returns a Provider reading the variables prefixed with
+// prefix in place of DEFAULTPREFIX, like "ORDERS_DB_" for a second database.
+func NewWithPrefix(prefix string) *Provider {
+	return &Provider{prefix: prefix}
+}
+
+// Read reads the rotating credentials from the environment.
+func (p *Provider) Read() (*gopqr.Secret, error) {
+	if doc, ok := os.LookupEnv(p.prefix + "SECRET"); ok {
+		return gopqr.ParseSecret([]byte(doc))
+	}
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		v, ok := os.LookupEnv(p.prefix + key)
+		if !ok {
+			return nil, &gopqr.SecretNotFoundError{Source: "environment", ID: p.prefix + key}
+		}
+		values[key] = v
+	}
+	opt := func(key string) string {
+		v, _ := os.LookupEnv(p.prefix + key)
+		return v
+	}
+	return &gopqr.Secret{
+		OddUsername:      values["ODD_USERNAME"],
+		OddPassword:      values["ODD_PASSWORD"],
+		EvenUsername:     values["EVEN_USERNAME"],
+		EvenPassword:     values["EVEN_PASSWORD"],
+		ActiveCredential: values["ACTIVE_CREDENTIAL"],
+		Host:             opt("HOST"),
+		Port:             json.Number(opt("PORT")),
+		SSLMode:          opt("SSLMODE"),
+		SSLRootCert:      opt("SSLROOTCERT"),
+		SSLCert:          opt("SSLCERT"),
+		SSLKey:           opt("SSLKEY"),
+	}, nil
+}
+
+// Refresher returns a gopqr.CredentialRefresher func that rereads the
+// environment and resets the credentials on the driver.
+func (p *Provider) Refresher(logger *log.Logger) func(*gopqr.Driver) {
+	return func(pqrDriver *gopqr.Driver) {
+		s, err := p.Read()
+		if err != nil {
+			logf(logger, "refreshing DB secret from the environment failed - %v", err)
+			return
+		}
+		p.apply(pqrDriver, s, true)
+	}
+}
+
+// Current returns the credentials last read from the environment, reading
+// them first if they have not been read yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	loaded, s := p.loaded, p.last
+	p.mu.Unlock()
+	if !loaded {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		s = p.last
+		p.mu.Unlock()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh rereads the credentials from the environment.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Read()
+	if err != nil {
+		return err
+	}
+	p.apply(nil, s, true)
+	return nil
+}
+
+// NewDriver reads the credentials and returns a sticky gopqr driver sourcing
+// its credentials from the provider. The notices of the driver are written
+// to the logger.
+func (p *Provider) NewDriver(logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Reload rereads the environment and applies the credentials to the driver
+// passed to WatchSignals, if any, when they changed.
+func (p *Provider) Reload() error {
+	s, err := p.Read()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	pqrDriver := p.driver
+	p.mu.Unlock()
+	p.apply(pqrDriver, s, false)
+	return nil
+}
+
+// WatchSignals calls Reload whenever the process receives SIGHUP, writing
+// failures to the logger. The driver may be nil when the Provider is set as
+// the Provider of the driver. Call Close to stop watching.
+func (p *Provider) WatchSignals(pqrDriver *gopqr.Driver, logger *log.Logger) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		return errors.New("signals are already being watched")
+	}
+	hup, stopNotify, err := notifyReload()
+	if err != nil {
+		return err
+	}
+	p.driver, p.stop = pqrDriver, make(chan struct{})
+	loop := func(stop <-chan struct{}, ran func()) {
+		defer stopNotify()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-hup:
+				ran()
+				if err := p.Reload(); err != nil {
+					logf(logger, "reloading DB secret from the environment failed - %v", err)
+				}
+			}
+		}
+	}
+	stop := p.stop
+	if pqrDriver != nil {
+		pqrDriver.Go("env_signals", func(ran func()) { loop(stop, ran) })
+	} else {
+		go loop(stop, func() {})
+	}
+	return nil
+}
+
+// Close stops watching signals.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	return nil
+}
+
+// apply records the secret as the current one and assigns it to the driver,
+// if there is one. Unless forced, a secret that is identical to the last one
+// applied is skipped.
+func (p *Provider) apply(pqrDriver *gopqr.Driver, s *gopqr.Secret, force bool) {
+	p.mu.Lock()
+	if !force && p.loaded && reflect.DeepEqual(p.last, *s) {
+		p.mu.Unlock()
+		return
+	}
+	p.loaded = true
+	p.last = *s
+	p.mu.Unlock()
+	if pqrDriver != nil {
+		s.Apply(pqrDriver)
+	}
+}
+
+func logf(logger *log.Logger, format string, v ...interface{}) {
+	if logger != nil {
+		logger.Print(fmt.Errorf(format, v...))
+	}
+}
@@ -0,0 +1,110 @@
+package env_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/env"
+)
+
+func setenv(t *testing.T, prefix string, values map[string]string) {
+	t.Helper()
+	for key, v := range values {
+		t.Setenv(prefix+key, v)
+	}
+}
+
+var variables = map[string]string{
+	"ODD_USERNAME":      "app_odd",
+	"ODD_PASSWORD":      "odd-pw",
+	"EVEN_USERNAME":     "app_even",
+	"EVEN_PASSWORD":     "even-pw",
+	"ACTIVE_CREDENTIAL": "even",
+	"HOST":              "db.internal",
+}
+
+func TestCurrentReadsVariables(t *testing.T) {
+	setenv(t, env.DEFAULTPREFIX, variables)
+	creds, err := env.New().Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Slots[0].Username != "app_odd" || creds.Slots[1].Password != "even-pw" || creds.Active != 1 {
+		t.Errorf("Current = %+v, want app_odd and app_even with even active", creds)
+	}
+	s, err := env.New().Read()
+	if err != nil {
+		t.Fatalf("Read failed - %v", err)
+	}
+	if s.Host != "db.internal" {
+		t.Errorf("Host = %q, want db.internal", s.Host)
+	}
+}
+
+func TestPrefixSelectsVariables(t *testing.T) {
+	setenv(t, env.DEFAULTPREFIX, variables)
+	setenv(t, "ORDERS_DB_", map[string]string{
+		"ODD_USERNAME":      "orders_odd",
+		"ODD_PASSWORD":      "orders-odd-pw",
+		"EVEN_USERNAME":     "orders_even",
+		"EVEN_PASSWORD":     "orders-even-pw",
+		"ACTIVE_CREDENTIAL": "odd",
+	})
+	creds, err := env.NewWithPrefix("ORDERS_DB_").Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if creds.Slots[0].Username != "orders_odd" || creds.Active != 0 {
+		t.Errorf("Current = %+v, want the credentials of the ORDERS_DB_ variables", creds)
+	}
+}
+
+func TestSecretDocumentTakesPrecedence(t *testing.T) {
+	setenv(t, env.DEFAULTPREFIX, variables)
+	t.Setenv("GOPQR_SECRET", `{"odd_username": "doc_odd", "odd_password": "doc-odd-pw", "even_username": "doc_even", "even_password": "doc-even-pw", "active_credential": "odd"}`)
+	creds, err := env.New().Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if creds.Slots[0].Username != "doc_odd" || creds.Active != 0 {
+		t.Errorf("Current = %+v, want the credentials of GOPQR_SECRET", creds)
+	}
+}
+
+func TestMissingVariableIsNotFound(t *testing.T) {
+	setenv(t, "MISSING_", map[string]string{"ODD_USERNAME": "app_odd", "ODD_PASSWORD": "odd-pw"})
+	_, err := env.NewWithPrefix("MISSING_").Current(context.Background())
+	if !errors.Is(err, gopqr.ErrSecretNotFound) {
+		t.Fatalf("Current = %v, want ErrSecretNotFound", err)
+	}
+	var notFound *gopqr.SecretNotFoundError
+	if !errors.As(err, &notFound) || notFound.ID != "MISSING_EVEN_USERNAME" {
+		t.Errorf("Current = %v, want the missing variable named", err)
+	}
+}
+
+func TestRefreshRereadsVariables(t *testing.T) {
+	setenv(t, env.DEFAULTPREFIX, variables)
+	p := env.New()
+	ctx := context.Background()
+	if _, err := p.Current(ctx); err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	t.Setenv("GOPQR_ODD_PASSWORD", "rotated")
+	t.Setenv("GOPQR_ACTIVE_CREDENTIAL", "odd")
+	if creds, _ := p.Current(ctx); creds.Slots[0].Password != "odd-pw" {
+		t.Errorf("Current before Refresh = %+v, want the credentials read first", creds)
+	}
+	if err := p.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	creds, err := p.Current(ctx)
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if creds.Slots[0].Password != "rotated" || creds.Active != 0 {
+		t.Errorf("Current after Refresh = %+v, want the rotated odd password active", creds)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package env
+
+import (
+	"errors"
+	"os"
+)
+
+func notifyReload() (<-chan os.Signal, func(), error) {
+	return nil, nil, errors.New("SIGHUP is not supported on this platform, call Reload instead")
+}
@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package env
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload returns the channel receiving SIGHUP and the func that stops
+// the delivery.
+func notifyReload() (<-chan os.Signal, func(), error) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	return ch, func() { signal.Stop(ch) }, nil
+}
@@ -0,0 +1,245 @@
+package etcdkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package etcdkv sources the rotating credentials for github.com/chandranarreddy/gopqr
+from a key of etcd holding the rotating credentials document. The key is
+followed through the watch stream of etcd, so a rotation written to etcd
+reaches the driver within moments instead of on the first failed
+authentication.
+
+Usage:
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{"etcd:2379"}})
+	...
+	p, err := etcdkv.New(etcdkv.Config{Client: client, Key: "/postgres/myapp/credentials"})
+	...
+	pqrDriver, err := p.NewDriver(ctx, logger)
+	...
+	if err := p.Watch(pqrDriver, logger); err != nil {
+		...
+	}
+	defer p.Close()
+	sql.Register("postgresrotating", pqrDriver)
+
+The Provider also implements gopqr.CredentialProvider. When it is set as the
+Provider of a driver, call Watch with a nil driver to keep it current -
+	pqrDriver := &gopqr.Driver{Provider: p}
+	err := p.Watch(nil, logger)
+*/
+
+// DEFAULTRETRYINTERVAL - default wait before watching again after a failure
+const DEFAULTRETRYINTERVAL = 5 * time.Second
+
+// Config holds the settings of the etcd provider.
+type Config struct {
+	// Client - etcd client to use
+	Client *clientv3.Client
+	// Key - Key of the rotating credentials document
+	Key string
+	// Format - Format of the document, detected unless set
+	Format gopqr.SecretFormat
+	// RetryInterval - Wait before watching again after a failure, defaults
+	// to DEFAULTRETRYINTERVAL
+	RetryInterval time.Duration
+}
+
+// Provider reads and watches the rotating credentials in an etcd key.
+type Provider struct {
+	client        *clientv3.Client
+	key           string
+	format        gopqr.SecretFormat
+	retryInterval time.Duration
+
+	mu     sync.Mutex
+	loaded bool
+	last   gopqr.Secret
+	cancel context.CancelFunc
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// New returns a Provider for the configured key.
+func New(cfg Config) (*Provider, error) {
+	if cfg.Client == nil {
+		return nil, errors.New("Client is required for the etcd provider")
+	}
+	if cfg.Key == "" {
+		return nil, errors.New("Key is required for the etcd provider")
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DEFAULTRETRYINTERVAL
+	}
+	return &Provider{
+		client:        cfg.Client,
+		key:           cfg.Key,
+		format:        cfg.Format,
+		retryInterval: cfg.RetryInterval,
+	}, nil
+}
+
+// Fetch reads the rotating credentials from the key, and returns the
+// revision of the store it was read at.
+func (p *Provider) Fetch(ctx context.Context) (*gopqr.Secret, int64, error) {
+	resp, err := p.client.Get(ctx, p.key)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch key %v from etcd - %w", p.key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, resp.Header.Revision, &gopqr.SecretNotFoundError{Source: "etcd", ID: p.key}
+	}
+	s, err := gopqr.ParseSecretAs(resp.Kvs[0].Value, p.format)
+	if err != nil {
+		return nil, resp.Header.Revision, err
+	}
+	return s, resp.Header.Revision, nil
+}
+
+// Current returns the credentials last read from the key, reading them
+// first if they have not been read yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	loaded, s := p.loaded, p.last
+	p.mu.Unlock()
+	if !loaded {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		s = p.last
+		p.mu.Unlock()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh rereads the credentials from the key.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, _, err := p.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	p.apply(nil, s, true)
+	return nil
+}
+
+// NewDriver reads the credentials and returns a sticky gopqr driver sourcing
+// its credentials from the provider. The notices of the driver are written
+// to the logger.
+func (p *Provider) NewDriver(ctx context.Context, logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Watch starts following the key through the watch stream of etcd and
+// applies the credentials to the driver whenever they change. Failures are
+// written to the logger and the key is read and watched again after the
+// RetryInterval. The driver may be nil when the Provider is set as the
+// Provider of the driver. Call Close to stop watching.
+func (p *Provider) Watch(pqrDriver *gopqr.Driver, logger *log.Logger) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel != nil {
+		return errors.New("etcd key is already being watched")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	if pqrDriver != nil {
+		pqrDriver.Go("etcdkv_watch", func(ran func()) { p.loop(ctx, pqrDriver, logger, ran) })
+	} else {
+		go p.loop(ctx, pqrDriver, logger, func() {})
+	}
+	return nil
+}
+
+// Close stops watching the key.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+	return nil
+}
+
+func (p *Provider) loop(ctx context.Context, pqrDriver *gopqr.Driver, logger *log.Logger, ran func()) {
+	for ctx.Err() == nil {
+		if err := p.follow(ctx, pqrDriver, logger, ran); err != nil && ctx.Err() == nil {
+			logf(logger, "watching DB secret in etcd failed - %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.retryInterval):
+		}
+	}
+}
+
+// follow reads the key, so that nothing written while the watch was down is
+// missed, and then applies the changes from the watch stream until it ends.
+func (p *Provider) follow(ctx context.Context, pqrDriver *gopqr.Driver, logger *log.Logger, ran func()) error {
+	s, revision, err := p.Fetch(ctx)
+	ran()
+	if err != nil {
+		return err
+	}
+	p.apply(pqrDriver, s, false)
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	for resp := range p.client.Watch(wctx, p.key, clientv3.WithRev(revision+1)) {
+		ran()
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range resp.Events {
+			if ev.Type == clientv3.EventTypeDelete {
+				logf(logger, "DB secret %v was deleted from etcd, keeping the last credentials", p.key)
+				continue
+			}
+			s, err := gopqr.ParseSecretAs(ev.Kv.Value, p.format)
+			if err != nil {
+				logf(logger, "parsing DB secret from etcd failed - %v", err)
+				continue
+			}
+			p.apply(pqrDriver, s, false)
+		}
+	}
+	return ctx.Err()
+}
+
+// apply records the secret as the current one and assigns it to the driver,
+// if there is one. Unless forced, a secret that is identical to the last one
+// applied is skipped.
+func (p *Provider) apply(pqrDriver *gopqr.Driver, s *gopqr.Secret, force bool) {
+	p.mu.Lock()
+	if !force && p.loaded && reflect.DeepEqual(p.last, *s) {
+		p.mu.Unlock()
+		return
+	}
+	p.loaded = true
+	p.last = *s
+	p.mu.Unlock()
+	if pqrDriver != nil {
+		s.Apply(pqrDriver)
+	}
+}
+
+func logf(logger *log.Logger, format string, v ...interface{}) {
+	if logger != nil {
+		logger.Print(fmt.Errorf(format, v...))
+	}
+}
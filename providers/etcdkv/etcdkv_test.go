@@ -0,0 +1,157 @@
+package etcdkv_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/etcdkv"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	key      = "/postgres/myapp/credentials"
+	document = `{"odd_username": "app_odd", "odd_password": "odd-pw", "even_username": "app_even", "even_password": "even-pw", "active_credential": "even"}`
+)
+
+// fakeStore stands in for the KV and Watcher of an etcd client, serving a
+// single key and streaming the values put to it to the watchers.
+type fakeStore struct {
+	clientv3.KV
+	clientv3.Watcher
+
+	mu       sync.Mutex
+	value    []byte
+	revision int64
+	watchers []chan clientv3.WatchResponse
+}
+
+func newFakeStore(value string) (*fakeStore, *clientv3.Client) {
+	store := &fakeStore{revision: 1}
+	if value != "" {
+		store.value = []byte(value)
+	}
+	return store, &clientv3.Client{KV: store, Watcher: store}
+}
+
+func (s *fakeStore) Get(ctx context.Context, k string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &clientv3.GetResponse{Header: &pb.ResponseHeader{Revision: s.revision}}
+	if k == key && s.value != nil {
+		resp.Kvs = []*mvccpb.KeyValue{{Key: []byte(k), Value: s.value, ModRevision: s.revision}}
+	}
+	return resp, nil
+}
+
+func (s *fakeStore) Watch(ctx context.Context, k string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	ch := make(chan clientv3.WatchResponse, 8)
+	s.mu.Lock()
+	s.watchers = append(s.watchers, ch)
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, w := range s.watchers {
+			if w == ch {
+				s.watchers = append(s.watchers[:i], s.watchers[i+1:]...)
+				close(ch)
+				break
+			}
+		}
+	}()
+	return ch
+}
+
+func (s *fakeStore) put(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.value = []byte(value)
+	s.revision++
+	ev := &clientv3.Event{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: s.value, ModRevision: s.revision}}
+	for _, w := range s.watchers {
+		w <- clientv3.WatchResponse{Header: &pb.ResponseHeader{Revision: s.revision}, Events: []*clientv3.Event{ev}}
+	}
+}
+
+func (s *fakeStore) watching() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.watchers) > 0
+}
+
+func TestCurrentReadsKey(t *testing.T) {
+	_, client := newFakeStore(document)
+	p, err := etcdkv.New(etcdkv.Config{Client: client, Key: key})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	creds, err := p.Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Slots[0].Username != "app_odd" || creds.Active != 1 {
+		t.Errorf("Current = %+v, want app_odd and app_even with even active", creds)
+	}
+}
+
+func TestMissingKeyIsNotFound(t *testing.T) {
+	_, client := newFakeStore("")
+	p, err := etcdkv.New(etcdkv.Config{Client: client, Key: key})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	if _, err := p.Current(context.Background()); !errors.Is(err, gopqr.ErrSecretNotFound) {
+		t.Errorf("Current of a missing key = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestNewRequiresClientAndKey(t *testing.T) {
+	_, client := newFakeStore(document)
+	if _, err := etcdkv.New(etcdkv.Config{Key: key}); err == nil {
+		t.Error("New without a Client succeeded, want an error")
+	}
+	if _, err := etcdkv.New(etcdkv.Config{Client: client}); err == nil {
+		t.Error("New without a Key succeeded, want an error")
+	}
+}
+
+func TestWatchAppliesChange(t *testing.T) {
+	store, client := newFakeStore(document)
+	p, err := etcdkv.New(etcdkv.Config{Client: client, Key: key, RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	if err := p.Watch(nil, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("Watch failed - %v", err)
+	}
+	defer p.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !store.watching() {
+		if time.Now().After(deadline) {
+			t.Fatal("Watch never opened a watch stream")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	store.put(`{"odd_username": "app_odd", "odd_password": "rotated", "even_username": "app_even", "even_password": "even-pw", "active_credential": "odd"}`)
+	ctx := context.Background()
+	for {
+		creds, err := p.Current(ctx)
+		if err == nil && creds.Slots[0].Password == "rotated" && creds.Active == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Current = %+v, %v after the key changed, want the rotated credentials", creds, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,182 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/internal/filewatch"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package file sources the rotating credentials for github.com/chandranarreddy/gopqr
+from a local file holding the rotating credentials document, as JSON or, for
+files ending in ".yaml" or ".yml", as YAML. This suits on-prem deployments
+where Chef, Ansible or an agent distributes the secrets as files. The file is
+reloaded when it changes, including when it is replaced by a rename, and on
+demand through Refresh -
+	{
+		"odd_username": "myOddUserName",
+		"odd_password": "myOddPassword",
+		"even_username": "myEvenUserName",
+		"even_password": "myEvenPassword",
+		"active_credential": "even"
+	}
+
+Usage:
+	p := file.New("/etc/myapp/db-credentials.json")
+	pqrDriver, err := p.NewDriver(logger)
+	...
+	if err := p.Watch(pqrDriver, logger); err != nil {
+		...
+	}
+	defer p.Close()
+	sql.Register("postgresrotating", pqrDriver)
+
+The Provider also implements gopqr.CredentialProvider. When it is set as the
+Provider of a driver, call Watch with a nil driver to keep it current -
+	pqrDriver := &gopqr.Driver{Provider: p}
+	err := p.Watch(nil, logger)
+*/
+
+// DEFAULTDEBOUNCE - default quiet period after the last change to the file
+// before it is reloaded, so that a file written in several steps is not read
+// half way
+const DEFAULTDEBOUNCE = filewatch.DEFAULTDEBOUNCE
+
+// Provider reads the rotating credentials from a file.
+type Provider struct {
+	// Debounce - Quiet period before reloading after a change, defaults to DEFAULTDEBOUNCE
+	Debounce time.Duration
+	// Format - Format of the document in the file, detected unless set
+	Format gopqr.SecretFormat
+
+	path  string
+	watch filewatch.Watcher
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "file" of gopqr.LoadConfig, whose params
+// are the Path of the file.
+func init() {
+	gopqr.RegisterProviderType("file", gopqr.ConfigProvider(func(cfg struct{ Path string }) (*Provider, error) {
+		if cfg.Path == "" {
+			return nil, errors.New("Path is required for the file provider")
+		}
+		return New(cfg.Path), nil
+	}))
+}
+
+// New returns a Provider reading the file at path.
+func New(path string) *Provider {
+	return &Provider{path: path}
+}
+
+// Read reads the rotating credentials from the file.
+func (p *Provider) Read() (*gopqr.Secret, error) {
+	raw, err := os.ReadFile(p.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &gopqr.SecretNotFoundError{Source: "file", ID: p.path, Err: err}
+		}
+		return nil, fmt.Errorf("failed to read credentials file %v - %w", p.path, err)
+	}
+	if ext := strings.ToLower(filepath.Ext(p.path)); ext == ".yaml" || ext == ".yml" {
+		if raw, err = yamlToJSON(raw); err != nil {
+			return nil, fmt.Errorf("failed to parse credentials file %v - %w", p.path, err)
+		}
+	}
+	return gopqr.ParseSecretAs(raw, p.Format)
+}
+
+// yamlToJSON converts a YAML document to JSON, for ParseSecret.
+func yamlToJSON(raw []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// Refresher returns a gopqr.CredentialRefresher func that rereads the file
+// and resets the credentials on the driver.
+func (p *Provider) Refresher(logger *log.Logger) func(*gopqr.Driver) {
+	return func(pqrDriver *gopqr.Driver) {
+		s, err := p.Read()
+		if err != nil {
+			filewatch.Logf(logger, "refreshing DB secret from credentials file failed - %v", err)
+			return
+		}
+		p.watch.Apply(pqrDriver, s, true)
+	}
+}
+
+// Current returns the credentials last read from the file, reading them
+// first if they have not been read yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	s, loaded := p.watch.Last()
+	if !loaded {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		s, _ = p.watch.Last()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh rereads the credentials from the file.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Read()
+	if err != nil {
+		return err
+	}
+	p.watch.Apply(nil, s, true)
+	return nil
+}
+
+// NewDriver reads the credentials and returns a sticky gopqr driver sourcing
+// its credentials from the provider, which rereads the file when a
+// credential fails authentication. The notices of the driver are written to
+// the logger.
+func (p *Provider) NewDriver(logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Watch starts watching the file and reloads the credentials into the
+// driver whenever it changes. The directory of the file is watched rather
+// than the file, as configuration management tools replace files by
+// renaming a new one over them. The credentials are only applied when they
+// differ from what was last applied, so that touching the file does not
+// reset the active credential. The driver may be nil when the Provider is
+// set as the Provider of the driver. Call Close to stop watching.
+func (p *Provider) Watch(pqrDriver *gopqr.Driver, logger *log.Logger) error {
+	return p.watch.Watch(filewatch.Config{
+		Dir:         filepath.Dir(p.path),
+		File:        filepath.Base(p.path),
+		Description: "credentials file",
+		Read:        p.Read,
+		Driver:      pqrDriver,
+		Logger:      logger,
+		Worker:      "file_watch",
+		Debounce:    p.Debounce,
+	})
+}
+
+// Close stops watching the file.
+func (p *Provider) Close() error {
+	return p.watch.Close()
+}
@@ -0,0 +1,110 @@
+package file_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/file"
+)
+
+const document = `{
+	"odd_username": "app_odd",
+	"odd_password": "odd-pw",
+	"even_username": "app_even",
+	"even_password": "even-pw",
+	"active_credential": "even"
+}`
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCurrentReadsJSONAndYAML(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"creds.json": document,
+		"creds.yaml": "odd_username: app_odd\nodd_password: odd-pw\neven_username: app_even\neven_password: even-pw\nactive_credential: even\n",
+	} {
+		path := filepath.Join(dir, name)
+		write(t, path, content)
+		creds, err := file.New(path).Current(context.Background())
+		if err != nil {
+			t.Fatalf("Current of %v failed - %v", name, err)
+		}
+		if len(creds.Slots) != 2 || creds.Slots[0].Username != "app_odd" || creds.Slots[1].Password != "even-pw" || creds.Active != 1 {
+			t.Errorf("Current of %v = %+v, want app_odd and app_even with even active", name, creds)
+		}
+	}
+}
+
+func TestRefreshRereadsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creds.json")
+	write(t, path, document)
+	p := file.New(path)
+	ctx := context.Background()
+	if _, err := p.Current(ctx); err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	write(t, path, `{"odd_username": "app_odd", "odd_password": "rotated", "even_username": "app_even", "even_password": "even-pw", "active_credential": "odd"}`)
+	if err := p.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	creds, err := p.Current(ctx)
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if creds.Slots[0].Password != "rotated" || creds.Active != 0 {
+		t.Errorf("Current after Refresh = %+v, want the rotated odd password active", creds)
+	}
+}
+
+func TestMissingFileIsNotFound(t *testing.T) {
+	_, err := file.New(filepath.Join(t.TempDir(), "missing.json")).Current(context.Background())
+	if !errors.Is(err, gopqr.ErrSecretNotFound) {
+		t.Errorf("Current of a missing file = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestWatchReloadsReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "creds.json")
+	write(t, path, document)
+	p := file.New(path)
+	p.Debounce = 10 * time.Millisecond
+	if err := p.Watch(nil, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("Watch failed - %v", err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+	if _, err := p.Current(ctx); err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+
+	// configuration management renames a new file over the old one
+	tmp := filepath.Join(dir, "creds.json.tmp")
+	write(t, tmp, `{"odd_username": "app_odd", "odd_password": "renamed", "even_username": "app_even", "even_password": "even-pw", "active_credential": "odd"}`)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		creds, err := p.Current(ctx)
+		if err == nil && creds.Slots[0].Password == "renamed" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Current = %+v, %v after the file was replaced, want it reloaded", creds, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
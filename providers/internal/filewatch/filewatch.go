@@ -0,0 +1,174 @@
+package filewatch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package filewatch reloads the secret of the providers sourcing it from files,
+file, k8ssecret and pgpass, whenever the files change. It is kept internal so
+that the fsnotify dependency it brings is only pulled in by those providers,
+not by everything importing package providers.
+
+Usage:
+	type Provider struct {
+		watch filewatch.Watcher
+	}
+
+	err := p.watch.Watch(filewatch.Config{Dir: dir, File: name, Read: p.read})
+*/
+
+// DEFAULTDEBOUNCE - default quiet period after the last change to watched
+// files before the secret is reloaded, so that files written in several
+// steps are not read half way
+const DEFAULTDEBOUNCE = 250 * time.Millisecond
+
+// Watcher holds the secret last read by a provider sourcing it from files,
+// and reloads it whenever they change. The zero value is ready to use.
+type Watcher struct {
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	loaded  bool
+	last    gopqr.Secret
+}
+
+// Config holds what a Watcher watches and how it reloads the secret.
+type Config struct {
+	// Dir - The directory watched. Watching the directory rather than the
+	// files catches files replaced by renaming a new one over them, as
+	// configuration management tools and the kubelet do.
+	Dir string
+	// File - When set, only changes to this file of Dir reload the secret,
+	// otherwise every change in Dir does
+	File string
+	// Description - What is watched, for the errors and logs, like
+	// "credentials file"
+	Description string
+	// Read - Reads the secret from the files
+	Read func() (*gopqr.Secret, error)
+	// Driver - The driver the secret is applied to, nil when the provider
+	// is the Provider of the driver
+	Driver *gopqr.Driver
+	// Logger - Where failures to watch or reload are written, if anywhere
+	Logger *log.Logger
+	// Worker - Name of the watch among the workers of the driver
+	Worker string
+	// Debounce - Quiet period before reloading after a change, defaults to
+	// DEFAULTDEBOUNCE
+	Debounce time.Duration
+}
+
+// Last returns the secret last applied, and whether one was.
+func (f *Watcher) Last() (gopqr.Secret, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.last, f.loaded
+}
+
+// Apply records the secret as the current one and assigns it to the
+// driver, if there is one. Unless forced, a secret that is identical to the
+// last one applied is skipped, so that touching the files does not reset
+// the active credential.
+func (f *Watcher) Apply(pqrDriver *gopqr.Driver, s *gopqr.Secret, force bool) {
+	f.mu.Lock()
+	if !force && f.loaded && reflect.DeepEqual(f.last, *s) {
+		f.mu.Unlock()
+		return
+	}
+	f.loaded = true
+	f.last = *s
+	f.mu.Unlock()
+	if pqrDriver != nil {
+		s.Apply(pqrDriver)
+	}
+}
+
+// Watch starts watching the directory of the config and applies the secret
+// it reads whenever the files change. Call Close to stop watching.
+func (f *Watcher) Watch(cfg Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.watcher != nil {
+		return errors.New(cfg.Description + " is already being watched")
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher for %v - %w", cfg.Description, err)
+	}
+	if err := w.Add(cfg.Dir); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to watch the directory of %v %v - %w", cfg.Description, cfg.Dir, err)
+	}
+	f.watcher = w
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = DEFAULTDEBOUNCE
+	}
+	if cfg.Driver != nil {
+		cfg.Driver.Go(cfg.Worker, func(ran func()) {
+			f.loop(w, cfg, ran)
+		})
+	} else {
+		go f.loop(w, cfg, func() {})
+	}
+	return nil
+}
+
+// Close stops watching.
+func (f *Watcher) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.watcher == nil {
+		return nil
+	}
+	err := f.watcher.Close()
+	f.watcher = nil
+	return err
+}
+
+func (f *Watcher) loop(w *fsnotify.Watcher, cfg Config, ran func()) {
+	name := filepath.Clean(filepath.Join(cfg.Dir, cfg.File))
+	var reload <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if cfg.File == "" || filepath.Clean(event.Name) == name {
+				reload = time.After(cfg.Debounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			Logf(cfg.Logger, "watching %v failed - %v", cfg.Description, err)
+		case <-reload:
+			reload = nil
+			ran()
+			s, err := cfg.Read()
+			if err != nil {
+				Logf(cfg.Logger, "reloading DB secret from %v failed - %v", cfg.Description, err)
+				continue
+			}
+			f.Apply(cfg.Driver, s, false)
+		}
+	}
+}
+
+// Logf writes the message to the logger, if there is one.
+func Logf(logger *log.Logger, format string, v ...interface{}) {
+	if logger != nil {
+		logger.Printf(format, v...)
+	}
+}
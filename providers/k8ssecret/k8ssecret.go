@@ -0,0 +1,236 @@
+package k8ssecret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/internal/filewatch"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package k8ssecret sources the rotating credentials for github.com/chandranarreddy/gopqr
+from a directory holding one file per key, which is how Kubernetes mounts a
+Secret volume. The directory is expected to have the following files -
+	odd_username
+	odd_password
+	even_username
+	even_password
+	active_credential
+
+and optionally host, port and sslmode files to move the driver to a new
+endpoint. Any other files are passed through in the Extra of the secret. The
+directory is watched for changes and the driver is updated when the kubelet
+(or anything else, such as external-secrets) rewrites the files, so
+credentials rotate without any cloud SDK in the application.
+
+Usage:
+	p := k8ssecret.New("/var/run/secrets/postgres")
+	pqrDriver, err := p.NewDriver(logger)
+	...
+	if err := p.Watch(pqrDriver, logger); err != nil {
+		...
+	}
+	defer p.Close()
+	sql.Register("postgresrotating", pqrDriver)
+
+The Provider also implements gopqr.CredentialProvider. When it is set as the
+Provider of a driver, call Watch with a nil driver to keep it current -
+	pqrDriver := &gopqr.Driver{Provider: p}
+	err := p.Watch(nil, logger)
+*/
+
+// DEFAULTDEBOUNCE - default quiet period after the last change in the
+// directory before the files are reloaded. The kubelet swaps the files in
+// several steps, reloading after every single event would read them half way.
+const DEFAULTDEBOUNCE = filewatch.DEFAULTDEBOUNCE
+
+var keys = [...]string{"odd_username", "odd_password", "even_username", "even_password", "active_credential"}
+
+var optionalKeys = [...]string{"host", "port", "sslmode", "sslrootcert", "sslcert", "sslkey"}
+
+// Provider reads the rotating credentials from a mounted secret directory.
+type Provider struct {
+	// Debounce - Quiet period before reloading after a change, defaults to DEFAULTDEBOUNCE
+	Debounce time.Duration
+
+	dir   string
+	watch filewatch.Watcher
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "k8ssecret" of gopqr.LoadConfig, whose
+// params are the Dir the Secret is mounted at.
+func init() {
+	gopqr.RegisterProviderType("k8ssecret", gopqr.ConfigProvider(func(cfg struct{ Dir string }) (*Provider, error) {
+		if cfg.Dir == "" {
+			return nil, errors.New("Dir is required for the k8ssecret provider")
+		}
+		return New(cfg.Dir), nil
+	}))
+}
+
+// New returns a Provider reading the files in dir.
+func New(dir string) *Provider {
+	return &Provider{dir: dir}
+}
+
+// Read reads the rotating credentials from the files in the directory.
+func (p *Provider) Read() (*gopqr.Secret, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		b, err := ioutil.ReadFile(filepath.Join(p.dir, key))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, &gopqr.SecretNotFoundError{Source: "mounted secret", ID: filepath.Join(p.dir, key), Err: err}
+			}
+			return nil, fmt.Errorf("failed to read %v from mounted secret - %w", key, err)
+		}
+		values[key] = strings.TrimRight(string(b), "\r\n")
+	}
+	for _, key := range optionalKeys {
+		b, err := ioutil.ReadFile(filepath.Join(p.dir, key))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %v from mounted secret - %w", key, err)
+		}
+		values[key] = strings.TrimRight(string(b), "\r\n")
+	}
+	extra, err := p.readExtra()
+	if err != nil {
+		return nil, err
+	}
+	return &gopqr.Secret{
+		OddUsername:      values["odd_username"],
+		OddPassword:      values["odd_password"],
+		EvenUsername:     values["even_username"],
+		EvenPassword:     values["even_password"],
+		ActiveCredential: values["active_credential"],
+		Host:             values["host"],
+		Port:             json.Number(values["port"]),
+		SSLMode:          values["sslmode"],
+		SSLRootCert:      values["sslrootcert"],
+		SSLCert:          values["sslcert"],
+		SSLKey:           values["sslkey"],
+		Extra:            extra,
+	}, nil
+}
+
+// readExtra reads the files in the directory that are not keys of the
+// rotating credentials. Hidden files are skipped, the kubelet keeps its
+// bookkeeping in them.
+func (p *Provider) readExtra() (map[string]string, error) {
+	known := make(map[string]bool, len(keys)+len(optionalKeys))
+	for _, key := range keys {
+		known[key] = true
+	}
+	for _, key := range optionalKeys {
+		known[key] = true
+	}
+	entries, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mounted secret - %w", err)
+	}
+	var extra map[string]string
+	for _, entry := range entries {
+		name := entry.Name()
+		if known[name] || strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(p.dir, name)
+		// the keys are symlinks into the hidden data directory
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v from mounted secret - %w", name, err)
+		}
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[name] = strings.TrimRight(string(b), "\r\n")
+	}
+	return extra, nil
+}
+
+// Current returns the credentials last read from the directory, reading
+// them first if they have not been read yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	s, loaded := p.watch.Last()
+	if !loaded {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		s, _ = p.watch.Last()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh rereads the credentials from the directory.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Read()
+	if err != nil {
+		return err
+	}
+	p.watch.Apply(nil, s, true)
+	return nil
+}
+
+// NewDriver reads the credentials and returns a sticky gopqr driver sourcing
+// its credentials from the provider, which rereads the directory when a
+// credential fails authentication. The notices of the driver are written to
+// the logger.
+func (p *Provider) NewDriver(logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Refresher returns a CredentialRefresher func that rereads the directory
+// and resets the credentials on the driver.
+func (p *Provider) Refresher(logger *log.Logger) func(*gopqr.Driver) {
+	return func(pqrDriver *gopqr.Driver) {
+		s, err := p.Read()
+		if err != nil {
+			filewatch.Logf(logger, "refreshing DB secret from mounted secret failed - %v", err)
+			return
+		}
+		p.watch.Apply(pqrDriver, s, true)
+	}
+}
+
+// Watch starts watching the directory and reloads the credentials into the
+// driver whenever the files change. The credentials are only applied when
+// they differ from what was last applied, so that touching the files does
+// not reset the active credential. The driver may be nil when the Provider is
+// set as the Provider of the driver. Call Close to stop watching.
+func (p *Provider) Watch(pqrDriver *gopqr.Driver, logger *log.Logger) error {
+	return p.watch.Watch(filewatch.Config{
+		Dir:         p.dir,
+		Description: "mounted secret",
+		Read:        p.Read,
+		Driver:      pqrDriver,
+		Logger:      logger,
+		Worker:      "k8ssecret_watch",
+		Debounce:    p.Debounce,
+	})
+}
+
+// Close stops watching the directory.
+func (p *Provider) Close() error {
+	return p.watch.Close()
+}
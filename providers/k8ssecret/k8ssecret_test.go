@@ -0,0 +1,117 @@
+package k8ssecret_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/k8ssecret"
+)
+
+var files = map[string]string{
+	"odd_username":      "app_odd",
+	"odd_password":      "odd-pw\n",
+	"even_username":     "app_even",
+	"even_password":     "even-pw\n",
+	"active_credential": "even",
+	"host":              "db.internal",
+	"region":            "eu-west-1",
+}
+
+// mount lays out the files the way the kubelet mounts a Secret volume - the
+// keys are symlinks through the ..data symlink into a timestamped hidden
+// directory, which is swapped on update.
+func mount(t *testing.T, dir, version string, values map[string]string) {
+	t.Helper()
+	data := filepath.Join(dir, ".."+version)
+	if err := os.Mkdir(data, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for key, v := range values {
+		if err := os.WriteFile(filepath.Join(data, key), []byte(v), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(".."+version, tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	for key := range values {
+		link := filepath.Join(dir, key)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join("..data", key), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadMountedSecret(t *testing.T) {
+	dir := t.TempDir()
+	mount(t, dir, "2024_01_01", files)
+	s, err := k8ssecret.New(dir).Read()
+	if err != nil {
+		t.Fatalf("Read failed - %v", err)
+	}
+	if s.OddPassword != "odd-pw" || s.EvenPassword != "even-pw" || s.ActiveCredential != "even" {
+		t.Errorf("Read = %+v, want the passwords without the trailing newline", s)
+	}
+	if s.Host != "db.internal" {
+		t.Errorf("Host = %q, want db.internal", s.Host)
+	}
+	if len(s.Extra) != 1 || s.Extra["region"] != "eu-west-1" {
+		t.Errorf("Extra = %v, want only region, without the keys or the kubelet bookkeeping", s.Extra)
+	}
+}
+
+func TestMissingKeyIsNotFound(t *testing.T) {
+	dir := t.TempDir()
+	mount(t, dir, "2024_01_01", map[string]string{"odd_username": "app_odd", "odd_password": "odd-pw"})
+	_, err := k8ssecret.New(dir).Current(context.Background())
+	if !errors.Is(err, gopqr.ErrSecretNotFound) {
+		t.Errorf("Current = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestWatchReloadsSwappedSecret(t *testing.T) {
+	dir := t.TempDir()
+	mount(t, dir, "2024_01_01", files)
+	p := k8ssecret.New(dir)
+	p.Debounce = 10 * time.Millisecond
+	if err := p.Watch(nil, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("Watch failed - %v", err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+	if _, err := p.Current(ctx); err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+
+	rotated := make(map[string]string, len(files))
+	for key, v := range files {
+		rotated[key] = v
+	}
+	rotated["odd_password"], rotated["active_credential"] = "rotated", "odd"
+	mount(t, dir, "2024_01_02", rotated)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		creds, err := p.Current(ctx)
+		if err == nil && creds.Slots[0].Password == "rotated" && creds.Active == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Current = %+v, %v after the secret was swapped, want the rotated credentials", creds, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,373 @@
+package ldapdir
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package ldapdir sources the rotating credentials for github.com/chandranarreddy/gopqr
+from an LDAP directory or Active Directory, for enterprises whose source of
+truth for service accounts is the directory rather than a secrets vault. Each
+slot is the entry of a service account. Its username and password are read
+from attributes of the entry, or the password is derived from the entry by a
+func of your own, like one decrypting a LAPS attribute. When the password was
+last set and when it expires are read from the directory too, so that the
+slot whose password was set last becomes the active one and StartExpiryRefresh
+refreshes ahead of the expiry.
+
+Usage:
+	p, err := ldapdir.New(ldapdir.Config{
+		URL:               "ldaps://dc1.corp.example.com",
+		BindDN:            "CN=gopqr-reader,OU=Services,DC=corp,DC=example,DC=com",
+		BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+		PasswordAttribute: "msLAPS-Password",
+		Slots: []ldapdir.Slot{
+			{Name: "odd", DN: "CN=svc-orders-odd,OU=Services,DC=corp,DC=example,DC=com"},
+			{Name: "even", DN: "CN=svc-orders-even,OU=Services,DC=corp,DC=example,DC=com"},
+		},
+	})
+	...
+	pqrDriver, err := p.NewDriver(ctx, logger)
+	...
+	sql.Register("postgresrotating", pqrDriver)
+
+The Provider also implements gopqr.CredentialProvider, so it can be set as
+the Provider of a driver instead -
+	pqrDriver := &gopqr.Driver{Provider: p}
+*/
+
+const (
+	//DEFAULTUSERNAMEATTRIBUTE - default attribute holding the username of a
+	// service account
+	DEFAULTUSERNAMEATTRIBUTE = "sAMAccountName"
+	//DEFAULTCHANGEDATTRIBUTE - default attribute holding when the password
+	// was last set
+	DEFAULTCHANGEDATTRIBUTE = "pwdLastSet"
+	//DEFAULTEXPIRYATTRIBUTE - default attribute holding when the password
+	// expires
+	DEFAULTEXPIRYATTRIBUTE = "msDS-UserPasswordExpiryTimeComputed"
+	//DEFAULTTIMEOUT - default deadline of the calls to the directory
+	DEFAULTTIMEOUT = 30 * time.Second
+)
+
+// Slot - A credential slot and the entry of its service account
+type Slot struct {
+	// Name - Name of the slot, like "odd" or "even"
+	Name string
+	// DN - Distinguished name of the entry of the service account
+	DN string
+}
+
+// Config holds the settings of the LDAP provider.
+type Config struct {
+	// URL - URL of the directory, like "ldaps://dc1.corp.example.com" or
+	// "ldap://ldap.example.com:389"
+	URL string
+	// BindDN and BindPassword - Account the provider reads the directory
+	// with. Leave BindDN empty to bind anonymously.
+	BindDN       string
+	BindPassword string
+	// TLSConfig - TLS settings of ldaps:// URLs and StartTLS
+	TLSConfig *tls.Config
+	// StartTLS - Upgrade an ldap:// connection with StartTLS before binding
+	StartTLS bool
+	// Slots - The slots and their service accounts, two or more
+	Slots []Slot
+	// Active - Slot made active when the directory tells nothing of when
+	// the passwords were set, defaults to the first one
+	Active string
+	// UsernameAttribute - Attribute holding the username, defaults to
+	// DEFAULTUSERNAMEATTRIBUTE
+	UsernameAttribute string
+	// PasswordAttribute - Attribute holding the password, required unless
+	// Password is set
+	PasswordAttribute string
+	// Password func, when set, derives the password of the service account
+	// from its entry instead, like by decrypting an attribute
+	Password func(entry *ldap.Entry) (string, error)
+	// ChangedAttribute - Attribute holding when the password was last set,
+	// defaults to DEFAULTCHANGEDATTRIBUTE. "-" reads none.
+	ChangedAttribute string
+	// ExpiryAttribute - Attribute holding when the password expires,
+	// defaults to DEFAULTEXPIRYATTRIBUTE. "-" reads none.
+	ExpiryAttribute string
+	// Timeout - Deadline of the calls to the directory, defaults to
+	// DEFAULTTIMEOUT
+	Timeout time.Duration
+}
+
+// Provider reads the rotating credentials from the directory.
+type Provider struct {
+	cfg Config
+
+	mu      sync.Mutex
+	current *gopqr.Secret
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "ldapdir" of gopqr.LoadConfig, whose params
+// are the fields of Config.
+func init() {
+	gopqr.RegisterProviderType("ldapdir", gopqr.ConfigProvider(New))
+}
+
+// New returns a Provider for the configured service accounts.
+func New(cfg Config) (*Provider, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("URL is required for the LDAP provider")
+	}
+	if len(cfg.Slots) < 2 {
+		return nil, errors.New("At least two Slots are required for the LDAP provider")
+	}
+	for i, slot := range cfg.Slots {
+		if slot.Name == "" || slot.DN == "" {
+			return nil, fmt.Errorf("Slots[%v] needs a Name and DN", i)
+		}
+	}
+	if cfg.PasswordAttribute == "" && cfg.Password == nil {
+		return nil, errors.New("PasswordAttribute or Password is required for the LDAP provider")
+	}
+	if cfg.UsernameAttribute == "" {
+		cfg.UsernameAttribute = DEFAULTUSERNAMEATTRIBUTE
+	}
+	if cfg.ChangedAttribute == "" {
+		cfg.ChangedAttribute = DEFAULTCHANGEDATTRIBUTE
+	}
+	if cfg.ExpiryAttribute == "" {
+		cfg.ExpiryAttribute = DEFAULTEXPIRYATTRIBUTE
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DEFAULTTIMEOUT
+	}
+	if cfg.Active == "" {
+		cfg.Active = cfg.Slots[0].Name
+	}
+	return &Provider{cfg: cfg}, nil
+}
+
+// Fetch binds to the directory and reads the entry of every slot. The slot
+// whose password was set last is the active one.
+func (p *Provider) Fetch(ctx context.Context) (*gopqr.Secret, error) {
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// the connection is closed once the context is done, failing the call
+	// in flight
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	s := &gopqr.Secret{ActiveCredential: p.cfg.Active}
+	var newest time.Time
+	for _, slot := range p.cfg.Slots {
+		entry, err := p.entry(conn, slot)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("reading %v from the directory failed - %w", slot.DN, ctx.Err())
+			}
+			return nil, err
+		}
+		secretSlot, err := p.slot(slot, entry)
+		if err != nil {
+			return nil, err
+		}
+		if secretSlot.ValidFrom.After(newest) {
+			newest = secretSlot.ValidFrom
+			s.ActiveCredential = slot.Name
+		}
+		s.Slots = append(s.Slots, secretSlot)
+	}
+	return s, nil
+}
+
+// dial connects and binds to the directory.
+func (p *Provider) dial(ctx context.Context) (*ldap.Conn, error) {
+	opts := []ldap.DialOpt{}
+	if p.cfg.TLSConfig != nil {
+		opts = append(opts, ldap.DialWithTLSConfig(p.cfg.TLSConfig))
+	}
+	conn, err := ldap.DialURL(p.cfg.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the directory at %v - %w", p.cfg.URL, err)
+	}
+	timeout := p.cfg.Timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	conn.SetTimeout(timeout)
+	if p.cfg.StartTLS {
+		tlsConfig := p.cfg.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: hostOf(p.cfg.URL)}
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS with the directory failed - %w", err)
+		}
+	}
+	if p.cfg.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(p.cfg.BindDN, p.cfg.BindPassword)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("binding to the directory as %v failed - %w", p.cfg.BindDN, err)
+	}
+	return conn, nil
+}
+
+// entry reads the entry of the service account of the slot.
+func (p *Provider) entry(conn *ldap.Conn, slot Slot) (*ldap.Entry, error) {
+	attributes := []string{p.cfg.UsernameAttribute}
+	for _, attribute := range []string{p.cfg.PasswordAttribute, p.cfg.ChangedAttribute, p.cfg.ExpiryAttribute} {
+		if attribute != "" && attribute != "-" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	if p.cfg.Password != nil {
+		// the func may derive the password from any of them
+		attributes = append(attributes, "*")
+	}
+	req := ldap.NewSearchRequest(slot.DN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1,
+		int(p.cfg.Timeout/time.Second), false, "(objectClass=*)", attributes, nil)
+	res, err := conn.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || (err == nil && len(res.Entries) == 0) {
+		return nil, &gopqr.SecretNotFoundError{Source: "LDAP", ID: slot.DN, Err: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %v from the directory failed - %w", slot.DN, err)
+	}
+	return res.Entries[0], nil
+}
+
+// slot reads the credential of the slot out of the entry of its service
+// account.
+func (p *Provider) slot(slot Slot, entry *ldap.Entry) (gopqr.SecretSlot, error) {
+	s := gopqr.SecretSlot{Name: slot.Name, Username: entry.GetAttributeValue(p.cfg.UsernameAttribute)}
+	if s.Username == "" {
+		return s, fmt.Errorf("entry %v has no %v", slot.DN, p.cfg.UsernameAttribute)
+	}
+	if p.cfg.Password != nil {
+		password, err := p.cfg.Password(entry)
+		if err != nil {
+			return s, fmt.Errorf("deriving the password of %v failed - %w", slot.DN, err)
+		}
+		s.Password = password
+	} else {
+		s.Password = entry.GetAttributeValue(p.cfg.PasswordAttribute)
+	}
+	if s.Password == "" {
+		return s, fmt.Errorf("entry %v has no password", slot.DN)
+	}
+	var err error
+	if p.cfg.ChangedAttribute != "-" {
+		if s.ValidFrom, err = directoryTime(entry.GetAttributeValue(p.cfg.ChangedAttribute)); err != nil {
+			return s, fmt.Errorf("%v of %v - %w", p.cfg.ChangedAttribute, slot.DN, err)
+		}
+	}
+	if p.cfg.ExpiryAttribute != "-" {
+		if s.ValidUntil, err = directoryTime(entry.GetAttributeValue(p.cfg.ExpiryAttribute)); err != nil {
+			return s, fmt.Errorf("%v of %v - %w", p.cfg.ExpiryAttribute, slot.DN, err)
+		}
+	}
+	return s, nil
+}
+
+// fileTimeUnixOffset is the seconds from 1601-01-01, which the times of
+// Active Directory count 100 nanosecond intervals from, to the Unix epoch.
+const fileTimeUnixOffset = 11644473600
+
+// directoryTime parses a time of the directory, either the integer
+// FILETIME of Active Directory, where 0 and the largest value stand for
+// never, or the GeneralizedTime of LDAP, like the pwdChangedTime of
+// OpenLDAP. An empty value is the zero time.
+func directoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if n <= 0 || n == 1<<63-1 {
+			return time.Time{}, nil
+		}
+		return time.Unix(n/1e7-fileTimeUnixOffset, n%1e7*100).UTC(), nil
+	}
+	for _, layout := range generalizedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a FILETIME nor a GeneralizedTime", value)
+}
+
+// generalizedTimeLayouts are the forms of GeneralizedTime directories send,
+// with or without fractions of a second and in UTC or with an offset.
+var generalizedTimeLayouts = []string{
+	"20060102150405Z0700",
+	"20060102150405.999999999Z0700",
+	"20060102150405,999999999Z0700",
+}
+
+// hostOf returns the host of the URL of the directory.
+func hostOf(url string) string {
+	_, rest, _ := strings.Cut(url, "://")
+	host, _, _ := strings.Cut(rest, "/")
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
+
+// Current returns the credentials read by the last Refresh, reading them
+// first if they have not been read yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	s := p.current
+	p.mu.Unlock()
+	if s == nil {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		s = p.current
+		p.mu.Unlock()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh rereads the credentials from the directory.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.current = s
+	p.mu.Unlock()
+	return nil
+}
+
+// NewDriver reads the credentials and returns a sticky gopqr driver
+// sourcing its credentials from the provider. The notices of the driver,
+// like failed refreshes, are written to the logger.
+func (p *Provider) NewDriver(ctx context.Context, logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
@@ -0,0 +1,234 @@
+package ldapdir
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	bindDN       = "CN=gopqr-reader,OU=Services,DC=corp,DC=example,DC=com"
+	bindPassword = "reader-pw"
+	oddDN        = "CN=svc-orders-odd,OU=Services,DC=corp,DC=example,DC=com"
+	evenDN       = "CN=svc-orders-even,OU=Services,DC=corp,DC=example,DC=com"
+)
+
+// fakeDirectory is an LDAP server answering simple binds as bindDN and base
+// object searches of its entries.
+type fakeDirectory struct {
+	entries map[string]map[string]string
+}
+
+// serve starts the directory and returns its URL.
+func (d *fakeDirectory) serve(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.handle(conn)
+		}
+	}()
+	return "ldap://" + ln.Addr().String()
+}
+
+func (d *fakeDirectory) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, op := packet.Children[0].Value, packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultSuccess
+			if op.Children[1].Value != bindDN || op.Children[2].Data.String() != bindPassword {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			conn.Write(message(id, result(ldap.ApplicationBindResponse, code)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			dn, _ := op.Children[0].Value.(string)
+			attributes, ok := d.entries[dn]
+			if !ok {
+				conn.Write(message(id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject)).Bytes())
+				continue
+			}
+			entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+			entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+			list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			for name, value := range attributes {
+				attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+				values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+				values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+				attribute.AppendChild(values)
+				list.AppendChild(attribute)
+			}
+			entry.AppendChild(list)
+			conn.Write(message(id, entry).Bytes())
+			conn.Write(message(id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func message(id interface{}, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	return packet
+}
+
+func result(tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
+
+// fileTime returns the FILETIME of Active Directory for the time.
+func fileTime(t time.Time) string {
+	return strconv.FormatInt((t.Unix()+fileTimeUnixOffset)*1e7, 10)
+}
+
+func newDirectoryProvider(t *testing.T, cfg Config) *Provider {
+	t.Helper()
+	set := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	directory := &fakeDirectory{entries: map[string]map[string]string{
+		oddDN: {
+			"sAMAccountName":                      "svc-orders-odd",
+			"msLAPS-Password":                     "odd-pw",
+			"pwdLastSet":                          fileTime(set),
+			"msDS-UserPasswordExpiryTimeComputed": fileTime(set.Add(90 * 24 * time.Hour)),
+		},
+		evenDN: {
+			"sAMAccountName":                      "svc-orders-even",
+			"msLAPS-Password":                     "even-pw",
+			"pwdLastSet":                          fileTime(set.Add(45 * 24 * time.Hour)),
+			"msDS-UserPasswordExpiryTimeComputed": "9223372036854775807",
+		},
+	}}
+	cfg.URL = directory.serve(t)
+	if cfg.BindDN == "" {
+		cfg.BindDN, cfg.BindPassword = bindDN, bindPassword
+	}
+	if cfg.Slots == nil {
+		cfg.Slots = []Slot{{Name: "odd", DN: oddDN}, {Name: "even", DN: evenDN}}
+	}
+	if cfg.PasswordAttribute == "" && cfg.Password == nil {
+		cfg.PasswordAttribute = "msLAPS-Password"
+	}
+	cfg.Timeout = 5 * time.Second
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	return p
+}
+
+func TestFetchReadsServiceAccounts(t *testing.T) {
+	s, err := newDirectoryProvider(t, Config{}).Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed - %v", err)
+	}
+	if len(s.Slots) != 2 {
+		t.Fatalf("slots = %+v, want odd and even", s.Slots)
+	}
+	odd, even := s.Slots[0], s.Slots[1]
+	if odd.Username != "svc-orders-odd" || odd.Password != "odd-pw" || even.Username != "svc-orders-even" || even.Password != "even-pw" {
+		t.Errorf("slots = %+v, want the usernames and passwords of the entries", s.Slots)
+	}
+	if s.ActiveCredential != "even" {
+		t.Errorf("ActiveCredential = %q, want even, whose password was set last", s.ActiveCredential)
+	}
+	if want := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC); !odd.ValidUntil.Equal(want) {
+		t.Errorf("ValidUntil of odd = %v, want %v", odd.ValidUntil, want)
+	}
+	if !even.ValidUntil.IsZero() {
+		t.Errorf("ValidUntil of even = %v, want a password that never expires", even.ValidUntil)
+	}
+}
+
+func TestFetchDerivesPassword(t *testing.T) {
+	p := newDirectoryProvider(t, Config{Password: func(entry *ldap.Entry) (string, error) {
+		return "derived-" + entry.GetAttributeValue("msLAPS-Password"), nil
+	}})
+	s, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed - %v", err)
+	}
+	if s.Slots[0].Password != "derived-odd-pw" {
+		t.Errorf("password = %q, want the one derived from the entry", s.Slots[0].Password)
+	}
+}
+
+func TestFetchMissingEntryIsNotFound(t *testing.T) {
+	p := newDirectoryProvider(t, Config{Slots: []Slot{{Name: "odd", DN: oddDN}, {Name: "even", DN: "CN=svc-gone,DC=corp,DC=example,DC=com"}}})
+	if _, err := p.Fetch(context.Background()); !errors.Is(err, gopqr.ErrSecretNotFound) {
+		t.Errorf("Fetch = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestFetchBindFailure(t *testing.T) {
+	p := newDirectoryProvider(t, Config{BindDN: bindDN, BindPassword: "wrong"})
+	if _, err := p.Fetch(context.Background()); !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Errorf("Fetch = %v, want the invalid credentials of the bind", err)
+	}
+}
+
+func TestDirectoryTime(t *testing.T) {
+	want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Time{
+		"":                    {},
+		"0":                   {},
+		"9223372036854775807": {},
+		"133485408000000000":  want,
+		"20240101000000Z":     want,
+		"20240101010000+0100": want,
+		"20240101000000.5Z":   want.Add(500 * time.Millisecond),
+	} {
+		got, err := directoryTime(value)
+		if err != nil {
+			t.Errorf("directoryTime(%q) failed - %v", value, err)
+			continue
+		}
+		if !got.Equal(expected) {
+			t.Errorf("directoryTime(%q) = %v, want %v", value, got, expected)
+		}
+	}
+	if _, err := directoryTime("yesterday"); err == nil {
+		t.Error(`directoryTime("yesterday") succeeded, want an error`)
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	for url, want := range map[string]string{
+		"ldaps://dc1.corp.example.com":           "dc1.corp.example.com",
+		"ldap://ldap.example.com:389":            "ldap.example.com",
+		"ldap://ldap.example.com:389/dc=example": "ldap.example.com",
+		"ldap://[::1]:389":                       "::1",
+		"ldap://[::1]":                           "::1",
+	} {
+		if got := hostOf(url); got != want {
+			t.Errorf("hostOf(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
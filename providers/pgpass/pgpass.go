@@ -0,0 +1,281 @@
+package pgpass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/internal/filewatch"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package pgpass sources the rotating credentials for
+github.com/chandranarreddy/gopqr from a standard PostgreSQL password file,
+~/.pgpass, so that shops already managing it with configuration management
+get rotation without a new secret format. Every line holds an entry -
+	hostname:port:database:username:password
+where "*" matches anything and ":" and "\" are escaped with "\". The entries
+matching the Host, Port and Database of the Config become the slots of the
+ring, by their username. The file is reloaded when it changes, including when
+it is replaced by a rename, and on demand through Refresh. Like libpq, a file
+readable by the group or others is refused, except on Windows.
+
+Usage:
+	p, err := pgpass.New(pgpass.Config{
+		Host:     "mydb.example.com",
+		Database: "mydb",
+		Slots:    []pgpass.Slot{{Name: "odd", Username: "app_odd"}, {Name: "even", Username: "app_even"}},
+		Active:   "even",
+	})
+	...
+	pqrDriver, err := p.NewDriver(logger)
+	...
+	if err := p.Watch(pqrDriver, logger); err != nil {
+		...
+	}
+	defer p.Close()
+*/
+
+const (
+	//DEFAULTDEBOUNCE - default quiet period after the last change to the file
+	// before it is reloaded, so that a file written in several steps is not
+	// read half way
+	DEFAULTDEBOUNCE = filewatch.DEFAULTDEBOUNCE
+)
+
+// Config holds where the password file is and which of its entries are the
+// credentials.
+type Config struct {
+	// Path - The password file, defaults to PGPASSFILE, or else ~/.pgpass
+	// (%APPDATA%\postgresql\pgpass.conf on Windows)
+	Path string
+	// Host, Port and Database - The entries are matched against, like libpq
+	// does for a connection. Empty values match every entry.
+	Host     string
+	Port     string
+	Database string
+	// Slots - The slots of the ring by the username of their entry. Leave
+	// empty to have every matching entry a slot named after its username,
+	// in the order of the file.
+	Slots []Slot
+	// Active - Name of the active slot, defaults to the first slot
+	Active string
+	// Debounce - Quiet period before reloading after a change, defaults to DEFAULTDEBOUNCE
+	Debounce time.Duration
+}
+
+// Slot names the entry of the password file a credential slot holds.
+type Slot struct {
+	Name     string
+	Username string
+}
+
+// Provider reads the rotating credentials from a password file.
+type Provider struct {
+	cfg Config
+
+	watch filewatch.Watcher
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "pgpass" of gopqr.LoadConfig, whose params
+// are the fields of Config.
+func init() {
+	gopqr.RegisterProviderType("pgpass", gopqr.ConfigProvider(New))
+}
+
+// New returns a Provider reading the password file of the config.
+func New(cfg Config) (*Provider, error) {
+	for i, slot := range cfg.Slots {
+		if slot.Name == "" || slot.Username == "" {
+			return nil, fmt.Errorf("Slots[%v] needs a Name and Username", i)
+		}
+	}
+	if cfg.Path == "" {
+		cfg.Path = os.Getenv("PGPASSFILE")
+	}
+	if cfg.Path == "" {
+		if runtime.GOOS == "windows" {
+			cfg.Path = filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+		} else {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("Path is required when there is no home directory - %w", err)
+			}
+			cfg.Path = filepath.Join(home, ".pgpass")
+		}
+	}
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = DEFAULTDEBOUNCE
+	}
+	return &Provider{cfg: cfg}, nil
+}
+
+// Read reads the rotating credentials from the password file.
+func (p *Provider) Read() (*gopqr.Secret, error) {
+	info, err := os.Stat(p.cfg.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &gopqr.SecretNotFoundError{Source: "pgpass", ID: p.cfg.Path, Err: err}
+		}
+		return nil, fmt.Errorf("failed to read password file %v - %w", p.cfg.Path, err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("password file %v has group or world access; permissions should be u=rw (0600) or less", p.cfg.Path)
+	}
+	raw, err := os.ReadFile(p.cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password file %v - %w", p.cfg.Path, err)
+	}
+	passwords := make(map[string]string)
+	var users []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, ok := splitEntry(line)
+		if !ok || !p.matches(fields) {
+			continue
+		}
+		// the first matching entry of a user wins, like for libpq
+		if _, seen := passwords[fields[3]]; !seen {
+			passwords[fields[3]] = fields[4]
+			users = append(users, fields[3])
+		}
+	}
+	slots := p.cfg.Slots
+	if len(slots) == 0 {
+		for _, user := range users {
+			slots = append(slots, Slot{Name: user, Username: user})
+		}
+	}
+	if len(slots) == 0 {
+		return nil, &gopqr.SecretNotFoundError{Source: "pgpass", ID: p.cfg.Path, Err: errors.New("no entry matches")}
+	}
+	s := &gopqr.Secret{ActiveCredential: p.cfg.Active}
+	if s.ActiveCredential == "" {
+		s.ActiveCredential = slots[0].Name
+	}
+	for _, slot := range slots {
+		password, ok := passwords[slot.Username]
+		if !ok {
+			return nil, fmt.Errorf("password file %v has no entry for %v of slot %v", p.cfg.Path, slot.Username, slot.Name)
+		}
+		s.Slots = append(s.Slots, gopqr.SecretSlot{Name: slot.Name, Username: slot.Username, Password: password})
+	}
+	return s, nil
+}
+
+// matches reports whether the host, port and database of the entry match
+// the config.
+func (p *Provider) matches(fields []string) bool {
+	for i, want := range []string{p.cfg.Host, p.cfg.Port, p.cfg.Database} {
+		if want != "" && fields[i] != "*" && fields[i] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// splitEntry splits a line of the password file into its five fields,
+// unescaped. The password is the rest of the line.
+func splitEntry(line string) ([]string, bool) {
+	fields := make([]string, 0, 5)
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case c == ':' && len(fields) < 4:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	fields = append(fields, field.String())
+	return fields, len(fields) == 5
+}
+
+// Refresher returns a gopqr.CredentialRefresher func that rereads the file
+// and resets the credentials on the driver.
+func (p *Provider) Refresher(logger *log.Logger) func(*gopqr.Driver) {
+	return func(pqrDriver *gopqr.Driver) {
+		s, err := p.Read()
+		if err != nil {
+			filewatch.Logf(logger, "refreshing DB secret from password file failed - %v", err)
+			return
+		}
+		p.watch.Apply(pqrDriver, s, true)
+	}
+}
+
+// Current returns the credentials last read from the file, reading them
+// first if they have not been read yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	s, loaded := p.watch.Last()
+	if !loaded {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		s, _ = p.watch.Last()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh rereads the credentials from the file.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Read()
+	if err != nil {
+		return err
+	}
+	p.watch.Apply(nil, s, true)
+	return nil
+}
+
+// NewDriver reads the credentials and returns a sticky gopqr driver sourcing
+// its credentials from the provider, which rereads the file when a
+// credential fails authentication. The notices of the driver are written to
+// the logger.
+func (p *Provider) NewDriver(logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Watch starts watching the file and reloads the credentials into the
+// driver whenever it changes. The directory of the file is watched rather
+// than the file, as configuration management tools replace files by
+// renaming a new one over them. The credentials are only applied when they
+// differ from what was last applied, so that touching the file does not
+// reset the active credential. The driver may be nil when the Provider is
+// set as the Provider of the driver. Call Close to stop watching.
+func (p *Provider) Watch(pqrDriver *gopqr.Driver, logger *log.Logger) error {
+	return p.watch.Watch(filewatch.Config{
+		Dir:         filepath.Dir(p.cfg.Path),
+		File:        filepath.Base(p.cfg.Path),
+		Description: "password file",
+		Read:        p.Read,
+		Driver:      pqrDriver,
+		Logger:      logger,
+		Worker:      "pgpass_watch",
+		Debounce:    p.cfg.Debounce,
+	})
+}
+
+// Close stops watching the file.
+func (p *Provider) Close() error {
+	return p.watch.Close()
+}
@@ -0,0 +1,113 @@
+package pgpass_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/pgpass"
+)
+
+const passfile = `# rotating credentials of mydb
+other.example.com:5432:mydb:app_odd:wrong-host
+mydb.example.com:5432:otherdb:app_odd:wrong-db
+mydb.example.com:*:mydb:app_odd:odd\:pw
+*:*:*:app_even:even-pw
+*:*:*:app_odd:shadowed
+`
+
+func writePassfile(t *testing.T, content string, perm os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pgpass")
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadMatchingEntries(t *testing.T) {
+	p, err := pgpass.New(pgpass.Config{Path: writePassfile(t, passfile, 0o600), Host: "mydb.example.com", Port: "5432", Database: "mydb"})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	s, err := p.Read()
+	if err != nil {
+		t.Fatalf("Read failed - %v", err)
+	}
+	if len(s.Slots) != 2 || s.Slots[0].Username != "app_odd" || s.Slots[1].Username != "app_even" {
+		t.Fatalf("slots = %+v, want app_odd and app_even in the order of the file", s.Slots)
+	}
+	if s.Slots[0].Password != "odd:pw" {
+		t.Errorf("password of app_odd = %q, want the first matching entry unescaped", s.Slots[0].Password)
+	}
+	if s.ActiveCredential != "app_odd" {
+		t.Errorf("ActiveCredential = %q, want the first slot", s.ActiveCredential)
+	}
+}
+
+func TestConfiguredSlots(t *testing.T) {
+	p, err := pgpass.New(pgpass.Config{
+		Path:     writePassfile(t, passfile, 0o600),
+		Host:     "mydb.example.com",
+		Database: "mydb",
+		Slots:    []pgpass.Slot{{Name: "odd", Username: "app_odd"}, {Name: "even", Username: "app_even"}},
+		Active:   "even",
+	})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	creds, err := p.Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 2 || creds.Slots[0].Name != "odd" || creds.Slots[1].Password != "even-pw" || creds.Active != 1 {
+		t.Errorf("Current = %+v, want the odd and even slots with even active", creds)
+	}
+
+	p, err = pgpass.New(pgpass.Config{Path: writePassfile(t, passfile, 0o600), Slots: []pgpass.Slot{{Name: "odd", Username: "app_missing"}}})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	if _, err := p.Read(); err == nil {
+		t.Error("Read of a slot without an entry succeeded, want an error")
+	}
+	if _, err := pgpass.New(pgpass.Config{Slots: []pgpass.Slot{{Name: "odd"}}}); err == nil {
+		t.Error("New of a slot without a Username succeeded, want an error")
+	}
+}
+
+func TestNoMatchingEntryIsNotFound(t *testing.T) {
+	for name, path := range map[string]string{
+		"missing file": filepath.Join(t.TempDir(), "pgpass"),
+		"no match":     writePassfile(t, "other.example.com:5432:mydb:app_odd:odd-pw\n", 0o600),
+	} {
+		p, err := pgpass.New(pgpass.Config{Path: path, Host: "mydb.example.com"})
+		if err != nil {
+			t.Fatalf("New failed - %v", err)
+		}
+		if _, err := p.Read(); !errors.Is(err, gopqr.ErrSecretNotFound) {
+			t.Errorf("Read of %v = %v, want ErrSecretNotFound", name, err)
+		}
+	}
+}
+
+func TestReadRefusesSharedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the permissions are not checked on Windows")
+	}
+	path := writePassfile(t, passfile, 0o600)
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := pgpass.New(pgpass.Config{Path: path})
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	if _, err := p.Read(); err == nil {
+		t.Error("Read of a world readable file succeeded, want it refused like libpq does")
+	}
+}
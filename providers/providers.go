@@ -0,0 +1,71 @@
+package providers
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package providers holds the building blocks shared by the credential providers
+of github.com/chandranarreddy/gopqr, and Chain, which falls back from one
+provider to the next.
+
+Usage:
+	primary, err := awssm.New(awssm.Config{Region: region, SecretID: secretID})
+	cache := file.New("/var/cache/myapp/db.json")
+	pqrDriver := &gopqr.Driver{
+		Provider: providers.Chain(primary, cache, env.New()),
+		Sticky:   true,
+	}
+*/
+
+// DEFAULTNEGATIVECACHETTL - default duration a not found secret is remembered
+const DEFAULTNEGATIVECACHETTL = 30 * time.Second
+
+// NegativeCache remembers that a secret was not found for a brief TTL, so
+// that a misconfigured secret ID does not make every refresh repeat an
+// expensive failing call against the store. The zero value is ready to use
+// with DEFAULTNEGATIVECACHETTL.
+type NegativeCache struct {
+	// TTL - How long a not found secret is remembered. A negative TTL turns
+	// the cache off.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	err   error
+	until time.Time
+}
+
+// Check returns the remembered not found error while it has not expired, and
+// nil otherwise.
+func (c *NegativeCache) Check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil && time.Now().Before(c.until) {
+		return c.err
+	}
+	c.err = nil
+	return nil
+}
+
+// Observe records the outcome of a call to the store. Errors matching
+// gopqr.ErrSecretNotFound are remembered, a successful call forgets them.
+func (c *NegativeCache) Observe(err error) {
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = DEFAULTNEGATIVECACHETTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case err == nil:
+		c.err = nil
+	case ttl > 0 && errors.Is(err, gopqr.ErrSecretNotFound):
+		c.err = err
+		c.until = time.Now().Add(ttl)
+	}
+}
@@ -0,0 +1,50 @@
+package providers_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers"
+)
+
+func TestNegativeCacheRemembersNotFound(t *testing.T) {
+	c := &providers.NegativeCache{TTL: 20 * time.Millisecond}
+	notFound := &gopqr.SecretNotFoundError{Source: "test", ID: "mysecret"}
+	c.Observe(notFound)
+	if err := c.Check(); err != notFound {
+		t.Fatalf("Check = %v, want the not found error remembered", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := c.Check(); err != nil {
+		t.Errorf("Check = %v after the TTL, want nil", err)
+	}
+
+	c.Observe(notFound)
+	c.Observe(nil)
+	if err := c.Check(); err != nil {
+		t.Errorf("Check = %v after a successful call, want nil", err)
+	}
+}
+
+func TestNegativeCacheIgnoresOtherErrors(t *testing.T) {
+	var c providers.NegativeCache
+	c.Observe(errors.New("throttled"))
+	if err := c.Check(); err != nil {
+		t.Errorf("Check = %v, want only not found errors remembered", err)
+	}
+	c.Observe(fmt.Errorf("fetching failed - %w", &gopqr.SecretNotFoundError{Source: "test", ID: "mysecret"}))
+	if err := c.Check(); !errors.Is(err, gopqr.ErrSecretNotFound) {
+		t.Errorf("Check = %v with the zero TTL, want a wrapped not found error remembered", err)
+	}
+}
+
+func TestNegativeCacheOff(t *testing.T) {
+	c := &providers.NegativeCache{TTL: -1}
+	c.Observe(&gopqr.SecretNotFoundError{Source: "test", ID: "mysecret"})
+	if err := c.Check(); err != nil {
+		t.Errorf("Check = %v with a negative TTL, want the cache off", err)
+	}
+}
@@ -0,0 +1,81 @@
+package rdsiam
+
+import (
+	"errors"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds/rdsutils"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package rdsiam makes github.com/chandranarreddy/gopqr authenticate with AWS RDS
+IAM authentication tokens instead of odd and even passwords. A fresh token is
+generated inside Open for every new connection since tokens expire 15 minutes
+after they are generated. RDS requires SSL for IAM authentication, so please
+use sslmode=require or stricter in the DSN.
+
+Usage:
+	pqrDriver, err := rdsiam.NewDriver(rdsiam.Config{
+		Region:   "us-west-2",
+		Username: "myiamuser",
+	})
+	...
+	sql.Register("postgresrotating", pqrDriver)
+	db, err := sqlx.Open("postgresrotating", "postgres://mydb.xxxx.us-west-2.rds.amazonaws.com:5432/mydb?sslmode=verify-full")
+*/
+
+// Config holds the settings of the RDS IAM token mode.
+type Config struct {
+	// Region - AWS region of the RDS instance
+	Region string
+	// Username - Database user that has been granted the rds_iam role
+	Username string
+	// Endpoint - "host:port" the token is generated for. Leave empty to use
+	// the host and port of the DSN passed to Open.
+	Endpoint string
+	// Credentials - AWS credentials to sign the token with. Leave nil to use
+	// the default credential chain of the AWS SDK.
+	Credentials *credentials.Credentials
+}
+
+// NewDriver returns a gopqr driver that connects as the configured user with
+// an RDS IAM authentication token generated at connect time.
+func NewDriver(cfg Config) (*gopqr.Driver, error) {
+	source, err := PasswordSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{
+		OddUsername:      cfg.Username,
+		EvenUsername:     cfg.Username,
+		ActiveCredential: "odd",
+		PasswordSource:   source,
+	})
+}
+
+// PasswordSource returns a func that generates RDS IAM authentication tokens,
+// suitable for the PasswordSource of a gopqr driver.
+func PasswordSource(cfg Config) (func(hostport, username string) (string, error), error) {
+	if cfg.Region == "" || cfg.Username == "" {
+		return nil, errors.New("Region and Username are required for RDS IAM authentication")
+	}
+	creds := cfg.Credentials
+	if creds == nil {
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		creds = sess.Config.Credentials
+	}
+	return func(hostport, username string) (string, error) {
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = hostport
+		}
+		return rdsutils.BuildAuthToken(endpoint, cfg.Region, username, creds)
+	}, nil
+}
@@ -0,0 +1,70 @@
+package rdsiam_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr/providers/rdsiam"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestPasswordSourceBuildsToken(t *testing.T) {
+	source, err := rdsiam.PasswordSource(rdsiam.Config{
+		Region:      "us-west-2",
+		Username:    "myiamuser",
+		Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""),
+	})
+	if err != nil {
+		t.Fatalf("PasswordSource failed - %v", err)
+	}
+	token, err := source("mydb.xxxx.us-west-2.rds.amazonaws.com:5432", "myiamuser")
+	if err != nil {
+		t.Fatalf("generating a token failed - %v", err)
+	}
+	u, err := url.Parse("https://" + token)
+	if err != nil {
+		t.Fatalf("token %q does not parse - %v", token, err)
+	}
+	if u.Host != "mydb.xxxx.us-west-2.rds.amazonaws.com:5432" {
+		t.Errorf("token is for %v, want the host and port of the DSN", u.Host)
+	}
+	q := u.Query()
+	if q.Get("Action") != "connect" || q.Get("DBUser") != "myiamuser" {
+		t.Errorf("token query = %v, want a connect action for myiamuser", q)
+	}
+	if cred := q.Get("X-Amz-Credential"); !strings.HasPrefix(cred, "AKIDEXAMPLE/") || !strings.Contains(cred, "/us-west-2/rds-db/") {
+		t.Errorf("X-Amz-Credential = %q, want it signed with the configured credentials for rds-db in us-west-2", cred)
+	}
+}
+
+func TestPasswordSourceEndpointOverridesDSN(t *testing.T) {
+	source, err := rdsiam.PasswordSource(rdsiam.Config{
+		Region:      "us-west-2",
+		Username:    "myiamuser",
+		Endpoint:    "mydb.xxxx.us-west-2.rds.amazonaws.com:5432",
+		Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""),
+	})
+	if err != nil {
+		t.Fatalf("PasswordSource failed - %v", err)
+	}
+	token, err := source("localhost:6432", "myiamuser")
+	if err != nil {
+		t.Fatalf("generating a token failed - %v", err)
+	}
+	if !strings.HasPrefix(token, "mydb.xxxx.us-west-2.rds.amazonaws.com:5432?") {
+		t.Errorf("token = %q, want it for the configured Endpoint rather than the proxy of the DSN", token)
+	}
+}
+
+func TestRegionAndUsernameRequired(t *testing.T) {
+	for _, cfg := range []rdsiam.Config{{Region: "us-west-2"}, {Username: "myiamuser"}} {
+		if _, err := rdsiam.PasswordSource(cfg); err == nil {
+			t.Errorf("PasswordSource(%+v) succeeded, want an error", cfg)
+		}
+		if _, err := rdsiam.NewDriver(cfg); err == nil {
+			t.Errorf("NewDriver(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package shared
+
+import "context"
+
+func lockFile(ctx context.Context, path string) (func(), error) {
+	return nil, errLockUnsupported
+}
@@ -0,0 +1,251 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package shared coordinates the credential refreshes of many processes of the
+same application on a host, such as forking workers, so that only one of them
+fetches from the secret store at a time. The process that holds the file lock
+refreshes the wrapped provider and writes what it fetched to a snapshot file.
+The other processes read the snapshot file instead of calling the store, and
+a process that waited on the lock while another one refreshed reuses that
+refresh rather than running its own. Every write increments the generation
+kept in the snapshot file, which is how the processes tell a new snapshot
+from the one they read, whatever the resolution of the mtimes.
+
+The snapshot file holds the credentials in the clear and is created with 0600
+permissions. Please keep it on a local filesystem readable only by the user
+of the application, like a tmpfs under /run. In strict FIPS mode, where
+credentials must not be kept unencrypted, the provider refuses to read or
+write it and fails with an error matching gopqr.ErrNotFIPSApproved.
+
+Usage:
+	p, err := awssm.New(awssm.Config{...})
+	...
+	pqrDriver := &gopqr.Driver{
+		Provider: shared.New(p, shared.Config{Path: "/run/myapp/dbcreds.json"}),
+	}
+*/
+
+// DEFAULTCHECKINTERVAL - default interval at which the snapshot file is checked for changes
+const DEFAULTCHECKINTERVAL = time.Second
+
+// lockPollInterval is how often a process waiting on the file lock tries to
+// take it again.
+const lockPollInterval = 50 * time.Millisecond
+
+// Config holds the settings of the shared provider.
+type Config struct {
+	// Path - Path of the snapshot file. The lock file is Path with ".lock"
+	// appended.
+	Path string
+	// CheckInterval - How often Current checks the snapshot file for a
+	// refresh by another process, defaults to DEFAULTCHECKINTERVAL
+	CheckInterval time.Duration
+}
+
+// Provider wraps a gopqr.CredentialProvider and shares its credentials with
+// the other processes on the host through a snapshot file.
+type Provider struct {
+	inner         gopqr.CredentialProvider
+	path          string
+	lockPath      string
+	checkInterval time.Duration
+
+	mu         sync.Mutex
+	current    *gopqr.Credentials
+	generation uint64
+	checked    time.Time
+}
+
+// snapshot is the content of the snapshot file.
+type snapshot struct {
+	// Generation - Incremented by every write of the file, so that processes
+	// tell snapshots apart however coarse the mtimes of the filesystem are
+	Generation uint64 `json:"generation"`
+	// Credentials - The credentials fetched by the last refresh
+	Credentials *gopqr.Credentials `json:"credentials"`
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// New returns a Provider sharing the credentials of inner through the
+// snapshot file in cfg.
+func New(inner gopqr.CredentialProvider, cfg Config) *Provider {
+	if cfg.CheckInterval == 0 {
+		cfg.CheckInterval = DEFAULTCHECKINTERVAL
+	}
+	return &Provider{
+		inner:         inner,
+		path:          cfg.Path,
+		lockPath:      cfg.Path + ".lock",
+		checkInterval: cfg.CheckInterval,
+	}
+}
+
+// Current returns the credentials in the snapshot file, rereading it when it
+// has changed. When there is no snapshot yet, the credentials are refreshed
+// first.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	if gopqr.FIPSMode() {
+		return gopqr.Credentials{}, errPlaintextSnapshot
+	}
+	p.mu.Lock()
+	current := p.current
+	fresh := current != nil && time.Since(p.checked) < p.checkInterval
+	p.mu.Unlock()
+	if fresh {
+		return *current, nil
+	}
+	creds, err := p.load()
+	if err == nil {
+		return creds, nil
+	}
+	if !os.IsNotExist(err) {
+		return gopqr.Credentials{}, err
+	}
+	if err := p.Refresh(ctx); err != nil {
+		return gopqr.Credentials{}, err
+	}
+	return p.load()
+}
+
+// errPlaintextSnapshot is returned in strict FIPS mode, where the snapshot
+// file cannot be used.
+var errPlaintextSnapshot = fmt.Errorf("shared: the snapshot file holds the credentials unencrypted - %w", gopqr.ErrNotFIPSApproved)
+
+// load reads the snapshot file, keeping the credentials already read unless
+// its generation has changed.
+func (p *Provider) load() (gopqr.Credentials, error) {
+	snap, err := p.read()
+	if err != nil {
+		return gopqr.Credentials{}, err
+	}
+	return p.install(snap), nil
+}
+
+// install makes the credentials of the snapshot the current ones, unless
+// those of its generation are current already.
+func (p *Provider) install(snap snapshot) gopqr.Credentials {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checked = time.Now()
+	// the snapshots of older releases carry no generation
+	if p.current == nil || snap.Generation == 0 || snap.Generation != p.generation {
+		p.current = snap.Credentials
+		p.generation = snap.Generation
+	}
+	return *p.current
+}
+
+// read reads and parses the snapshot file. A file holding bare credentials,
+// as older releases wrote, is read as generation 0.
+func (p *Provider) read() (snapshot, error) {
+	b, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return snapshot{}, fmt.Errorf("failed to parse credentials snapshot %v - %w", p.path, err)
+	}
+	if snap.Credentials == nil {
+		var creds gopqr.Credentials
+		if err := json.Unmarshal(b, &creds); err != nil {
+			return snapshot{}, fmt.Errorf("failed to parse credentials snapshot %v - %w", p.path, err)
+		}
+		snap = snapshot{Credentials: &creds}
+	}
+	return snap, nil
+}
+
+// readGeneration returns the generation of the snapshot file, 0 when there is
+// none yet.
+func (p *Provider) readGeneration() (uint64, error) {
+	snap, err := p.read()
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return snap.Generation, err
+}
+
+// Refresh takes the file lock and refreshes the wrapped provider, writing
+// what it fetched to the snapshot file. When another process refreshed while
+// this one waited on the lock, its snapshot is used instead.
+func (p *Provider) Refresh(ctx context.Context) error {
+	if gopqr.FIPSMode() {
+		return errPlaintextSnapshot
+	}
+	requested, err := p.readGeneration()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(ctx, p.lockPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	latest, err := p.readGeneration()
+	if err != nil {
+		return err
+	}
+	// a snapshot written after this refresh was requested is as fresh as
+	// this one would be
+	if latest > requested {
+		_, err := p.load()
+		return err
+	}
+	if err := p.inner.Refresh(ctx); err != nil {
+		return err
+	}
+	creds, err := p.inner.Current(ctx)
+	if err != nil {
+		return err
+	}
+	return p.write(snapshot{Generation: latest + 1, Credentials: &creds})
+}
+
+// write replaces the snapshot file with the snapshot.
+func (p *Provider) write(snap snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p.path), filepath.Base(p.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write credentials snapshot - %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials snapshot - %w", err)
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials snapshot - %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write credentials snapshot - %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p.path); err != nil {
+		return fmt.Errorf("failed to write credentials snapshot - %w", err)
+	}
+	p.install(snap)
+	return nil
+}
+
+// errLockUnsupported is returned on platforms without flock.
+var errLockUnsupported = errors.New("File lock coordination is not supported on this platform")
@@ -0,0 +1,143 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package shared_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/shared"
+	"github.com/chandranarreddy/gopqr/testsupport"
+)
+
+// countingStore counts the refreshes of the secret store it wraps, standing
+// in for a store that is metered or rate limited.
+type countingStore struct {
+	*testsupport.SecretStore
+	delay     time.Duration
+	refreshes atomic.Int32
+}
+
+func (s *countingStore) Refresh(ctx context.Context) error {
+	s.refreshes.Add(1)
+	time.Sleep(s.delay)
+	return s.SecretStore.Refresh(ctx)
+}
+
+func newStore(password string) *countingStore {
+	return &countingStore{SecretStore: testsupport.NewSecretStore(gopqr.Secret{
+		OddUsername: "app_odd", OddPassword: password,
+		EvenUsername: "app_even", EvenPassword: "even-pw",
+		ActiveCredential: "odd",
+	})}
+}
+
+func TestSecondProcessReadsSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbcreds.json")
+	first, second := newStore("odd-pw"), newStore("stale")
+	p1 := shared.New(first, shared.Config{Path: path})
+	p2 := shared.New(second, shared.Config{Path: path, CheckInterval: time.Nanosecond})
+	ctx := context.Background()
+
+	if _, err := p1.Current(ctx); err != nil {
+		t.Fatalf("Current of the first process failed - %v", err)
+	}
+	creds, err := p2.Current(ctx)
+	if err != nil {
+		t.Fatalf("Current of the second process failed - %v", err)
+	}
+	if creds.Slots[0].Password != "odd-pw" {
+		t.Errorf("password read by the second process = %q, want the one in the snapshot", creds.Slots[0].Password)
+	}
+	if n := second.refreshes.Load(); n != 0 {
+		t.Errorf("second process refreshed its store %v times, want it to read the snapshot", n)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("snapshot file permissions = %o, want 0600", perm)
+	}
+
+	// a refresh of the first process reaches the second once it checks
+	first.Put(ctx, &gopqr.Secret{OddUsername: "app_odd", OddPassword: "rotated", EvenUsername: "app_even", EvenPassword: "even-pw", ActiveCredential: "odd"})
+	if err := p1.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed - %v", err)
+	}
+	if creds, _ := p2.Current(ctx); creds.Slots[0].Password != "rotated" {
+		t.Errorf("password read by the second process = %q after the refresh, want rotated", creds.Slots[0].Password)
+	}
+}
+
+func TestConcurrentRefreshesCoalesce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbcreds.json")
+	var stores []*countingStore
+	var providers []*shared.Provider
+	for i := 0; i < 8; i++ {
+		store := newStore("odd-pw")
+		store.delay = 100 * time.Millisecond
+		stores = append(stores, store)
+		providers = append(providers, shared.New(store, shared.Config{Path: path}))
+	}
+	var wg sync.WaitGroup
+	for _, p := range providers {
+		wg.Add(1)
+		go func(p *shared.Provider) {
+			defer wg.Done()
+			if err := p.Refresh(context.Background()); err != nil {
+				t.Errorf("Refresh failed - %v", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+	var total int32
+	for _, store := range stores {
+		total += store.refreshes.Load()
+	}
+	if total != 1 {
+		t.Errorf("the store was refreshed %v times, want the refreshes that waited on the lock to reuse the first", total)
+	}
+}
+
+func TestReadsBareCredentialsSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbcreds.json")
+	b, err := json.Marshal(gopqr.Credentials{Slots: []gopqr.Credential{{Name: "odd", Username: "app_odd", Password: "odd-pw"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := newStore("other")
+	creds, err := shared.New(store, shared.Config{Path: path}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if len(creds.Slots) != 1 || creds.Slots[0].Password != "odd-pw" || store.refreshes.Load() != 0 {
+		t.Errorf("Current = %+v, want the credentials of the snapshot of an older release", creds)
+	}
+}
+
+func TestFIPSRefusesSnapshot(t *testing.T) {
+	if err := gopqr.SetFIPSMode(true); err != nil {
+		t.Fatal(err)
+	}
+	defer gopqr.SetFIPSMode(false)
+	p := shared.New(newStore("odd-pw"), shared.Config{Path: filepath.Join(t.TempDir(), "dbcreds.json")})
+	if _, err := p.Current(context.Background()); !errors.Is(err, gopqr.ErrNotFIPSApproved) {
+		t.Errorf("Current in strict FIPS mode = %v, want ErrNotFIPSApproved", err)
+	}
+	if err := p.Refresh(context.Background()); !errors.Is(err, gopqr.ErrNotFIPSApproved) {
+		t.Errorf("Refresh in strict FIPS mode = %v, want ErrNotFIPSApproved", err)
+	}
+}
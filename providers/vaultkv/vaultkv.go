@@ -0,0 +1,293 @@
+package vaultkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package vaultkv sources the rotating credentials for github.com/chandranarreddy/gopqr
+from a HashiCorp Vault KV version 2 secret. Rather than a document holding
+both credentials, every version of the secret holds a single credential -
+	{
+		"username": "myUserName",
+		"password": "myPassword"
+	}
+
+and the provider reads the latest version N along with version N-1 of the same
+path, mapping them to the "current" and "previous" slots of the driver. A
+rotator simply writes a new version, and the credential it replaces stays
+around as the fallback until the version after. This gives the same safety
+as the AWSCURRENT and AWSPREVIOUS staging labels of AWS Secrets Manager
+without a custom secret layout.
+
+Usage:
+	p, err := vaultkv.New(vaultkv.Config{
+		Mount: "secret",
+		Path:  "postgres/myapp",
+	})
+	...
+	pqrDriver, err := p.NewDriver(ctx, logger)
+	...
+	sql.Register("postgresrotating", pqrDriver)
+
+The Provider also implements gopqr.CredentialProvider, so it can be set as
+the Provider of a driver instead -
+	pqrDriver := &gopqr.Driver{Provider: p}
+*/
+
+const (
+	//DEFAULTMOUNT - default mount path of the KV version 2 secrets engine
+	DEFAULTMOUNT = "secret"
+	//DEFAULTUSERNAMEKEY - default key of the username within a version
+	DEFAULTUSERNAMEKEY = "username"
+	//DEFAULTPASSWORDKEY - default key of the password within a version
+	DEFAULTPASSWORDKEY = "password"
+	//DEFAULTTIMEOUT - default deadline for a refresh triggered by the driver
+	DEFAULTTIMEOUT = time.Minute
+	//CURRENTSLOT - name of the slot holding the latest version
+	CURRENTSLOT = "current"
+	//PREVIOUSSLOT - name of the slot holding the version before the latest
+	PREVIOUSSLOT = "previous"
+)
+
+// Config holds the settings of the Vault KV provider.
+type Config struct {
+	// Client - Vault client to use. Leave nil to build one from the usual
+	// VAULT_ADDR, VAULT_TOKEN and related environment variables.
+	Client *vault.Client
+	// Mount - Mount path of the KV version 2 secrets engine, defaults to DEFAULTMOUNT
+	Mount string
+	// Path - Path of the secret within the mount
+	Path string
+	// UsernameKey - Key of the username within a version, defaults to DEFAULTUSERNAMEKEY
+	UsernameKey string
+	// PasswordKey - Key of the password within a version, defaults to DEFAULTPASSWORDKEY
+	PasswordKey string
+	// PreferPrevious - When set, version N-1 is the active credential and the
+	// latest version is only tried when it fails authentication. Set it when
+	// the rotator writes the new version before it has changed the password
+	// in the database, the way AWSPENDING is used.
+	PreferPrevious bool
+	// Timeout - Deadline for each refresh invoked by the driver, defaults to DEFAULTTIMEOUT
+	Timeout time.Duration
+	// NegativeCacheTTL - How long a not found secret is remembered before the
+	// store is asked again, defaults to providers.DEFAULTNEGATIVECACHETTL. A
+	// negative value turns negative caching off.
+	NegativeCacheTTL time.Duration
+	// OnNotFound func is invoked with the gopqr.SecretNotFoundError when the
+	// store reports that the secret does not exist, so that misconfiguration
+	// can be flagged right away.
+	OnNotFound func(error)
+}
+
+// Provider fetches the two latest versions of a Vault KV version 2 secret.
+type Provider struct {
+	kv             *vault.KVv2
+	path           string
+	usernameKey    string
+	passwordKey    string
+	preferPrevious bool
+	timeout        time.Duration
+
+	negative   providers.NegativeCache
+	onNotFound func(error)
+
+	mu      sync.Mutex
+	current *gopqr.Secret
+}
+
+var _ gopqr.CredentialProvider = (*Provider)(nil)
+
+// init registers the provider type "vaultkv" of gopqr.LoadConfig, whose params
+// are the fields of Config.
+func init() {
+	gopqr.RegisterProviderType("vaultkv", gopqr.ConfigProvider(New))
+}
+
+// New returns a Provider for the configured secret.
+func New(cfg Config) (*Provider, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("Path is required for the Vault KV provider")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = DEFAULTMOUNT
+	}
+	if cfg.UsernameKey == "" {
+		cfg.UsernameKey = DEFAULTUSERNAMEKEY
+	}
+	if cfg.PasswordKey == "" {
+		cfg.PasswordKey = DEFAULTPASSWORDKEY
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DEFAULTTIMEOUT
+	}
+	client := cfg.Client
+	if client == nil {
+		var err error
+		client, err = vault.NewClient(vault.DefaultConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Vault client - %w", err)
+		}
+	}
+	return &Provider{
+		kv:             client.KVv2(cfg.Mount),
+		path:           cfg.Path,
+		usernameKey:    cfg.UsernameKey,
+		passwordKey:    cfg.PasswordKey,
+		preferPrevious: cfg.PreferPrevious,
+		timeout:        cfg.Timeout,
+
+		negative:   providers.NegativeCache{TTL: cfg.NegativeCacheTTL},
+		onNotFound: cfg.OnNotFound,
+	}, nil
+}
+
+// Fetch reads the latest version of the secret and the version before it,
+// and returns them as the "current" and "previous" slots of a secret. When
+// there is no usable previous version, like right after the secret was
+// created, the secret only has the "current" slot. A secret that does not
+// exist results in a *gopqr.SecretNotFoundError, which is remembered for the
+// NegativeCacheTTL.
+func (p *Provider) Fetch(ctx context.Context) (*gopqr.Secret, error) {
+	if err := p.negative.Check(); err != nil {
+		return nil, err
+	}
+	latest, err := p.kv.Get(ctx, p.path)
+	if err != nil {
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			return nil, p.notFound(err)
+		}
+		return nil, fmt.Errorf("failed to fetch secret %v from Vault - %w", p.path, err)
+	}
+	p.negative.Observe(nil)
+	current, err := p.credential(CURRENTSLOT, latest)
+	if err != nil {
+		return nil, err
+	}
+	s := &gopqr.Secret{
+		Slots:            []gopqr.SecretSlot{current},
+		ActiveCredential: CURRENTSLOT,
+	}
+	if latest.VersionMetadata == nil || latest.VersionMetadata.Version <= 1 {
+		return s, nil
+	}
+	prev, err := p.kv.GetVersion(ctx, p.path, latest.VersionMetadata.Version-1)
+	if err != nil {
+		// deleted and destroyed versions read as not found
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to fetch version %v of secret %v from Vault - %w", latest.VersionMetadata.Version-1, p.path, err)
+	}
+	previous, err := p.credential(PREVIOUSSLOT, prev)
+	if err != nil {
+		return nil, err
+	}
+	s.Slots = append(s.Slots, previous)
+	if p.preferPrevious {
+		s.ActiveCredential = PREVIOUSSLOT
+	}
+	return s, nil
+}
+
+// credential reads the username and password out of a version of the
+// secret, along with the version and when it was written.
+func (p *Provider) credential(name string, secret *vault.KVSecret) (gopqr.SecretSlot, error) {
+	username, _ := secret.Data[p.usernameKey].(string)
+	password, _ := secret.Data[p.passwordKey].(string)
+	version := 0
+	var created time.Time
+	if secret.VersionMetadata != nil {
+		version = secret.VersionMetadata.Version
+		created = secret.VersionMetadata.CreatedTime
+	}
+	if username == "" || password == "" {
+		return gopqr.SecretSlot{}, fmt.Errorf("version %v of secret %v in Vault has no %v or %v", version, p.path, p.usernameKey, p.passwordKey)
+	}
+	return gopqr.SecretSlot{
+		Name:      name,
+		Username:  username,
+		Password:  password,
+		ValidFrom: created,
+		Version:   strconv.Itoa(version),
+	}, nil
+}
+
+func (p *Provider) notFound(err error) error {
+	nf := &gopqr.SecretNotFoundError{Source: "Vault", ID: p.path, Err: err}
+	p.negative.Observe(nf)
+	if p.onNotFound != nil {
+		p.onNotFound(nf)
+	}
+	return nf
+}
+
+// Current returns the credentials fetched by the last Refresh, fetching them
+// first if they have not been fetched yet.
+func (p *Provider) Current(ctx context.Context) (gopqr.Credentials, error) {
+	p.mu.Lock()
+	s := p.current
+	p.mu.Unlock()
+	if s == nil {
+		if err := p.Refresh(ctx); err != nil {
+			return gopqr.Credentials{}, err
+		}
+		p.mu.Lock()
+		s = p.current
+		p.mu.Unlock()
+	}
+	return s.Credentials(), nil
+}
+
+// Refresh fetches the secret from Vault.
+func (p *Provider) Refresh(ctx context.Context) error {
+	s, err := p.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.current = s
+	p.mu.Unlock()
+	return nil
+}
+
+// NewDriver fetches the secret and returns a sticky gopqr driver sourcing
+// its credentials from the provider. The notices of the driver, like failed
+// refreshes, are written to the logger.
+func (p *Provider) NewDriver(ctx context.Context, logger *log.Logger) (*gopqr.Driver, error) {
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return gopqr.NewDriver(gopqr.Config{Provider: p, Logger: logger})
+}
+
+// Refresher returns a CredentialRefresher func that refetches the secret
+// from Vault and resets the credentials on the driver. Failures are reported
+// to the logger, if one is supplied, and leave the driver as is.
+func (p *Provider) Refresher(logger *log.Logger) func(*gopqr.Driver) {
+	return func(pqrDriver *gopqr.Driver) {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+		s, err := p.Fetch(ctx)
+		if err != nil {
+			if logger != nil {
+				logger.Print(fmt.Errorf("refreshing DB secret from Vault failed - %w", err))
+			}
+			return
+		}
+		s.Apply(pqrDriver)
+		return
+	}
+}
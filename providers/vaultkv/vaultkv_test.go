@@ -0,0 +1,155 @@
+package vaultkv_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/providers/vaultkv"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// fakeKV serves the versions of a single secret of a KV version 2 mount
+// over the Vault HTTP API.
+type fakeKV struct {
+	mu       sync.Mutex
+	versions []map[string]interface{}
+	destroy  map[int]bool
+	requests int
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.requests++
+	if r.URL.Path != "/v1/secret/data/postgres/myapp" || len(kv.versions) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+		return
+	}
+	version := len(kv.versions)
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, _ = strconv.Atoi(v)
+	}
+	if version < 1 || version > len(kv.versions) || kv.destroy[version] {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{
+			"data": kv.versions[version-1],
+			"metadata": map[string]interface{}{
+				"version":      version,
+				"created_time": time.Date(2024, 1, version, 0, 0, 0, 0, time.UTC).Format(time.RFC3339Nano),
+			},
+		},
+	})
+}
+
+func newProvider(t *testing.T, kv *fakeKV, cfg vaultkv.Config) *vaultkv.Provider {
+	t.Helper()
+	srv := httptest.NewServer(kv)
+	t.Cleanup(srv.Close)
+	config := vault.DefaultConfig()
+	config.Address = srv.URL
+	client, err := vault.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("test-token")
+	cfg.Client, cfg.Path = client, "postgres/myapp"
+	p, err := vaultkv.New(cfg)
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	return p
+}
+
+func version(username, password string) map[string]interface{} {
+	return map[string]interface{}{"username": username, "password": password}
+}
+
+func TestFetchLatestTwoVersions(t *testing.T) {
+	kv := &fakeKV{versions: []map[string]interface{}{version("app_v1", "pw-1"), version("app_v2", "pw-2"), version("app_v3", "pw-3")}}
+	p := newProvider(t, kv, vaultkv.Config{})
+	s, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed - %v", err)
+	}
+	if len(s.Slots) != 2 {
+		t.Fatalf("slots = %+v, want the current and previous versions", s.Slots)
+	}
+	current, previous := s.Slots[0], s.Slots[1]
+	if current.Name != vaultkv.CURRENTSLOT || current.Username != "app_v3" || current.Password != "pw-3" || current.Version != "3" {
+		t.Errorf("current slot = %+v, want version 3", current)
+	}
+	if previous.Name != vaultkv.PREVIOUSSLOT || previous.Username != "app_v2" || previous.Version != "2" {
+		t.Errorf("previous slot = %+v, want version 2", previous)
+	}
+	if !current.ValidFrom.Equal(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ValidFrom = %v, want the created time of version 3", current.ValidFrom)
+	}
+	if s.ActiveCredential != vaultkv.CURRENTSLOT {
+		t.Errorf("ActiveCredential = %q, want %q", s.ActiveCredential, vaultkv.CURRENTSLOT)
+	}
+}
+
+func TestFetchPreferPrevious(t *testing.T) {
+	kv := &fakeKV{versions: []map[string]interface{}{version("app_v1", "pw-1"), version("app_v2", "pw-2")}}
+	creds, err := newProvider(t, kv, vaultkv.Config{PreferPrevious: true}).Current(context.Background())
+	if err != nil {
+		t.Fatalf("Current failed - %v", err)
+	}
+	if active := creds.Slots[creds.Active]; active.Username != "app_v1" {
+		t.Errorf("active credential = %+v, want version 1", active)
+	}
+}
+
+func TestFetchWithoutPreviousVersion(t *testing.T) {
+	for name, kv := range map[string]*fakeKV{
+		"first version":     {versions: []map[string]interface{}{version("app_v1", "pw-1")}},
+		"destroyed version": {versions: []map[string]interface{}{version("app_v1", "pw-1"), version("app_v2", "pw-2")}, destroy: map[int]bool{1: true}},
+	} {
+		s, err := newProvider(t, kv, vaultkv.Config{}).Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("Fetch of the %v failed - %v", name, err)
+		}
+		if len(s.Slots) != 1 || s.Slots[0].Name != vaultkv.CURRENTSLOT {
+			t.Errorf("slots of the %v = %+v, want only the current slot", name, s.Slots)
+		}
+	}
+}
+
+func TestFetchVersionWithoutPassword(t *testing.T) {
+	kv := &fakeKV{versions: []map[string]interface{}{{"username": "app_v1"}}}
+	if _, err := newProvider(t, kv, vaultkv.Config{}).Fetch(context.Background()); err == nil {
+		t.Error("Fetch of a version without a password succeeded, want an error")
+	}
+}
+
+func TestMissingSecretIsNotFoundAndCached(t *testing.T) {
+	kv := &fakeKV{}
+	var reported []error
+	p := newProvider(t, kv, vaultkv.Config{OnNotFound: func(err error) { reported = append(reported, err) }})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := p.Fetch(ctx); !errors.Is(err, gopqr.ErrSecretNotFound) {
+			t.Fatalf("Fetch of a missing secret = %v, want ErrSecretNotFound", err)
+		}
+	}
+	if kv.requests != 1 {
+		t.Errorf("%v requests to Vault, want the second fetch answered by the negative cache", kv.requests)
+	}
+	if len(reported) != 1 {
+		t.Errorf("OnNotFound called %v times, want once", len(reported))
+	}
+}
@@ -0,0 +1,85 @@
+package gopqr_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+	"github.com/lib/pq"
+)
+
+// countingProvider signals every Refresh of the provider it wraps.
+type countingProvider struct {
+	gopqr.CredentialProvider
+	refreshed chan struct{}
+}
+
+func (p *countingProvider) Refresh(ctx context.Context) error {
+	p.refreshed <- struct{}{}
+	return p.CredentialProvider.Refresh(ctx)
+}
+
+func TestRDSProxyAuthFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		rdsProxy     bool
+		codes        []string
+		code         pq.ErrorCode
+		wantFallback bool
+	}{
+		{"proxy 28000", true, nil, "28000", false},
+		{"proxy 28P01", true, nil, "28P01", true},
+		{"proxy 28000 listed", true, []string{"28000"}, "28000", true},
+		{"no proxy 28000", false, nil, "28000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := gopqrtest.NewBackend()
+			backend.Allow("app_odd", "odd-pw")
+			backend.Allow("app_even", "even-pw")
+			refused := &pq.Error{Severity: "FATAL", Code: tt.code, Message: "refused by the proxy"}
+			backend.FailNext(refused)
+			d, store := testDriver(backend)
+			provider := &countingProvider{CredentialProvider: store, refreshed: make(chan struct{}, 8)}
+			d.Provider = provider
+			d.RDSProxy = tt.rdsProxy
+			d.AuthFailureCodes = tt.codes
+
+			conn, err := d.Open(testDSN)
+			attempts := backend.Attempts()
+			if tt.wantFallback {
+				if err != nil {
+					t.Fatalf("Open failed, want it to fall back - %v", err)
+				}
+				conn.Close()
+				if len(attempts) != 2 || attempts[1].Username != "app_even" {
+					t.Errorf("attempts = %+v, want a fallback to app_even", attempts)
+				}
+				select {
+				case <-provider.refreshed:
+				case <-time.After(5 * time.Second):
+					t.Errorf("no refresh after %v", tt.code)
+				}
+				return
+			}
+			var pqErr *pq.Error
+			if !errors.As(err, &pqErr) || pqErr.Code != tt.code {
+				t.Fatalf("Open = %v, want the %v of the proxy", err, tt.code)
+			}
+			if errors.Is(err, gopqr.ErrAllCredentialsFailed) {
+				t.Errorf("Open = %v, want no credential reported as failed", err)
+			}
+			if len(attempts) != 1 {
+				t.Errorf("attempts = %+v, want no fallback", attempts)
+			}
+			select {
+			case <-provider.refreshed:
+				t.Errorf("refreshed after %v", tt.code)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
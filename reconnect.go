@@ -0,0 +1,51 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// ForceReconnect makes every connection of the database, which must have
+// been opened over a connector of a gopqr driver, like with sql.Open, OpenDB
+// or a MultiDriver, be established afresh with the current credentials.
+// Connections of the database are retired, so that those in use are closed
+// as soon as they are returned to the pool and idle ones as soon as the pool
+// would hand them out. Other databases over the same driver keep theirs.
+// This is meant for operators who need the old credentials out of use now,
+// like after revoking them.
+//
+// The connector of the database is found through one of its connections,
+// so one is opened when none is idle, and its error is returned if that
+// fails.
+func ForceReconnect(db *sql.DB) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		c := rotatingConnOf(driverConn)
+		if c == nil || c.reconnects == nil {
+			return errors.New("Database is not opened over a connector of a gopqr driver")
+		}
+		c.reconnects.Add(1)
+		return nil
+	})
+}
+
+// rotatingConnOf returns the connection of gopqr the driverConn is or wraps,
+// nil when there is none.
+func rotatingConnOf(driverConn any) *rotatingConn {
+	for {
+		switch c := driverConn.(type) {
+		case *rotatingConn:
+			return c
+		case interface{ Unwrap() driver.Conn }:
+			driverConn = c.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package gopqr_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/gopqrtest"
+)
+
+// opens returns the connections the backend opened.
+func opens(backend *gopqrtest.Backend) int {
+	n := 0
+	for _, a := range backend.Attempts() {
+		if a.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestForceReconnectScopedToDatabase(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	d, _ := testDriver(backend)
+	forced, other := gopqr.OpenDB(testDSN, d), gopqr.OpenDB(testDSN, d)
+	defer forced.Close()
+	defer other.Close()
+	ctx := context.Background()
+	for _, db := range []*sql.DB{forced, other} {
+		if err := db.PingContext(ctx); err != nil {
+			t.Fatalf("Ping failed - %v", err)
+		}
+	}
+	before := opens(backend)
+	if err := gopqr.ForceReconnect(forced); err != nil {
+		t.Fatalf("ForceReconnect failed - %v", err)
+	}
+	if err := other.PingContext(ctx); err != nil {
+		t.Fatalf("Ping failed - %v", err)
+	}
+	if n := opens(backend); n != before {
+		t.Errorf("%v connections opened for the other database, want its idle one reused", n-before)
+	}
+	if err := forced.PingContext(ctx); err != nil {
+		t.Fatalf("Ping failed - %v", err)
+	}
+	if n := opens(backend); n != before+1 {
+		t.Errorf("%v connections opened after ForceReconnect, want 1", n-before)
+	}
+}
+
+func TestForceReconnectMultiDriver(t *testing.T) {
+	backend := gopqrtest.NewBackend()
+	backend.Allow("app_odd", "odd-pw")
+	d, _ := testDriver(backend)
+	m := &gopqr.MultiDriver{Default: d}
+	connector, err := m.OpenConnector(testDSN)
+	if err != nil {
+		t.Fatalf("OpenConnector failed - %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Ping failed - %v", err)
+	}
+	before := opens(backend)
+	if err := gopqr.ForceReconnect(db); err != nil {
+		t.Fatalf("ForceReconnect of a MultiDriver database failed - %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Ping failed - %v", err)
+	}
+	if n := opens(backend); n != before+1 {
+		t.Errorf("%v connections opened after ForceReconnect, want 1", n-before)
+	}
+}
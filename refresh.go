@@ -0,0 +1,105 @@
+package gopqr
+
+import (
+	"context"
+	"sync"
+)
+
+// refreshFlight coalesces concurrent refreshes of the credentials. When many
+// connections fail authentication at once, each Open would otherwise run its
+// own refresh and hammer the secret store. Opens waiting for the refresh in
+// flight are all released together once it finishes.
+type refreshFlight struct {
+	mu   sync.Mutex
+	call *refreshCall
+	// waiting - Opens waiting for the call to finish
+	waiting int
+}
+
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// begin registers a refresh as in flight and reports whether the caller is
+// to run it, see run, or the refresh returned is already in flight. A
+// refresh run from a goroutine is registered before the goroutine starts,
+// so that wait sees it right away.
+func (f *refreshFlight) begin() (*refreshCall, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.call != nil {
+		return f.call, false
+	}
+	f.call = &refreshCall{done: make(chan struct{})}
+	return f.call, true
+}
+
+// run runs fn as the refresh begin registered, and releases those waiting
+// for it once it returns.
+func (f *refreshFlight) run(c *refreshCall, fn func() error) error {
+	defer func() {
+		f.mu.Lock()
+		f.call = nil
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	c.err = fn()
+	return c.err
+}
+
+// do runs fn unless a run is already in flight, in which case it waits for
+// that run and returns its outcome instead.
+func (f *refreshFlight) do(fn func() error) error {
+	c, leader := f.begin()
+	if !leader {
+		<-c.done
+		return c.err
+	}
+	return f.run(c, fn)
+}
+
+// wait blocks until the refresh in flight, if any, has finished, or until
+// the context is done. Waiters are released all at once, in no particular
+// order.
+func (f *refreshFlight) wait(ctx context.Context) error {
+	f.mu.Lock()
+	c := f.call
+	if c == nil {
+		f.mu.Unlock()
+		return nil
+	}
+	f.waiting++
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.waiting--
+		f.mu.Unlock()
+	}()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// depth returns the number of Opens waiting for the refresh in flight.
+func (f *refreshFlight) depth() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.waiting
+}
+
+// inFlight reports whether a refresh is in flight.
+func (f *refreshFlight) inFlight() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.call != nil
+}
+
+// RefreshQueueDepth returns the number of Opens waiting for the refresh in
+// flight to finish, see WaitForRefresh.
+func (d *Driver) RefreshQueueDepth() int {
+	return d.refresh.depth()
+}
@@ -0,0 +1,95 @@
+package gopqr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshFlightSharesRun(t *testing.T) {
+	var f refreshFlight
+	var runs atomic.Int32
+	release := make(chan struct{})
+	failed := errors.New("refresh failed")
+	fn := func() error {
+		runs.Add(1)
+		<-release
+		return failed
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.do(fn)
+		}()
+	}
+	for !f.inFlight() {
+		time.Sleep(time.Millisecond)
+	}
+	// let the others queue up behind the run in flight
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := runs.Load(); n != 1 {
+		t.Errorf("fn ran %v times, want once", n)
+	}
+	for i, err := range errs {
+		if err != failed {
+			t.Errorf("do %v = %v, want the error of the shared run", i, err)
+		}
+	}
+}
+
+func TestRefreshFlightWaitSeesRegisteredRun(t *testing.T) {
+	var f refreshFlight
+	c, leader := f.begin()
+	if !leader {
+		t.Fatal("begin on an idle flight did not lead")
+	}
+	// the run is registered but has not started, like a background
+	// refresh whose goroutine is not scheduled yet
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait = %v, want it to wait for the registered run", err)
+	}
+	if _, leader := f.begin(); leader {
+		t.Fatal("begin led a second run while one was registered")
+	}
+
+	waited := make(chan error)
+	go func() { waited <- f.wait(context.Background()) }()
+	for f.depth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	f.run(c, func() error { return nil })
+	if err := <-waited; err != nil {
+		t.Errorf("wait = %v once the run finished, want nil", err)
+	}
+	if d := f.depth(); d != 0 {
+		t.Errorf("depth = %v once the run finished, want 0", d)
+	}
+}
+
+func TestStartRefreshIsSeenByClose(t *testing.T) {
+	release := make(chan struct{})
+	d := &Driver{Provider: FromRefreshFunc(func(ctx context.Context) (Credential, Credential, string, error) {
+		<-release
+		return Credential{Username: "u", Password: "p"}, Credential{Username: "v", Password: "q"}, "odd", nil
+	})}
+	d.startRefresh()
+	if !d.refresh.inFlight() {
+		t.Fatal("refresh not in flight as startRefresh returns")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close = %v while the refresh runs, want it to wait for the refresh", err)
+	}
+	close(release)
+}
@@ -0,0 +1,65 @@
+package gopqr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RefreshFunc fetches the latest odd and even credential and names the
+// active one, "odd" or "even". Unlike a CredentialRefresher, it does not get
+// the driver to change, so there is no lock to take or to forget releasing -
+// the driver installs what it returns under its own lock. On error the
+// driver keeps the credentials it has, and the refresh fails with the error.
+type RefreshFunc func(ctx context.Context) (odd, even Credential, active string, err error)
+
+// FromRefreshFunc adapts a RefreshFunc to a CredentialProvider -
+//
+//	pqrDriver := &gopqr.Driver{Provider: gopqr.FromRefreshFunc(fetch)}
+//
+// The func is invoked on every Refresh, and once by the first Current, and
+// never twice at once. Current hands out what it last returned.
+func FromRefreshFunc(fn RefreshFunc) CredentialProvider {
+	return &refreshFuncProvider{fn: fn}
+}
+
+type refreshFuncProvider struct {
+	fn RefreshFunc
+	// calls - Held while the func runs
+	calls sync.Mutex
+
+	mu      sync.Mutex
+	current *Credentials
+}
+
+func (p *refreshFuncProvider) Current(ctx context.Context) (Credentials, error) {
+	p.mu.Lock()
+	c := p.current
+	p.mu.Unlock()
+	if c == nil {
+		if err := p.Refresh(ctx); err != nil {
+			return Credentials{}, err
+		}
+		p.mu.Lock()
+		c = p.current
+		p.mu.Unlock()
+	}
+	return *c, nil
+}
+
+func (p *refreshFuncProvider) Refresh(ctx context.Context) error {
+	p.calls.Lock()
+	defer p.calls.Unlock()
+	odd, even, active, err := p.fn(ctx)
+	if err != nil {
+		return err
+	}
+	if active != oddCredential.String() && active != evenCredential.String() {
+		return fmt.Errorf("The refresh func returned %q as the active credential, it must be %q or %q", active, oddCredential, evenCredential)
+	}
+	c := OddEven(odd, even, active)
+	p.mu.Lock()
+	p.current = &c
+	p.mu.Unlock()
+	return nil
+}
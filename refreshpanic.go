@@ -0,0 +1,38 @@
+package gopqr
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+)
+
+// ErrRefreshPanicked is matched by errors.Is when the provider or the
+// CredentialRefresher panicked during a refresh. The panic is recovered and
+// the credentials in use before the refresh stay in use.
+var ErrRefreshPanicked = errors.New("Refresh of the credentials panicked")
+
+// recovered returns fn turning a panic into an error matching
+// ErrRefreshPanicked, so that a panic in code of the user does not crash
+// the process from a goroutine spawned by Open. A CredentialRefresher that
+// panicked between AcquireLock and ReleaseLock would leave the lock held and
+// every rotation and refresh after it blocked, so the lock is released
+// then, without publishing what the refresher set.
+func (d *Driver) recovered(fn func() error) func() error {
+	return func() (err error) {
+		d.mux.tracking.Add(1)
+		defer d.mux.tracking.Add(-1)
+		defer func() {
+			if p := recover(); p != nil {
+				d.logf("credential refresh panicked - %v\n%s", p, debug.Stack())
+				d.event(slog.LevelError, "credential refresh panicked", "panic", fmt.Sprint(p))
+				if d.mux.heldBy(goroutineID()) {
+					d.mux.release()
+					d.logf("credential lock held by the panicking refresh was force-released, its changes are not published")
+				}
+				err = fmt.Errorf("%w - %v", ErrRefreshPanicked, p)
+			}
+		}()
+		return fn()
+	}
+}
@@ -0,0 +1,49 @@
+package gopqr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrRefreshTimeout is matched by errors.Is when a refresh of the
+// credentials did not finish within the RefreshTimeout of the driver. The
+// credentials in use before the refresh stay in use.
+var ErrRefreshTimeout = errors.New("Refresh of the credentials timed out")
+
+// refreshContext returns the context a refresh runs with, bounded by the
+// RefreshTimeout of the driver when it is set and cancelled by Close.
+func (d *Driver) refreshContext() (context.Context, context.CancelFunc) {
+	timeout := d.refreshTimeout()
+	if timeout <= 0 {
+		return context.WithCancel(d.lifetime())
+	}
+	return context.WithTimeout(d.lifetime(), timeout)
+}
+
+// withinDeadline runs fn, giving up on it with ErrRefreshTimeout once the
+// context is done. fn keeps running on its own goroutine until it returns,
+// as a provider or refresher ignoring the context cannot be stopped, but
+// the refresh in flight is over and the Opens waiting on it are released.
+// A panic of fn is returned as an error matching ErrRefreshPanicked.
+func (d *Driver) withinDeadline(ctx context.Context, fn func() error) error {
+	fn = d.recovered(fn)
+	if d.refreshTimeout() <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return d.refreshTimedOut(err)
+		}
+		return err
+	case <-ctx.Done():
+		return d.refreshTimedOut(ctx.Err())
+	}
+}
+
+func (d *Driver) refreshTimedOut(cause error) error {
+	return fmt.Errorf("%w after %v - %w", ErrRefreshTimeout, d.refreshTimeout(), cause)
+}
@@ -0,0 +1,98 @@
+package gopqr
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ErrDriverRegistered is matched by errors.Is when Register is given a name
+// that a driver is already registered under.
+var ErrDriverRegistered = errors.New("A driver is already registered under the name")
+
+// registerMu serializes the registrations made through gopqr, so that the
+// check for a registered name and the registration happen at once.
+var (
+	registerMu     sync.Mutex
+	registerSuffix uint64
+)
+
+// Register registers the driver with database/sql under the name, like
+// sql.Register, but returns an error matching ErrDriverRegistered instead of
+// panicking when the name is taken. Consider OpenDB, which needs no name at
+// all.
+func Register(name string, d *Driver) error {
+	if d == nil {
+		return errors.New("Register needs a driver")
+	}
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	return register(name, d)
+}
+
+// MustRegister is Register panicking on failure, for package init.
+func MustRegister(name string, d *Driver) {
+	if err := Register(name, d); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterMulti registers the MultiDriver with database/sql under the name,
+// like Register.
+func RegisterMulti(name string, m *MultiDriver) error {
+	if m == nil {
+		return errors.New("RegisterMulti needs a driver")
+	}
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	return register(name, m)
+}
+
+// MustRegisterMulti is RegisterMulti panicking on failure, for package init.
+func MustRegisterMulti(name string, m *MultiDriver) {
+	if err := RegisterMulti(name, m); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterUnique registers the driver under the prefix followed by a number
+// that makes the name unique, and returns the name. It is meant for tests
+// that build many drivers -
+//
+//	db, err := sql.Open(gopqr.RegisterUnique("postgresrotating", pqrDriver), dsn)
+func RegisterUnique(prefix string, d *Driver) string {
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	for {
+		registerSuffix++
+		name := prefix + "-" + strconv.FormatUint(registerSuffix, 10)
+		err := register(name, d)
+		if err == nil {
+			return name
+		}
+		if !errors.Is(err, ErrDriverRegistered) {
+			panic(err)
+		}
+	}
+}
+
+// register registers the driver, turning the panic of sql.Register into an
+// error. A name registered elsewhere in the meantime still panics in
+// sql.Register, so that panic is recovered as well.
+func register(name string, d driver.Driver) (err error) {
+	for _, registered := range sql.Drivers() {
+		if registered == name {
+			return fmt.Errorf("%w - %v", ErrDriverRegistered, name)
+		}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%w - %v", ErrDriverRegistered, p)
+		}
+	}()
+	sql.Register(name, d)
+	return nil
+}
@@ -0,0 +1,66 @@
+package gopqr
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	//DEFAULTRETRYATTEMPTS - default number of attempts of a refresh, the first one included
+	DEFAULTRETRYATTEMPTS = 5
+	//DEFAULTRETRYBACKOFF - default delay before the first retry of a refresh
+	DEFAULTRETRYBACKOFF = 500 * time.Millisecond
+	//DEFAULTRETRYMAXBACKOFF - default cap on the delay between retries of a refresh
+	DEFAULTRETRYMAXBACKOFF = 30 * time.Second
+)
+
+// RetryPolicy retries failed credential refreshes, like when the secret
+// store is throttling, with exponential backoff. The zero value is ready to
+// use with DEFAULTRETRYATTEMPTS, DEFAULTRETRYBACKOFF and
+// DEFAULTRETRYMAXBACKOFF.
+type RetryPolicy struct {
+	// MaxAttempts - Attempts of a refresh, the first one included, defaults to DEFAULTRETRYATTEMPTS
+	MaxAttempts int
+	// Backoff - Delay before the first retry, doubled for every retry after, defaults to DEFAULTRETRYBACKOFF
+	Backoff time.Duration
+	// MaxBackoff - Cap on the delay between retries, defaults to DEFAULTRETRYMAXBACKOFF
+	MaxBackoff time.Duration
+	// OnTerminalFailure func is invoked with the last error once every
+	// attempt of a refresh has failed
+	OnTerminalFailure func(err error)
+}
+
+// do runs fn until it succeeds or the attempts run out. A nil policy runs fn
+// once.
+func (p *RetryPolicy) do(fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+	attempts, backoff, maxBackoff := p.MaxAttempts, p.Backoff, p.MaxBackoff
+	if attempts <= 0 {
+		attempts = DEFAULTRETRYATTEMPTS
+	}
+	if backoff <= 0 {
+		backoff = DEFAULTRETRYBACKOFF
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DEFAULTRETRYMAXBACKOFF
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	err = fmt.Errorf("Refreshing credentials failed after %v attempts - %w", attempts, err)
+	if p.OnTerminalFailure != nil {
+		p.OnTerminalFailure(err)
+	}
+	return err
+}
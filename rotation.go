@@ -0,0 +1,154 @@
+package gopqr
+
+import (
+	"time"
+)
+
+// RotationState is what a RotationPolicy gets to see when it is consulted.
+type RotationState struct {
+	// Active - Name of the active credential
+	Active string
+	// ActiveSince - When the active credential became active
+	ActiveSince time.Time
+	// Generation - Counts the times new credentials were installed on the
+	// driver, like by the refresher or the provider. It changes whenever the
+	// secret changes.
+	Generation uint64
+	// Degraded - Set while the active credential fails authentication and the
+	// driver gets by on a fallback credential, or while its error budget is
+	// exhausted
+	Degraded bool
+}
+
+// RotationPolicy decides when the driver flips its active credential. The
+// driver consults it on every Open.
+type RotationPolicy interface {
+	// RotateOnOpen reports whether the active credential should advance to
+	// the next slot before a new connection is made.
+	RotateOnOpen(s RotationState) bool
+	// SwapOnFallback reports whether the credential that authenticated after
+	// the active one failed should become the active credential.
+	SwapOnFallback(s RotationState) bool
+}
+
+type perOpen struct{}
+
+func (perOpen) RotateOnOpen(RotationState) bool   { return true }
+func (perOpen) SwapOnFallback(RotationState) bool { return false }
+
+// PerOpen returns the policy that alternates the active credential on every
+// Open. This is what the driver does unless told otherwise.
+func PerOpen() RotationPolicy {
+	return perOpen{}
+}
+
+type onAuthFailure struct{}
+
+func (onAuthFailure) RotateOnOpen(RotationState) bool   { return false }
+func (onAuthFailure) SwapOnFallback(RotationState) bool { return true }
+
+// OnAuthFailure returns the policy that keeps the active credential until
+// it fails authentication, at which point the credential the driver fell
+// back to becomes active. This is what Sticky does.
+func OnAuthFailure() RotationPolicy {
+	return onAuthFailure{}
+}
+
+type onInterval struct {
+	interval time.Duration
+}
+
+func (p onInterval) RotateOnOpen(s RotationState) bool {
+	return time.Since(s.ActiveSince) >= p.interval
+}
+
+func (onInterval) SwapOnFallback(RotationState) bool { return true }
+
+// OnInterval returns the policy that flips the active credential on the
+// first Open after it has been active for the interval, and on an
+// authentication failure.
+func OnInterval(interval time.Duration) RotationPolicy {
+	return onInterval{interval: interval}
+}
+
+type onSecretVersionChange struct{}
+
+func (onSecretVersionChange) RotateOnOpen(RotationState) bool   { return false }
+func (onSecretVersionChange) SwapOnFallback(RotationState) bool { return false }
+
+// OnSecretVersionChange returns the policy that leaves the active credential
+// to the secret. It only changes when a new version of the secret is
+// installed that names another active credential. Authentication failures
+// still fall back to the other credentials for the connection at hand.
+func OnSecretVersionChange() RotationPolicy {
+	return onSecretVersionChange{}
+}
+
+// policy returns the RotationPolicy of the driver, which defaults to
+// OnAuthFailure for sticky drivers and PerOpen otherwise. GOPQR_DISABLE_ROTATION
+// and grace mode override it.
+func (d *Driver) policy() RotationPolicy {
+	if flags().disableRotation {
+		return frozen{}
+	}
+	if d.InGrace() {
+		return grace{}
+	}
+	policy, sticky := d.configuredPolicy()
+	if policy != nil {
+		return policy
+	}
+	if sticky || d.RDSProxy {
+		return OnAuthFailure()
+	}
+	return PerOpen()
+}
+
+// rotationState takes the state a RotationPolicy is consulted with.
+func (d *Driver) rotationState() RotationState {
+	// the active credential of a driver that has not rotated yet counts as
+	// active since the first Open
+	d.activeSince.CompareAndSwap(0, time.Now().UnixNano())
+	return RotationState{
+		Active:      d.current().active,
+		ActiveSince: time.Unix(0, d.activeSince.Load()),
+		Generation:  d.generation.Load(),
+		Degraded:    d.degraded.Load() || d.ErrorBudget.Exhausted(),
+	}
+}
+
+// RotationState returns the rotation state of the driver, like to correlate
+// it with the latency of queries in traces.
+func (d *Driver) RotationState() RotationState {
+	return d.rotationState()
+}
+
+// activated records that the active credential changed just now.
+func (d *Driver) activated() {
+	d.activeSince.Store(time.Now().UnixNano())
+}
+
+// credentialsInstalled records that new credentials were installed on the
+// driver, with the active credential before and after, rolling the new one
+// out when a Canary is set.
+func (d *Driver) credentialsInstalled(from, to string) {
+	installs := d.generation.Add(1)
+	if from != to {
+		d.activated()
+		d.canaryStarts(from, to, installs)
+	}
+}
+
+// rotated audits the rotation and invokes the OnRotate hook, if one is set,
+// when the active credential changed, and writes the StatusFile. It is
+// invoked outside of the lock so that the hook may use the driver.
+func (d *Driver) rotated(from, to string) {
+	if from != to {
+		d.rotations.Add(1)
+		d.audit(AuditRecord{Event: AuditRotation, From: from, Slot: to, Outcome: AuditSuccess})
+		if d.OnRotate != nil {
+			d.OnRotate(from, to)
+		}
+	}
+	d.statusChanged()
+}
@@ -0,0 +1,250 @@
+package rotator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/passwordgen"
+	"github.com/chandranarreddy/gopqr/scram"
+	"github.com/lib/pq"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package rotator performs the producing side of a rotation for the
+credentials consumed by a github.com/chandranarreddy/gopqr driver. Every
+Rotate generates a new password for the standby credential (the slot
+following the active one), sets it on the server with ALTER ROLE, writes the
+secret with the standby as the new active credential back to the store and
+finally has the driver pick it up. Connections on the previously active
+credential keep working until it is itself rotated next time.
+
+Usage:
+	r := rotator.New(pqrDriver, adminDB, store)
+	res, err := r.Rotate(ctx)
+
+adminDB must be allowed to ALTER ROLE the users of the secret, like the
+MaintenanceDB of the driver. store reads and writes the secret the driver
+consumes.
+*/
+
+// DEFAULTPASSWORDBYTES - number of random bytes in the passwords generated
+// by earlier releases
+//
+// Deprecated: Passwords are generated by passwordgen, DEFAULTLENGTH
+// characters long.
+const DEFAULTPASSWORDBYTES = 32
+
+// SecretStore is where the rotator reads the rotating credentials document
+// from and writes it back to, like the secret the Provider of the driver
+// reads.
+type SecretStore interface {
+	// Get returns the secret as it is stored now.
+	Get(ctx context.Context) (*gopqr.Secret, error)
+	// Put stores the secret, replacing what was stored.
+	Put(ctx context.Context, s *gopqr.Secret) error
+}
+
+// Rotator rotates the credentials of a driver on the server and in its
+// secret store.
+type Rotator struct {
+	// Driver - The driver to pick up the rotated credentials, refreshing
+	// its Provider or assigning the secret to it otherwise. May be nil.
+	Driver *gopqr.Driver
+	// DB - Connection to the server allowed to ALTER ROLE the users
+	DB *sql.DB
+	// Store - Where the secret is read from and written to
+	Store SecretStore
+	// GeneratePassword - Generates the new password, defaults to the zero
+	// passwordgen.Policy. Use passwordgen.Generator for policies of your own.
+	GeneratePassword func() (string, error)
+	// DrainWindow - When set, the password of the previously active
+	// credential expires on the server (VALID UNTIL) this long after the
+	// rotation, which should leave the pools enough time to drain its
+	// connections. It makes the database enforce the rotation as well.
+	DrainWindow time.Duration
+	// ValidFor - When set, the new password expires on the server this
+	// long after the rotation, which should be past the next rotation plus
+	// the DrainWindow. Unset, it never expires.
+	ValidFor time.Duration
+	// VerifyDB - Connection to the server the fleet connects to, on which
+	// the change of the password is verified before the secret is written,
+	// defaults to DB. Point it past any replica or proxy that may lag.
+	VerifyDB *sql.DB
+	// VerifyTimeout - How long the change may take to show on VerifyDB,
+	// defaults to DEFAULTVERIFYTIMEOUT
+	VerifyTimeout time.Duration
+	// SkipVerify - Write the secret right after changing the password,
+	// without verifying the change
+	SkipVerify bool
+	// SCRAM - Set the password as its SCRAM-SHA-256 verifier, computed
+	// locally, so that the password itself never reaches the server. Always
+	// on in strict FIPS mode, where the server must not keep an md5 hash
+	SCRAM bool
+}
+
+// Result tells what a Rotate did.
+type Result struct {
+	// Rotated - Name of the slot whose password was changed
+	Rotated string
+	// Username - The user whose password was changed
+	Username string
+	// Previous - Name of the slot that was active before
+	Previous string
+	// PreviousValidUntil - When the password of the previously active
+	// credential expires on the server, the zero time when it does not
+	PreviousValidUntil time.Time
+}
+
+// New returns a rotator for the driver, changing passwords over db and
+// keeping the secret in store.
+func New(d *gopqr.Driver, db *sql.DB, store SecretStore) *Rotator {
+	return &Rotator{Driver: d, DB: db, Store: store}
+}
+
+// Rotate changes the password of the standby credential and makes it the
+// active one. Unless SkipVerify is set, the secret is only written once the
+// change shows in the catalog of the VerifyDB, a new hash in pg_authid and
+// the expiry in pg_roles, and the error matches ErrNotVerified when it did
+// not in time. When verifying or writing the secret fails after the
+// password was changed on the server, the active credential keeps working
+// and the next Rotate changes the password of the standby again.
+func (r *Rotator) Rotate(ctx context.Context) (*Result, error) {
+	if r.DB == nil || r.Store == nil {
+		return nil, errors.New("rotator needs a DB and a Store")
+	}
+	s, err := r.Store.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading the secret failed - %v", err)
+	}
+	creds := s.Credentials()
+	if len(creds.Slots) < 2 {
+		return nil, errors.New("rotating needs at least two credential slots")
+	}
+	previous := creds.Slots[creds.Active]
+	standby := creds.Slots[(creds.Active+1)%len(creds.Slots)]
+	if standby.Username == "" {
+		return nil, fmt.Errorf("credential slot %v has no username", standby.Name)
+	}
+	password, err := r.generate()
+	if err != nil {
+		return nil, fmt.Errorf("generating the password failed - %v", err)
+	}
+	var before string
+	hashed := false
+	if !r.SkipVerify {
+		before, err = r.passwordHash(ctx, standby.Username)
+		if err != nil && err != errNoAuthid {
+			return nil, fmt.Errorf("reading the password of %v failed - %v", standby.Name, err)
+		}
+		hashed = err == nil
+	}
+	sent := password
+	if r.SCRAM || gopqr.FIPSMode() {
+		if sent, err = scram.Verifier(password); err != nil {
+			return nil, fmt.Errorf("computing the verifier of %v failed - %v", standby.Name, err)
+		}
+	}
+	now := time.Now()
+	alter := fmt.Sprintf("ALTER ROLE %v WITH PASSWORD %v",
+		pq.QuoteIdentifier(standby.Username), pq.QuoteLiteral(sent))
+	var validUntil time.Time
+	if r.ValidFor > 0 {
+		validUntil = now.Add(r.ValidFor)
+	}
+	expires := r.ValidFor > 0 || r.DrainWindow > 0
+	if expires {
+		// the role may still carry the expiry it was given when it was
+		// rotated out last time
+		alter += " VALID UNTIL " + pq.QuoteLiteral(validUntilText(validUntil))
+	}
+	if _, err := r.DB.ExecContext(ctx, alter); err != nil {
+		return nil, fmt.Errorf("changing the password of %v failed - %v", standby.Name, err)
+	}
+	if !r.SkipVerify {
+		if err := r.verify(ctx, standby.Username, before, hashed, expires, validUntil); err != nil {
+			return nil, fmt.Errorf("verifying the password of %v failed - %w", standby.Name, err)
+		}
+	}
+	res := &Result{Rotated: standby.Name, Username: standby.Username, Previous: previous.Name}
+	if r.DrainWindow > 0 {
+		res.PreviousValidUntil = now.Add(r.DrainWindow)
+	}
+	setPassword(s, standby.Name, password)
+	setValidUntil(s, standby.Name, validUntil)
+	setValidUntil(s, previous.Name, res.PreviousValidUntil)
+	s.ActiveCredential = standby.Name
+	if err := r.Store.Put(ctx, s); err != nil {
+		return nil, fmt.Errorf("writing the secret failed - %v", err)
+	}
+	// the previous credential only expires once the secret no longer has
+	// it active
+	if r.DrainWindow > 0 && previous.Username != standby.Username {
+		if _, err := r.DB.ExecContext(ctx, fmt.Sprintf("ALTER ROLE %v VALID UNTIL %v",
+			pq.QuoteIdentifier(previous.Username), pq.QuoteLiteral(validUntilText(res.PreviousValidUntil)))); err != nil {
+			return res, fmt.Errorf("setting the expiry of %v failed - %v", previous.Name, err)
+		}
+	}
+	if r.Driver != nil {
+		if err := r.pickUp(s); err != nil {
+			return res, fmt.Errorf("the driver failed to pick up the rotated credentials - %v", err)
+		}
+	}
+	return res, nil
+}
+
+// pickUp has the driver use the rotated secret.
+func (r *Rotator) pickUp(s *gopqr.Secret) error {
+	if r.Driver.Provider != nil {
+		return r.Driver.Refresh()
+	}
+	s.Apply(r.Driver)
+	return nil
+}
+
+func (r *Rotator) generate() (string, error) {
+	if r.GeneratePassword != nil {
+		return r.GeneratePassword()
+	}
+	return passwordgen.Generate(passwordgen.Policy{})
+}
+
+// validUntilText returns the time as the text of a VALID UNTIL, the zero
+// time meaning never.
+func validUntilText(t time.Time) string {
+	if t.IsZero() {
+		return "infinity"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// setValidUntil sets the expiry of the named slot in the secret. The odd and
+// even credential of a secret without slots carry no metadata.
+func setValidUntil(s *gopqr.Secret, slot string, t time.Time) {
+	for i := range s.Slots {
+		if s.Slots[i].Name == slot {
+			s.Slots[i].ValidUntil = t
+		}
+	}
+}
+
+// setPassword sets the password of the named slot in the secret.
+func setPassword(s *gopqr.Secret, slot, password string) {
+	if len(s.Slots) > 0 {
+		for i := range s.Slots {
+			if s.Slots[i].Name == slot {
+				s.Slots[i].Password = password
+			}
+		}
+		return
+	}
+	if slot == "odd" {
+		s.OddPassword = password
+	} else {
+		s.EvenPassword = password
+	}
+}
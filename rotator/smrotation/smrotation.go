@@ -0,0 +1,370 @@
+package smrotation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/passwordgen"
+	"github.com/chandranarreddy/gopqr/scram"
+	"github.com/lib/pq"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package smrotation implements the rotation Lambda of AWS Secrets Manager for
+the rotating credentials document a github.com/chandranarreddy/gopqr driver
+consumes, so that the producing Lambda and the consuming driver come from the
+same module and their formats cannot drift. It follows the four steps
+Secrets Manager invokes a rotation Lambda with -
+
+	createSecret - a new password for the standby credential, the slot
+	               following the active one, is staged as AWSPENDING with the
+	               standby as the active credential
+	setSecret    - the password is set on the server with ALTER ROLE
+	testSecret   - every credential of the pending secret must authenticate
+	finishSecret - AWSCURRENT moves to the pending version
+
+The credential that was active keeps working throughout, and drivers move to
+the new one when they refresh. Fields of the document gopqr does not know
+about are carried over as they are.
+
+Usage:
+	func main() {
+		adminDB, err := sql.Open("postgres", adminDSN)
+		h, err := smrotation.New(smrotation.Config{
+			DB:  adminDB,
+			DSN: "postgres://db.internal:5432/app?sslmode=verify-full",
+		})
+		lambda.Start(h.Handle)
+	}
+*/
+
+const (
+	stageCurrent = "AWSCURRENT"
+	stagePending = "AWSPENDING"
+)
+
+const (
+	// StepCreate - Stages the secret with the new password as AWSPENDING
+	StepCreate = "createSecret"
+	// StepSet - Sets the new password on the server
+	StepSet = "setSecret"
+	// StepTest - Checks that the pending credentials authenticate
+	StepTest = "testSecret"
+	// StepFinish - Marks the pending secret AWSCURRENT
+	StepFinish = "finishSecret"
+)
+
+// Event is the event Secrets Manager invokes a rotation Lambda with.
+type Event struct {
+	SecretID           string `json:"SecretId"`
+	ClientRequestToken string `json:"ClientRequestToken"`
+	Step               string `json:"Step"`
+}
+
+// Config holds the settings of a Handler.
+type Config struct {
+	// Client - Secrets Manager client, built for the Region when not set
+	Client secretsmanageriface.SecretsManagerAPI
+	// Region - Region of Secrets Manager, defaults to that of the Lambda
+	Region string
+	// DB - Connection to the server allowed to ALTER ROLE the users of the
+	// secret
+	DB *sql.DB
+	// DSN - DSN of the server the applications connect to, without
+	// credentials, on which the pending credentials are tested
+	DSN string
+	// GeneratePassword - Generates the new password, defaults to the zero
+	// passwordgen.Policy
+	GeneratePassword func() (string, error)
+	// SCRAM - Set the password as its SCRAM-SHA-256 verifier, computed
+	// locally, so that the password itself never reaches the server
+	SCRAM bool
+}
+
+// Handler handles the rotation events of Secrets Manager.
+type Handler struct {
+	sm       secretsmanageriface.SecretsManagerAPI
+	db       *sql.DB
+	dsn      string
+	generate func() (string, error)
+	scram    bool
+}
+
+// New returns a Handler for the configuration.
+func New(cfg Config) (*Handler, error) {
+	if cfg.DB == nil || cfg.DSN == "" {
+		return nil, errors.New("DB and DSN are required for the rotation handler")
+	}
+	sm := cfg.Client
+	if sm == nil {
+		awsConfig := &aws.Config{}
+		if cfg.Region != "" {
+			awsConfig.Region = aws.String(cfg.Region)
+		}
+		sess, err := session.NewSession(awsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AWS session - %v", err)
+		}
+		sm = secretsmanager.New(sess)
+	}
+	generate := cfg.GeneratePassword
+	if generate == nil {
+		generate = passwordgen.Generator(passwordgen.Policy{})
+	}
+	return &Handler{sm: sm, db: cfg.DB, dsn: cfg.DSN, generate: generate, scram: cfg.SCRAM}, nil
+}
+
+// Handle runs the step of the event. It has the signature lambda.Start
+// expects.
+func (h *Handler) Handle(ctx context.Context, e Event) error {
+	done, err := h.checkVersion(ctx, e)
+	if err != nil || done {
+		return err
+	}
+	switch e.Step {
+	case StepCreate:
+		return h.create(ctx, e)
+	case StepSet:
+		return h.set(ctx, e)
+	case StepTest:
+		return h.test(ctx, e)
+	case StepFinish:
+		return h.finish(ctx, e)
+	}
+	return fmt.Errorf("unknown rotation step %q", e.Step)
+}
+
+// checkVersion makes sure that rotation is enabled on the secret and that
+// the version of the event is staged for rotation. It reports done when the
+// version is AWSCURRENT already, like when a step is retried.
+func (h *Handler) checkVersion(ctx context.Context, e Event) (bool, error) {
+	desc, err := h.sm.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(e.SecretID)})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe secret %v - %v", e.SecretID, err)
+	}
+	if !aws.BoolValue(desc.RotationEnabled) {
+		return false, fmt.Errorf("secret %v is not enabled for rotation", e.SecretID)
+	}
+	stages, ok := desc.VersionIdsToStages[e.ClientRequestToken]
+	if !ok {
+		return false, fmt.Errorf("secret version %v has no stage for rotation of secret %v", e.ClientRequestToken, e.SecretID)
+	}
+	if hasStage(stages, stageCurrent) {
+		return true, nil
+	}
+	if !hasStage(stages, stagePending) {
+		return false, fmt.Errorf("secret version %v is not set as AWSPENDING for rotation of secret %v", e.ClientRequestToken, e.SecretID)
+	}
+	return false, nil
+}
+
+// create stages the secret with a new password for the standby credential,
+// made the active one, unless the version is staged already.
+func (h *Handler) create(ctx context.Context, e Event) error {
+	raw, current, err := h.get(ctx, e.SecretID, stageCurrent, "")
+	if err != nil {
+		return err
+	}
+	if _, _, err := h.get(ctx, e.SecretID, stagePending, e.ClientRequestToken); err == nil {
+		return nil
+	} else if !isNotFound(err) {
+		return err
+	}
+	creds := current.Credentials()
+	if len(creds.Slots) < 2 {
+		return errors.New("rotating needs at least two credential slots")
+	}
+	standby := creds.Slots[(creds.Active+1)%len(creds.Slots)]
+	if standby.Username == "" {
+		return fmt.Errorf("credential slot %v has no username", standby.Name)
+	}
+	password, err := h.generate()
+	if err != nil {
+		return fmt.Errorf("generating the password failed - %v", err)
+	}
+	setPassword(current, standby.Name, password)
+	current.ActiveCredential = standby.Name
+	pending, err := patch(raw, current)
+	if err != nil {
+		return err
+	}
+	_, err = h.sm.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:           aws.String(e.SecretID),
+		ClientRequestToken: aws.String(e.ClientRequestToken),
+		SecretString:       aws.String(pending),
+		VersionStages:      []*string{aws.String(stagePending)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stage the new version of secret %v - %v", e.SecretID, err)
+	}
+	return nil
+}
+
+// set changes the password of the active credential of the pending secret
+// on the server.
+func (h *Handler) set(ctx context.Context, e Event) error {
+	_, pending, err := h.get(ctx, e.SecretID, stagePending, e.ClientRequestToken)
+	if err != nil {
+		return err
+	}
+	creds := pending.Credentials()
+	standby := creds.Slots[creds.Active]
+	password := standby.Password
+	if h.scram {
+		if password, err = scram.Verifier(password); err != nil {
+			return fmt.Errorf("computing the verifier of %v failed - %v", standby.Name, err)
+		}
+	}
+	if _, err := h.db.ExecContext(ctx, fmt.Sprintf("ALTER ROLE %v WITH PASSWORD %v",
+		pq.QuoteIdentifier(standby.Username), pq.QuoteLiteral(password))); err != nil {
+		return fmt.Errorf("changing the password of %v failed - %v", standby.Name, err)
+	}
+	return nil
+}
+
+// test checks that every credential of the pending secret authenticates,
+// the new one as well as the one that was active.
+func (h *Handler) test(ctx context.Context, e Event) error {
+	_, pending, err := h.get(ctx, e.SecretID, stagePending, e.ClientRequestToken)
+	if err != nil {
+		return err
+	}
+	d := &gopqr.Driver{}
+	pending.Apply(d)
+	if _, err := d.ValidateCredentials(ctx, h.dsn); err != nil {
+		return fmt.Errorf("the pending credentials of secret %v failed the test - %w", e.SecretID, err)
+	}
+	return nil
+}
+
+// finish moves AWSCURRENT to the pending version.
+func (h *Handler) finish(ctx context.Context, e Event) error {
+	desc, err := h.sm.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(e.SecretID)})
+	if err != nil {
+		return fmt.Errorf("failed to describe secret %v - %v", e.SecretID, err)
+	}
+	var current string
+	for version, stages := range desc.VersionIdsToStages {
+		if hasStage(stages, stageCurrent) {
+			current = version
+		}
+	}
+	if current == e.ClientRequestToken {
+		return nil
+	}
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(e.SecretID),
+		VersionStage:    aws.String(stageCurrent),
+		MoveToVersionId: aws.String(e.ClientRequestToken),
+	}
+	if current != "" {
+		input.RemoveFromVersionId = aws.String(current)
+	}
+	if _, err := h.sm.UpdateSecretVersionStageWithContext(ctx, input); err != nil {
+		return fmt.Errorf("failed to make version %v of secret %v current - %v", e.ClientRequestToken, e.SecretID, err)
+	}
+	return nil
+}
+
+// get reads a version of the secret, by stage and optionally by ID, and
+// parses it as the rotating credentials document.
+func (h *Handler) get(ctx context.Context, id, stage, version string) ([]byte, *gopqr.Secret, error) {
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(id),
+		VersionStage: aws.String(stage),
+	}
+	if version != "" {
+		input.VersionId = aws.String(version)
+	}
+	result, err := h.sm.GetSecretValueWithContext(ctx, input)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.SecretString == nil {
+		return nil, nil, fmt.Errorf("secret %v has no secret string", id)
+	}
+	raw := []byte(*result.SecretString)
+	s, err := gopqr.ParseSecretAs(raw, gopqr.FormatRotating)
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, s, nil
+}
+
+// patch returns the document with the credentials of the secret in place of
+// its own, keeping every other field as it was.
+func patch(raw []byte, s *gopqr.Secret) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return "", fmt.Errorf("unmarshalling the secret failed - %v", err)
+	}
+	set := func(name string, value interface{}) error {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		fields[name] = b
+		return nil
+	}
+	if err := set("active_credential", s.ActiveCredential); err != nil {
+		return "", err
+	}
+	if len(s.Slots) > 0 {
+		if err := set("slots", s.Slots); err != nil {
+			return "", err
+		}
+	} else {
+		if err := set("odd_password", s.OddPassword); err != nil {
+			return "", err
+		}
+		if err := set("even_password", s.EvenPassword); err != nil {
+			return "", err
+		}
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// setPassword sets the password of the named slot in the secret.
+func setPassword(s *gopqr.Secret, slot, password string) {
+	if len(s.Slots) > 0 {
+		for i := range s.Slots {
+			if s.Slots[i].Name == slot {
+				s.Slots[i].Password = password
+			}
+		}
+		return
+	}
+	if slot == "odd" {
+		s.OddPassword = password
+	} else {
+		s.EvenPassword = password
+	}
+}
+
+func hasStage(stages []*string, stage string) bool {
+	for _, s := range stages {
+		if aws.StringValue(s) == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException
+}
@@ -0,0 +1,98 @@
+package rotator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DEFAULTVERIFYTIMEOUT - default time the change of a password may take to
+// show on the VerifyDB
+const DEFAULTVERIFYTIMEOUT = 30 * time.Second
+
+// verifyInterval - time between two looks at the catalog while verifying
+const verifyInterval = time.Second
+
+// ErrNotVerified is matched by errors.Is when the change of a password did
+// not show on the VerifyDB in time, like when it is a lagging replica. The
+// secret is not written then, so the fleet keeps the active credential.
+var ErrNotVerified = errors.New("password change did not take effect")
+
+// errNoAuthid - the role of the rotator may not read pg_authid, like on
+// most managed services
+var errNoAuthid = errors.New("pg_authid is not readable")
+
+func (r *Rotator) verifyDB() *sql.DB {
+	if r.VerifyDB != nil {
+		return r.VerifyDB
+	}
+	return r.DB
+}
+
+// passwordHash returns the hash of the password of the role in pg_authid,
+// or errNoAuthid when the rotator may not read it. The hash is only ever
+// compared, never exposed.
+func (r *Rotator) passwordHash(ctx context.Context, username string) (string, error) {
+	var hash string
+	err := r.verifyDB().QueryRowContext(ctx,
+		"SELECT coalesce(rolpassword, '') FROM pg_authid WHERE rolname = $1", username).Scan(&hash)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "42501" {
+		return "", errNoAuthid
+	}
+	return hash, err
+}
+
+// verify waits for the change of the password of the role to show in the
+// catalog of the VerifyDB - a new hash in pg_authid, and the expiry in
+// rolvaliduntil when one was set. Without access to pg_authid (hashed
+// false) only the expiry can be verified.
+func (r *Rotator) verify(ctx context.Context, username, before string, hashed, expires bool, validUntil time.Time) error {
+	timeout := r.VerifyTimeout
+	if timeout <= 0 {
+		timeout = DEFAULTVERIFYTIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		changed, err := r.changed(ctx, username, before, hashed, expires, validUntil)
+		if ctx.Err() != nil {
+			return ErrNotVerified
+		}
+		if err != nil {
+			return err
+		}
+		if changed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ErrNotVerified
+		case <-time.After(verifyInterval):
+		}
+	}
+}
+
+// changed looks at the catalog once.
+func (r *Rotator) changed(ctx context.Context, username, before string, hashed, expires bool, validUntil time.Time) (bool, error) {
+	if hashed {
+		hash, err := r.passwordHash(ctx, username)
+		if err != nil {
+			return false, err
+		}
+		if hash == before {
+			return false, nil
+		}
+	}
+	if !expires {
+		return true, nil
+	}
+	var set bool
+	err := r.verifyDB().QueryRowContext(ctx,
+		"SELECT coalesce(rolvaliduntil = $2::timestamptz, false) FROM pg_roles WHERE rolname = $1",
+		username, validUntilText(validUntil)).Scan(&set)
+	return set, err
+}
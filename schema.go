@@ -0,0 +1,159 @@
+package gopqr
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed secret.schema.json
+var secretSchema []byte
+
+// SecretSchema returns the JSON Schema of the rotating credentials document,
+// so that infrastructure as code pipelines can validate secrets before they
+// are deployed.
+func SecretSchema() []byte {
+	return append([]byte(nil), secretSchema...)
+}
+
+var sslModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// ValidateSecret checks the rotating credentials document against the rules
+// of SecretSchema, returning an error that lists every problem found.
+func ValidateSecret(raw []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("Secret is not a JSON object - %v", err)
+	}
+	s, err := ParseSecretAs(raw, FormatRotating)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	if targets, ok := fields["targets"]; ok {
+		return targetProblems(targets, len(fields) > 1)
+	}
+	if _, ok := fields["slots"]; ok {
+		names := make(map[string]bool, len(s.Slots))
+		if len(s.Slots) == 0 {
+			problems = append(problems, "slots is empty")
+		}
+		for i, slot := range s.Slots {
+			if slot.Name == "" || slot.Username == "" || (slot.Password == "" && slot.Keytab == "" && !slot.CertOnly) {
+				problems = append(problems, fmt.Sprintf("slots[%v] needs a name, username and password, keytab or cert_only", i))
+			}
+			if slot.CertOnly && (slot.SSLCert == "" || slot.SSLKey == "") {
+				problems = append(problems, fmt.Sprintf("slots[%v] is cert_only but lacks its sslcert or sslkey", i))
+			}
+			if names[slot.Name] {
+				problems = append(problems, fmt.Sprintf("slots[%v] repeats the name %q", i, slot.Name))
+			}
+			names[slot.Name] = true
+			if slot.Port != "" && !validPort(slot.Port.String()) {
+				problems = append(problems, fmt.Sprintf("slots[%v] port %v is not a valid port", i, slot.Port))
+			}
+			if slot.SSLMode != "" && !sslModes[slot.SSLMode] {
+				problems = append(problems, fmt.Sprintf("slots[%v] sslmode %q is not a valid sslmode", i, slot.SSLMode))
+			}
+			problems = append(problems, paramProblems(fmt.Sprintf("slots[%v] params", i), slot.Params)...)
+		}
+		if !names[s.ActiveCredential] {
+			problems = append(problems, fmt.Sprintf("active_credential %q names none of the slots", s.ActiveCredential))
+		}
+	} else {
+		for _, key := range []rotaterEnum{oddUser, oddPassword, evenUser, evenPassword} {
+			var v string
+			if json.Unmarshal(fields[key.String()], &v) != nil || v == "" {
+				problems = append(problems, fmt.Sprintf("%v is missing", key))
+			}
+		}
+		if s.ActiveCredential != oddCredential.String() && s.ActiveCredential != evenCredential.String() {
+			problems = append(problems, fmt.Sprintf("active_credential must be \"odd\" or \"even\", not %q", s.ActiveCredential))
+		}
+	}
+	if s.Port != "" && !validPort(s.Port.String()) {
+		problems = append(problems, fmt.Sprintf("port %v is not a valid port", s.Port))
+	}
+	if s.SSLMode != "" && !sslModes[s.SSLMode] {
+		problems = append(problems, fmt.Sprintf("sslmode %q is not a valid sslmode", s.SSLMode))
+	}
+	problems = append(problems, paramProblems("odd_params", s.OddParams)...)
+	problems = append(problems, paramProblems("even_params", s.EvenParams)...)
+	if len(problems) > 0 {
+		return errors.New("Invalid secret - " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// targetProblems validates the targets of a document, which carries
+// nothing else, every target against the rules of a document of its own.
+func targetProblems(raw json.RawMessage, others bool) error {
+	var targets []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &targets); err != nil {
+		return fmt.Errorf("Invalid secret - targets is not an array of objects - %v", err)
+	}
+	var problems []string
+	if others {
+		problems = append(problems, "targets cannot be combined with other fields")
+	}
+	if len(targets) == 0 {
+		problems = append(problems, "targets is empty")
+	}
+	keys := make(map[string]bool, len(targets))
+	for i, target := range targets {
+		var host, dbname string
+		if json.Unmarshal(target["host"], &host) != nil || host == "" {
+			problems = append(problems, fmt.Sprintf("targets[%v] needs a host", i))
+		}
+		json.Unmarshal(target["dbname"], &dbname)
+		if key := databaseKey(host, dbname); keys[key] {
+			problems = append(problems, fmt.Sprintf("targets[%v] repeats the database %v", i, key))
+		} else {
+			keys[key] = true
+		}
+		if _, ok := target["targets"]; ok {
+			problems = append(problems, fmt.Sprintf("targets[%v] cannot have targets", i))
+			continue
+		}
+		// the host of a target names its database, not an endpoint
+		delete(target, "host")
+		delete(target, "dbname")
+		doc, _ := json.Marshal(target)
+		if err := ValidateSecret(doc); err != nil {
+			problems = append(problems, fmt.Sprintf("targets[%v]: %v", i, strings.TrimPrefix(err.Error(), "Invalid secret - ")))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("Invalid secret - " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// paramProblems lists the parameters a credential cannot set.
+func paramProblems(field string, params map[string]string) []string {
+	var problems []string
+	for k := range params {
+		if reservedParams[k] {
+			problems = append(problems, fmt.Sprintf("%v cannot set %q", field, k))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// validPort reports whether the text is a TCP port number.
+func validPort(text string) bool {
+	port, err := strconv.Atoi(text)
+	return err == nil && port > 0 && port <= 65535
+}
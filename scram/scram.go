@@ -0,0 +1,103 @@
+package scram
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/lib/pq"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package scram computes the SCRAM-SHA-256 verifiers Postgres keeps in
+pg_authid locally, so that a rotation can set a new password with ALTER ROLE
+without the password itself ever reaching the server, its statement logs or
+pg_stat_statements. The server stores a verifier it is given as it is,
+exactly as if it had computed it from the password.
+
+Usage:
+	stmt, err := scram.AlterRole("app_even", password, time.Time{})
+	...
+	_, err = adminDB.ExecContext(ctx, stmt)
+
+The rotator package and gopqr-rotate do so with their SCRAM setting. Only
+ASCII passwords are accepted, like those of passwordgen, as others would
+need the SASLprep normalization the server applies to them first.
+*/
+
+const (
+	//DEFAULTITERATIONS - iterations of PBKDF2, the scram_iterations Postgres
+	//defaults to
+	DEFAULTITERATIONS = 4096
+	//SALTBYTES - length of the random salt of a verifier
+	SALTBYTES = 16
+)
+
+// Verifier returns the SCRAM-SHA-256 verifier of the password, with a random
+// salt and DEFAULTITERATIONS, in the format of pg_authid -
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>.
+func Verifier(password string) (string, error) {
+	salt := make([]byte, SALTBYTES)
+	if err := gopqr.CheckPrimitive(gopqr.PrimitiveCSPRNG); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("scram: generating the salt failed - %v", err)
+	}
+	return VerifierWith(password, salt, DEFAULTITERATIONS)
+}
+
+// VerifierWith returns the SCRAM-SHA-256 verifier of the password with the
+// salt and iterations given.
+func VerifierWith(password string, salt []byte, iterations int) (string, error) {
+	if err := gopqr.CheckPrimitive(gopqr.PrimitivePBKDF2); err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("scram: the password is empty")
+	}
+	for i := 0; i < len(password); i++ {
+		if password[i] >= 0x80 {
+			return "", errors.New("scram: the password is not ASCII, which needs SASLprep")
+		}
+	}
+	if len(salt) == 0 || iterations < 1 {
+		return "", errors.New("scram: a salt and at least one iteration are required")
+	}
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return "", fmt.Errorf("scram: deriving the key failed - %v", err)
+	}
+	clientKey := mac(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := mac(salted, "Server Key")
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("SCRAM-SHA-256$%v:%v$%v:%v", iterations, b64(salt), b64(storedKey[:]), b64(serverKey)), nil
+}
+
+func mac(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+// AlterRole returns the ALTER ROLE statement setting the password of the
+// role as its verifier, and the expiry when validUntil is not the zero time.
+func AlterRole(role, password string, validUntil time.Time) (string, error) {
+	verifier, err := Verifier(password)
+	if err != nil {
+		return "", err
+	}
+	stmt := fmt.Sprintf("ALTER ROLE %v WITH PASSWORD %v", pq.QuoteIdentifier(role), pq.QuoteLiteral(verifier))
+	if !validUntil.IsZero() {
+		stmt += " VALID UNTIL " + pq.QuoteLiteral(validUntil.UTC().Format(time.RFC3339))
+	}
+	return stmt, nil
+}
@@ -0,0 +1,215 @@
+package gopqr
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Secret represents the rotating credentials document as it is stored in a
+// secret store such as AWS Secrets Manager or Azure Key Vault -
+//
+//	{
+//		"odd_username": "myOddUserName",
+//		"odd_password": "myOddPassword",
+//		"even_username": "myEvenUserName",
+//		"even_password": "myEvenPassword",
+//		"active_credential": "even"
+//	}
+//
+// The document may also carry "host", "port" and "sslmode" to move the driver
+// to a new endpoint, such as after a database migration, and "sslrootcert",
+// "sslcert" and "sslkey" to replace its TLS settings, like a reissued CA
+// bundle. "odd_params" and "even_params" carry query parameters of one
+// credential only, like {"options": "-c role=app_rw"} when the users have
+// different default roles. To rotate through more than two credentials, the
+// document carries a ring of "slots" instead of the odd and even credential,
+// with "active_credential" naming the active slot, and each slot may carry
+// "params" of its own -
+//
+//	{
+//		"slots": [
+//			{"name": "blue", "username": "myBlueUserName", "password": "myBluePassword"},
+//			{"name": "green", "username": "myGreenUserName", "password": "myGreenPassword"},
+//			{"name": "canary", "username": "myCanaryUserName", "password": "myCanaryPassword",
+//			 "valid_until": "2030-01-01T00:00:00Z", "version": "42"}
+//		],
+//		"active_credential": "green"
+//	}
+//
+// To serve several databases out of one document, it carries "targets"
+// instead, each holding the credentials of the database of its "host" and
+// "dbname", see NewMultiDriverFor -
+//
+//	{
+//		"targets": [
+//			{"host": "orders.db.internal", "dbname": "orders",
+//			 "odd_username": "ordersOdd", "odd_password": "...",
+//			 "even_username": "ordersEven", "even_password": "...", "active_credential": "odd"},
+//			{"host": "users.db.internal",
+//			 "slots": [...], "active_credential": "green"}
+//		]
+//	}
+//
+// Any other fields teams pack into the same document, like the endpoint of a
+// read replica or a schema name, are passed through in Extra.
+type Secret struct {
+	OddUsername      string       `json:"odd_username"`
+	OddPassword      string       `json:"odd_password"`
+	EvenUsername     string       `json:"even_username"`
+	EvenPassword     string       `json:"even_password"`
+	ActiveCredential string       `json:"active_credential"`
+	Host             string       `json:"host,omitempty"`
+	Port             json.Number  `json:"port,omitempty"`
+	SSLMode          string       `json:"sslmode,omitempty"`
+	SSLRootCert      string       `json:"sslrootcert,omitempty"`
+	SSLCert          string       `json:"sslcert,omitempty"`
+	SSLKey           string       `json:"sslkey,omitempty"`
+	Slots            []SecretSlot `json:"slots,omitempty"`
+	// Targets - The credentials of several databases, in place of those
+	// above
+	Targets []SecretTarget `json:"targets,omitempty"`
+
+	// OddParams and EvenParams - Optional query parameters of the odd and
+	// the even credential, see the Params of Credential
+	OddParams  map[string]string `json:"odd_params,omitempty"`
+	EvenParams map[string]string `json:"even_params,omitempty"`
+	// Extra - The fields of the document that are not listed above. String
+	// values are kept as is, others as their JSON text.
+	Extra map[string]string `json:"-"`
+}
+
+// SecretTarget is the credentials of one database of a Secret carrying
+// several. The host of the target names the database rather than
+// overriding the endpoint of the DSN.
+type SecretTarget struct {
+	// Host and DBName - The database of the DSNs the credentials are for,
+	// matched like by the Map of a MultiDriver. An empty DBName matches every
+	// database of the host.
+	Host   string `json:"host"`
+	DBName string `json:"dbname,omitempty"`
+	Secret
+}
+
+// SecretSlot is one credential of the ring of slots in a Secret.
+type SecretSlot struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// ValidFrom, ValidUntil and Version - Optional metadata of the
+	// credential, the times in RFC 3339 format
+	ValidFrom  time.Time `json:"valid_from,omitempty"`
+	ValidUntil time.Time `json:"valid_until,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	// Stages - Optional labels of the version of the credential, like
+	// AWSCURRENT, see ByStage
+	Stages []string `json:"stages,omitempty"`
+	// Host, Port and SSLMode - Optional endpoint of this credential only
+	Host    string      `json:"host,omitempty"`
+	Port    json.Number `json:"port,omitempty"`
+	SSLMode string      `json:"sslmode,omitempty"`
+	// SSLCert and SSLKey - Optional client certificate and private key of
+	// this credential, the paths of files or PEM encoded
+	SSLCert string `json:"sslcert,omitempty"`
+	SSLKey  string `json:"sslkey,omitempty"`
+	// Keytab - Optional keytab this credential authenticates with over
+	// GSSAPI in place of the password, the path of a file or base64 encoded
+	// behind "base64:"
+	Keytab string `json:"keytab,omitempty"`
+	// CertOnly - Optional, when true this credential authenticates with its
+	// sslcert and sslkey alone, without a password
+	CertOnly bool `json:"cert_only,omitempty"`
+	// Params - Optional query parameters of this credential only, like
+	// {"options": "-c role=app_rw"}
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// ParseSecret unmarshals the document fetched from a secret store, which is
+// the rotating credentials document unless DetectSecretFormat tells
+// otherwise, like for the single credential kept by the managed rotation of
+// AWS Secrets Manager. Use ParseSecretAs to insist on a format.
+func ParseSecret(raw []byte) (*Secret, error) {
+	return ParseSecretAs(raw, FormatAuto)
+}
+
+// parseRotating unmarshals the rotating credentials document.
+func parseRotating(raw []byte) (*Secret, error) {
+	var s Secret
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("Unmarshalling rotating credentials secret failed - %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("Unmarshalling rotating credentials secret failed - %w", err)
+	}
+	for name, value := range fields {
+		if secretFields[name] {
+			continue
+		}
+		if s.Extra == nil {
+			s.Extra = make(map[string]string)
+		}
+		s.Extra[name] = extraValue(value)
+	}
+	return &s, nil
+}
+
+// extraValue returns a string field as is and any other as its JSON text.
+func extraValue(value json.RawMessage) string {
+	var str string
+	if err := json.Unmarshal(value, &str); err == nil {
+		return str
+	}
+	return string(value)
+}
+
+// secretFields are the fields of the document that are not passed through
+// in Extra.
+var secretFields = map[string]bool{
+	oddUser.String():          true,
+	oddPassword.String():      true,
+	evenUser.String():         true,
+	evenPassword.String():     true,
+	activeCredential.String(): true,
+	"host":                    true,
+	"port":                    true,
+	"sslmode":                 true,
+	"sslrootcert":             true,
+	"sslcert":                 true,
+	"sslkey":                  true,
+	"odd_params":              true,
+	"even_params":             true,
+	"slots":                   true,
+	"targets":                 true,
+}
+
+// Apply assigns the credentials held in the secret to the driver within the
+// protection of the driver's lock. It is meant to be called from within a
+// CredentialRefresher func.
+func (s *Secret) Apply(d *Driver) {
+	d.AcquireLock()
+	from := d.ActiveCredential
+	active := s.ActiveCredential
+	if d.SelectActive != nil {
+		active = d.selectActive(s.Credentials().Slots, active)
+	}
+	d.OddUsername = s.OddUsername
+	d.OddPassword = s.OddPassword
+	d.EvenUsername = s.EvenUsername
+	d.EvenPassword = s.EvenPassword
+	d.ActiveCredential = active
+	d.Host = s.Host
+	d.Port = s.Port.String()
+	d.SSLMode = s.SSLMode
+	d.SSLRootCert, d.SSLCert, d.SSLKey = s.SSLRootCert, s.SSLCert, s.SSLKey
+	d.Slots = nil
+	// the odd and even credential carry their params as a ring of slots
+	if len(s.Slots) > 0 || len(s.OddParams) > 0 || len(s.EvenParams) > 0 {
+		d.Slots = s.Credentials().Slots
+	}
+	d.extra = copyExtra(s.Extra)
+	d.credentialsInstalled(from, active)
+	d.ReleaseLock()
+	d.passExtra(s.Extra)
+	d.rotated(from, active)
+}
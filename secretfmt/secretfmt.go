@@ -0,0 +1,194 @@
+package secretfmt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package secretfmt does the JSON plumbing every custom refresher of
+github.com/chandranarreddy/gopqr otherwise writes by hand - unmarshalling the
+secret into a struct of its own and copying the credentials out of it. The
+fields of the struct are mapped to the credentials of the driver by their
+gopqr tags, so the secret can keep whatever layout and field names the team
+picked -
+
+	type dbSecret struct {
+		Blue     string `json:"blue_user" gopqr:"odd_username"`
+		BluePw   string `json:"blue_pass" gopqr:"odd_password"`
+		Green    string `json:"green_user" gopqr:"even_username"`
+		GreenPw  string `json:"green_pass" gopqr:"even_password"`
+		Live     string `json:"live" gopqr:"active_credential"`
+		Endpoint struct {
+			Host string `json:"host" gopqr:"host"`
+			Port int    `json:"port" gopqr:"port"`
+		} `json:"endpoint"`
+	}
+
+Usage:
+	pqrDriver, err := gopqr.New(gopqr.WithRefreshFunc(
+		secretfmt.RefreshFunc[dbSecret](func(ctx context.Context) ([]byte, error) {
+			return fetchSecret(ctx)
+		})))
+
+The tags are odd_username, odd_password, even_username, even_password,
+active_credential, host, port, sslmode, sslrootcert, sslcert and sslkey, each
+on a string field, or an integer field for port. Structs nested in the struct
+are searched too, and fields tagged gopqr:"-" are skipped. The usernames are
+required. Without an active_credential, the odd credential is the active one.
+*/
+
+// Unmarshal unmarshals the JSON secret into a value of T. The error does not
+// quote the secret.
+func Unmarshal[T any](raw []byte) (T, error) {
+	var v T
+	if len(raw) == 0 {
+		return v, errors.New("secretfmt: the secret is empty")
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return v, fmt.Errorf("secretfmt: unmarshalling the secret into %T failed - %w", v, redact(err))
+	}
+	return v, nil
+}
+
+// redact drops the values of the secret json quotes in its errors, keeping
+// where the secret went wrong.
+func redact(err error) error {
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		return fmt.Errorf("invalid JSON at offset %v", syntax.Offset)
+	}
+	var typ *json.UnmarshalTypeError
+	if errors.As(err, &typ) {
+		return fmt.Errorf("a JSON %v cannot be put in the %v field %v", typ.Value, typ.Type, typ.Field)
+	}
+	return err
+}
+
+// Parse unmarshals the JSON secret into a value of T and returns the
+// credentials mapped by its gopqr tags, see Credentials.
+func Parse[T any](raw []byte) (gopqr.Credentials, error) {
+	v, err := Unmarshal[T](raw)
+	if err != nil {
+		return gopqr.Credentials{}, err
+	}
+	return Credentials(v)
+}
+
+// RefreshFunc returns a gopqr.RefreshFunc fetching the secret with fetch and
+// parsing it as a T, to be set with gopqr.WithRefreshFunc.
+func RefreshFunc[T any](fetch func(ctx context.Context) ([]byte, error)) gopqr.RefreshFunc {
+	return func(ctx context.Context) (odd, even gopqr.Credential, active string, err error) {
+		raw, err := fetch(ctx)
+		if err != nil {
+			return odd, even, "", err
+		}
+		creds, err := Parse[T](raw)
+		if err != nil {
+			return odd, even, "", err
+		}
+		return creds.Slots[0], creds.Slots[1], creds.Slots[creds.Active].Name, nil
+	}
+}
+
+// Credentials returns the odd and even credentials held in v, a struct or a
+// pointer to one, mapped by the gopqr tags of its fields.
+func Credentials(v any) (gopqr.Credentials, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return gopqr.Credentials{}, errors.New("secretfmt: the secret is a nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return gopqr.Credentials{}, fmt.Errorf("secretfmt: the secret must be a struct, not %v", rv.Type())
+	}
+	fields := make(map[string]string)
+	if err := collect(rv, fields); err != nil {
+		return gopqr.Credentials{}, err
+	}
+	var missing []string
+	for _, tag := range []string{"odd_username", "even_username"} {
+		if fields[tag] == "" {
+			missing = append(missing, tag)
+		}
+	}
+	if len(missing) > 0 {
+		return gopqr.Credentials{}, fmt.Errorf("secretfmt: the secret has no %v", strings.Join(missing, " and no "))
+	}
+	active := fields["active_credential"]
+	if active == "" {
+		active = "odd"
+	}
+	if active != "odd" && active != "even" {
+		return gopqr.Credentials{}, fmt.Errorf("secretfmt: the active_credential of the secret is %q, it must be \"odd\" or \"even\"", active)
+	}
+	c := gopqr.OddEven(
+		gopqr.Credential{Username: fields["odd_username"], Password: fields["odd_password"]},
+		gopqr.Credential{Username: fields["even_username"], Password: fields["even_password"]},
+		active,
+	)
+	c.Host, c.Port, c.SSLMode = fields["host"], fields["port"], fields["sslmode"]
+	c.SSLRootCert, c.SSLCert, c.SSLKey = fields["sslrootcert"], fields["sslcert"], fields["sslkey"]
+	return c, nil
+}
+
+// tags are the gopqr tags Credentials knows of.
+var tags = map[string]bool{
+	"odd_username": true, "odd_password": true, "even_username": true, "even_password": true,
+	"active_credential": true, "host": true, "port": true, "sslmode": true,
+	"sslrootcert": true, "sslcert": true, "sslkey": true,
+}
+
+// collect puts the values of the tagged fields of the struct in fields by
+// their tag, searching the structs nested in it.
+func collect(rv reflect.Value, fields map[string]string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		tag, tagged := field.Tag.Lookup("gopqr")
+		if tag == "-" {
+			continue
+		}
+		if !tagged {
+			for fv.Kind() == reflect.Pointer && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := collect(fv, fields); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !tags[tag] {
+			return fmt.Errorf("secretfmt: the field %v.%v has the unknown tag gopqr:%q", rt.Name(), field.Name, tag)
+		}
+		if _, dup := fields[tag]; dup {
+			return fmt.Errorf("secretfmt: more than one field has the tag gopqr:%q", tag)
+		}
+		switch {
+		case fv.Kind() == reflect.String:
+			fields[tag] = fv.String()
+		case tag == "port" && (fv.CanInt() || fv.CanUint()):
+			if !fv.IsZero() {
+				fields[tag] = fmt.Sprint(fv.Interface())
+			}
+		default:
+			return fmt.Errorf("secretfmt: the field %v.%v tagged gopqr:%q must be a string, not %v", rt.Name(), field.Name, tag, fv.Type())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package gopqr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SecretFormat is the layout of a secret document fetched from a store.
+type SecretFormat int
+
+const (
+	// FormatAuto - Detect the format from the fields of the document
+	FormatAuto SecretFormat = iota
+	// FormatRotating - The gopqr rotating credentials document, with an odd
+	// and even credential or a ring of slots
+	FormatRotating
+	// FormatSinglePair - A single "username" and "password", as kept by the
+	// managed rotation of AWS Secrets Manager along with "host", "port",
+	// "dbname" and "engine"
+	FormatSinglePair
+	// FormatVaultDynamic - The response of the database secrets engine of
+	// HashiCorp Vault, carrying the credential in "data" along with its
+	// lease, which sets the ValidUntil and Version of the credential
+	FormatVaultDynamic
+)
+
+// SINGLESLOT - name of the slot holding the credential of a secret that has a single one
+const SINGLESLOT = "current"
+
+func (f SecretFormat) String() string {
+	switch f {
+	case FormatRotating:
+		return "rotating"
+	case FormatSinglePair:
+		return "single pair"
+	case FormatVaultDynamic:
+		return "vault dynamic"
+	}
+	return "auto"
+}
+
+// singlePair is a secret holding a single credential.
+type singlePair struct {
+	Username string      `json:"username"`
+	Password string      `json:"password"`
+	Host     string      `json:"host"`
+	Port     json.Number `json:"port"`
+}
+
+// vaultDynamic is a credential leased from the database secrets engine of
+// HashiCorp Vault.
+type vaultDynamic struct {
+	LeaseID       string          `json:"lease_id"`
+	LeaseDuration json.Number     `json:"lease_duration"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// DetectSecretFormat tells the format of a secret document by its fields. A
+// document with none of the fields it recognizes is taken for
+// FormatRotating, so that its problems are reported against that format.
+func DetectSecretFormat(raw []byte) (SecretFormat, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return FormatAuto, fmt.Errorf("Unmarshalling rotating credentials secret failed - %w", err)
+	}
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := fields[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case has("slots"), has("targets"), has(oddUser.String()), has(evenUser.String()):
+		return FormatRotating, nil
+	case has("data") && (has("lease_id") || has("lease_duration")):
+		return FormatVaultDynamic, nil
+	case has("username", "password"):
+		return FormatSinglePair, nil
+	}
+	return FormatRotating, nil
+}
+
+// ParseSecretAs unmarshals a secret document of the format into a Secret.
+// Documents holding a single credential result in a Secret with the single
+// slot SINGLESLOT. Their other fields, like the lease of a Vault credential,
+// are passed through in Extra.
+func ParseSecretAs(raw []byte, format SecretFormat) (*Secret, error) {
+	if format == FormatAuto {
+		var err error
+		if format, err = DetectSecretFormat(raw); err != nil {
+			return nil, err
+		}
+	}
+	switch format {
+	case FormatSinglePair:
+		return parseSinglePair(raw, raw, nil)
+	case FormatVaultDynamic:
+		var v vaultDynamic
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("Unmarshalling Vault dynamic credential failed - %w", err)
+		}
+		if len(v.Data) == 0 {
+			return nil, errors.New("Vault dynamic credential has no data")
+		}
+		lease := map[string]string{}
+		if v.LeaseID != "" {
+			lease["lease_id"] = v.LeaseID
+		}
+		if v.LeaseDuration != "" {
+			lease["lease_duration"] = v.LeaseDuration.String()
+		}
+		s, err := parseSinglePair(v.Data, raw, lease)
+		if err != nil {
+			return nil, err
+		}
+		if seconds, err := v.LeaseDuration.Int64(); err == nil && seconds > 0 {
+			s.Slots[0].ValidUntil = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+		s.Slots[0].Version = v.LeaseID
+		return s, nil
+	}
+	return parseRotating(raw)
+}
+
+// parseSinglePair unmarshals the credential held in raw, passing the other
+// fields of raw and the extra fields through in Extra. The credential is
+// never passed through.
+func parseSinglePair(raw, doc []byte, extra map[string]string) (*Secret, error) {
+	var p singlePair
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("Unmarshalling single credential secret failed - %w", err)
+	}
+	if p.Username == "" || p.Password == "" {
+		return nil, errors.New("Single credential secret needs a username and password")
+	}
+	s := &Secret{
+		Slots:            []SecretSlot{{Name: SINGLESLOT, Username: p.Username, Password: p.Password}},
+		ActiveCredential: SINGLESLOT,
+		Host:             p.Host,
+		Port:             p.Port,
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("Unmarshalling single credential secret failed - %w", err)
+	}
+	for name, value := range fields {
+		switch name {
+		case "username", "password", "host", "port":
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[name] = extraValue(value)
+	}
+	if len(extra) > 0 {
+		s.Extra = extra
+	}
+	return s, nil
+}
@@ -0,0 +1,96 @@
+package gopqr
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// SlotSelector picks the active slot of newly installed credentials from the
+// metadata of the ring, rather than trusting the active credential the
+// secret names, which may be stale and point at a revoked user. It returns
+// the name of the slot, or "" to keep the one the secret names. See ByStage
+// and ByNewestVersion.
+type SlotSelector func(ring []Credential, named string) string
+
+// ByStage returns the SlotSelector picking the slot whose Stages hold the
+// stage, like AWSCURRENT for the credential of the current version of a
+// Secrets Manager secret.
+func ByStage(stage string) SlotSelector {
+	return func(ring []Credential, named string) string {
+		for _, c := range ring {
+			for _, s := range c.Stages {
+				if s == stage {
+					return c.Name
+				}
+			}
+		}
+		return ""
+	}
+}
+
+// ByNewestVersion returns the SlotSelector picking the slot with the newest
+// Version, compared as numbers when both are and as text otherwise, and
+// leaving out credentials that are not valid at the time. Slots without a
+// Version are never picked.
+func ByNewestVersion() SlotSelector {
+	return func(ring []Credential, named string) string {
+		var newest *Credential
+		now := time.Now()
+		for i := range ring {
+			c := &ring[i]
+			if c.Version == "" || !c.ValidAt(now) {
+				continue
+			}
+			if newest == nil || newerVersion(c.Version, newest.Version) {
+				newest = c
+			}
+		}
+		if newest == nil {
+			return ""
+		}
+		return newest.Name
+	}
+}
+
+// newerVersion reports whether version a is newer than b.
+func newerVersion(a, b string) bool {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	if errA == nil && errB == nil {
+		return na > nb
+	}
+	return a > b
+}
+
+// selectActive returns the slot of the ring the SelectActive of the driver
+// picks, or the named one when it has none or picks none of the ring.
+func (d *Driver) selectActive(ring []Credential, named string) string {
+	if d.SelectActive == nil {
+		return named
+	}
+	selected := d.SelectActive(ring, named)
+	if selected == "" || selected == named {
+		return named
+	}
+	for _, c := range ring {
+		if c.Name == selected {
+			d.event(slog.LevelWarn, "active credential of the secret overridden by the selector", "named", named, "selected", selected)
+			return selected
+		}
+	}
+	return named
+}
+
+// sameStrings reports whether both lists hold the same strings in order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
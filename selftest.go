@@ -0,0 +1,157 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SelfTestStep is the outcome of one step of a SelfTest run.
+type SelfTestStep struct {
+	// Name - Name of the step, such as "connect_active"
+	Name string
+	// Credential - The credential, "odd" or "even" or the name of a slot, the
+	// step connected with
+	Credential string
+	// Duration - How long the step took
+	Duration time.Duration
+	// Err - Why the step failed, nil when it passed
+	Err error
+}
+
+// SelfTestReport is the structured report returned by SelfTest.
+type SelfTestReport struct {
+	// Passed - Whether all the steps passed
+	Passed bool
+	// InitialActive - The active credential before the self test began
+	InitialActive string
+	// FinalActive - The active credential after the refresher was invoked
+	FinalActive string
+	// Steps - The steps in the order they were run
+	Steps []SelfTestStep
+}
+
+// SelfTest runs a scripted sequence against the database to verify the
+// rotation setup end to end. It connects with the active credential, then
+// with each standby credential, then opens a connection through the driver
+// while simulating an authentication failure of the active credential,
+// invokes the CredentialRefresher (or refreshes the Provider) and finally
+// verifies that the driver connects with whichever credential is active after
+// the swap. It is meant for deployment smoke tests. Please note that the
+// simulated failure rotates the active credential of the driver just like a
+// real failure would.
+func (d *Driver) SelfTest(ctx context.Context, dsn string) *SelfTestReport {
+	report := &SelfTestReport{Passed: true}
+	dial := d.dialContext(ctx)
+	run := func(name string, step func() (string, error)) {
+		start := time.Now()
+		cred, err := step()
+		report.Steps = append(report.Steps, SelfTestStep{
+			Name:       name,
+			Credential: cred,
+			Duration:   time.Since(start),
+			Err:        err,
+		})
+		if err != nil {
+			report.Passed = false
+		}
+	}
+	connectWith := func(slot string) (string, error) {
+		return slot, d.checkSlot(ctx, dsn, slot)
+	}
+
+	if err := d.syncProvider(ctx); err != nil {
+		report.Passed = false
+		report.Steps = append(report.Steps, SelfTestStep{Name: "read_provider", Err: err})
+		return report
+	}
+	active, err := d.currentActive()
+	if err != nil {
+		report.Passed = false
+		report.Steps = append(report.Steps, SelfTestStep{Name: "read_active", Err: err})
+		return report
+	}
+	report.InitialActive = active
+
+	run("connect_active", func() (string, error) {
+		return connectWith(active)
+	})
+	ring := d.current().ring
+	for _, slot := range ring {
+		if slot.Name == active {
+			continue
+		}
+		standby := slot.Name
+		run("connect_standby", func() (string, error) {
+			return connectWith(standby)
+		})
+	}
+	fallback := ring[(slotIndex(ring, active)+1)%len(ring)].Name
+	run("simulated_auth_failure", func() (string, error) {
+		failed := false
+		simulated := func(slotDSN string) (driver.Conn, error) {
+			if !failed {
+				failed = true
+				return nil, &pq.Error{Code: "28P01", Message: "simulated authentication failure"}
+			}
+			return dial(slotDSN)
+		}
+		refreshRequested := false
+		conn, err := d.open(dsn, nil, simulated, func() { refreshRequested = true }, nil)
+		if err != nil {
+			return fallback, err
+		}
+		defer conn.Close()
+		if !refreshRequested {
+			return fallback, errors.New("Fallback did not request a credential refresh")
+		}
+		return fallback, nil
+	})
+	run("invoke_refresher", func() (string, error) {
+		done := make(chan error, 1)
+		go func() {
+			done <- d.refreshCredentials()
+		}()
+		select {
+		case err := <-done:
+			return "", err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	})
+	run("verify_swap", func() (string, error) {
+		final, err := d.currentActive()
+		if err != nil {
+			return "", err
+		}
+		report.FinalActive = final
+		return connectWith(final)
+	})
+	return report
+}
+
+// checkSlot opens and closes a connection with the credential of the slot,
+// leaving the rotation state of the driver untouched.
+func (d *Driver) checkSlot(ctx context.Context, dsn string, slot string) error {
+	slotDSN, err := d.dsnFor(dsn, slot)
+	if err != nil {
+		return err
+	}
+	conn, err := d.dialContext(ctx)(slotDSN)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// currentActive reads the active credential under the lock of the driver.
+func (d *Driver) currentActive() (string, error) {
+	if err := d.mux.acquire(d, d.LockTimeout); err != nil {
+		return "", err
+	}
+	defer d.mux.release()
+	return d.ActiveCredential, nil
+}
@@ -0,0 +1,92 @@
+package gopqr
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	//DEFAULTSHADOWINTERVAL - Interval StartShadowValidation validates the
+	//inactive credentials at unless told otherwise
+	DEFAULTSHADOWINTERVAL = 5 * time.Minute
+	//DEFAULTSHADOWTIMEOUT - Time a throwaway connection of
+	//StartShadowValidation gets to authenticate
+	DEFAULTSHADOWTIMEOUT = 10 * time.Second
+)
+
+// StartShadowValidation validates the inactive credentials of the driver in
+// the background every interval (defaults to DEFAULTSHADOWINTERVAL). It
+// opens and closes a throwaway connection to the DSN with the credential of
+// every slot that is not active, leaving the rotation state alone, so that
+// a standby credential revoked early is caught before a failover needs it
+// rather than during the outage. Each outcome is handed to the
+// OnShadowValidation hook of the driver, and a credential failing
+// authentication is logged and counted by the Metrics. It returns a func
+// that stops the validation and waits for one in flight to finish.
+func (d *Driver) StartShadowValidation(dsn string, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DEFAULTSHADOWINTERVAL
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.runWorker("shadow_validation", true, func(ran func()) {
+			t := time.NewTimer(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					d.shadowValidate(ctx, dsn)
+					ran()
+					t.Reset(interval)
+				}
+			}
+		})
+	}()
+	var once sync.Once
+	return d.tracked(func() {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+}
+
+// shadowValidate validates the credential of every inactive slot once.
+func (d *Driver) shadowValidate(ctx context.Context, dsn string) {
+	snap := d.current()
+	ring, active := snap.ring, snap.activeSlot()
+	for _, cred := range ring {
+		if cred.Name == active {
+			continue
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, DEFAULTSHADOWTIMEOUT)
+		start := time.Now()
+		err := redact(d.checkSlot(checkCtx, dsn, cred.Name), ring)
+		cancel()
+		if ctx.Err() != nil {
+			// stopped, the outcome tells nothing of the credential
+			return
+		}
+		v := SlotValidation{Slot: cred.Name, Authenticated: err == nil, Duration: time.Since(start), Err: err}
+		switch {
+		case err == nil:
+			d.event(slog.LevelDebug, "inactive credential authenticates", "slot", cred.Name)
+		case d.isAuthFailure(err):
+			d.metrics().AuthFailed(cred.Name)
+			d.logf("inactive credential %v failed authentication - %v", cred.Name, err)
+			d.event(slog.LevelWarn, "inactive credential failed authentication", "slot", cred.Name, "error", err)
+		default:
+			d.event(slog.LevelWarn, "validating inactive credential failed", "slot", cred.Name, "error", err)
+		}
+		if d.OnShadowValidation != nil {
+			d.OnShadowValidation(v)
+		}
+	}
+}
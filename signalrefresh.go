@@ -0,0 +1,89 @@
+package gopqr
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// StartRefreshOn refreshes the credentials of the driver in the background
+// whenever the trigger receives, until it is closed, like for an operator
+// endpoint or a watch of the secret store of your own. Triggers arriving
+// while a refresh is in flight are served by the next refresh. It returns a
+// func that stops listening and waits for a refresh in flight to finish.
+func (d *Driver) StartRefreshOn(trigger <-chan struct{}) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.runWorker("triggered_refresh", true, func(ran func()) {
+			for {
+				select {
+				case <-done:
+					return
+				case _, ok := <-trigger:
+					if !ok {
+						return
+					}
+					if err := d.refreshCredentials(); err != nil {
+						d.logf("triggered credential refresh failed - %v", err)
+					}
+					ran()
+				}
+			}
+		})
+	}()
+	var once sync.Once
+	return d.tracked(func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	})
+}
+
+// StartRefreshOnSignal refreshes the credentials of the driver whenever the
+// process receives one of the signals, SIGHUP unless given, so that
+// operators rotating secrets out of band can have the process pick up the
+// new credentials with a kill -HUP rather than a redeploy. Signals arriving
+// while a refresh is in flight are served by the next refresh. It returns a
+// func that stops listening for the signals and waits for a refresh in
+// flight to finish. Note that the signals no longer terminate the process
+// until then.
+func (d *Driver) StartRefreshOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		if hangupSignal == nil {
+			return func() {}
+		}
+		sigs = []os.Signal{hangupSignal}
+	}
+	received := make(chan os.Signal, 1)
+	trigger := make(chan struct{})
+	signal.Notify(received, sigs...)
+	done := make(chan struct{})
+	go func() {
+		defer close(trigger)
+		for {
+			select {
+			case <-done:
+				return
+			case <-received:
+				select {
+				case trigger <- struct{}{}:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	stopRefresh := d.StartRefreshOn(trigger)
+	var once sync.Once
+	return d.tracked(func() {
+		once.Do(func() {
+			signal.Stop(received)
+			close(done)
+			stopRefresh()
+		})
+	})
+}
@@ -0,0 +1,264 @@
+package sim
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package sim simulates how the connection pool of database/sql behaves while
+github.com/chandranarreddy/gopqr rotates credentials, for capacity planning
+before rotation is enabled in production. Given the pool settings, the load,
+the rotation cadence and how long the superseded credential stays valid, it
+tells how many connections are on stale credentials at any time, and how
+many outlive the drain window, so that ConnMaxLifetime and the drain window
+can be chosen to fit each other.
+
+Usage:
+	res, err := sim.Run(sim.Config{
+		MaxOpenConns:     20,
+		MaxIdleConns:     10,
+		ConnMaxLifetime:  30 * time.Minute,
+		QPS:              200,
+		QueryDuration:    20 * time.Millisecond,
+		RotationInterval: 24 * time.Hour,
+		DrainWindow:      time.Hour,
+		Duration:         72 * time.Hour,
+	})
+	...
+	fmt.Println(res.MaxBeyondDrain, res.LongestStale)
+
+The model is the one database/sql follows - idle connections are reused
+last in first out, expired ones are closed when they are returned or found
+idle, and new connections are opened with the credential active at the time.
+*/
+
+const (
+	//DEFAULTSTEP - default resolution of the simulation, which is made finer
+	// for queries shorter than it
+	DEFAULTSTEP = time.Second
+	//DEFAULTSAMPLEINTERVAL - default time between the samples of a result
+	DEFAULTSAMPLEINTERVAL = time.Minute
+	//DEFAULTMAXIDLECONNS - idle connections database/sql keeps unless told otherwise
+	DEFAULTMAXIDLECONNS = 2
+)
+
+// Config describes the pool, the load and the rotation to simulate.
+type Config struct {
+	// MaxOpenConns - As set with sql.DB.SetMaxOpenConns, 0 for unlimited
+	MaxOpenConns int
+	// MaxIdleConns - As set with sql.DB.SetMaxIdleConns, defaults to
+	// DEFAULTMAXIDLECONNS. A negative value keeps no idle connections.
+	MaxIdleConns int
+	// ConnMaxLifetime - As set with sql.DB.SetConnMaxLifetime, 0 for unlimited
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime - As set with sql.DB.SetConnMaxIdleTime, 0 for unlimited
+	ConnMaxIdleTime time.Duration
+	// QPS - Mean queries per second, arriving as a Poisson process
+	QPS float64
+	// QueryDuration - How long a connection is held per query
+	QueryDuration time.Duration
+	// RotationInterval - Time between rotations of the credentials
+	RotationInterval time.Duration
+	// DrainWindow - How long a superseded credential stays valid after a
+	// rotation, like until the rotator drops the old password or
+	// terminates its sessions
+	DrainWindow time.Duration
+	// Duration - Simulated time span
+	Duration time.Duration
+	// Step - Resolution of the simulation, defaults to DEFAULTSTEP or the
+	// QueryDuration, whichever is shorter
+	Step time.Duration
+	// SampleInterval - Time between the samples of the result, defaults to
+	// DEFAULTSAMPLEINTERVAL. The peaks of the result account for every step.
+	SampleInterval time.Duration
+	// Seed - Seed of the arrivals, so that runs can be repeated
+	Seed int64
+}
+
+// Sample is the state of the pool at one step of the simulation.
+type Sample struct {
+	// At - Simulated time since the start
+	At time.Duration
+	// Open - Open connections, idle or in use
+	Open int
+	// InUse - Connections running a query
+	InUse int
+	// Stale - Open connections authenticated with a superseded credential
+	Stale int
+	// BeyondDrain - Stale connections whose credential has outlived the
+	// drain window
+	BeyondDrain int
+}
+
+// Result is the outcome of a simulation.
+type Result struct {
+	// Samples - The state of the pool at every SampleInterval
+	Samples []Sample
+	// Rotations - Rotations that happened
+	Rotations int
+	// MaxOpen, MaxStale and MaxBeyondDrain - Peaks over the run
+	MaxOpen        int
+	MaxStale       int
+	MaxBeyondDrain int
+	// LongestStale - Longest a connection stayed open after its credential
+	// was superseded
+	LongestStale time.Duration
+	// Waits - Steps queries spent waiting for a connection because the pool
+	// was at MaxOpenConns
+	Waits int
+}
+
+type conn struct {
+	generation int
+	opened     time.Duration
+	idleSince  time.Duration
+	busyUntil  time.Duration
+}
+
+// Run simulates the pool as configured.
+func Run(cfg Config) (*Result, error) {
+	if cfg.Duration <= 0 || cfg.QPS <= 0 || cfg.QueryDuration <= 0 || cfg.RotationInterval <= 0 {
+		return nil, errors.New("Duration, QPS, QueryDuration and RotationInterval must be positive")
+	}
+	step := cfg.Step
+	if step <= 0 {
+		step = DEFAULTSTEP
+		if cfg.QueryDuration < step {
+			step = cfg.QueryDuration
+		}
+	}
+	interval := cfg.SampleInterval
+	if interval <= 0 {
+		interval = DEFAULTSAMPLEINTERVAL
+	}
+	maxIdle := cfg.MaxIdleConns
+	if maxIdle == 0 {
+		maxIdle = DEFAULTMAXIDLECONNS
+	}
+	if maxIdle < 0 {
+		maxIdle = 0
+	}
+	if cfg.MaxOpenConns > 0 && maxIdle > cfg.MaxOpenConns {
+		maxIdle = cfg.MaxOpenConns
+	}
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	res := &Result{Samples: make([]Sample, 0, int(cfg.Duration/interval)+1)}
+	nextSample := time.Duration(0)
+
+	var idle, busy []*conn
+	waiting := 0
+	generation := 0
+	rotatedAt := []time.Duration{0}
+	expired := func(c *conn, now time.Duration) bool {
+		return cfg.ConnMaxLifetime > 0 && now-c.opened >= cfg.ConnMaxLifetime
+	}
+	closeConn := func(c *conn, now time.Duration) {
+		if c.generation < generation {
+			if stale := now - rotatedAt[c.generation+1]; stale > res.LongestStale {
+				res.LongestStale = stale
+			}
+		}
+	}
+
+	for now := time.Duration(0); now <= cfg.Duration; now += step {
+		if now/cfg.RotationInterval > time.Duration(generation) {
+			generation++
+			rotatedAt = append(rotatedAt, now)
+			res.Rotations++
+		}
+
+		// return the connections whose query finished
+		running := busy[:0]
+		for _, c := range busy {
+			if c.busyUntil > now {
+				running = append(running, c)
+				continue
+			}
+			if expired(c, now) || len(idle) >= maxIdle {
+				closeConn(c, now)
+				continue
+			}
+			c.idleSince = now
+			idle = append(idle, c)
+		}
+		busy = running
+
+		// the cleaner closes expired idle connections
+		kept := idle[:0]
+		for _, c := range idle {
+			if expired(c, now) || (cfg.ConnMaxIdleTime > 0 && now-c.idleSince >= cfg.ConnMaxIdleTime) {
+				closeConn(c, now)
+				continue
+			}
+			kept = append(kept, c)
+		}
+		idle = kept
+
+		arrivals := waiting + poisson(rng, cfg.QPS*step.Seconds())
+		waiting = 0
+		for i := 0; i < arrivals; i++ {
+			var c *conn
+			if n := len(idle); n > 0 {
+				c, idle = idle[n-1], idle[:n-1]
+			} else if cfg.MaxOpenConns <= 0 || len(busy) < cfg.MaxOpenConns {
+				c = &conn{generation: generation, opened: now}
+			} else {
+				waiting = arrivals - i
+				res.Waits += waiting
+				break
+			}
+			c.busyUntil = now + cfg.QueryDuration
+			busy = append(busy, c)
+		}
+
+		sample := Sample{At: now, InUse: len(busy), Open: len(busy) + len(idle)}
+		for _, set := range [][]*conn{busy, idle} {
+			for _, c := range set {
+				if c.generation < generation {
+					sample.Stale++
+					if now-rotatedAt[c.generation+1] > cfg.DrainWindow {
+						sample.BeyondDrain++
+					}
+				}
+			}
+		}
+		if now >= nextSample {
+			res.Samples = append(res.Samples, sample)
+			nextSample += interval
+		}
+		res.MaxOpen = max(res.MaxOpen, sample.Open)
+		res.MaxStale = max(res.MaxStale, sample.Stale)
+		res.MaxBeyondDrain = max(res.MaxBeyondDrain, sample.BeyondDrain)
+	}
+	end := cfg.Duration
+	for _, set := range [][]*conn{busy, idle} {
+		for _, c := range set {
+			closeConn(c, end)
+		}
+	}
+	return res, nil
+}
+
+// poisson draws from a Poisson distribution of mean lambda.
+func poisson(rng *rand.Rand, lambda float64) int {
+	if lambda > 30 {
+		// the normal approximation is close enough and does not underflow
+		n := int(math.Round(lambda + math.Sqrt(lambda)*rng.NormFloat64()))
+		if n < 0 {
+			return 0
+		}
+		return n
+	}
+	l, k, p := math.Exp(-lambda), 0, 1.0
+	for {
+		p *= rng.Float64()
+		if p <= l {
+			return k
+		}
+		k++
+	}
+}
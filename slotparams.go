@@ -0,0 +1,46 @@
+package gopqr
+
+import (
+	"fmt"
+	"sort"
+)
+
+// reservedParams are the settings a credential sets through its own fields,
+// which its Params cannot override.
+var reservedParams = map[string]bool{
+	"user":     true,
+	"password": true,
+	"host":     true,
+	"port":     true,
+}
+
+// paramsFor returns the query parameters of the credential as settings, in
+// the order of their keys so that the DSNs built with them are the same
+// every time.
+func paramsFor(cred Credential) ([]dsnSetting, error) {
+	if len(cred.Params) == 0 {
+		return nil, nil
+	}
+	settings := make([]dsnSetting, 0, len(cred.Params))
+	for k, v := range cred.Params {
+		if reservedParams[k] {
+			return nil, fmt.Errorf("%w - parameter %q of %v is set through the credential itself", ErrInvalidDSN, k, cred.Name)
+		}
+		settings = append(settings, dsnSetting{key: k, value: v})
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].key < settings[j].key })
+	return settings, nil
+}
+
+// sameParams reports whether both sets of parameters are the same.
+func sameParams(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
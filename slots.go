@@ -0,0 +1,106 @@
+package gopqr
+
+import "time"
+
+// slots returns the ring of credentials the driver rotates through. Unless
+// Slots is set, the ring is the odd and the even credential.
+func (d *Driver) slots() []Credential {
+	if len(d.Slots) > 0 {
+		return d.Slots
+	}
+	return []Credential{
+		{Name: oddCredential.String(), Username: d.OddUsername, Password: d.OddPassword},
+		{Name: evenCredential.String(), Username: d.EvenUsername, Password: d.EvenPassword},
+	}
+}
+
+// slotIndex returns the index of the named slot in the ring. A name that is
+// not in the ring maps to the last slot, just like anything but "odd" has
+// always meant the even credential.
+func slotIndex(ring []Credential, name string) int {
+	for i := range ring {
+		if ring[i].Name == name {
+			return i
+		}
+	}
+	return len(ring) - 1
+}
+
+// validSlot returns the index of the first slot from active on, in ring
+// order, whose credential is valid at the time, or active when none is.
+// Last resort slots are passed over.
+func validSlot(ring []Credential, active int, t time.Time) int {
+	for i := 0; i < len(ring); i++ {
+		if next := (active + i) % len(ring); ring[next].ValidAt(t) && !ring[next].LastResort {
+			return next
+		}
+	}
+	return active
+}
+
+// nextSlot returns the name of the slot following the named one in the ring,
+// passing over last resort slots.
+func (d *Driver) nextSlot(name string) string {
+	ring := d.slots()
+	from := slotIndex(ring, name)
+	for i := 1; i < len(ring); i++ {
+		if next := ring[(from+i)%len(ring)]; !next.LastResort {
+			return next.Name
+		}
+	}
+	return name
+}
+
+// fallbackOrder returns the slots of the ring to fall back to when the one
+// at index active failed authentication, in ring order from it, with the
+// last resort slots after all the others.
+func fallbackOrder(ring []Credential, active int) []Credential {
+	fallbacks := make([]Credential, 0, len(ring))
+	var lastResort []Credential
+	for i := 1; i < len(ring); i++ {
+		c := ring[(active+i)%len(ring)]
+		if c.LastResort {
+			lastResort = append(lastResort, c)
+			continue
+		}
+		fallbacks = append(fallbacks, c)
+	}
+	return append(fallbacks, lastResort...)
+}
+
+// equal reports whether both sets of credentials are the same.
+func (c Credentials) equal(o Credentials) bool {
+	if c.Active != o.Active || c.Host != o.Host || c.Port != o.Port || c.SSLMode != o.SSLMode ||
+		c.SSLRootCert != o.SSLRootCert || c.SSLCert != o.SSLCert || c.SSLKey != o.SSLKey ||
+		len(c.Slots) != len(o.Slots) || len(c.Extra) != len(o.Extra) || len(c.Targets) != len(o.Targets) {
+		return false
+	}
+	for i, t := range c.Targets {
+		if u := o.Targets[i]; t.Host != u.Host || t.DBName != u.DBName || !t.Credentials.equal(u.Credentials) {
+			return false
+		}
+	}
+	for i := range c.Slots {
+		if !c.Slots[i].same(o.Slots[i]) {
+			return false
+		}
+	}
+	for k, v := range c.Extra {
+		if w, ok := o.Extra[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// OddEven returns the default two slot Credentials made of the odd and even
+// credential, with active being "odd" or "even".
+func OddEven(odd, even Credential, active string) Credentials {
+	odd.Name = oddCredential.String()
+	even.Name = evenCredential.String()
+	c := Credentials{Slots: []Credential{odd, even}}
+	if active != oddCredential.String() {
+		c.Active = 1
+	}
+	return c
+}
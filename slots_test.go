@@ -0,0 +1,22 @@
+package gopqr
+
+import "testing"
+
+func TestCredentialsEqualComparesTargets(t *testing.T) {
+	target := func(password string) Credentials {
+		return Credentials{Targets: []TargetCredentials{{
+			Host:        "db.internal",
+			DBName:      "orders",
+			Credentials: Credentials{Slots: []Credential{{Name: "odd", Username: "u", Password: password}}},
+		}}}
+	}
+	if !target("pw").equal(target("pw")) {
+		t.Error("equal = false for the same targets, want true")
+	}
+	if target("pw").equal(target("rotated")) {
+		t.Error("equal = true after the password of a target was rotated, want false")
+	}
+	if target("pw").equal(Credentials{}) {
+		t.Error("equal = true after the targets were dropped, want false")
+	}
+}
@@ -0,0 +1,222 @@
+package gopqr
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// snapshot is an immutable copy of the credentials and endpoint of the
+// driver. It is published whenever they change under the lock, so that Open
+// and the pool hooks read them without taking the lock and without racing
+// with a CredentialRefresher writing the exported fields.
+type snapshot struct {
+	ring    []Credential
+	active  string
+	host    string
+	port    string
+	sslmode string
+	// rootcert, cert and key - The TLS settings of the driver
+	rootcert string
+	cert     string
+	key      string
+	// gen - The generation of the snapshot, under which the DSN cache keeps
+	// the queries built with its settings
+	gen uint64
+	// holds - Opens and Snapshots reading the credentials of the snapshot,
+	// which keep their PasswordBytes from being zeroed once it is retired
+	holds   atomic.Int64
+	retired atomic.Bool
+}
+
+// retiring holds the snapshots the driver replaced while they were held.
+type retiring struct {
+	mu    sync.Mutex
+	snaps []*snapshot
+}
+
+// publish takes a snapshot of the credentials and endpoint of the driver.
+// It must be called with the lock held, after they changed. The
+// PasswordBytes of the credentials it retires are zeroed once no Open holds
+// a snapshot with them, see sweep.
+func (d *Driver) publish() {
+	d.retiring.mu.Lock()
+	defer d.retiring.mu.Unlock()
+	if prev := d.snap.Swap(d.takeSnapshot()); prev != nil {
+		prev.retired.Store(true)
+		d.retiring.snaps = append(d.retiring.snaps, prev)
+	}
+	d.sweep()
+}
+
+// hold returns the current snapshot, held until release is called on it, so
+// that the PasswordBytes of its credentials are not zeroed while it is read.
+func (d *Driver) hold() *snapshot {
+	for {
+		s := d.current()
+		s.holds.Add(1)
+		if !s.retired.Load() || d.snap.Load() == s {
+			// the current snapshot is only retired by the WipeOnClose of
+			// Close, after which there is nothing left to spare
+			return s
+		}
+		// replaced between loading and holding it, so it may be zeroed
+		// already
+		d.release(s)
+	}
+}
+
+// release releases a snapshot returned by hold.
+func (d *Driver) release(s *snapshot) {
+	if s.holds.Add(-1) == 0 && s.retired.Load() {
+		d.retiring.mu.Lock()
+		d.sweep()
+		d.retiring.mu.Unlock()
+	}
+}
+
+// retireCurrent retires the current snapshot as well, for its PasswordBytes
+// to be zeroed once no Open holds it any longer.
+func (d *Driver) retireCurrent() {
+	d.retiring.mu.Lock()
+	defer d.retiring.mu.Unlock()
+	s := d.current()
+	if !s.retired.Swap(true) {
+		d.retiring.snaps = append(d.retiring.snaps, s)
+	}
+	d.sweep()
+}
+
+// sweep zeroes the PasswordBytes of the retired snapshots no longer held,
+// sparing those the current snapshot or a held one still shares, and drops
+// the queries the DSN cache built with their settings. It must be called
+// with the retiring lock held.
+func (d *Driver) sweep() {
+	var live [][]Credential
+	if current := d.snap.Load(); !current.retired.Load() {
+		live = append(live, current.ring)
+	}
+	var idle []*snapshot
+	held := d.retiring.snaps[:0]
+	for _, s := range d.retiring.snaps {
+		if s.holds.Load() > 0 {
+			live = append(live, s.ring)
+			held = append(held, s)
+		} else {
+			idle = append(idle, s)
+		}
+	}
+	for i := len(held); i < len(d.retiring.snaps); i++ {
+		d.retiring.snaps[i] = nil
+	}
+	d.retiring.snaps = held
+	for _, s := range idle {
+		wipeRetired(s.ring, live...)
+		d.dsns.drop(s.gen)
+	}
+}
+
+func (d *Driver) takeSnapshot() *snapshot {
+	return &snapshot{
+		ring:     append([]Credential(nil), d.slots()...),
+		active:   d.ActiveCredential,
+		host:     d.Host,
+		port:     d.Port,
+		sslmode:  d.SSLMode,
+		rootcert: d.SSLRootCert,
+		cert:     d.SSLCert,
+		key:      d.SSLKey,
+		gen:      d.snapGen.Add(1),
+	}
+}
+
+// current returns the last published snapshot. Until one is published, like
+// for a driver built with its fields set and used right away, it is taken
+// from the fields as they are under the lock. A holder of the lock published
+// one as they acquired it, so current never waits for the lock it holds.
+func (d *Driver) current() *snapshot {
+	if s := d.snap.Load(); s != nil {
+		return s
+	}
+	if d.mux.acquire(d, d.LockTimeout) == nil {
+		d.mux.release()
+	}
+	return d.snap.Load()
+}
+
+// activeSlot returns the name of the slot Open connects with first, which is
+// the active credential unless GOPQR_FORCE_SLOT names another slot.
+func (s *snapshot) activeSlot() string {
+	return activeSlotOf(s.ring, s.active)
+}
+
+// endpoint identifies the host, port and sslmode overrides in effect.
+func (s *snapshot) endpoint() string {
+	return s.host + "\x00" + s.port + "\x00" + s.sslmode
+}
+
+// CredentialsSnapshot is a copy of the credentials of a driver, for health
+// checks, rotation controllers and debugging tools to read without racing
+// with the refreshes, which the exported fields of the driver do not allow.
+// It is the driver's own, so changing it changes nothing on the driver.
+type CredentialsSnapshot struct {
+	// Slots - The ring of credentials, the odd and the even credential
+	// unless the driver was given Slots
+	Slots []Credential
+	// Active - Name of the active credential
+	Active string
+	// Host, Port and SSLMode - The endpoint overrides in effect, if any
+	Host    string
+	Port    string
+	SSLMode string
+	// SSLRootCert, SSLCert and SSLKey - The TLS settings of the driver
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+}
+
+// Snapshot returns a copy of the credentials of the driver as they were
+// last installed, by its Provider or at the ReleaseLock of a refresher, so
+// never half written. It takes no lock, so it may be called from anywhere,
+// a refresher included. Drivers built by New and NewDriver have their
+// credentials installed already. For others, until credentials were
+// installed once, it copies the fields of the driver as they are, like the
+// first Open does, so call it once they are set. The copy holds passwords, so keep it away from logs.
+func (d *Driver) Snapshot() CredentialsSnapshot {
+	s := d.hold()
+	defer d.release(s)
+	snap := CredentialsSnapshot{
+		Slots:       make([]Credential, len(s.ring)),
+		Active:      s.active,
+		Host:        s.host,
+		Port:        s.port,
+		SSLMode:     s.sslmode,
+		SSLRootCert: s.rootcert,
+		SSLCert:     s.cert,
+		SSLKey:      s.key,
+	}
+	for i, c := range s.ring {
+		// the slices and maps are shared with the driver, which zeroes the
+		// PasswordBytes once they are replaced and no longer held
+		c.PasswordBytes = append([]byte(nil), c.PasswordBytes...)
+		c.Stages = append([]string(nil), c.Stages...)
+		c.Params = copyExtra(c.Params)
+		snap.Slots[i] = c
+	}
+	return snap
+}
+
+// Slot returns the credential of the named slot.
+func (s CredentialsSnapshot) Slot(name string) (Credential, bool) {
+	for _, c := range s.Slots {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Credential{}, false
+}
+
+// ActiveCredential returns the active credential.
+func (s CredentialsSnapshot) ActiveCredential() Credential {
+	c, _ := s.Slot(s.Active)
+	return c
+}
@@ -0,0 +1,63 @@
+package gopqr_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+// TestSnapshotConcurrentWithRefresher is meant for -race, which CI runs: a
+// refresher writing the fields of the driver under the lock must not race
+// with Snapshot, which takes no lock.
+func TestSnapshotConcurrentWithRefresher(t *testing.T) {
+	d, err := gopqr.NewDriver(gopqr.Config{
+		OddUsername:         "app_odd",
+		OddPassword:         "odd-0",
+		EvenUsername:        "app_even",
+		EvenPassword:        "even-0",
+		ActiveCredential:    "odd",
+		CredentialRefresher: func(*gopqr.Driver) {},
+	})
+	if err != nil {
+		t.Fatalf("NewDriver failed - %v", err)
+	}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snap := d.Snapshot()
+				if len(snap.Slots) != 2 || (snap.Active != "odd" && snap.Active != "even") {
+					t.Errorf("Snapshot = %+v, want the odd and even credentials", snap)
+					return
+				}
+				odd, even := snap.Slots[0].Password, snap.Slots[1].Password
+				if odd[len("odd-"):] != even[len("even-"):] {
+					t.Errorf("Snapshot holds %v and %v, want the passwords of one refresh", odd, even)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 200; i++ {
+		d.AcquireLock()
+		d.OddPassword, d.EvenPassword = fmt.Sprint("odd-", i), fmt.Sprint("even-", i)
+		if i%2 == 0 {
+			d.ActiveCredential = "odd"
+		} else {
+			d.ActiveCredential = "even"
+		}
+		d.ReleaseLock()
+	}
+	close(done)
+	wg.Wait()
+}
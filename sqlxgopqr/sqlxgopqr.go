@@ -0,0 +1,117 @@
+package sqlxgopqr
+
+import (
+	"errors"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package sqlxgopqr opens a github.com/jmoiron/sqlx database over a
+github.com/chandranarreddy/gopqr driver in one call, with the pool sized and
+the lifetime of its connections bounded so that connections of rotated out
+credentials are recycled. It lives apart from the driver so that the driver
+does not depend on sqlx.
+
+Usage:
+	p, err := awssm.New(awssm.Config{SecretID: "prod/orders/db"})
+	...
+	db, err := sqlxgopqr.OpenSQLX("postgres://1.2.3.4:5432/mydb?sslmode=verify-full", p,
+		sqlxgopqr.WithMaxOpenConns(20),
+		sqlxgopqr.WithDriverOptions(gopqr.WithRotationPolicy(gopqr.OnAuthFailure())),
+	)
+*/
+
+const (
+	//DEFAULTMAXOPENCONNS - default number of connections the pool opens at most
+	DEFAULTMAXOPENCONNS = 10
+	//DEFAULTMAXIDLECONNS - default number of idle connections the pool keeps
+	DEFAULTMAXIDLECONNS = 5
+	//DEFAULTCONNMAXLIFETIME - default time after which a connection is
+	// recycled, so that the pool moves on to the credential that is active
+	DEFAULTCONNMAXLIFETIME = 30 * time.Minute
+	//DEFAULTCONNMAXIDLETIME - default time after which an idle connection is
+	// closed
+	DEFAULTCONNMAXIDLETIME = 5 * time.Minute
+)
+
+// Option configures the database opened by OpenSQLX.
+type Option func(*config)
+
+type config struct {
+	driverOpts      []gopqr.Option
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+}
+
+// WithDriverOptions configures the driver the database is opened over, like
+// its RotationPolicy or its logger.
+func WithDriverOptions(opts ...gopqr.Option) Option {
+	return func(c *config) { c.driverOpts = append(c.driverOpts, opts...) }
+}
+
+// WithMaxOpenConns sets the number of connections the pool opens at most,
+// DEFAULTMAXOPENCONNS unless set. Negative is unlimited, like
+// sql.DB.SetMaxOpenConns.
+func WithMaxOpenConns(n int) Option {
+	return func(c *config) { c.maxOpenConns = n }
+}
+
+// WithMaxIdleConns sets the number of idle connections the pool keeps,
+// DEFAULTMAXIDLECONNS unless set. Negative keeps none, like
+// sql.DB.SetMaxIdleConns.
+func WithMaxIdleConns(n int) Option {
+	return func(c *config) { c.maxIdleConns = n }
+}
+
+// WithConnMaxLifetime sets the time after which a connection is recycled,
+// DEFAULTCONNMAXLIFETIME unless set. Negative never recycles them,
+// like sql.DB.SetConnMaxLifetime.
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(c *config) { c.connMaxLifetime = d }
+}
+
+// WithConnMaxIdleTime sets the time after which an idle connection is
+// closed, DEFAULTCONNMAXIDLETIME unless set. Negative never closes them,
+// like sql.DB.SetConnMaxIdleTime.
+func WithConnMaxIdleTime(d time.Duration) Option {
+	return func(c *config) { c.connMaxIdleTime = d }
+}
+
+// OpenSQLX builds a driver taking its credentials from the provider and opens
+// a sqlx database over it, without registering the driver with sql.Register.
+// The DSN carries no credentials. Like sqlx.Open, no connection is made until
+// one is needed, and queries are bound like those of lib/pq.
+func OpenSQLX(dsn string, provider gopqr.CredentialProvider, opts ...Option) (*sqlx.DB, error) {
+	if provider == nil {
+		return nil, errors.New("OpenSQLX needs a credential provider")
+	}
+	c := config{
+		maxOpenConns:    DEFAULTMAXOPENCONNS,
+		maxIdleConns:    DEFAULTMAXIDLECONNS,
+		connMaxLifetime: DEFAULTCONNMAXLIFETIME,
+		connMaxIdleTime: DEFAULTCONNMAXIDLETIME,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	d, err := gopqr.New(append([]gopqr.Option{gopqr.WithProvider(provider)}, c.driverOpts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if c.maxOpenConns > 0 && c.maxIdleConns > c.maxOpenConns {
+		c.maxIdleConns = c.maxOpenConns
+	}
+	db := sqlx.NewDb(gopqr.OpenDB(dsn, d), "postgres")
+	db.SetMaxOpenConns(c.maxOpenConns)
+	db.SetMaxIdleConns(c.maxIdleConns)
+	db.SetConnMaxLifetime(c.connMaxLifetime)
+	db.SetConnMaxIdleTime(c.connMaxIdleTime)
+	return db, nil
+}
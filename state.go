@@ -0,0 +1,76 @@
+package gopqr
+
+import (
+	"time"
+)
+
+// State is a snapshot of the rotation state of the driver, to answer
+// programmatically whether an instance is in the middle of a rotation and
+// whether its refresh is healthy.
+type State struct {
+	// ActiveSlot - Name of the slot Open connects with first
+	ActiveSlot string
+	// LastRotation - When the active credential last changed, or the first
+	// Open if it never did
+	LastRotation time.Time
+	// Generation - Times new credentials were installed on the driver
+	Generation uint64
+	// LastRefresh - When the credentials were last refreshed successfully,
+	// the zero time if they never were
+	LastRefresh time.Time
+	// LastRefreshAttempt and LastRefreshError - When the credentials were
+	// last attempted to be refreshed and its failure, nil if it succeeded
+	LastRefreshAttempt time.Time
+	LastRefreshError   error
+	// Refreshing - Whether a refresh of the credentials is in flight
+	Refreshing bool
+	// Degraded - Whether the driver gets by on a fallback credential or its
+	// error budget is exhausted
+	Degraded bool
+	// Fallbacks - Opens that authenticated with a fallback credential after
+	// the first one they tried failed authentication
+	Fallbacks uint64
+	// FailedOpens - Opens that returned an error
+	FailedOpens uint64
+	// LiveConns - Connections open by the user that authenticated them, see
+	// LiveConns
+	LiveConns map[string]int
+}
+
+// MidRotation reports whether the driver is in the middle of a rotation,
+// refreshing its credentials or getting by on a fallback credential.
+func (s State) MidRotation() bool {
+	return s.Refreshing || s.Degraded
+}
+
+// RefreshHealthy reports whether the last attempt to refresh the credentials
+// succeeded, or none was made yet.
+func (s State) RefreshHealthy() bool {
+	return s.LastRefreshError == nil
+}
+
+// refreshAttempt is the outcome of the last attempt to refresh.
+type refreshAttempt struct {
+	at  time.Time
+	err error
+}
+
+// State returns a snapshot of the rotation state of the driver.
+func (d *Driver) State() State {
+	rs := d.rotationState()
+	s := State{
+		ActiveSlot:   d.current().activeSlot(),
+		LastRotation: rs.ActiveSince,
+		Generation:   rs.Generation,
+		LastRefresh:  d.LastRefresh(),
+		Refreshing:   d.refresh.inFlight(),
+		Degraded:     rs.Degraded,
+		Fallbacks:    d.fallbacks.Load(),
+		FailedOpens:  d.failedOpens.Load(),
+		LiveConns:    d.LiveConns(),
+	}
+	if a := d.lastAttempt.Load(); a != nil {
+		s.LastRefreshAttempt, s.LastRefreshError = a.at, a.err
+	}
+	return s
+}
@@ -0,0 +1,145 @@
+package gopqr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusFile serializes the writes of the StatusFile of a driver.
+type statusFile struct {
+	mu   sync.Mutex
+	last []byte
+}
+
+// statusSnapshot is the content of the StatusFile.
+type statusSnapshot struct {
+	Active               string            `json:"active_credential"`
+	ActiveSince          time.Time         `json:"active_since"`
+	ActiveVersion        string            `json:"active_version,omitempty"`
+	ActiveValidUntil     *time.Time        `json:"active_valid_until,omitempty"`
+	Generation           uint64            `json:"generation"`
+	Degraded             bool              `json:"degraded"`
+	LastRefresh          *time.Time        `json:"last_refresh,omitempty"`
+	RiskyFeaturesAllowed bool              `json:"risky_features_allowed"`
+	NoFallback           bool              `json:"no_fallback"`
+	Version              string            `json:"version"`
+	Policy               string            `json:"policy"`
+	EnvFlags             map[string]string `json:"env_flags,omitempty"`
+}
+
+// statusChanged writes the status of the driver to its StatusFile, if it
+// has one and the status differs from what was last written. The file is
+// replaced by a rename, so readers never see it half written. Failures are
+// written to the Logger.
+func (d *Driver) statusChanged() {
+	if d.StatusFile == "" {
+		return
+	}
+	content, err := d.statusContent(d.Status())
+	if err != nil {
+		d.logf("encoding the status failed - %v", err)
+		return
+	}
+	d.statusFile.mu.Lock()
+	defer d.statusFile.mu.Unlock()
+	if bytes.Equal(content, d.statusFile.last) {
+		return
+	}
+	if err := writeAtomic(d.StatusFile, content); err != nil {
+		d.logf("writing the status file failed - %v", err)
+		return
+	}
+	d.statusFile.last = content
+}
+
+// statusContent encodes the status as JSON, or in the text format of
+// Prometheus when the StatusFile ends with ".prom" for the textfile
+// collector of node exporter.
+func (d *Driver) statusContent(s DriverStatus) ([]byte, error) {
+	if strings.HasSuffix(d.StatusFile, ".prom") {
+		var b bytes.Buffer
+		gauge := func(name, help string, v float64) {
+			fmt.Fprintf(&b, "# HELP gopqr_%v %v\n# TYPE gopqr_%v gauge\ngopqr_%v %v\n", name, help, name, name, v)
+		}
+		fmt.Fprintf(&b, "# HELP gopqr_build_info The version of the driver and its rotation policy.\n# TYPE gopqr_build_info gauge\n")
+		fmt.Fprintf(&b, "gopqr_build_info{version=%q,policy=%q} 1\n", s.Version, s.Policy)
+		fmt.Fprintf(&b, "# HELP gopqr_active_credential The active credential of the driver.\n# TYPE gopqr_active_credential gauge\n")
+		fmt.Fprintf(&b, "gopqr_active_credential{slot=%q,version=%q} 1\n", s.Active, s.ActiveVersion)
+		gauge("active_since_seconds", "When the active credential became active.", float64(s.ActiveSince.Unix()))
+		gauge("credentials_generation", "Times new credentials were installed on the driver.", float64(s.Generation))
+		gauge("degraded", "1 while the driver gets by on a fallback credential or its error budget is exhausted.", boolGauge(s.Degraded))
+		gauge("no_fallback", "1 while Open fails closed rather than fall back to the other credentials.", boolGauge(s.NoFallback))
+		if !s.LastRefresh.IsZero() {
+			gauge("last_refresh_seconds", "When the credentials were last refreshed successfully.", float64(s.LastRefresh.Unix()))
+		}
+		if !s.ActiveValidUntil.IsZero() {
+			gauge("active_valid_until_seconds", "When the active credential expires.", float64(s.ActiveValidUntil.Unix()))
+		}
+		return b.Bytes(), nil
+	}
+	snapshot := statusSnapshot{
+		Active:               s.Active,
+		ActiveSince:          s.ActiveSince,
+		ActiveVersion:        s.ActiveVersion,
+		Generation:           s.Generation,
+		Degraded:             s.Degraded,
+		RiskyFeaturesAllowed: s.RiskyFeaturesAllowed,
+		NoFallback:           s.NoFallback,
+		Version:              s.Version,
+		Policy:               s.Policy,
+		EnvFlags:             s.EnvFlags,
+	}
+	if !s.ActiveValidUntil.IsZero() {
+		snapshot.ActiveValidUntil = &s.ActiveValidUntil
+	}
+	if !s.LastRefresh.IsZero() {
+		snapshot.LastRefresh = &s.LastRefresh
+	}
+	content, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeAtomic replaces the file at path with the content by writing a
+// temporary file next to it and renaming it over the file.
+func writeAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// setDegraded records whether the driver gets by on a fallback credential.
+func (d *Driver) setDegraded(degraded bool) {
+	if d.degraded.Swap(degraded) != degraded {
+		d.statusChanged()
+	}
+}
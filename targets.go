@@ -0,0 +1,81 @@
+package gopqr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TargetCredentials - The credentials of one database of a provider serving
+// several
+type TargetCredentials struct {
+	// Host and DBName - The database the credentials are for. An empty
+	// DBName stands for every database of the host.
+	Host   string
+	DBName string
+	Credentials
+}
+
+// Target returns the CredentialProvider of the credentials of the database
+// of the host among the Targets of the provider, so that one secret serves
+// the drivers of several databases. An empty dbname picks the target of
+// every database of the host. Refreshing it refreshes the provider.
+func Target(p CredentialProvider, host, dbname string) CredentialProvider {
+	return &targetProvider{p: p, key: databaseKey(host, dbname)}
+}
+
+type targetProvider struct {
+	p   CredentialProvider
+	key string
+}
+
+func (t *targetProvider) Current(ctx context.Context) (Credentials, error) {
+	c, err := t.p.Current(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	for _, target := range c.Targets {
+		if databaseKey(target.Host, target.DBName) == t.key {
+			return target.Credentials, nil
+		}
+	}
+	return Credentials{}, &SecretNotFoundError{Source: "targets", ID: t.key, Err: errors.New("no target of the credentials is for the database")}
+}
+
+func (t *targetProvider) Refresh(ctx context.Context) error {
+	return t.p.Refresh(ctx)
+}
+
+// NewMultiDriverFor returns a MultiDriver mapping the database of every
+// target of the credentials of the provider to a Driver of its own, built
+// with the options and sourcing its credentials from the target, so that
+// one secret and one registered driver serve every database -
+//
+//	multi, err := gopqr.NewMultiDriverFor(ctx, p, gopqr.WithSticky())
+//	gopqr.MustRegisterMulti("postgresrotating", multi)
+//	orders, err := sql.Open("postgresrotating", "postgres://orders.db.internal:5432/orders")
+//
+// Every target keeps a rotation state of its own, while a refresh of any of
+// them refreshes the provider, so wrap one fetching from a secret store in
+// providers.Cache. Targets added to the secret later
+// are served once they are mapped with Map.
+func NewMultiDriverFor(ctx context.Context, p CredentialProvider, opts ...Option) (*MultiDriver, error) {
+	c, err := p.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Targets) == 0 {
+		return nil, fmt.Errorf("%w - the credentials have no targets", ErrInvalidConfig)
+	}
+	m := NewMultiDriver()
+	for _, target := range c.Targets {
+		d, err := New(append(opts[:len(opts):len(opts)], WithProvider(Target(p, target.Host, target.DBName)))...)
+		if err != nil {
+			return nil, fmt.Errorf("Building the driver of %v failed - %w", databaseKey(target.Host, target.DBName), err)
+		}
+		if err := m.Map(target.Host, target.DBName, d); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
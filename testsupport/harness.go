@@ -0,0 +1,164 @@
+package testsupport
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/lib/pq"
+)
+
+// Harness is a Postgres server in a container with an odd and an even role,
+// a SecretStore holding both and a sticky driver reading the store, for end
+// to end tests of rotation against a live server.
+type Harness struct {
+	// Server - Server the roles are created on
+	Server *Server
+	// Store - SecretStore the Driver reads its credentials from
+	Store *SecretStore
+	// Driver - Driver under test, starting with the odd role active
+	Driver *gopqr.Driver
+}
+
+// NewHarness starts a server of the version and sets up a Harness on it.
+// It is skipped where docker is not available.
+func NewHarness(t *testing.T, version string, opts ...gopqr.Option) *Harness {
+	t.Helper()
+	skipWithoutDocker(t)
+	return NewHarnessOn(t, Start(t, version), opts...)
+}
+
+// NewHarnessOn sets up a Harness on a running server. The roles have names
+// unique to the harness so that several can share a server, and are dropped
+// when the test is done. The options are applied to the driver after the
+// provider and sticky ones.
+func NewHarnessOn(t *testing.T, srv *Server, opts ...gopqr.Option) *Harness {
+	t.Helper()
+	suffix := randomHex(4)
+	odd, even := "gopqr_odd_"+suffix, "gopqr_even_"+suffix
+	oddPassword, evenPassword := randomHex(16), randomHex(16)
+	srv.CreateRoles(t, odd, oddPassword, even, evenPassword)
+	t.Cleanup(func() {
+		for _, name := range []string{odd, even} {
+			srv.Admin.Exec("DROP ROLE IF EXISTS " + pq.QuoteIdentifier(name))
+		}
+	})
+	store := NewSecretStore(gopqr.Secret{
+		OddUsername:      odd,
+		OddPassword:      oddPassword,
+		EvenUsername:     even,
+		EvenPassword:     evenPassword,
+		ActiveCredential: "odd",
+	})
+	d, err := gopqr.New(append([]gopqr.Option{gopqr.WithProvider(store), gopqr.WithSticky()}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Harness{Server: srv, Store: store, Driver: d}
+}
+
+// DB returns a database of the driver on the server, closed when the test
+// is done.
+func (h *Harness) DB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(h.Driver.Connector(h.Server.DSN))
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// CurrentUser opens a new connection of the driver and returns the role it
+// authenticated as.
+func (h *Harness) CurrentUser(ctx context.Context) (string, error) {
+	db := sql.OpenDB(h.Driver.Connector(h.Server.DSN))
+	defer db.Close()
+	var user string
+	err := db.QueryRowContext(ctx, "SELECT current_user").Scan(&user)
+	return user, err
+}
+
+// Username returns the role of the slot, "odd" or "even".
+func (h *Harness) Username(t *testing.T, slot string) string {
+	t.Helper()
+	s, err := h.Store.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch slot {
+	case "odd":
+		return s.OddUsername
+	case "even":
+		return s.EvenUsername
+	}
+	t.Fatalf("unknown slot %q, want odd or even", slot)
+	return ""
+}
+
+// Expire makes the password of the role of the slot expire, so the server
+// fails its authentication the way it does once a rotation has moved on,
+// while the secret store still holds it.
+func (h *Harness) Expire(t *testing.T, slot string) {
+	t.Helper()
+	h.validUntil(t, slot, "-infinity")
+}
+
+// Renew undoes Expire.
+func (h *Harness) Renew(t *testing.T, slot string) {
+	t.Helper()
+	h.validUntil(t, slot, "infinity")
+}
+
+// Rotate gives the role of the slot a new password on the server and in the
+// store, the way a rotation does, and returns it. The driver keeps the old
+// password until it refreshes.
+func (h *Harness) Rotate(t *testing.T, slot string) string {
+	t.Helper()
+	ctx := context.Background()
+	password := randomHex(16)
+	s, err := h.Store.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slot == "even" {
+		s.EvenPassword = password
+	} else {
+		s.OddPassword = password
+	}
+	h.Server.SetPassword(t, h.Username(t, slot), password)
+	if err := h.Store.Put(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	return password
+}
+
+// Activate marks the slot, "odd" or "even", active in the store.
+func (h *Harness) Activate(t *testing.T, slot string) {
+	t.Helper()
+	ctx := context.Background()
+	s, err := h.Store.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ActiveCredential = slot
+	if err := h.Store.Put(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (h *Harness) validUntil(t *testing.T, slot, until string) {
+	t.Helper()
+	name := h.Username(t, slot)
+	if _, err := h.Server.Admin.Exec(fmt.Sprintf("ALTER ROLE %v VALID UNTIL %v",
+		pq.QuoteIdentifier(name), pq.QuoteLiteral(until))); err != nil {
+		t.Fatalf("changing the expiry of %v failed - %v", name, err)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,310 @@
+package testsupport
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+	"github.com/chandranarreddy/gopqr/rotator"
+	"github.com/lib/pq"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package testsupport runs integration tests of github.com/chandranarreddy/gopqr
+against real Postgres servers of several major versions, which differ in
+their default password encryption (md5 until 13, SCRAM from 14) and in how
+they report failed authentication. Every version runs in a container started
+with the docker CLI, and the tests are skipped where docker is not available.
+
+Usage:
+	func TestRotation(t *testing.T) {
+		testsupport.Matrix(t)
+	}
+
+	func TestMyProvider(t *testing.T) {
+		testsupport.Run(t, func(t *testing.T, srv *testsupport.Server) {
+			...
+		}, "15", "16")
+	}
+
+For scenarios of your own, NewHarness starts a server with an odd and an
+even role and a driver reading them, and expires or rotates either role
+mid-test -
+	func TestFallback(t *testing.T) {
+		h := testsupport.NewHarness(t, "16")
+		db := h.DB(t)
+		h.Expire(t, "odd")
+		... // new connections of db authenticate as h.Username(t, "even")
+	}
+
+The versions default to DEFAULTVERSIONS, or to the comma separated list of
+the GOPQR_TEST_POSTGRES_VERSIONS environment variable.
+*/
+
+// DEFAULTVERSIONS - Postgres major versions the matrix runs against
+var DEFAULTVERSIONS = []string{"12", "13", "14", "15", "16"}
+
+// ENVVERSIONS - Environment variable overriding DEFAULTVERSIONS
+const ENVVERSIONS = "GOPQR_TEST_POSTGRES_VERSIONS"
+
+// DEFAULTSTARTTIMEOUT - How long a container is given to accept connections
+const DEFAULTSTARTTIMEOUT = 2 * time.Minute
+
+const adminPassword = "gopqr-admin"
+
+// Server is a Postgres server in a container.
+type Server struct {
+	// Version - Major version of the server
+	Version string
+	// DSN - DSN of the server without credentials, for the driver
+	DSN string
+	// Admin - Connection as the superuser of the server
+	Admin *sql.DB
+}
+
+// Matrix runs the rotation suite of gopqr against every version, as a
+// subtest per version.
+func Matrix(t *testing.T, versions ...string) {
+	Run(t, RotationSuite, versions...)
+}
+
+// Run starts a server of every version and runs fn against it as a
+// subtest. The servers are removed when the subtests are done.
+func Run(t *testing.T, fn func(t *testing.T, srv *Server), versions ...string) {
+	t.Helper()
+	skipWithoutDocker(t)
+	if len(versions) == 0 {
+		versions = DEFAULTVERSIONS
+		if env := os.Getenv(ENVVERSIONS); env != "" {
+			versions = strings.Split(env, ",")
+		}
+	}
+	for _, version := range versions {
+		version := strings.TrimSpace(version)
+		t.Run("postgres-"+version, func(t *testing.T) {
+			srv := Start(t, version)
+			fn(t, srv)
+		})
+	}
+}
+
+// Start runs a server of the version in a container, removed when the test
+// is done.
+func Start(t *testing.T, version string) *Server {
+	t.Helper()
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_PASSWORD="+adminPassword,
+		"-p", "127.0.0.1::5432",
+		"postgres:"+version).Output()
+	if err != nil {
+		t.Fatalf("starting postgres %v failed - %v", version, commandError(err))
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		exec.Command("docker", "rm", "-f", id).Run()
+	})
+	out, err = exec.Command("docker", "port", id, "5432/tcp").Output()
+	if err != nil {
+		t.Fatalf("finding the port of postgres %v failed - %v", version, commandError(err))
+	}
+	// the first line is the IPv4 binding, like 127.0.0.1:49153
+	addr := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	srv := &Server{
+		Version: version,
+		DSN:     fmt.Sprintf("postgres://%v/postgres?sslmode=disable", addr),
+	}
+	admin, err := sql.Open("postgres", fmt.Sprintf("postgres://postgres:%v@%v/postgres?sslmode=disable", adminPassword, addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	srv.Admin = admin
+	deadline := time.Now().Add(DEFAULTSTARTTIMEOUT)
+	for {
+		err := admin.Ping()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("postgres %v did not accept connections - %v", version, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return srv
+}
+
+// CreateRoles creates a login role of the name and password for every pair
+// of arguments.
+func (srv *Server) CreateRoles(t *testing.T, namesAndPasswords ...string) {
+	t.Helper()
+	for i := 0; i+1 < len(namesAndPasswords); i += 2 {
+		name, password := namesAndPasswords[i], namesAndPasswords[i+1]
+		if _, err := srv.Admin.Exec(fmt.Sprintf("CREATE ROLE %v LOGIN PASSWORD %v",
+			pq.QuoteIdentifier(name), pq.QuoteLiteral(password))); err != nil {
+			t.Fatalf("creating role %v failed - %v", name, err)
+		}
+	}
+}
+
+// SetPassword changes the password of the role, like a rotation would.
+func (srv *Server) SetPassword(t *testing.T, name, password string) {
+	t.Helper()
+	if _, err := srv.Admin.Exec(fmt.Sprintf("ALTER ROLE %v WITH PASSWORD %v",
+		pq.QuoteIdentifier(name), pq.QuoteLiteral(password))); err != nil {
+		t.Fatalf("changing the password of %v failed - %v", name, err)
+	}
+}
+
+// SecretStore holds a secret in memory. It is both the Provider of a driver
+// and the SecretStore of a rotator, like a secret store would be.
+type SecretStore struct {
+	mu     sync.Mutex
+	secret gopqr.Secret
+}
+
+// NewSecretStore returns a store holding the secret.
+func NewSecretStore(s gopqr.Secret) *SecretStore {
+	return &SecretStore{secret: s}
+}
+
+// Current implements gopqr.CredentialProvider.
+func (s *SecretStore) Current(ctx context.Context) (gopqr.Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secret.Credentials(), nil
+}
+
+// Refresh implements gopqr.CredentialProvider.
+func (s *SecretStore) Refresh(ctx context.Context) error {
+	return nil
+}
+
+// Get implements rotator.SecretStore.
+func (s *SecretStore) Get(ctx context.Context) (*gopqr.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := s.secret
+	secret.Slots = append([]gopqr.SecretSlot(nil), s.secret.Slots...)
+	return &secret, nil
+}
+
+// Put implements rotator.SecretStore.
+func (s *SecretStore) Put(ctx context.Context, secret *gopqr.Secret) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secret = *secret
+	return nil
+}
+
+// RotationSuite checks the rotation behavior of the driver against the
+// server - connecting with the active credential, falling back when it
+// fails authentication, failing with every credential rotated away, a
+// rotation by the rotator package being picked up, and a role expiring
+// mid-test.
+func RotationSuite(t *testing.T, srv *Server) {
+	srv.CreateRoles(t, "gopqr_odd", "odd-password-1", "gopqr_even", "even-password-1")
+	store := NewSecretStore(gopqr.Secret{
+		OddUsername:      "gopqr_odd",
+		OddPassword:      "odd-password-1",
+		EvenUsername:     "gopqr_even",
+		EvenPassword:     "even-password-1",
+		ActiveCredential: "odd",
+	})
+	d, err := gopqr.New(gopqr.WithProvider(store), gopqr.WithSticky())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	currentUser := func() (string, error) {
+		db := sql.OpenDB(d.Connector(srv.DSN))
+		defer db.Close()
+		var user string
+		err = db.QueryRowContext(ctx, "SELECT current_user").Scan(&user)
+		return user, err
+	}
+
+	t.Run("active", func(t *testing.T) {
+		if user, err := currentUser(); err != nil || user != "gopqr_odd" {
+			t.Fatalf("connected as %q - %v, want gopqr_odd", user, err)
+		}
+	})
+	t.Run("fallback", func(t *testing.T) {
+		srv.SetPassword(t, "gopqr_odd", "rotated-away")
+		if user, err := currentUser(); err != nil || user != "gopqr_even" {
+			t.Fatalf("connected as %q - %v, want a fallback to gopqr_even", user, err)
+		}
+	})
+	t.Run("exhausted", func(t *testing.T) {
+		srv.SetPassword(t, "gopqr_even", "rotated-away")
+		_, err := currentUser()
+		if !errors.Is(err, gopqr.ErrBothCredentialsFailed) {
+			t.Fatalf("got %v, want an error matching ErrBothCredentialsFailed", err)
+		}
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) {
+			t.Fatalf("got %v, want the *pq.Error of the server wrapped", err)
+		}
+		srv.SetPassword(t, "gopqr_even", "even-password-1")
+	})
+	t.Run("rotator", func(t *testing.T) {
+		r := rotator.New(d, srv.Admin, store)
+		for i := 0; i < 2; i++ {
+			res, err := r.Rotate(ctx)
+			if err != nil {
+				t.Fatalf("rotation %v failed - %v", i, err)
+			}
+			if user, err := currentUser(); err != nil || user != res.Username {
+				t.Fatalf("connected as %q - %v after rotating %v, want %v", user, err, res.Rotated, res.Username)
+			}
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		report, err := d.ValidateCredentials(ctx, srv.DSN)
+		if err != nil || len(report.Authenticated()) != 2 {
+			t.Fatalf("got %+v - %v, want both credentials to authenticate", report, err)
+		}
+	})
+	t.Run("expired", func(t *testing.T) {
+		h := NewHarnessOn(t, srv)
+		db := h.DB(t)
+		if err := db.PingContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+		h.Expire(t, "odd")
+		// connections already open stay usable, new ones fall back
+		if err := db.PingContext(ctx); err != nil {
+			t.Fatalf("open connection failed after the expiry - %v", err)
+		}
+		even := h.Username(t, "even")
+		if user, err := h.CurrentUser(ctx); err != nil || user != even {
+			t.Fatalf("connected as %q - %v, want a fallback to %v", user, err, even)
+		}
+		h.Renew(t, "odd")
+	})
+}
+
+// skipWithoutDocker skips the test where the docker CLI is not installed.
+func skipWithoutDocker(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not available - ", err)
+	}
+}
+
+// commandError adds what the command wrote to stderr to its error.
+func commandError(err error) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+		return fmt.Errorf("%v - %v", err, strings.TrimSpace(string(exit.Stderr)))
+	}
+	return err
+}
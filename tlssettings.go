@@ -0,0 +1,64 @@
+package gopqr
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// isPEM reports whether the value holds PEM encoded material rather than the
+// path of a file.
+func isPEM(v string) bool {
+	return strings.HasPrefix(strings.TrimSpace(v), "-----BEGIN")
+}
+
+// tlsSettings returns the sslrootcert, sslcert and sslkey settings in effect
+// for the credential, its client certificate taking precedence over that of
+// the driver. Paths are passed on as they are. PEM material is passed inline
+// with sslinline, which lib/pq applies to all three, so the files of the
+// others, including those the DSN names by their paths for the settings the
+// credential and the driver leave empty (get returns the settings of the
+// DSN), are read and inlined along with it.
+func (d *Driver) tlsSettings(cred Credential, get func(string) string) ([]dsnSetting, error) {
+	s := d.current()
+	cert, key := s.cert, s.key
+	if cred.SSLCert != "" || cred.SSLKey != "" {
+		cert, key = cred.SSLCert, cred.SSLKey
+	}
+	if s.rootcert == "" && cert == "" && key == "" {
+		// the common case, which Open should not allocate for
+		return nil, nil
+	}
+	own := []dsnSetting{{"sslrootcert", s.rootcert}, {"sslcert", cert}, {"sslkey", key}}
+	inline := false
+	settings := make([]dsnSetting, 0, len(own)+1)
+	for _, o := range own {
+		if o.value != "" {
+			settings = append(settings, o)
+			inline = inline || isPEM(o.value)
+		}
+	}
+	if !inline {
+		return settings, nil
+	}
+	for i := range own {
+		if own[i].value == "" {
+			own[i].value = get(own[i].key)
+		}
+	}
+	settings = append(settings[:0], dsnSetting{key: "sslinline", value: "true"})
+	for _, o := range own {
+		if o.value == "" {
+			continue
+		}
+		if !isPEM(o.value) {
+			pem, err := os.ReadFile(o.value)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read %v of %v to pass it inline - %w", o.key, cred.Name, err)
+			}
+			o.value = string(pem)
+		}
+		settings = append(settings, o)
+	}
+	return settings, nil
+}
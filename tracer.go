@@ -0,0 +1,45 @@
+package gopqr
+
+import (
+	"context"
+)
+
+// OpenOutcome tells which credential an Open connected with.
+type OpenOutcome struct {
+	// Slot - Name of the credential slot that authenticated
+	Slot string
+	// Fallback - Whether the slot was a fallback after the active credential
+	// failed authentication
+	Fallback bool
+}
+
+// Tracer brackets the Opens and refreshes of the driver, so that latency can
+// be correlated with the rotation of credentials. The otelgopqr package
+// implements it with OpenTelemetry spans.
+type Tracer interface {
+	// StartOpen is invoked as Open begins. The returned context is used to
+	// connect, and the returned func is invoked with the outcome once Open
+	// returns.
+	StartOpen(ctx context.Context) (context.Context, func(OpenOutcome, error))
+	// StartRefresh is invoked as a refresh of the credentials begins, and
+	// the returned func once it has finished, retries included.
+	StartRefresh(ctx context.Context) func(error)
+}
+
+type noTracer struct{}
+
+func (noTracer) StartOpen(ctx context.Context) (context.Context, func(OpenOutcome, error)) {
+	return ctx, func(OpenOutcome, error) {}
+}
+
+func (noTracer) StartRefresh(context.Context) func(error) {
+	return func(error) {}
+}
+
+// tracer returns the Tracer of the driver, or one doing nothing.
+func (d *Driver) tracer() Tracer {
+	if d.Tracer != nil {
+		return d.Tracer
+	}
+	return noTracer{}
+}
@@ -0,0 +1,87 @@
+package gopqr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SlotValidation is the outcome of validating the credential of one slot.
+type SlotValidation struct {
+	// Slot - Name of the credential slot, "odd" or "even" or the Name of
+	// one of the Slots
+	Slot string
+	// Active - Whether the slot holds the active credential
+	Active bool
+	// Authenticated - Whether a connection with the credential was made
+	Authenticated bool
+	// Duration - How long connecting took
+	Duration time.Duration
+	// Err - Why the connection failed, with the credentials redacted, nil
+	// when it was made
+	Err error
+}
+
+// CredentialsValidation is the report returned by ValidateCredentials.
+type CredentialsValidation struct {
+	// Slots - Outcome of every slot of the driver, in ring order
+	Slots []SlotValidation
+}
+
+// Authenticated returns the names of the slots whose credentials
+// authenticate.
+func (v CredentialsValidation) Authenticated() []string {
+	var slots []string
+	for _, s := range v.Slots {
+		if s.Authenticated {
+			slots = append(slots, s.Slot)
+		}
+	}
+	return slots
+}
+
+// ActiveAuthenticated reports whether the active credential authenticates,
+// that is whether Open gets by without the fallback path.
+func (v CredentialsValidation) ActiveAuthenticated() bool {
+	for _, s := range v.Slots {
+		if s.Active {
+			return s.Authenticated
+		}
+	}
+	return false
+}
+
+// ValidateCredentials makes a connection with the credential of every slot
+// of the driver, odd and even or the Slots, and reports which of them
+// authenticate. It leaves the rotation state of the driver untouched and is
+// cheap enough for a readiness probe, to detect a broken secret before
+// traffic hits the fallback path. The error matches ErrCredentialsInvalid
+// when any credential did not authenticate, and the report tells which.
+func (d *Driver) ValidateCredentials(ctx context.Context, dsn string) (CredentialsValidation, error) {
+	var v CredentialsValidation
+	if err := d.syncProvider(ctx); err != nil {
+		return v, err
+	}
+	snap := d.current()
+	ring, active := snap.ring, snap.activeSlot()
+	var failed []string
+	for _, cred := range ring {
+		start := time.Now()
+		err := redact(d.checkSlot(ctx, dsn, cred.Name), ring)
+		v.Slots = append(v.Slots, SlotValidation{
+			Slot:          cred.Name,
+			Active:        cred.Name == active,
+			Authenticated: err == nil,
+			Duration:      time.Since(start),
+			Err:           err,
+		})
+		if err != nil {
+			failed = append(failed, cred.Name+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return v, fmt.Errorf("%w - %v", ErrCredentialsInvalid, strings.Join(failed, "; "))
+	}
+	return v, nil
+}
@@ -0,0 +1,77 @@
+package gopqr
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// version - Semantic version of this release of the driver
+const version = "1.0.0"
+
+// modulePath - Import path of the driver, to find it among the dependencies
+// of a binary
+const modulePath = "github.com/chandranarreddy/gopqr"
+
+// Version returns the semantic version of the driver, so that the operators
+// of large fleets can tell which driver, and with it which behavior, every
+// service runs.
+func Version() string {
+	return version
+}
+
+// Build describes the binary the driver is built into.
+type Build struct {
+	// Version - Semantic version of the driver, as returned by Version
+	Version string
+	// ModuleVersion - Version of the driver module the binary was built
+	// with, like "v1.0.0", or "(devel)" when built from a checkout. Empty
+	// when the binary carries no build information.
+	ModuleVersion string
+	// Main - Import path of the main module of the binary
+	Main string
+	// GoVersion - Version of Go the binary was built with
+	GoVersion string
+}
+
+// BuildInfo returns the version of the driver along with what the binary
+// records of how it was built.
+func BuildInfo() Build {
+	b := Build{Version: version, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Main = info.Main.Path
+	if info.Main.Path == modulePath {
+		b.ModuleVersion = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			b.ModuleVersion = dep.Version
+			if dep.Replace != nil {
+				b.ModuleVersion = dep.Replace.Version
+			}
+		}
+	}
+	return b
+}
+
+// policyName names the RotationPolicy for the status of the driver, or
+// returns "custom" for policies of the application.
+func policyName(p RotationPolicy) string {
+	switch p.(type) {
+	case perOpen:
+		return "per_open"
+	case onAuthFailure:
+		return "on_auth_failure"
+	case onInterval:
+		return "on_interval"
+	case onSecretVersionChange:
+		return "on_secret_version_change"
+	case frozen:
+		return "frozen"
+	case grace:
+		return "grace"
+	}
+	return "custom"
+}
@@ -0,0 +1,204 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+const (
+	//DEFAULTNOTIFYTIMEOUT - default timeout of a single notification
+	DEFAULTNOTIFYTIMEOUT = 5 * time.Second
+	//DEFAULTNOTIFYQUEUE - default number of notifications waiting to be sent
+	DEFAULTNOTIFYQUEUE = 64
+)
+
+const (
+	// EventFallback - The active credential failed authentication and Open
+	// fell back to the others
+	EventFallback = "fallback"
+	// EventRotation - The active credential of the driver changed
+	EventRotation = "rotation"
+)
+
+// Event is the JSON body a Notifier POSTs.
+type Event struct {
+	// Type - EventFallback or EventRotation
+	Type string `json:"type"`
+	// Instance - InstanceID of the Notifier
+	Instance string `json:"instance"`
+	// From and To - Slots the active credential changed between, for
+	// EventRotation
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Slot - Active slot of the driver as the event happened, for
+	// EventFallback the slot that failed
+	Slot string `json:"slot"`
+	// Error - The authentication failure, with DSNs redacted, for
+	// EventFallback
+	Error string `json:"error,omitempty"`
+	// Time - When the event happened
+	Time time.Time `json:"time"`
+}
+
+// Notifier POSTs an Event to a URL whenever a driver falls back or rotates,
+// so that a rotation controller learns right away when clients start
+// falling back. Requests are signed the way HMACVerifier expects, and are
+// sent in the background so that Open is never held up by the receiver.
+type Notifier struct {
+	// URL - Where the events are POSTed
+	URL string
+	// Secret - Key of the HMAC-SHA256 signature over the timestamp and the
+	// body. Requests are not signed without one.
+	Secret []byte
+	// SignatureHeader and TimestampHeader - Headers carrying the signature,
+	// default to DEFAULTSIGNATUREHEADER and DEFAULTTIMESTAMPHEADER
+	SignatureHeader string
+	TimestampHeader string
+	// InstanceID - Tells the instances of an application apart, defaults to
+	// hostname/pid
+	InstanceID string
+	// Client - Sends the requests, defaults to an http.Client with a timeout
+	// of DEFAULTNOTIFYTIMEOUT
+	Client *http.Client
+	// QueueSize - Events waiting to be sent before new ones are dropped,
+	// defaults to DEFAULTNOTIFYQUEUE
+	QueueSize int
+	// OnError func, when set, is invoked with the failures to send an
+	// event, which are otherwise written to the Logger
+	OnError func(Event, error)
+	// Logger - Where failures to send are written, when set
+	Logger *log.Logger
+
+	queue chan Event
+}
+
+// Notify installs the notifier on the driver. The OnRotate and
+// OnAuthFallback hooks already set on the driver keep being invoked, but
+// hooks set after Notify replace the notifier. Events are sent by a worker
+// of the driver, which runs until the context is done.
+func Notify(ctx context.Context, d *gopqr.Driver, n *Notifier) error {
+	if n.URL == "" {
+		return errors.New("A URL is required to send notifications to")
+	}
+	if n.InstanceID == "" {
+		host, _ := os.Hostname()
+		n.InstanceID = host + "/" + strconv.Itoa(os.Getpid())
+	}
+	if n.Client == nil {
+		n.Client = &http.Client{Timeout: DEFAULTNOTIFYTIMEOUT}
+	}
+	size := n.QueueSize
+	if size <= 0 {
+		size = DEFAULTNOTIFYQUEUE
+	}
+	n.queue = make(chan Event, size)
+
+	onRotate, onFallback := d.OnRotate, d.OnAuthFallback
+	d.OnRotate = func(from, to string) {
+		if onRotate != nil {
+			onRotate(from, to)
+		}
+		n.enqueue(Event{Type: EventRotation, From: from, To: to, Slot: to})
+	}
+	d.OnAuthFallback = func(err error) {
+		if onFallback != nil {
+			onFallback(err)
+		}
+		n.enqueue(Event{Type: EventFallback, Slot: d.State().ActiveSlot, Error: gopqr.RedactDSN(err.Error())})
+	}
+	d.Go("webhook_notify", func(ran func()) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-n.queue:
+				if err := n.Send(ctx, e); err != nil {
+					n.failed(e, err)
+				}
+				ran()
+			}
+		}
+	})
+	return nil
+}
+
+// enqueue queues the event for sending, dropping it when the queue is full.
+func (n *Notifier) enqueue(e Event) {
+	e.Instance, e.Time = n.InstanceID, time.Now().UTC()
+	select {
+	case n.queue <- e:
+	default:
+		n.failed(e, errors.New("Notification queue is full, event dropped"))
+	}
+}
+
+// Send POSTs the event right away, signed when the notifier has a Secret.
+// Responses other than 2xx are errors.
+func (n *Notifier) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.Secret) > 0 {
+		if err := gopqr.CheckPrimitive(gopqr.PrimitiveHMACSHA256); err != nil {
+			return err
+		}
+		sigHeader, tsHeader := n.SignatureHeader, n.TimestampHeader
+		if sigHeader == "" {
+			sigHeader = DEFAULTSIGNATUREHEADER
+		}
+		if tsHeader == "" {
+			tsHeader = DEFAULTTIMESTAMPHEADER
+		}
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, n.Secret)
+		mac.Write([]byte(ts + "."))
+		mac.Write(body)
+		req.Header.Set(tsHeader, ts)
+		req.Header.Set(sigHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, DEFAULTMAXBODY))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Notification of %v was answered with %v", e.Type, resp.Status)
+	}
+	return nil
+}
+
+func (n *Notifier) failed(e Event, err error) {
+	if n.OnError != nil {
+		n.OnError(e, err)
+		return
+	}
+	if n.Logger != nil {
+		n.Logger.Printf("gopqr: failed to send the %v notification - %v", e.Type, err)
+	}
+}
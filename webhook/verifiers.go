@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+const (
+	//DEFAULTSIGNATUREHEADER - default header carrying the HMAC signature
+	DEFAULTSIGNATUREHEADER = "X-Gopqr-Signature"
+	//DEFAULTTIMESTAMPHEADER - default header carrying the time the request was signed at
+	DEFAULTTIMESTAMPHEADER = "X-Gopqr-Timestamp"
+	//DEFAULTMAXSKEW - default age beyond which a signed request is rejected
+	DEFAULTMAXSKEW = 5 * time.Minute
+)
+
+// HMACVerifier accepts requests signed with HMAC-SHA256 over the timestamp
+// and the body. Requests signed longer than MaxSkew ago, or whose signature
+// was already seen, are rejected so that captured requests cannot be
+// replayed.
+type HMACVerifier struct {
+	// Secret - Key shared with the sender
+	Secret []byte
+	// SignatureHeader - Header carrying the hex encoded signature, defaults to DEFAULTSIGNATUREHEADER
+	SignatureHeader string
+	// TimestampHeader - Header carrying the unix time of signing, defaults to DEFAULTTIMESTAMPHEADER
+	TimestampHeader string
+	// MaxSkew - Maximum age of a request, defaults to DEFAULTMAXSKEW
+	MaxSkew time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// Verify implements Verifier.
+func (v *HMACVerifier) Verify(r *http.Request, body []byte) error {
+	if err := gopqr.CheckPrimitive(gopqr.PrimitiveHMACSHA256); err != nil {
+		return err
+	}
+	if len(v.Secret) == 0 {
+		return fmt.Errorf("No HMAC secret is set - %w", ErrUnverified)
+	}
+	sigHeader, tsHeader, skew := v.SignatureHeader, v.TimestampHeader, v.MaxSkew
+	if sigHeader == "" {
+		sigHeader = DEFAULTSIGNATUREHEADER
+	}
+	if tsHeader == "" {
+		tsHeader = DEFAULTTIMESTAMPHEADER
+	}
+	if skew <= 0 {
+		skew = DEFAULTMAXSKEW
+	}
+	ts := r.Header.Get(tsHeader)
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Missing or invalid %v - %w", tsHeader, ErrUnverified)
+	}
+	signedAt := time.Unix(unix, 0)
+	if age := time.Since(signedAt); age > skew || age < -skew {
+		return fmt.Errorf("Request signed at %v is outside the allowed skew - %w", signedAt, ErrUnverified)
+	}
+	sig, err := hex.DecodeString(r.Header.Get(sigHeader))
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("Missing or invalid %v - %w", sigHeader, ErrUnverified)
+	}
+	mac := hmac.New(sha256.New, v.Secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return fmt.Errorf("Signature mismatch - %w", ErrUnverified)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for s, at := range v.seen {
+		if now.Sub(at) > 2*skew {
+			delete(v.seen, s)
+		}
+	}
+	key := string(sig)
+	if _, replayed := v.seen[key]; replayed {
+		return fmt.Errorf("Replayed request - %w", ErrUnverified)
+	}
+	if v.seen == nil {
+		v.seen = make(map[string]time.Time)
+	}
+	v.seen[key] = now
+	return nil
+}
+
+// MTLSVerifier accepts requests made over TLS with a client certificate
+// that was verified against the ClientCAs of the server, which must be
+// configured with tls.RequireAndVerifyClientCert or
+// tls.VerifyClientCertIfGiven.
+type MTLSVerifier struct {
+	// AllowedNames - When set, the client certificate must carry one of these
+	// DNS names or common names
+	AllowedNames []string
+}
+
+// Verify implements Verifier.
+func (v *MTLSVerifier) Verify(r *http.Request, body []byte) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return fmt.Errorf("No verified client certificate - %w", ErrUnverified)
+	}
+	if len(v.AllowedNames) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, allowed := range v.AllowedNames {
+		for _, name := range names {
+			if name == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Client certificate %v is not allowed - %w", cert.Subject, ErrUnverified)
+}
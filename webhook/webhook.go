@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/chandranarreddy/gopqr"
+)
+
+/*
+Author: Chandrakanth Narreddy
+Package webhook receives push notifications to refresh the credentials of a
+github.com/chandranarreddy/gopqr driver, like from the rotation job right
+after it changed a password, so that the driver does not wait for the first
+authentication failure. Every request must pass the Verifier of the handler,
+which makes exposing the endpoint inside a cluster safe by default -
+HMACVerifier checks a signature over the body and rejects replays,
+MTLSVerifier checks the client certificate, and All combines verifiers.
+
+Usage:
+	h := webhook.New(pqrDriver, webhook.All(
+		&webhook.MTLSVerifier{AllowedNames: []string{"rotator.internal"}},
+		&webhook.HMACVerifier{Secret: key},
+	))
+	http.Handle("/gopqr/refresh", h)
+
+The sender signs the request as -
+	X-Gopqr-Timestamp: <unix seconds>
+	X-Gopqr-Signature: hex(HMAC-SHA256(key, timestamp + "." + body))
+
+The other way round, a Notifier POSTs an Event signed the same way whenever
+the driver falls back or rotates, so that a rotation controller learns right
+away when clients start falling back -
+	err := webhook.Notify(ctx, pqrDriver, &webhook.Notifier{
+		URL:    "https://rotator.internal/gopqr/events",
+		Secret: key,
+	})
+*/
+
+// DEFAULTMAXBODY - default cap on the size of a notification
+const DEFAULTMAXBODY = 64 << 10
+
+// ErrUnverified is wrapped by the errors of verifiers that rejected a request.
+var ErrUnverified = errors.New("Request failed verification")
+
+// Verifier decides whether a push notification may trigger a refresh.
+type Verifier interface {
+	// Verify returns an error when the request, whose body has been read
+	// into body, must be rejected.
+	Verify(r *http.Request, body []byte) error
+}
+
+// VerifierFunc adapts a func to a Verifier.
+type VerifierFunc func(r *http.Request, body []byte) error
+
+// Verify calls f.
+func (f VerifierFunc) Verify(r *http.Request, body []byte) error {
+	return f(r, body)
+}
+
+// All returns a Verifier that only accepts requests accepted by every one
+// of the verifiers. Without any verifier, nil ones aside, it rejects every
+// request, like a Handler without a Verifier.
+func All(verifiers ...Verifier) Verifier {
+	return VerifierFunc(func(r *http.Request, body []byte) error {
+		verified := false
+		for _, v := range verifiers {
+			if v == nil {
+				continue
+			}
+			verified = true
+			if err := v.Verify(r, body); err != nil {
+				return err
+			}
+		}
+		if !verified {
+			return fmt.Errorf("No verifier was given to All - %w", ErrUnverified)
+		}
+		return nil
+	})
+}
+
+// Handler refreshes the credentials of the driver on verified POST requests.
+type Handler struct {
+	// Driver - The driver whose credentials are refreshed
+	Driver *gopqr.Driver
+	// Verifier - Verifies every request. A handler without one rejects every
+	// request.
+	Verifier Verifier
+	// MaxBody - Cap on the size of a notification, defaults to DEFAULTMAXBODY
+	MaxBody int64
+	// OnRejected func, when set, is invoked with the requests that failed
+	// verification, like to raise an alert
+	OnRejected func(r *http.Request, err error)
+}
+
+// New returns a Handler refreshing the credentials of the driver on requests
+// that pass the verifier.
+func New(d *gopqr.Driver, verifier Verifier) *Handler {
+	return &Handler{Driver: d, Verifier: verifier}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	max := h.MaxBody
+	if max <= 0 {
+		max = DEFAULTMAXBODY
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if h.Verifier == nil {
+		err = errors.New("No Verifier is set on the webhook handler")
+	} else {
+		err = h.Verifier.Verify(r, body)
+	}
+	if err != nil {
+		if h.OnRejected != nil {
+			h.OnRejected(r, err)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := h.Driver.Refresh(); err != nil {
+		http.Error(w, "refresh failed", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package gopqr
+
+import (
+	"bytes"
+	"sync"
+)
+
+// wipeMu keeps the passwords of credentials from being read while those of
+// retired credentials are zeroed.
+var wipeMu sync.RWMutex
+
+// PasswordText returns the password of the credential, PasswordBytes when
+// it is set and Password otherwise. The string returned is a copy that is
+// not zeroed, so it is best kept no longer than it takes to connect.
+func (c Credential) PasswordText() string {
+	if c.PasswordBytes == nil {
+		return c.Password
+	}
+	wipeMu.RLock()
+	defer wipeMu.RUnlock()
+	return string(c.PasswordBytes)
+}
+
+// wipeRetired zeroes the PasswordBytes of the credentials of the old ring
+// that none of the live rings holds. A slice shared with a live ring, or
+// holding the same password for the same slot, is left as it is, so that
+// pooled connections of that slot are not taken for replaced.
+func wipeRetired(old []Credential, live ...[]Credential) {
+	wipeMu.Lock()
+	defer wipeMu.Unlock()
+	for _, o := range old {
+		if len(o.PasswordBytes) == 0 || kept(o, live) {
+			continue
+		}
+		for i := range o.PasswordBytes {
+			o.PasswordBytes[i] = 0
+		}
+	}
+}
+
+func kept(o Credential, live [][]Credential) bool {
+	for _, ring := range live {
+		for _, c := range ring {
+			if len(c.PasswordBytes) == 0 {
+				continue
+			}
+			if &c.PasswordBytes[0] == &o.PasswordBytes[0] ||
+				(c.Name == o.Name && bytes.Equal(c.PasswordBytes, o.PasswordBytes)) {
+				return true
+			}
+		}
+	}
+	return false
+}
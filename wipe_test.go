@@ -0,0 +1,62 @@
+package gopqr
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWipeWaitsForHolders(t *testing.T) {
+	old := []byte("old-pw")
+	d := &Driver{Slots: []Credential{{Name: "a", Username: "u", PasswordBytes: old}}}
+	d.AcquireLock()
+	d.ReleaseLock()
+
+	held := d.hold()
+	d.AcquireLock()
+	d.Slots = []Credential{{Name: "a", Username: "u", PasswordBytes: []byte("new-pw")}}
+	d.ReleaseLock()
+	if !bytes.Equal(old, []byte("old-pw")) {
+		t.Fatalf("password of the held snapshot = %q, want it kept until released", old)
+	}
+	if got := held.ring[0].PasswordText(); got != "old-pw" {
+		t.Fatalf("PasswordText of the held snapshot = %q, want old-pw", got)
+	}
+	d.release(held)
+	if !bytes.Equal(old, make([]byte, len(old))) {
+		t.Errorf("password of the retired snapshot = %q once released, want it zeroed", old)
+	}
+}
+
+func TestWipeSparesSharedPasswords(t *testing.T) {
+	shared := []byte("shared-pw")
+	d := &Driver{Slots: []Credential{{Name: "a", Username: "u", PasswordBytes: shared}}}
+	d.AcquireLock()
+	d.ReleaseLock()
+
+	held := d.hold()
+	d.AcquireLock()
+	d.Slots = []Credential{{Name: "a", Username: "u2", PasswordBytes: []byte("other-pw")}}
+	d.ReleaseLock()
+	// the next refresh brings the password of the held snapshot back
+	d.AcquireLock()
+	d.Slots = []Credential{{Name: "a", Username: "u", PasswordBytes: shared}}
+	d.ReleaseLock()
+	d.release(held)
+	if !bytes.Equal(shared, []byte("shared-pw")) {
+		t.Errorf("password shared with the current credentials = %q, want it kept", shared)
+	}
+}
+
+func TestWipeOnCloseWipesCurrent(t *testing.T) {
+	pw := []byte("pw")
+	d := &Driver{Slots: []Credential{{Name: "a", Username: "u", PasswordBytes: pw}}, WipeOnClose: true}
+	d.AcquireLock()
+	d.ReleaseLock()
+	d.retireCurrent()
+	if !bytes.Equal(pw, make([]byte, len(pw))) {
+		t.Errorf("password = %q after Close, want it zeroed", pw)
+	}
+	// Snapshot after Close returns rather than waiting for a snapshot
+	// that is never replaced
+	d.Snapshot()
+}
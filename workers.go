@@ -0,0 +1,116 @@
+package gopqr
+
+import (
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DEFAULTWORKERRESTARTDELAY - Time a background goroutine of the driver
+// waits before it is restarted after it panicked
+const DEFAULTWORKERRESTARTDELAY = time.Second
+
+// WorkerStats describes one kind of background goroutine of the driver, like
+// the scheduled refresh or the watcher of a provider, so that leaking or
+// stalled goroutines of long-lived services show on dashboards.
+type WorkerStats struct {
+	// Name - Kind of the goroutines, like "auto_refresh"
+	Name string
+	// Live - Goroutines of the kind running now
+	Live int
+	// LastRun - When one of them last did its work, the zero time if never
+	LastRun time.Time
+	// Restarts - Times one of them was restarted after it panicked
+	Restarts uint64
+}
+
+type workerState struct {
+	live     atomic.Int64
+	lastRun  atomic.Int64
+	restarts atomic.Uint64
+}
+
+// workers holds the states of the background goroutines of a driver by
+// their kind.
+type workers struct {
+	mu sync.Mutex
+	m  map[string]*workerState
+}
+
+func (d *Driver) worker(name string) *workerState {
+	d.workers.mu.Lock()
+	defer d.workers.mu.Unlock()
+	if d.workers.m == nil {
+		d.workers.m = make(map[string]*workerState)
+	}
+	w, ok := d.workers.m[name]
+	if !ok {
+		w = &workerState{}
+		d.workers.m[name] = w
+	}
+	return w
+}
+
+// Go runs fn in a background goroutine counted among the Workers of the
+// driver under the name, for the watchers of providers and the like. fn
+// calls ran whenever it did its work, and is restarted after
+// DEFAULTWORKERRESTARTDELAY when it panics. It is not restarted once it
+// returns.
+func (d *Driver) Go(name string, fn func(ran func())) {
+	go d.runWorker(name, true, fn)
+}
+
+// runWorker runs fn counted among the Workers of the driver until it
+// returns, restarting it after a panic when restart is set, unless the
+// driver is closed meanwhile.
+func (d *Driver) runWorker(name string, restart bool, fn func(ran func())) {
+	w := d.worker(name)
+	w.live.Add(1)
+	defer w.live.Add(-1)
+	ran := func() { w.lastRun.Store(time.Now().UnixNano()) }
+	for {
+		if !d.runRecovered(name, fn, ran) || !restart {
+			return
+		}
+		w.restarts.Add(1)
+		t := time.NewTimer(DEFAULTWORKERRESTARTDELAY)
+		select {
+		case <-d.lifetime().Done():
+			// Close does not wait out the delay of a restart
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// runRecovered runs fn and reports whether it panicked, logging the panic.
+func (d *Driver) runRecovered(name string, fn func(ran func()), ran func()) (panicked bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			panicked = true
+			d.logf("background goroutine %v panicked - %v\n%s", name, p, debug.Stack())
+		}
+	}()
+	fn(ran)
+	return false
+}
+
+// Workers returns the background goroutines of the driver by their kind,
+// sorted by name. Kinds whose goroutines all ended are kept with Live 0.
+func (d *Driver) Workers() []WorkerStats {
+	d.workers.mu.Lock()
+	stats := make([]WorkerStats, 0, len(d.workers.m))
+	for name, w := range d.workers.m {
+		s := WorkerStats{Name: name, Live: int(w.live.Load()), Restarts: w.restarts.Load()}
+		if n := w.lastRun.Load(); n != 0 {
+			s.LastRun = time.Unix(0, n)
+		}
+		stats = append(stats, s)
+	}
+	d.workers.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
@@ -0,0 +1,38 @@
+package gopqr
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestRunWorkerStopsRestartingOnClose(t *testing.T) {
+	d := &Driver{Logger: log.New(io.Discard, "", 0)}
+	panicked := make(chan struct{}, 1)
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		d.runWorker("test", true, func(ran func()) {
+			select {
+			case panicked <- struct{}{}:
+			default:
+			}
+			panic("worker failed")
+		})
+	}()
+	<-panicked
+	start := time.Now()
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed - %v", err)
+	}
+	select {
+	case <-returned:
+		if waited := time.Since(start); waited >= DEFAULTWORKERRESTARTDELAY {
+			t.Errorf("runWorker returned %v after Close, want it not to wait out the restart delay", waited)
+		}
+	case <-time.After(2 * DEFAULTWORKERRESTARTDELAY):
+		t.Fatal("runWorker still restarting after Close")
+	}
+}
@@ -0,0 +1,302 @@
+package gopqr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// rotatingConn wraps the connection opened by the underlying driver so that
+// gopqr can retire it from the pool, like once the endpoint it was opened
+// against or the credential it authenticated with has been replaced. Every
+// optional interface of database/sql/driver that lib/pq implements is passed
+// through to the wrapped connection. When the wrapped connection lacks one,
+// the call falls back to what database/sql itself would have done, so that
+// the wrapper never silently drops a context, a transaction option or a
+// feature. Its statements are wrapped likewise, see rotatingStmt.
+type rotatingConn struct {
+	driver.Conn
+	d        *Driver
+	endpoint string
+	// reconnects and epoch - The count of the ForceReconnects of the
+	// connector that opened the connection, nil for one opened by Open, and
+	// its value when the connection was opened
+	reconnects *atomic.Uint64
+	epoch      uint64
+	// snap and cred - The snapshot of the driver the connection was opened
+	// under and the credential that authenticated it
+	snap *snapshot
+	cred Credential
+	// opened - When Open returned the connection
+	opened time.Time
+}
+
+func (d *Driver) wrap(conn driver.Conn, snap *snapshot, cred Credential) driver.Conn {
+	c := &rotatingConn{Conn: conn, d: d, endpoint: snap.endpoint(), snap: snap, cred: cred, opened: time.Now()}
+	d.live.add(c)
+	return c
+}
+
+// Close implements driver.Conn, counting the connection out of the
+// LiveConns of its user.
+func (c *rotatingConn) Close() error {
+	c.d.live.remove(c)
+	return c.Conn.Close()
+}
+
+// retired reports whether the connection should no longer be handed out,
+// like once the endpoint was replaced, the credential it authenticated with
+// was replaced or a reconnect was forced.
+func (c *rotatingConn) retired() bool {
+	if c.reconnects != nil && c.reconnects.Load() != c.epoch {
+		return true
+	}
+	current := c.d.current()
+	if current == c.snap {
+		return false
+	}
+	if current.endpoint() != c.endpoint {
+		return true
+	}
+	return !c.d.keepReplacedConns() && c.cred.Name != dsnCredential && replaced(current.ring, c.cred)
+}
+
+// replaced reports whether the ring no longer holds the credential as it
+// was, because the slot is gone or its user, password, endpoint, client
+// certificate, keytab, kind of authentication or parameters changed.
+func replaced(ring []Credential, cred Credential) bool {
+	for _, c := range ring {
+		if c.Name == cred.Name {
+			return c.Username != cred.Username || c.PasswordText() != cred.PasswordText() ||
+				c.Host != cred.Host || c.Port != cred.Port || c.SSLMode != cred.SSLMode ||
+				c.SSLCert != cred.SSLCert || c.SSLKey != cred.SSLKey || c.Keytab != cred.Keytab ||
+				c.CertOnly != cred.CertOnly || !sameParams(c.Params, cred.Params)
+		}
+	}
+	return true
+}
+
+// Unwrap returns the connection opened by the underlying driver.
+func (c *rotatingConn) Unwrap() driver.Conn {
+	return c.Conn
+}
+
+// UnwrapConn returns the connection or statement opened by the underlying
+// driver, like lib/pq, when handed one of gopqr, such as the driverConn of
+// sql.Conn.Raw, and what it is handed otherwise.
+func UnwrapConn(driverConn any) any {
+	switch c := driverConn.(type) {
+	case *rotatingConn:
+		return c.Conn
+	case *rotatingStmt:
+		return c.Stmt
+	}
+	return driverConn
+}
+
+// hook hands the rotation state to the QueryHook of the driver, if it has one.
+func (c *rotatingConn) hook(ctx context.Context) {
+	if c.d.QueryHook != nil {
+		c.d.QueryHook(ctx, c.d.rotationState())
+	}
+}
+
+// IsValid implements driver.Validator.
+func (c *rotatingConn) IsValid() bool {
+	if c.retired() {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// ResetSession implements driver.SessionResetter.
+func (c *rotatingConn) ResetSession(ctx context.Context) error {
+	if c.retired() {
+		return driver.ErrBadConn
+	}
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// BeginTx implements driver.ConnBeginTx.
+func (c *rotatingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("The underlying driver does not support non-default isolation levels")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("The underlying driver does not support read-only transactions")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Conn.Begin()
+}
+
+// PrepareContext implements driver.ConnPrepareContext.
+func (c *rotatingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else if err = ctx.Err(); err == nil {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingStmt{Stmt: stmt, c: c}, nil
+}
+
+// Prepare implements driver.Conn.
+func (c *rotatingConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// QueryContext implements driver.QueryerContext.
+func (c *rotatingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		c.hook(ctx)
+		return q.QueryContext(ctx, query, args)
+	}
+	if q, ok := c.Conn.(driver.Queryer); ok {
+		values, err := namedValues(args)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.hook(ctx)
+		return q.Query(query, values)
+	}
+	return nil, driver.ErrSkip
+}
+
+// ExecContext implements driver.ExecerContext.
+func (c *rotatingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		c.hook(ctx)
+		return e.ExecContext(ctx, query, args)
+	}
+	if e, ok := c.Conn.(driver.Execer); ok {
+		values, err := namedValues(args)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.hook(ctx)
+		return e.Exec(query, values)
+	}
+	return nil, driver.ErrSkip
+}
+
+// Ping implements driver.Pinger.
+func (c *rotatingConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker.
+func (c *rotatingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// rotatingStmt wraps a statement prepared on a rotatingConn so that the
+// QueryHook sees the statements executed as well, including the COPY FROM
+// STDIN statements of pq.CopyIn. The optional interfaces of
+// database/sql/driver, and the CopyData of lib/pq, are passed through to the
+// wrapped statement.
+type rotatingStmt struct {
+	driver.Stmt
+	c *rotatingConn
+}
+
+// Unwrap returns the statement prepared by the underlying driver.
+func (s *rotatingStmt) Unwrap() driver.Stmt {
+	return s.Stmt
+}
+
+// ExecContext implements driver.StmtExecContext.
+func (s *rotatingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		s.c.hook(ctx)
+		return e.ExecContext(ctx, args)
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.c.hook(ctx)
+	return s.Stmt.Exec(values)
+}
+
+// QueryContext implements driver.StmtQueryContext.
+func (s *rotatingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		s.c.hook(ctx)
+		return q.QueryContext(ctx, args)
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.c.hook(ctx)
+	return s.Stmt.Query(values)
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, deferring to the
+// connection when the statement does not check its arguments itself, as
+// database/sql would have.
+func (s *rotatingStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return s.c.CheckNamedValue(nv)
+}
+
+// CopyData passes a line of a COPY FROM STDIN statement of lib/pq through.
+func (s *rotatingStmt) CopyData(ctx context.Context, line string) (driver.Result, error) {
+	if c, ok := s.Stmt.(interface {
+		CopyData(ctx context.Context, line string) (driver.Result, error)
+	}); ok {
+		return c.CopyData(ctx, line)
+	}
+	return nil, errors.New("The statement is not a COPY FROM STDIN")
+}
+
+// namedValues converts the arguments for the underlying drivers that take
+// them without names, which these drivers do not support.
+func namedValues(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errors.New("The underlying driver does not support named parameters")
+		}
+		values[i] = nv.Value
+	}
+	return values, nil
+}